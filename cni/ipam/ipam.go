@@ -178,8 +178,10 @@ func (plugin *ipamPlugin) Add(args *cniSkel.CmdArgs) error {
 		log.Printf("[cni-ipam] Allocated address poolID %v with subnet %v.", poolID, subnet)
 	}
 
-	// Allocate an address for the endpoint.
-	address, err := plugin.am.RequestAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, nwCfg.Ipam.Address, nil)
+	// Allocate an address for the endpoint. The network namespace is recorded so that the address
+	// is kept if the namespace survives a reboot.
+	options := map[string]string{ipam.OptNetNsPath: args.Netns}
+	address, err := plugin.am.RequestAddress(nwCfg.Ipam.AddrSpace, nwCfg.Ipam.Subnet, nwCfg.Ipam.Address, options)
 	if err != nil {
 		err = plugin.Errorf("Failed to allocate address: %v", err)
 		return err
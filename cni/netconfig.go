@@ -34,6 +34,7 @@ type NetworkConfig struct {
 	LogTarget        string `json:"logTarget,omitempty"`
 	MultiTenancy     bool   `json:"multiTenancy,omitempty"`
 	EnableSnatOnHost bool   `json:"enableSnatOnHost,omitempty"`
+	EbtablesBackend  string `json:"ebtablesBackend,omitempty"`
 	Ipam             struct {
 		Type          string `json:"type"`
 		Environment   string `json:"environment,omitempty"`
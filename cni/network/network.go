@@ -223,6 +223,11 @@ func (plugin *netPlugin) Add(args *cniSkel.CmdArgs) error {
 
 	log.Printf("[cni-net] Read network configuration %+v.", nwCfg)
 
+	if err = setEbtablesBackend(nwCfg); err != nil {
+		err = plugin.Errorf("Failed to set ebtables backend: %v", err)
+		return err
+	}
+
 	// Initialize values from network config.
 	networkId := nwCfg.Name
 	endpointId := GetEndpointID(args)
@@ -524,6 +529,11 @@ func (plugin *netPlugin) Delete(args *cniSkel.CmdArgs) error {
 
 	log.Printf("[cni-net] Read network configuration %+v.", nwCfg)
 
+	if err = setEbtablesBackend(nwCfg); err != nil {
+		err = plugin.Errorf("Failed to set ebtables backend: %v", err)
+		return err
+	}
+
 	// Initialize values from network config.
 	networkId := nwCfg.Name
 	endpointId := GetEndpointID(args)
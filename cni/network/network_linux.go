@@ -6,6 +6,7 @@ import (
 
 	"github.com/Azure/azure-container-networking/cni"
 	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/ebtables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/network"
 	cniTypes "github.com/containernetworking/cni/pkg/types"
@@ -21,6 +22,11 @@ func handleConsecutiveAdd(containerId, endpointId string, nwInfo *network.Networ
 	return nil, nil
 }
 
+// setEbtablesBackend selects the backend of the bridge rules from the network configuration.
+func setEbtablesBackend(nwCfg *cni.NetworkConfig) error {
+	return ebtables.SetBackend(nwCfg.EbtablesBackend)
+}
+
 func addDefaultRoute(gwIPString string, epInfo *network.EndpointInfo, result *cniTypesCurr.Result) {
 	_, defaultIPNet, _ := net.ParseCIDR("0.0.0.0/0")
 	dstIP := net.IPNet{IP: net.ParseIP("0.0.0.0"), Mask: defaultIPNet.Mask}
@@ -29,11 +35,21 @@ func addDefaultRoute(gwIPString string, epInfo *network.EndpointInfo, result *cn
 	result.Routes = append(result.Routes, &cniTypes.Route{Dst: dstIP, GW: gwIP})
 }
 
+// getEncapIDKey returns the option key under which the multitenancy id is passed to the network package.
+// VLAN is assumed when no encap type is specified.
+func getEncapIDKey(multiTenancyInfo cns.MultiTenancyInfo) string {
+	if multiTenancyInfo.EncapType == cns.Vxlan {
+		return network.VxlanIDKey
+	}
+
+	return network.VlanIDKey
+}
+
 func setNetworkOptions(cnsNwConfig *cns.GetNetworkContainerResponse, nwInfo *network.NetworkInfo) {
 	if cnsNwConfig != nil && cnsNwConfig.MultiTenancyInfo.ID != 0 {
 		log.Printf("Setting Network Options")
 		vlanMap := make(map[string]interface{})
-		vlanMap[network.VlanIDKey] = strconv.Itoa(cnsNwConfig.MultiTenancyInfo.ID)
+		vlanMap[getEncapIDKey(cnsNwConfig.MultiTenancyInfo)] = strconv.Itoa(cnsNwConfig.MultiTenancyInfo.ID)
 		vlanMap[network.SnatBridgeIPKey] = cnsNwConfig.LocalIPConfiguration.GatewayIPAddress + "/" + strconv.Itoa(int(cnsNwConfig.LocalIPConfiguration.IPSubnet.PrefixLength))
 		nwInfo.Options[dockerNetworkOption] = vlanMap
 	}
@@ -42,7 +58,10 @@ func setNetworkOptions(cnsNwConfig *cns.GetNetworkContainerResponse, nwInfo *net
 func setEndpointOptions(cnsNwConfig *cns.GetNetworkContainerResponse, epInfo *network.EndpointInfo, vethName string) {
 	if cnsNwConfig != nil && cnsNwConfig.MultiTenancyInfo.ID != 0 {
 		log.Printf("Setting Endpoint Options")
-		epInfo.Data[network.VlanIDKey] = cnsNwConfig.MultiTenancyInfo.ID
+		epInfo.Data[getEncapIDKey(cnsNwConfig.MultiTenancyInfo)] = cnsNwConfig.MultiTenancyInfo.ID
+		if cnsNwConfig.MultiTenancyInfo.EncapType == cns.Vxlan {
+			epInfo.Data[network.VxlanRemoteIPKey] = cnsNwConfig.MultiTenancyInfo.RemoteIPAddress
+		}
 		epInfo.Data[network.LocalIPKey] = cnsNwConfig.LocalIPConfiguration.IPSubnet.IPAddress + "/" + strconv.Itoa(int(cnsNwConfig.LocalIPConfiguration.IPSubnet.PrefixLength))
 		epInfo.Data[network.SnatBridgeIPKey] = cnsNwConfig.LocalIPConfiguration.GatewayIPAddress + "/" + strconv.Itoa(int(cnsNwConfig.LocalIPConfiguration.IPSubnet.PrefixLength))
 	}
@@ -0,0 +1,45 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/network"
+)
+
+func TestSetEndpointOptions(t *testing.T) {
+	tests := []struct {
+		multiTenancyInfo cns.MultiTenancyInfo
+		expected         map[string]interface{}
+	}{
+		{
+			multiTenancyInfo: cns.MultiTenancyInfo{EncapType: cns.Vlan, ID: 100},
+			expected:         map[string]interface{}{network.VlanIDKey: 100},
+		},
+		{
+			multiTenancyInfo: cns.MultiTenancyInfo{ID: 100},
+			expected:         map[string]interface{}{network.VlanIDKey: 100},
+		},
+		{
+			multiTenancyInfo: cns.MultiTenancyInfo{EncapType: cns.Vxlan, ID: 5000, RemoteIPAddress: "10.0.0.4"},
+			expected:         map[string]interface{}{network.VxlanIDKey: 5000, network.VxlanRemoteIPKey: "10.0.0.4"},
+		},
+	}
+
+	for _, test := range tests {
+		cnsNwConfig := &cns.GetNetworkContainerResponse{MultiTenancyInfo: test.multiTenancyInfo}
+		epInfo := &network.EndpointInfo{Data: make(map[string]interface{})}
+
+		setEndpointOptions(cnsNwConfig, epInfo, "veth")
+
+		for key, value := range test.expected {
+			if epInfo.Data[key] != value {
+				t.Errorf("Expected %v=%v for %+v but got %+v", key, value, test.multiTenancyInfo, epInfo.Data)
+			}
+		}
+
+		if _, ok := epInfo.Data[network.VxlanRemoteIPKey]; ok && test.multiTenancyInfo.EncapType != cns.Vxlan {
+			t.Errorf("Unexpected vxlan remote endpoint for %+v: %+v", test.multiTenancyInfo, epInfo.Data)
+		}
+	}
+}
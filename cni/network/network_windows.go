@@ -60,6 +60,11 @@ func handleConsecutiveAdd(containerId, endpointId string, nwInfo *network.Networ
 	return nil, err
 }
 
+// setEbtablesBackend is a dummy function for Windows platform.
+func setEbtablesBackend(nwCfg *cni.NetworkConfig) error {
+	return nil
+}
+
 func addDefaultRoute(gwIPString string, epInfo *network.EndpointInfo, result *cniTypesCurr.Result) {
 }
 
@@ -12,6 +12,7 @@ import (
 	"github.com/Azure/azure-container-networking/cnm/ipam"
 	"github.com/Azure/azure-container-networking/cnm/network"
 	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/ebtables"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/platform"
 	"github.com/Azure/azure-container-networking/store"
@@ -89,6 +90,13 @@ var args = common.ArgumentList{
 		Type:         "int",
 		DefaultValue: "",
 	},
+	{
+		Name:         common.OptEbtablesBackend,
+		Shorthand:    common.OptEbtablesBackendAlias,
+		Description:  "Set the backend of the bridge rules",
+		Type:         "string",
+		DefaultValue: ebtables.EbtablesBackend,
+	},
 	{
 		Name:         common.OptVersion,
 		Shorthand:    common.OptVersionAlias,
@@ -115,6 +123,7 @@ func main() {
 	logTarget := common.GetArg(common.OptLogTarget).(int)
 	ipamQueryUrl, _ := common.GetArg(common.OptIpamQueryUrl).(string)
 	ipamQueryInterval, _ := common.GetArg(common.OptIpamQueryInterval).(int)
+	ebtablesBackend, _ := common.GetArg(common.OptEbtablesBackend).(string)
 	vers := common.GetArg(common.OptVersion).(bool)
 
 	if vers {
@@ -169,6 +178,11 @@ func main() {
 	log.Printf("Running on %v", platform.GetOSInfo())
 	common.LogNetworkInterfaces()
 
+	if err = ebtables.SetBackend(ebtablesBackend); err != nil {
+		fmt.Printf("Failed to set ebtables backend: %v\n", err)
+		return
+	}
+
 	// Set plugin options.
 	netPlugin.SetOption(common.OptAPIServerURL, url)
 
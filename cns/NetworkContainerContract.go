@@ -4,12 +4,15 @@ import "encoding/json"
 
 // Container Network Service DNC Contract
 const (
-	SetOrchestratorType                      = "/network/setorchestratortype"
-	CreateOrUpdateNetworkContainer           = "/network/createorupdatenetworkcontainer"
-	DeleteNetworkContainer                   = "/network/deletenetworkcontainer"
-	GetNetworkContainerStatus                = "/network/getnetworkcontainerstatus"
-	GetInterfaceForContainer                 = "/network/getinterfaceforcontainer"
-	GetNetworkContainerByOrchestratorContext = "/network/getnetworkcontainerbyorchestratorcontext"
+	SetOrchestratorType                       = "/network/setorchestratortype"
+	CreateOrUpdateNetworkContainer            = "/network/createorupdatenetworkcontainer"
+	DeleteNetworkContainer                    = "/network/deletenetworkcontainer"
+	GetNetworkContainerStatus                 = "/network/getnetworkcontainerstatus"
+	GetInterfaceForContainer                  = "/network/getinterfaceforcontainer"
+	GetNetworkContainerByOrchestratorContext  = "/network/getnetworkcontainerbyorchestratorcontext"
+	GetNetworkContainersByOrchestratorContext = "/network/getnetworkcontainersbyorchestratorcontext"
+	ListNetworkContainers                     = "/network/listnetworkcontainers"
+	SyncNetworkContainers                     = "/network/syncnetworkcontainers"
 )
 
 // NetworkContainer Types
@@ -31,6 +34,20 @@ const (
 	Vxlan = "Vxlan"
 )
 
+// NetworkContainer programming states
+const (
+	NetworkContainerProgrammed = "Programmed" // Host has programmed the version requested by the VM.
+	NetworkContainerPending    = "Pending"    // Host has not yet programmed the version requested by the VM.
+)
+
+// NetworkContainer sync operations
+const (
+	SyncOperationCreate = "Create"
+	SyncOperationUpdate = "Update"
+	SyncOperationDelete = "Delete"
+	SyncOperationNone   = "None"
+)
+
 // CreateNetworkContainerRequest specifies request to create a network container or network isolation boundary.
 type CreateNetworkContainerRequest struct {
 	Version                    string
@@ -47,15 +64,22 @@ type CreateNetworkContainerRequest struct {
 }
 
 // KubernetesPodInfo is an OrchestratorContext that holds PodName and PodNamespace.
+// PodUID identifies the pod instance, so that a recreated pod does not get the network containers of the old one.
+// InterfaceName is optional and narrows lookups to a specific interface.
+// Primary marks the network container of the pod's primary interface and is ignored in lookups.
 type KubernetesPodInfo struct {
-	PodName      string
-	PodNamespace string
+	PodName       string
+	PodNamespace  string
+	PodUID        string
+	InterfaceName string
+	Primary       bool
 }
 
 // MultiTenancyInfo contains encap type and id.
 type MultiTenancyInfo struct {
-	EncapType string
-	ID        int // This can be vlanid, vxlanid, gre-key etc. (depends on EnacapType).
+	EncapType       string
+	ID              int    // This can be vlanid, vxlanid, gre-key etc. (depends on EnacapType).
+	RemoteIPAddress string // Remote tunnel endpoint, required when EncapType is Vxlan.
 }
 
 // IPConfiguration contains details about ip config to provision in the VM.
@@ -98,6 +122,7 @@ type GetNetworkContainerStatusResponse struct {
 	NetworkContainerid string
 	Version            string
 	AzureHostVersion   string
+	State              string
 	Response           Response
 }
 
@@ -109,6 +134,7 @@ type GetNetworkContainerRequest struct {
 
 // GetNetworkContainerResponse describes the response to retrieve a specifc network container.
 type GetNetworkContainerResponse struct {
+	NetworkContainerID         string
 	IPConfiguration            IPConfiguration
 	Routes                     []Route
 	CnetAddressSpace           []IPSubnet
@@ -118,6 +144,12 @@ type GetNetworkContainerResponse struct {
 	Response                   Response
 }
 
+// GetNetworkContainersResponse describes the response to retrieve all network containers of an orchestrator context.
+type GetNetworkContainersResponse struct {
+	NetworkContainers []GetNetworkContainerResponse
+	Response          Response
+}
+
 // DeleteNetworkContainerRequest specifies the details about the request to delete a specifc network container.
 type DeleteNetworkContainerRequest struct {
 	NetworkContainerid string
@@ -147,3 +179,39 @@ type NetworkInterface struct {
 	Name      string
 	IPAddress string
 }
+
+// NetworkContainerInfo describes a network container held by CNS.
+type NetworkContainerInfo struct {
+	NetworkContainerid   string
+	NetworkContainerType string
+	Version              string
+	AzureHostVersion     string
+	State                string
+}
+
+// ListNetworkContainersResponse describes the response to enumerate all network containers.
+type ListNetworkContainersResponse struct {
+	NetworkContainers []NetworkContainerInfo
+	Response          Response
+}
+
+// SyncNetworkContainersRequest specifies the complete set of network containers that should exist on the node.
+// Network containers not in the set are deleted. An empty set is rejected unless DeleteAll is set.
+// The sync is applied atomically: if any network container fails, the changes already made are rolled back.
+type SyncNetworkContainersRequest struct {
+	NetworkContainers []CreateNetworkContainerRequest
+	DeleteAll         bool
+}
+
+// SyncNetworkContainerResult describes the outcome of syncing a single network container.
+type SyncNetworkContainerResult struct {
+	NetworkContainerid string
+	Operation          string
+	Response           Response
+}
+
+// SyncNetworkContainersResponse describes the response to sync network containers.
+type SyncNetworkContainersResponse struct {
+	Results  []SyncNetworkContainerResult
+	Response Response
+}
@@ -18,6 +18,9 @@ const (
 	V2Prefix                    = "/v0.2"
 )
 
+// RequestIDHeader is the HTTP header that carries the ID correlating a request with its response.
+const RequestIDHeader = "X-Request-ID"
+
 // SetEnvironmentRequest describes the Request to set the environment in CNS.
 type SetEnvironmentRequest struct {
 	Location    string
@@ -99,6 +102,13 @@ type Response struct {
 	Message    string
 }
 
+// Error describes the error returned by CNS when a request fails.
+type Error struct {
+	ReturnCode int
+	Message    string
+	RequestID  string
+}
+
 // OptionMap describes generic options that can be passed to CNS.
 type OptionMap map[string]interface{}
 
@@ -3,6 +3,7 @@ package cnsclient
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -56,24 +57,32 @@ func (cnsClient *CNSClient) GetNetworkConfiguration(orchestratorContext []byte)
 
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		errMsg := fmt.Sprintf("[Azure CNSClient] GetNetworkConfiguration invalid http status code: %v", res.StatusCode)
-		log.Printf(errMsg)
-		return nil, fmt.Errorf(errMsg)
+	var resp struct {
+		cns.GetNetworkContainerResponse
+		Error *cns.Error
 	}
 
-	var resp cns.GetNetworkContainerResponse
-
 	err = json.NewDecoder(res.Body).Decode(&resp)
 	if err != nil {
+		if res.StatusCode != http.StatusOK {
+			log.Printf("[Azure CNSClient] GetNetworkConfiguration invalid http status code: %v", res.StatusCode)
+			return nil, fmt.Errorf("[Azure CNSClient] GetNetworkConfiguration invalid http status code: %v", res.StatusCode)
+		}
+
 		log.Printf("[Azure CNSClient] Error received while parsing GetNetworkConfiguration response resp:%v err:%v", res.Body, err.Error())
 		return nil, err
 	}
 
+	if resp.Error != nil {
+		log.Printf("[Azure CNSClient] GetNetworkConfiguration received error response status:%v request:%v :%v",
+			res.StatusCode, resp.Error.RequestID, resp.Error.Message)
+		return nil, errors.New(resp.Error.Message)
+	}
+
 	if resp.Response.ReturnCode != 0 {
 		log.Printf("[Azure CNSClient] GetNetworkConfiguration received error response :%v", resp.Response.Message)
-		return nil, fmt.Errorf(resp.Response.Message)
+		return nil, errors.New(resp.Response.Message)
 	}
 
-	return &resp, nil
+	return &resp.GetNetworkContainerResponse, nil
 }
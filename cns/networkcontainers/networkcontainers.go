@@ -4,10 +4,6 @@
 package networkcontainers
 
 import (
-	"errors"
-	"fmt"
-	"net"
-
 	"github.com/Azure/azure-container-networking/cns"
 	"github.com/Azure/azure-container-networking/log"
 )
@@ -17,18 +13,6 @@ type NetworkContainers struct {
 	logpath string
 }
 
-func interfaceExists(iFaceName string) (bool, error) {
-	_, err := net.InterfaceByName(iFaceName)
-
-	if err != nil {
-		errMsg := fmt.Sprintf("[Azure CNS] Unable to get interface by name %v, %v", iFaceName, err)
-		log.Printf(errMsg)
-		return false, errors.New(errMsg)
-	}
-
-	return true, nil
-}
-
 // Create creates a network container.
 func (cn *NetworkContainers) Create(createNetworkContainerRequest cns.CreateNetworkContainerRequest) error {
 	log.Printf("[Azure CNS] NetworkContainers.Create called")
@@ -5,6 +5,11 @@ package networkcontainers
 
 import "github.com/Azure/azure-container-networking/cns"
 
+// Exists reports network containers as present, as their interfaces aren't created on Linux.
+func (cn *NetworkContainers) Exists(networkContainerID string) (bool, error) {
+	return true, nil
+}
+
 func createOrUpdateInterface(createNetworkContainerRequest cns.CreateNetworkContainerRequest) error {
 	return nil
 }
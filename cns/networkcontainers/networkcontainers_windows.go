@@ -16,6 +16,28 @@ import (
 	"github.com/Azure/azure-container-networking/log"
 )
 
+// interfaceExists checks whether an interface is present. A missing interface is not an error,
+// so that it can be polled without logging.
+func interfaceExists(iFaceName string) (bool, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return false, err
+	}
+
+	for _, iface := range interfaces {
+		if iface.Name == iFaceName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Exists checks whether the interface backing a network container is present on the VM.
+func (cn *NetworkContainers) Exists(networkContainerID string) (bool, error) {
+	return interfaceExists(networkContainerID)
+}
+
 func createOrUpdateInterface(createNetworkContainerRequest cns.CreateNetworkContainerRequest) error {
 	exists, _ := interfaceExists(createNetworkContainerRequest.NetworkContainerid)
 
@@ -0,0 +1,194 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// bufferedResponseWriter holds a handler's response so that the HTTP status can be derived from its return code.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+// WriteHeader records the status code set by the handler.
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+// Write buffers the response body written by the handler.
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// getHTTPStatus maps a CNS return code to an HTTP status code.
+func getHTTPStatus(returnCode int) int {
+	switch returnCode {
+	case Success:
+		return http.StatusOK
+	case UnsupportedNetworkType,
+		InvalidParameter,
+		MalformedSubnet,
+		UnspecifiedNetworkName,
+		NetworkContainerNotSpecified,
+		UnsupportedOrchestratorType:
+		return http.StatusBadRequest
+	case ReservationNotFound,
+		NotFound,
+		UnknownContainerID:
+		return http.StatusNotFound
+	case AddressUnavailable:
+		return http.StatusConflict
+	case UnsupportedEnvironment:
+		return http.StatusPreconditionFailed
+	case UnreachableHost,
+		UnreachableDockerDaemon,
+		CallToHostFailed:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// newRequestID generates an ID that correlates a request with its response and logs.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// getReturnCode extracts the return code and message from a response body.
+// Responses either are a cns.Response or embed one in a field named Response.
+func getReturnCode(body []byte) (int, string, error) {
+	var resp struct {
+		ReturnCode int
+		Message    string
+		Response   *cns.Response
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, "", err
+	}
+
+	if resp.Response != nil {
+		return resp.Response.ReturnCode, resp.Response.Message, nil
+	}
+
+	return resp.ReturnCode, resp.Message, nil
+}
+
+// addRoute registers a handler for the path and its versioned equivalent.
+// Requests with other methods are rejected, request bodies are validated, and
+// responses carry an HTTP status and a structured error derived from their return code.
+func (service *httpRestService) addRoute(path string, handler func(http.ResponseWriter, *http.Request), methods ...string) {
+	h := service.newHandler(handler, methods...)
+	service.Listener.AddHandler(path, h)
+	service.Listener.AddHandler(cns.V2Prefix+path, h)
+}
+
+// newHandler wraps a CNS handler with method enforcement, body validation and error mapping.
+func (service *httpRestService) newHandler(handler func(http.ResponseWriter, *http.Request), methods ...string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(cns.RequestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(cns.RequestIDHeader, requestID)
+		log.Printf("[Azure CNS] Request %v %v %v", requestID, r.Method, r.URL.Path)
+
+		allowed := false
+		for _, method := range methods {
+			if r.Method == method {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			message := fmt.Sprintf("[Azure CNS] Error. %v does not support %v.", r.URL.Path, r.Method)
+			service.sendError(w, http.StatusMethodNotAllowed, nil, InvalidParameter, message, requestID)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			var body []byte
+			var err error
+
+			if r.Body != nil {
+				body, err = ioutil.ReadAll(r.Body)
+				r.Body.Close()
+			}
+
+			if err != nil || len(bytes.TrimSpace(body)) == 0 || !json.Valid(body) {
+				message := "[Azure CNS] Error. Request body is not a valid JSON document."
+				service.sendError(w, http.StatusBadRequest, nil, InvalidParameter, message, requestID)
+				return
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		bw := &bufferedResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		handler(bw, r)
+
+		body := bw.body.Bytes()
+		returnCode, message, err := getReturnCode(body)
+		if err != nil {
+			// The handler failed before producing a CNS response, e.g. while decoding the request.
+			returnCode = UnexpectedError
+			if bw.statusCode == http.StatusBadRequest {
+				returnCode = InvalidParameter
+			}
+
+			service.sendError(w, bw.statusCode, nil, returnCode, strings.TrimSpace(string(body)), requestID)
+			return
+		}
+
+		if returnCode == Success {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(bw.statusCode)
+			w.Write(body)
+			return
+		}
+
+		service.sendError(w, getHTTPStatus(returnCode), body, returnCode, message, requestID)
+	}
+}
+
+// sendError sends a response with a structured error, preserving the fields of the original response if any.
+func (service *httpRestService) sendError(w http.ResponseWriter, statusCode int, body []byte, returnCode int, message string, requestID string) {
+	resp := make(map[string]interface{})
+	if body != nil {
+		json.Unmarshal(body, &resp)
+	}
+
+	resp["Error"] = cns.Error{
+		ReturnCode: returnCode,
+		Message:    message,
+		RequestID:  requestID,
+	}
+
+	log.Printf("[Azure CNS] Request %v failed with status %v return code %v: %v", requestID, statusCode, returnCode, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Separator between the components of an orchestrator context key.
+	// Kubernetes namespace and pod names cannot contain this character, which keeps keys collision-free.
+	orchestratorContextKeySeparator = "/"
+)
+
+// getOrchestratorContextKey returns the key under which network containers of a pod instance are indexed.
+// Network containers saved without a pod UID are indexed under an empty UID.
+func getOrchestratorContextKey(podInfo cns.KubernetesPodInfo) string {
+	return getPodKeyPrefix(podInfo) + podInfo.PodUID
+}
+
+// getPodKeyPrefix returns the prefix of the keys of all instances of a pod.
+func getPodKeyPrefix(podInfo cns.KubernetesPodInfo) string {
+	return podInfo.PodNamespace + orchestratorContextKeySeparator + podInfo.PodName + orchestratorContextKeySeparator
+}
+
+// podInfoMatches returns whether a network container saved for savedPodInfo satisfies a lookup for podInfo.
+// The interface name is only compared when both sides specify it.
+func podInfoMatches(podInfo cns.KubernetesPodInfo, savedPodInfo cns.KubernetesPodInfo) bool {
+	return podInfo.InterfaceName == "" || savedPodInfo.InterfaceName == "" || podInfo.InterfaceName == savedPodInfo.InterfaceName
+}
+
+// addOrchestratorContext associates a network container with an orchestrator context.
+// A network container that is already associated with the context keeps its position.
+// This function should only be called when service is locked.
+func (service *httpRestService) addOrchestratorContext(key string, networkContainerID string) {
+	for _, containerID := range service.state.ContainerIDsByOrchestratorContext[key] {
+		if containerID == networkContainerID {
+			return
+		}
+	}
+
+	// A network container belongs to exactly one orchestrator context.
+	service.removeOrchestratorContext(networkContainerID)
+
+	if service.state.ContainerIDsByOrchestratorContext == nil {
+		service.state.ContainerIDsByOrchestratorContext = make(map[string][]string)
+	}
+
+	service.state.ContainerIDsByOrchestratorContext[key] = append(service.state.ContainerIDsByOrchestratorContext[key], networkContainerID)
+}
+
+// removeOrchestratorContext removes a network container from its orchestrator context.
+// This function should only be called when service is locked.
+func (service *httpRestService) removeOrchestratorContext(networkContainerID string) {
+	for key, containerIDs := range service.state.ContainerIDsByOrchestratorContext {
+		for i, containerID := range containerIDs {
+			if containerID != networkContainerID {
+				continue
+			}
+
+			containerIDs = append(containerIDs[:i], containerIDs[i+1:]...)
+			if len(containerIDs) == 0 {
+				delete(service.state.ContainerIDsByOrchestratorContext, key)
+			} else {
+				service.state.ContainerIDsByOrchestratorContext[key] = containerIDs
+			}
+
+			break
+		}
+	}
+}
+
+// getPodInstanceContainerIDs returns the IDs of network containers of the pod instance a lookup refers to.
+// A lookup without a pod UID refers to the network containers saved without one, or else to the only
+// instance of the pod. It fails if several instances of the pod have network containers, so that a
+// recreated pod never gets the network containers of its predecessor.
+// This function should only be called when service is locked.
+func (service *httpRestService) getPodInstanceContainerIDs(podInfo cns.KubernetesPodInfo) ([]string, error) {
+	if containerIDs, ok := service.state.ContainerIDsByOrchestratorContext[getOrchestratorContextKey(podInfo)]; ok || podInfo.PodUID != "" {
+		return containerIDs, nil
+	}
+
+	var containerIDs []string
+	instances := 0
+
+	prefix := getPodKeyPrefix(podInfo)
+	for key, ids := range service.state.ContainerIDsByOrchestratorContext {
+		if strings.HasPrefix(key, prefix) {
+			containerIDs = ids
+			instances++
+		}
+	}
+
+	if instances > 1 {
+		return nil, fmt.Errorf("Network containers exist for %d instances of pod %v, PodUID is required", instances, prefix)
+	}
+
+	return containerIDs, nil
+}
+
+// getContainerIDsByOrchestratorContext returns the IDs of network containers that belong to a pod instance.
+// The network container of the pod's primary interface comes first, followed by the others ordered by interface name.
+// This function should only be called when service is locked.
+func (service *httpRestService) getContainerIDsByOrchestratorContext(podInfo cns.KubernetesPodInfo) ([]string, error) {
+	podContainerIDs, err := service.getPodInstanceContainerIDs(podInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var containerIDs []string
+	savedPodInfos := make(map[string]cns.KubernetesPodInfo)
+
+	for _, containerID := range podContainerIDs {
+		containerDetails, ok := service.state.ContainerStatus[containerID]
+		if !ok {
+			continue
+		}
+
+		var savedPodInfo cns.KubernetesPodInfo
+		err := json.Unmarshal(containerDetails.CreateNetworkContainerRequest.OrchestratorContext, &savedPodInfo)
+		if err != nil {
+			log.Printf("[Azure CNS] Unmarshalling orchestrator context of %v failed with error %v", containerID, err)
+			continue
+		}
+
+		if podInfoMatches(podInfo, savedPodInfo) {
+			containerIDs = append(containerIDs, containerID)
+			savedPodInfos[containerID] = savedPodInfo
+		}
+	}
+
+	sort.SliceStable(containerIDs, func(i, j int) bool {
+		podInfoI, podInfoJ := savedPodInfos[containerIDs[i]], savedPodInfos[containerIDs[j]]
+		if podInfoI.Primary != podInfoJ.Primary {
+			return podInfoI.Primary
+		}
+		return podInfoI.InterfaceName < podInfoJ.InterfaceName
+	})
+
+	return containerIDs, nil
+}
+
+// rebuildOrchestratorContexts rebuilds the orchestrator context index from saved network containers.
+// This also migrates state persisted before network containers were indexed by pod instance.
+func (service *httpRestService) rebuildOrchestratorContexts() {
+	switch service.state.OrchestratorType {
+	case cns.Kubernetes, cns.ServiceFabric:
+	default:
+		return
+	}
+
+	service.state.ContainerIDsByOrchestratorContext = make(map[string][]string)
+
+	// Add network containers in a stable order, the lookups order them by interface.
+	var containerIDs []string
+	for containerID := range service.state.ContainerStatus {
+		containerIDs = append(containerIDs, containerID)
+	}
+	sort.Strings(containerIDs)
+
+	for _, containerID := range containerIDs {
+		req := service.state.ContainerStatus[containerID].CreateNetworkContainerRequest
+		if req.NetworkContainerType != cns.AzureContainerInstance &&
+			req.NetworkContainerType != cns.ClearContainer {
+			continue
+		}
+
+		var podInfo cns.KubernetesPodInfo
+		err := json.Unmarshal(req.OrchestratorContext, &podInfo)
+		if err != nil {
+			log.Printf("[Azure CNS] Unmarshalling orchestrator context of %v failed with error %v", containerID, err)
+			continue
+		}
+
+		service.addOrchestratorContext(getOrchestratorContextKey(podInfo), containerID)
+	}
+
+	log.Printf("[Azure CNS] Rebuilt orchestrator contexts %+v", service.state.ContainerIDsByOrchestratorContext)
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package restserver
+
+import (
+	"time"
+
+	"github.com/Azure/azure-container-networking/cns"
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Interval at which network containers are reconciled against the host.
+	networkContainerReconcileInterval = 30 * time.Second
+)
+
+// getNetworkContainerState returns whether the host has programmed the version of the network container requested by the VM.
+func getNetworkContainerState(containerStatus containerstatus) string {
+	if containerStatus.HostVersion != "" && containerStatus.HostVersion == containerStatus.VMVersion {
+		return cns.NetworkContainerProgrammed
+	}
+
+	return cns.NetworkContainerPending
+}
+
+// startNetworkContainerReconciler starts the background reconciliation of network containers.
+func (service *httpRestService) startNetworkContainerReconciler() {
+	service.stopReconcilerChan = make(chan struct{})
+
+	go func(stopCh <-chan struct{}) {
+		ticker := time.NewTicker(networkContainerReconcileInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				service.reconcileNetworkContainers()
+			case <-stopCh:
+				log.Printf("[Azure CNS] Network container reconciler stopped.")
+				return
+			}
+		}
+	}(service.stopReconcilerChan)
+}
+
+// stopNetworkContainerReconciler stops the background reconciliation of network containers.
+func (service *httpRestService) stopNetworkContainerReconciler() {
+	if service.stopReconcilerChan != nil {
+		close(service.stopReconcilerChan)
+		service.stopReconcilerChan = nil
+	}
+}
+
+// reconcileNetworkContainers compares the goal state of every network container with the
+// version programmed on the host and recreates local interfaces that have diverged.
+func (service *httpRestService) reconcileNetworkContainers() {
+	// Take a snapshot so that calls to the host are not made while holding the lock.
+	service.lock.Lock()
+	var containers []containerstatus
+	for _, containerStatus := range service.state.ContainerStatus {
+		containers = append(containers, containerStatus)
+	}
+	service.lock.Unlock()
+
+	for _, containerStatus := range containers {
+		req := containerStatus.CreateNetworkContainerRequest
+
+		var hostVersion string
+		containerVersion, err := service.imdsClient.GetNetworkContainerInfoFromHost(
+			req.NetworkContainerid,
+			req.PrimaryInterfaceIdentifier,
+			req.AuthorizationToken, swiftAPIVersion)
+		if err != nil {
+			log.Printf("[Azure CNS] Failed to query host for network container %v, err:%v.", req.NetworkContainerid, err)
+		} else {
+			hostVersion = containerVersion.ProgrammedVersion
+		}
+
+		service.reconcileNetworkContainer(req.NetworkContainerid, req.Version, hostVersion)
+	}
+}
+
+// reconcileNetworkContainer records the version of a network container programmed on the host, and
+// recreates its local interface if the interface is missing or the host has drifted from the goal state.
+// Network containers are only created, updated and deleted under ncLock, so the goal state checked
+// here stays current while the interface is recreated without holding the lock. Network containers
+// that were updated or deleted while the host was being queried are skipped.
+func (service *httpRestService) reconcileNetworkContainer(networkContainerID string, vmVersion string, hostVersion string) {
+	service.ncLock.Lock()
+	defer service.ncLock.Unlock()
+
+	service.lock.Lock()
+	containerStatus, ok := service.state.ContainerStatus[networkContainerID]
+	service.lock.Unlock()
+
+	if !ok || containerStatus.VMVersion != vmVersion {
+		return
+	}
+
+	// The host version is only recorded once the interface was reapplied, so that failed reapplies are retried.
+	if containerStatus.CreateNetworkContainerRequest.NetworkContainerType == cns.WebApps {
+		if err := service.reconcileInterface(containerStatus, hostVersion); err != nil {
+			return
+		}
+	}
+
+	service.lock.Lock()
+	service.setHostVersion(networkContainerID, containerStatus, hostVersion)
+	service.lock.Unlock()
+}
+
+// reconcileInterface recreates the local interface of a WebApps network container if it is missing,
+// or reapplies it if the host changed to a version other than the goal state.
+func (service *httpRestService) reconcileInterface(containerStatus containerstatus, hostVersion string) error {
+	req := containerStatus.CreateNetworkContainerRequest
+	networkContainerID := req.NetworkContainerid
+
+	exists, err := service.networkContainer.Exists(networkContainerID)
+	if err != nil {
+		log.Printf("[Azure CNS] Failed to look up interface for network container %v, err:%v.", networkContainerID, err)
+		return err
+	}
+
+	switch {
+	case !exists:
+		log.Printf("[Azure CNS] Interface for network container %v not found, recreating.", networkContainerID)
+	case hostVersion != "" && hostVersion != containerStatus.HostVersion && hostVersion != containerStatus.VMVersion:
+		log.Printf("[Azure CNS] Host programmed version %v of network container %v but the goal is version %v, reapplying.",
+			hostVersion, networkContainerID, containerStatus.VMVersion)
+	default:
+		return nil
+	}
+
+	if err = service.networkContainer.Update(req); err != nil {
+		log.Printf("[Azure CNS] Failed to recreate network container %v, err:%v.", networkContainerID, err)
+		return err
+	}
+
+	return nil
+}
+
+// setHostVersion records the version of a network container programmed on the host.
+// The caller must hold the lock.
+func (service *httpRestService) setHostVersion(networkContainerID string, containerStatus containerstatus, hostVersion string) {
+	if hostVersion == "" || containerStatus.HostVersion == hostVersion {
+		return
+	}
+
+	containerStatus.HostVersion = hostVersion
+	service.state.ContainerStatus[networkContainerID] = containerStatus
+
+	log.Printf("[Azure CNS] Network container %v is %v, VM version %v host version %v.",
+		networkContainerID, getNetworkContainerState(containerStatus), containerStatus.VMVersion, hostVersion)
+
+	service.saveState()
+}
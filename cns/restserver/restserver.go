@@ -32,14 +32,16 @@ const (
 // httpRestService represents http listener for CNS - Container Networking Service.
 type httpRestService struct {
 	*cns.Service
-	dockerClient     *dockerclient.DockerClient
-	imdsClient       *imdsclient.ImdsClient
-	ipamClient       *ipamclient.IpamClient
-	networkContainer *networkcontainers.NetworkContainers
-	routingTable     *routes.RoutingTable
-	store            store.KeyValueStore
-	state            *httpRestServiceState
-	lock             sync.Mutex
+	dockerClient       *dockerclient.DockerClient
+	imdsClient         *imdsclient.ImdsClient
+	ipamClient         *ipamclient.IpamClient
+	networkContainer   *networkcontainers.NetworkContainers
+	routingTable       *routes.RoutingTable
+	store              store.KeyValueStore
+	state              *httpRestServiceState
+	lock               sync.Mutex
+	ncLock             sync.Mutex
+	stopReconcilerChan chan struct{}
 }
 
 // containerstatus is used to save status of an existing container
@@ -52,14 +54,14 @@ type containerstatus struct {
 
 // httpRestServiceState contains the state we would like to persist.
 type httpRestServiceState struct {
-	Location                         string
-	NetworkType                      string
-	OrchestratorType                 string
-	Initialized                      bool
-	ContainerIDByOrchestratorContext map[string]string          // OrchestratorContext is key and value is NetworkContainerID.
-	ContainerStatus                  map[string]containerstatus // NetworkContainerID is key.
-	Networks                         map[string]*networkInfo
-	TimeStamp                        time.Time
+	Location                          string
+	NetworkType                       string
+	OrchestratorType                  string
+	Initialized                       bool
+	ContainerIDsByOrchestratorContext map[string][]string        // OrchestratorContext is key and value is list of NetworkContainerIDs.
+	ContainerStatus                   map[string]containerstatus // NetworkContainerID is key.
+	Networks                          map[string]*networkInfo
+	TimeStamp                         time.Time
 }
 
 type networkInfo struct {
@@ -131,39 +133,27 @@ func (service *httpRestService) Start(config *common.ServiceConfig) error {
 		return err
 	}
 
-	// Add handlers.
-	listener := service.Listener
-	// default handlers
-	listener.AddHandler(cns.SetEnvironmentPath, service.setEnvironment)
-	listener.AddHandler(cns.CreateNetworkPath, service.createNetwork)
-	listener.AddHandler(cns.DeleteNetworkPath, service.deleteNetwork)
-	listener.AddHandler(cns.ReserveIPAddressPath, service.reserveIPAddress)
-	listener.AddHandler(cns.ReleaseIPAddressPath, service.releaseIPAddress)
-	listener.AddHandler(cns.GetHostLocalIPPath, service.getHostLocalIP)
-	listener.AddHandler(cns.GetIPAddressUtilizationPath, service.getIPAddressUtilization)
-	listener.AddHandler(cns.GetUnhealthyIPAddressesPath, service.getUnhealthyIPAddresses)
-	listener.AddHandler(cns.CreateOrUpdateNetworkContainer, service.createOrUpdateNetworkContainer)
-	listener.AddHandler(cns.DeleteNetworkContainer, service.deleteNetworkContainer)
-	listener.AddHandler(cns.GetNetworkContainerStatus, service.getNetworkContainerStatus)
-	listener.AddHandler(cns.GetInterfaceForContainer, service.getInterfaceForContainer)
-	listener.AddHandler(cns.SetOrchestratorType, service.setOrchestratorType)
-	listener.AddHandler(cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContext)
-
-	// handlers for v0.2
-	listener.AddHandler(cns.V2Prefix+cns.SetEnvironmentPath, service.setEnvironment)
-	listener.AddHandler(cns.V2Prefix+cns.CreateNetworkPath, service.createNetwork)
-	listener.AddHandler(cns.V2Prefix+cns.DeleteNetworkPath, service.deleteNetwork)
-	listener.AddHandler(cns.V2Prefix+cns.ReserveIPAddressPath, service.reserveIPAddress)
-	listener.AddHandler(cns.V2Prefix+cns.ReleaseIPAddressPath, service.releaseIPAddress)
-	listener.AddHandler(cns.V2Prefix+cns.GetHostLocalIPPath, service.getHostLocalIP)
-	listener.AddHandler(cns.V2Prefix+cns.GetIPAddressUtilizationPath, service.getIPAddressUtilization)
-	listener.AddHandler(cns.V2Prefix+cns.GetUnhealthyIPAddressesPath, service.getUnhealthyIPAddresses)
-	listener.AddHandler(cns.V2Prefix+cns.CreateOrUpdateNetworkContainer, service.createOrUpdateNetworkContainer)
-	listener.AddHandler(cns.V2Prefix+cns.DeleteNetworkContainer, service.deleteNetworkContainer)
-	listener.AddHandler(cns.V2Prefix+cns.GetNetworkContainerStatus, service.getNetworkContainerStatus)
-	listener.AddHandler(cns.V2Prefix+cns.GetInterfaceForContainer, service.getInterfaceForContainer)
-	listener.AddHandler(cns.V2Prefix+cns.SetOrchestratorType, service.setOrchestratorType)
-	listener.AddHandler(cns.V2Prefix+cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContext)
+	// Add handlers for both the default and the v0.2 paths.
+	service.addRoute(cns.SetEnvironmentPath, service.setEnvironment, http.MethodPost)
+	service.addRoute(cns.CreateNetworkPath, service.createNetwork, http.MethodPost)
+	service.addRoute(cns.DeleteNetworkPath, service.deleteNetwork, http.MethodPost)
+	service.addRoute(cns.ReserveIPAddressPath, service.reserveIPAddress, http.MethodPost)
+	service.addRoute(cns.ReleaseIPAddressPath, service.releaseIPAddress, http.MethodPost)
+	service.addRoute(cns.GetHostLocalIPPath, service.getHostLocalIP, http.MethodGet)
+	service.addRoute(cns.GetIPAddressUtilizationPath, service.getIPAddressUtilization, http.MethodGet)
+	service.addRoute(cns.GetUnhealthyIPAddressesPath, service.getUnhealthyIPAddresses, http.MethodGet)
+	service.addRoute(cns.CreateOrUpdateNetworkContainer, service.createOrUpdateNetworkContainer, http.MethodPost)
+	service.addRoute(cns.DeleteNetworkContainer, service.deleteNetworkContainer, http.MethodPost)
+	service.addRoute(cns.GetNetworkContainerStatus, service.getNetworkContainerStatus, http.MethodPost)
+	service.addRoute(cns.GetInterfaceForContainer, service.getInterfaceForContainer, http.MethodPost)
+	service.addRoute(cns.SetOrchestratorType, service.setOrchestratorType, http.MethodPost)
+	service.addRoute(cns.GetNetworkContainerByOrchestratorContext, service.getNetworkContainerByOrchestratorContext, http.MethodPost)
+	service.addRoute(cns.GetNetworkContainersByOrchestratorContext, service.getNetworkContainersByOrchestratorContext, http.MethodPost)
+	service.addRoute(cns.ListNetworkContainers, service.listNetworkContainers, http.MethodGet)
+	service.addRoute(cns.SyncNetworkContainers, service.syncNetworkContainers, http.MethodPost)
+
+	// Start reconciling network containers against the host.
+	service.startNetworkContainerReconciler()
 
 	log.Printf("[Azure CNS]  Listening.")
 	return nil
@@ -171,6 +161,7 @@ func (service *httpRestService) Start(config *common.ServiceConfig) error {
 
 // Stop stops the CNS.
 func (service *httpRestService) Stop() {
+	service.stopNetworkContainerReconciler()
 	service.Uninitialize()
 	log.Printf("[Azure CNS]  Service stopped.")
 }
@@ -187,15 +178,11 @@ func (service *httpRestService) setEnvironment(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	switch r.Method {
-	case "POST":
-		log.Printf("[Azure CNS]  POST received for SetEnvironment.")
-		service.state.Location = req.Location
-		service.state.NetworkType = req.NetworkType
-		service.state.Initialized = true
-		service.saveState()
-	default:
-	}
+	log.Printf("[Azure CNS]  POST received for SetEnvironment.")
+	service.state.Location = req.Location
+	service.state.NetworkType = req.NetworkType
+	service.state.Initialized = true
+	service.saveState()
 
 	resp := &cns.Response{ReturnCode: 0}
 	err = service.Listener.Encode(w, &resp)
@@ -220,71 +207,64 @@ func (service *httpRestService) createNetwork(w http.ResponseWriter, r *http.Req
 			returnMessage = fmt.Sprintf("[Azure CNS] Error. Unable to decode input request.")
 			returnCode = InvalidParameter
 		} else {
-			switch r.Method {
-			case "POST":
-				dc := service.dockerClient
-				rt := service.routingTable
-				err = dc.NetworkExists(req.NetworkName)
+			dc := service.dockerClient
+			rt := service.routingTable
+			err = dc.NetworkExists(req.NetworkName)
 
-				// Network does not exist.
-				if err != nil {
-					switch service.state.NetworkType {
-					case "Underlay":
-						switch service.state.Location {
-						case "Azure":
-							log.Printf("[Azure CNS] Goign to create network with name %v.", req.NetworkName)
-
-							err = rt.GetRoutingTable()
-							if err != nil {
-								// We should not fail the call to create network for this.
-								// This is because restoring routes is a fallback mechanism in case
-								// network driver is not behaving as expected.
-								// The responsibility to restore routes is with network driver.
-								log.Printf("[Azure CNS] Unable to get routing table from node, %+v.", err.Error())
-							}
-
-							nicInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromHost()
-							if err != nil {
-								returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryInterfaceInfoFromHost failed %v.", err.Error())
-								returnCode = UnexpectedError
-								break
-							}
-
-							err = dc.CreateNetwork(req.NetworkName, nicInfo, req.Options)
-							if err != nil {
-								returnMessage = fmt.Sprintf("[Azure CNS] Error. CreateNetwork failed %v.", err.Error())
-								returnCode = UnexpectedError
-							}
-
-							err = rt.RestoreRoutingTable()
-							if err != nil {
-								log.Printf("[Azure CNS] Unable to restore routing table on node, %+v.", err.Error())
-							}
-
-							networkInfo := &networkInfo{
-								NetworkName: req.NetworkName,
-								NicInfo:     nicInfo,
-								Options:     req.Options,
-							}
-
-							service.state.Networks[req.NetworkName] = networkInfo
-
-						case "StandAlone":
-							returnMessage = fmt.Sprintf("[Azure CNS] Error. Underlay network is not supported in StandAlone environment. %v.", err.Error())
-							returnCode = UnsupportedEnvironment
+			// Network does not exist.
+			if err != nil {
+				switch service.state.NetworkType {
+				case "Underlay":
+					switch service.state.Location {
+					case "Azure":
+						log.Printf("[Azure CNS] Goign to create network with name %v.", req.NetworkName)
+
+						err = rt.GetRoutingTable()
+						if err != nil {
+							// We should not fail the call to create network for this.
+							// This is because restoring routes is a fallback mechanism in case
+							// network driver is not behaving as expected.
+							// The responsibility to restore routes is with network driver.
+							log.Printf("[Azure CNS] Unable to get routing table from node, %+v.", err.Error())
+						}
+
+						nicInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromHost()
+						if err != nil {
+							returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryInterfaceInfoFromHost failed %v.", err.Error())
+							returnCode = UnexpectedError
+							break
 						}
-					case "Overlay":
-						returnMessage = fmt.Sprintf("[Azure CNS] Error. Overlay support not yet available. %v.", err.Error())
+
+						err = dc.CreateNetwork(req.NetworkName, nicInfo, req.Options)
+						if err != nil {
+							returnMessage = fmt.Sprintf("[Azure CNS] Error. CreateNetwork failed %v.", err.Error())
+							returnCode = UnexpectedError
+						}
+
+						err = rt.RestoreRoutingTable()
+						if err != nil {
+							log.Printf("[Azure CNS] Unable to restore routing table on node, %+v.", err.Error())
+						}
+
+						networkInfo := &networkInfo{
+							NetworkName: req.NetworkName,
+							NicInfo:     nicInfo,
+							Options:     req.Options,
+						}
+
+						service.state.Networks[req.NetworkName] = networkInfo
+
+					case "StandAlone":
+						returnMessage = fmt.Sprintf("[Azure CNS] Error. Underlay network is not supported in StandAlone environment. %v.", err.Error())
 						returnCode = UnsupportedEnvironment
 					}
-				} else {
-					returnMessage = fmt.Sprintf("[Azure CNS] Received a request to create an already existing network %v", req.NetworkName)
-					log.Printf(returnMessage)
+				case "Overlay":
+					returnMessage = fmt.Sprintf("[Azure CNS] Error. Overlay support not yet available. %v.", err.Error())
+					returnCode = UnsupportedEnvironment
 				}
-
-			default:
-				returnMessage = "[Azure CNS] Error. CreateNetwork did not receive a POST."
-				returnCode = InvalidParameter
+			} else {
+				returnMessage = fmt.Sprintf("[Azure CNS] Received a request to create an already existing network %v", req.NetworkName)
+				log.Printf(returnMessage)
 			}
 		}
 
@@ -321,31 +301,24 @@ func (service *httpRestService) deleteNetwork(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	switch r.Method {
-	case "POST":
-		dc := service.dockerClient
-		err := dc.NetworkExists(req.NetworkName)
-
-		// Network does exist
-		if err == nil {
-			log.Printf("[Azure CNS] Goign to delete network with name %v.", req.NetworkName)
-			err := dc.DeleteNetwork(req.NetworkName)
-			if err != nil {
-				returnMessage = fmt.Sprintf("[Azure CNS] Error. DeleteNetwork failed %v.", err.Error())
-				returnCode = UnexpectedError
-			}
+	dc := service.dockerClient
+	err = dc.NetworkExists(req.NetworkName)
+
+	// Network does exist
+	if err == nil {
+		log.Printf("[Azure CNS] Goign to delete network with name %v.", req.NetworkName)
+		err := dc.DeleteNetwork(req.NetworkName)
+		if err != nil {
+			returnMessage = fmt.Sprintf("[Azure CNS] Error. DeleteNetwork failed %v.", err.Error())
+			returnCode = UnexpectedError
+		}
+	} else {
+		if err == fmt.Errorf("Network not found") {
+			log.Printf("[Azure CNS] Received a request to delete network that does not exist: %v.", req.NetworkName)
 		} else {
-			if err == fmt.Errorf("Network not found") {
-				log.Printf("[Azure CNS] Received a request to delete network that does not exist: %v.", req.NetworkName)
-			} else {
-				returnCode = UnexpectedError
-				returnMessage = err.Error()
-			}
+			returnCode = UnexpectedError
+			returnMessage = err.Error()
 		}
-
-	default:
-		returnMessage = "[Azure CNS] Error. DeleteNetwork did not receive a POST."
-		returnCode = InvalidParameter
 	}
 
 	resp := &cns.Response{
@@ -363,6 +336,80 @@ func (service *httpRestService) deleteNetwork(w http.ResponseWriter, r *http.Req
 	log.Response(service.Name, resp, err)
 }
 
+// getPoolID returns the ID of the IPAM pool for the subnet of the primary interface.
+func (service *httpRestService) getPoolID() (string, int, string) {
+	ic := service.ipamClient
+
+	ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
+	if err != nil {
+		return "", UnexpectedError, fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
+	}
+
+	asID, err := ic.GetAddressSpace()
+	if err != nil {
+		return "", UnexpectedError, fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
+	}
+
+	poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
+	if err != nil {
+		return "", UnexpectedError, fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
+	}
+
+	return poolID, 0, ""
+}
+
+// reserveIPAddressInPool reserves an IP address for a reservation ID and returns the address.
+func (service *httpRestService) reserveIPAddressInPool(reservationID string) (string, int, string) {
+	poolID, returnCode, returnMessage := service.getPoolID()
+	if returnCode != 0 {
+		return "", returnCode, returnMessage
+	}
+
+	addr, err := service.ipamClient.ReserveIPAddress(poolID, reservationID)
+	if err != nil {
+		return "", AddressUnavailable, fmt.Sprintf("[Azure CNS] ReserveIpAddress failed with %+v", err.Error())
+	}
+
+	addressIP, _, err := net.ParseCIDR(addr)
+	if err != nil {
+		return "", UnexpectedError, fmt.Sprintf("[Azure CNS] ParseCIDR failed with %+v", err.Error())
+	}
+
+	return addressIP.String(), 0, ""
+}
+
+// releaseIPAddressInPool releases the IP address reserved for a reservation ID.
+func (service *httpRestService) releaseIPAddressInPool(reservationID string) (int, string) {
+	poolID, returnCode, returnMessage := service.getPoolID()
+	if returnCode != 0 {
+		return returnCode, returnMessage
+	}
+
+	err := service.ipamClient.ReleaseIPAddress(poolID, reservationID)
+	if err != nil {
+		return ReservationNotFound, fmt.Sprintf("[Azure CNS] ReleaseIpAddress failed with %+v", err.Error())
+	}
+
+	return 0, ""
+}
+
+// getPoolIPAddressUtilization returns the capacity, available and unhealthy IP addresses of the pool.
+func (service *httpRestService) getPoolIPAddressUtilization() (int, int, []string, int, string) {
+	poolID, returnCode, returnMessage := service.getPoolID()
+	if returnCode != 0 {
+		return 0, 0, nil, returnCode, returnMessage
+	}
+
+	capacity, available, unhealthyAddrs, err := service.ipamClient.GetIPAddressUtilization(poolID)
+	if err != nil {
+		return 0, 0, nil, UnexpectedError, fmt.Sprintf("[Azure CNS] Error. GetIPUtilization failed %v", err.Error())
+	}
+
+	log.Printf("[Azure CNS] Capacity %v Available %v UnhealthyAddrs %v", capacity, available, unhealthyAddrs)
+
+	return capacity, available, unhealthyAddrs, 0, ""
+}
+
 // Handles ip reservation requests.
 func (service *httpRestService) reserveIPAddress(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[Azure CNS] reserveIPAddress")
@@ -370,8 +417,6 @@ func (service *httpRestService) reserveIPAddress(w http.ResponseWriter, r *http.
 	var req cns.ReserveIPAddressRequest
 	returnMessage := ""
 	returnCode := 0
-	addr := ""
-	address := ""
 	err := service.Listener.Decode(w, r, &req)
 
 	log.Request(service.Name, &req, err)
@@ -385,50 +430,10 @@ func (service *httpRestService) reserveIPAddress(w http.ResponseWriter, r *http.
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. ReservationId is empty")
 	}
 
-	switch r.Method {
-	case "POST":
-		ic := service.ipamClient
-
-		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		asID, err := ic.GetAddressSpace()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		addr, err = ic.ReserveIPAddress(poolID, req.ReservationID)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] ReserveIpAddress failed with %+v", err.Error())
-			returnCode = AddressUnavailable
-			break
-		}
-
-		addressIP, _, err := net.ParseCIDR(addr)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] ParseCIDR failed with %+v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-		address = addressIP.String()
-
-	default:
-		returnMessage = "[Azure CNS] Error. ReserveIP did not receive a POST."
-		returnCode = InvalidParameter
-
+	address, code, message := service.reserveIPAddressInPool(req.ReservationID)
+	if code != 0 {
+		returnCode = code
+		returnMessage = message
 	}
 
 	resp := cns.Response{
@@ -461,40 +466,9 @@ func (service *httpRestService) releaseIPAddress(w http.ResponseWriter, r *http.
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. ReservationId is empty")
 	}
 
-	switch r.Method {
-	case "POST":
-		ic := service.ipamClient
-
-		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		asID, err := ic.GetAddressSpace()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		err = ic.ReleaseIPAddress(poolID, req.ReservationID)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] ReleaseIpAddress failed with %+v", err.Error())
-			returnCode = ReservationNotFound
-		}
-
-	default:
-		returnMessage = "[Azure CNS] Error. ReleaseIP did not receive a POST."
-		returnCode = InvalidParameter
+	if code, message := service.releaseIPAddressInPool(req.ReservationID); code != 0 {
+		returnCode = code
+		returnMessage = message
 	}
 
 	resp := cns.Response{
@@ -517,26 +491,20 @@ func (service *httpRestService) getHostLocalIP(w http.ResponseWriter, r *http.Re
 	hostLocalIP := "0.0.0.0"
 
 	if service.state.Initialized {
-		switch r.Method {
-		case "GET":
-			switch service.state.NetworkType {
-			case "Underlay":
-				if service.imdsClient != nil {
-					piface, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
-					if err == nil {
-						hostLocalIP = piface.PrimaryIP
-						found = true
-					} else {
-						log.Printf("[Azure-CNS] Received error from GetPrimaryInterfaceInfoFromMemory. err: %v", err.Error())
-					}
+		switch service.state.NetworkType {
+		case "Underlay":
+			if service.imdsClient != nil {
+				piface, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
+				if err == nil {
+					hostLocalIP = piface.PrimaryIP
+					found = true
+				} else {
+					log.Printf("[Azure-CNS] Received error from GetPrimaryInterfaceInfoFromMemory. err: %v", err.Error())
 				}
-
-			case "Overlay":
-				errmsg = "[Azure-CNS] Overlay is not yet supported."
 			}
 
-		default:
-			errmsg = "[Azure-CNS] GetHostLocalIP API expects a GET."
+		case "Overlay":
+			errmsg = "[Azure-CNS] Overlay is not yet supported."
 		}
 	}
 
@@ -564,49 +532,7 @@ func (service *httpRestService) getIPAddressUtilization(w http.ResponseWriter, r
 	log.Printf("[Azure CNS] getIPAddressUtilization")
 	log.Request(service.Name, "getIPAddressUtilization", nil)
 
-	returnMessage := ""
-	returnCode := 0
-	capacity := 0
-	available := 0
-	var unhealthyAddrs []string
-
-	switch r.Method {
-	case "GET":
-		ic := service.ipamClient
-
-		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		asID, err := ic.GetAddressSpace()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		capacity, available, unhealthyAddrs, err = ic.GetIPAddressUtilization(poolID)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetIPUtilization failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-		log.Printf("[Azure CNS] Capacity %v Available %v UnhealthyAddrs %v", capacity, available, unhealthyAddrs)
-
-	default:
-		returnMessage = "[Azure CNS] Error. GetIPUtilization did not receive a GET."
-		returnCode = InvalidParameter
-	}
+	capacity, available, unhealthyAddrs, returnCode, returnMessage := service.getPoolIPAddressUtilization()
 
 	resp := cns.Response{
 		ReturnCode: returnCode,
@@ -664,49 +590,7 @@ func (service *httpRestService) getUnhealthyIPAddresses(w http.ResponseWriter, r
 	log.Printf("[Azure CNS] getUnhealthyIPAddresses")
 	log.Request(service.Name, "getUnhealthyIPAddresses", nil)
 
-	returnMessage := ""
-	returnCode := 0
-	capacity := 0
-	available := 0
-	var unhealthyAddrs []string
-
-	switch r.Method {
-	case "GET":
-		ic := service.ipamClient
-
-		ifInfo, err := service.imdsClient.GetPrimaryInterfaceInfoFromMemory()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPrimaryIfaceInfo failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		asID, err := ic.GetAddressSpace()
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetAddressSpace failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		poolID, err := ic.GetPoolID(asID, ifInfo.Subnet)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetPoolID failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-
-		capacity, available, unhealthyAddrs, err = ic.GetIPAddressUtilization(poolID)
-		if err != nil {
-			returnMessage = fmt.Sprintf("[Azure CNS] Error. GetIPUtilization failed %v", err.Error())
-			returnCode = UnexpectedError
-			break
-		}
-		log.Printf("[Azure CNS] Capacity %v Available %v UnhealthyAddrs %v", capacity, available, unhealthyAddrs)
-
-	default:
-		returnMessage = "[Azure CNS] Error. GetUnhealthyIP did not receive a POST."
-		returnCode = InvalidParameter
-	}
+	_, _, unhealthyAddrs, returnCode, returnMessage := service.getPoolIPAddressUtilization()
 
 	resp := cns.Response{
 		ReturnCode: returnCode,
@@ -801,6 +685,10 @@ func (service *httpRestService) restoreState() error {
 		return err
 	}
 
+	// The orchestrator context index is derived from the saved network containers, so it is rebuilt
+	// to migrate state persisted with older keys.
+	service.rebuildOrchestratorContexts()
+
 	log.Printf("[Azure CNS]  Restored state, %+v\n", service.state)
 	return nil
 }
@@ -874,11 +762,7 @@ func (service *httpRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 
 			log.Printf("Pod info %v", podInfo)
 
-			if service.state.ContainerIDByOrchestratorContext == nil {
-				service.state.ContainerIDByOrchestratorContext = make(map[string]string)
-			}
-
-			service.state.ContainerIDByOrchestratorContext[podInfo.PodName+podInfo.PodNamespace] = req.NetworkContainerid
+			service.addOrchestratorContext(getOrchestratorContextKey(podInfo), req.NetworkContainerid)
 			break
 
 		default:
@@ -890,6 +774,26 @@ func (service *httpRestService) saveNetworkContainerGoalState(req cns.CreateNetw
 	return 0, ""
 }
 
+// applyNetworkContainer creates or updates the network container and saves its goal state.
+func (service *httpRestService) applyNetworkContainer(req cns.CreateNetworkContainerRequest) (int, string) {
+	if req.NetworkContainerType == cns.WebApps {
+		// try to get the saved nc state if it exists
+		service.lock.Lock()
+		existing, ok := service.state.ContainerStatus[req.NetworkContainerid]
+		service.lock.Unlock()
+
+		// create/update nc only if it doesn't exist or it exists and the requested version is different from the saved version
+		if !ok || (ok && existing.VMVersion != req.Version) {
+			nc := service.networkContainer
+			if err := nc.Create(req); err != nil {
+				return UnexpectedError, fmt.Sprintf("[Azure CNS] Error. CreateOrUpdateNetworkContainer failed %v", err.Error())
+			}
+		}
+	}
+
+	return service.saveNetworkContainerGoalState(req)
+}
+
 func (service *httpRestService) createOrUpdateNetworkContainer(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[Azure CNS] createOrUpdateNetworkContainer")
 
@@ -908,31 +812,9 @@ func (service *httpRestService) createOrUpdateNetworkContainer(w http.ResponseWr
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. NetworkContainerid is empty")
 	}
 
-	switch r.Method {
-	case "POST":
-		if req.NetworkContainerType == cns.WebApps {
-			// try to get the saved nc state if it exists
-			service.lock.Lock()
-			existing, ok := service.state.ContainerStatus[req.NetworkContainerid]
-			service.lock.Unlock()
-
-			// create/update nc only if it doesn't exist or it exists and the requested version is different from the saved version
-			if !ok || (ok && existing.VMVersion != req.Version) {
-				nc := service.networkContainer
-				if err = nc.Create(req); err != nil {
-					returnMessage = fmt.Sprintf("[Azure CNS] Error. CreateOrUpdateNetworkContainer failed %v", err.Error())
-					returnCode = UnexpectedError
-					break
-				}
-			}
-		}
-
-		returnCode, returnMessage = service.saveNetworkContainerGoalState(req)
-
-	default:
-		returnMessage = "[Azure CNS] Error. CreateOrUpdateNetworkContainer did not receive a POST."
-		returnCode = InvalidParameter
-	}
+	service.ncLock.Lock()
+	returnCode, returnMessage = service.applyNetworkContainer(req)
+	service.ncLock.Unlock()
 
 	resp := cns.Response{
 		ReturnCode: returnCode,
@@ -968,9 +850,9 @@ func (service *httpRestService) getNetworkContainerByID(w http.ResponseWriter, r
 	log.Response(service.Name, reserveResp, err)
 }
 
-func (service *httpRestService) getNetworkContainerResponse(req cns.GetNetworkContainerRequest) cns.GetNetworkContainerResponse {
-	var containerID string
-	var getNetworkContainerResponse cns.GetNetworkContainerResponse
+func (service *httpRestService) getNetworkContainerResponses(req cns.GetNetworkContainerRequest) ([]cns.GetNetworkContainerResponse, int, string) {
+	var containerIDs []string
+	var getNetworkContainerResponses []cns.GetNetworkContainerResponse
 
 	service.lock.Lock()
 	defer service.lock.Unlock()
@@ -980,41 +862,54 @@ func (service *httpRestService) getNetworkContainerResponse(req cns.GetNetworkCo
 		var podInfo cns.KubernetesPodInfo
 		err := json.Unmarshal(req.OrchestratorContext, &podInfo)
 		if err != nil {
-			getNetworkContainerResponse.Response.ReturnCode = UnexpectedError
-			getNetworkContainerResponse.Response.Message = fmt.Sprintf("Unmarshalling orchestrator context failed with error %v", err)
-			return getNetworkContainerResponse
+			return nil, UnexpectedError, fmt.Sprintf("Unmarshalling orchestrator context failed with error %v", err)
 		}
 
 		log.Printf("pod info %+v", podInfo)
-		containerID = service.state.ContainerIDByOrchestratorContext[podInfo.PodName+podInfo.PodNamespace]
-		log.Printf("containerid %v", containerID)
+		containerIDs, err = service.getContainerIDsByOrchestratorContext(podInfo)
+		if err != nil {
+			return nil, InvalidParameter, err.Error()
+		}
+
+		log.Printf("containerids %v", containerIDs)
 		break
 
 	default:
-		getNetworkContainerResponse.Response.ReturnCode = UnsupportedOrchestratorType
-		getNetworkContainerResponse.Response.Message = fmt.Sprintf("Invalid orchestrator type %v", service.state.OrchestratorType)
-		return getNetworkContainerResponse
+		return nil, UnsupportedOrchestratorType, fmt.Sprintf("Invalid orchestrator type %v", service.state.OrchestratorType)
 	}
 
-	containerStatus := service.state.ContainerStatus
-	containerDetails, ok := containerStatus[containerID]
-	if !ok {
-		getNetworkContainerResponse.Response.ReturnCode = UnknownContainerID
-		getNetworkContainerResponse.Response.Message = "NetworkContainer doesn't exist."
-		return getNetworkContainerResponse
+	if len(containerIDs) == 0 {
+		return nil, UnknownContainerID, "NetworkContainer doesn't exist."
+	}
+
+	for _, containerID := range containerIDs {
+		savedReq := service.state.ContainerStatus[containerID].CreateNetworkContainerRequest
+		getNetworkContainerResponses = append(getNetworkContainerResponses, cns.GetNetworkContainerResponse{
+			NetworkContainerID:         savedReq.NetworkContainerid,
+			IPConfiguration:            savedReq.IPConfiguration,
+			Routes:                     savedReq.Routes,
+			CnetAddressSpace:           savedReq.CnetAddressSpace,
+			MultiTenancyInfo:           savedReq.MultiTenancyInfo,
+			PrimaryInterfaceIdentifier: savedReq.PrimaryInterfaceIdentifier,
+			LocalIPConfiguration:       savedReq.LocalIPConfiguration,
+		})
 	}
 
-	savedReq := containerDetails.CreateNetworkContainerRequest
-	getNetworkContainerResponse = cns.GetNetworkContainerResponse{
-		IPConfiguration:            savedReq.IPConfiguration,
-		Routes:                     savedReq.Routes,
-		CnetAddressSpace:           savedReq.CnetAddressSpace,
-		MultiTenancyInfo:           savedReq.MultiTenancyInfo,
-		PrimaryInterfaceIdentifier: savedReq.PrimaryInterfaceIdentifier,
-		LocalIPConfiguration:       savedReq.LocalIPConfiguration,
+	return getNetworkContainerResponses, 0, ""
+}
+
+func (service *httpRestService) getNetworkContainerResponse(req cns.GetNetworkContainerRequest) cns.GetNetworkContainerResponse {
+	var getNetworkContainerResponse cns.GetNetworkContainerResponse
+
+	getNetworkContainerResponses, returnCode, returnMessage := service.getNetworkContainerResponses(req)
+	if returnCode != 0 {
+		getNetworkContainerResponse.Response.ReturnCode = returnCode
+		getNetworkContainerResponse.Response.Message = returnMessage
+		return getNetworkContainerResponse
 	}
 
-	return getNetworkContainerResponse
+	// Network containers are ordered with the pod's primary first.
+	return getNetworkContainerResponses[0]
 }
 
 func (service *httpRestService) getNetworkContainerByOrchestratorContext(w http.ResponseWriter, r *http.Request) {
@@ -1034,6 +929,62 @@ func (service *httpRestService) getNetworkContainerByOrchestratorContext(w http.
 	log.Response(service.Name, getNetworkContainerResponse, err)
 }
 
+// removeNetworkContainer deletes the network container and its goal state.
+func (service *httpRestService) removeNetworkContainer(networkContainerID string) (int, string) {
+	service.lock.Lock()
+	containerStatus, ok := service.state.ContainerStatus[networkContainerID]
+	service.lock.Unlock()
+
+	if !ok {
+		log.Printf("Not able to retrieve network container details for this container id %v", networkContainerID)
+		return 0, ""
+	}
+
+	if containerStatus.CreateNetworkContainerRequest.NetworkContainerType == cns.WebApps {
+		nc := service.networkContainer
+		if err := nc.Delete(networkContainerID); err != nil {
+			return UnexpectedError, fmt.Sprintf("[Azure CNS] Error. DeleteNetworkContainer failed %v", err.Error())
+		}
+	}
+
+	service.lock.Lock()
+	defer service.lock.Unlock()
+
+	if service.state.ContainerStatus != nil {
+		delete(service.state.ContainerStatus, networkContainerID)
+	}
+
+	service.removeOrchestratorContext(networkContainerID)
+
+	service.saveState()
+	return 0, ""
+}
+
+func (service *httpRestService) getNetworkContainersByOrchestratorContext(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] getNetworkContainersByOrchestratorContext")
+
+	var req cns.GetNetworkContainerRequest
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	getNetworkContainerResponses, returnCode, returnMessage := service.getNetworkContainerResponses(req)
+
+	getNetworkContainersResponse := cns.GetNetworkContainersResponse{
+		NetworkContainers: getNetworkContainerResponses,
+		Response: cns.Response{
+			ReturnCode: returnCode,
+			Message:    returnMessage,
+		},
+	}
+
+	err = service.Listener.Encode(w, &getNetworkContainersResponse)
+	log.Response(service.Name, getNetworkContainersResponse, err)
+}
+
 func (service *httpRestService) deleteNetworkContainer(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[Azure CNS] deleteNetworkContainer")
 
@@ -1052,51 +1003,9 @@ func (service *httpRestService) deleteNetworkContainer(w http.ResponseWriter, r
 		returnMessage = fmt.Sprintf("[Azure CNS] Error. NetworkContainerid is empty")
 	}
 
-	switch r.Method {
-	case "POST":
-		var containerStatus containerstatus
-		var ok bool
-
-		service.lock.Lock()
-		containerStatus, ok = service.state.ContainerStatus[req.NetworkContainerid]
-		service.lock.Unlock()
-
-		if !ok {
-			log.Printf("Not able to retrieve network container details for this container id %v", req.NetworkContainerid)
-			break
-		}
-
-		if containerStatus.CreateNetworkContainerRequest.NetworkContainerType == cns.WebApps {
-			nc := service.networkContainer
-			if err := nc.Delete(req.NetworkContainerid); err != nil {
-				returnMessage = fmt.Sprintf("[Azure CNS] Error. DeleteNetworkContainer failed %v", err.Error())
-				returnCode = UnexpectedError
-				break
-			}
-		}
-
-		service.lock.Lock()
-		defer service.lock.Unlock()
-
-		if service.state.ContainerStatus != nil {
-			delete(service.state.ContainerStatus, req.NetworkContainerid)
-		}
-
-		if service.state.ContainerIDByOrchestratorContext != nil {
-			for orchestratorContext, networkContainerID := range service.state.ContainerIDByOrchestratorContext {
-				if networkContainerID == req.NetworkContainerid {
-					delete(service.state.ContainerIDByOrchestratorContext, orchestratorContext)
-					break
-				}
-			}
-		}
-
-		service.saveState()
-		break
-	default:
-		returnMessage = "[Azure CNS] Error. DeleteNetworkContainer did not receive a POST."
-		returnCode = InvalidParameter
-	}
+	service.ncLock.Lock()
+	returnCode, returnMessage = service.removeNetworkContainer(req.NetworkContainerid)
+	service.ncLock.Unlock()
 
 	resp := cns.Response{
 		ReturnCode: returnCode,
@@ -1136,6 +1045,7 @@ func (service *httpRestService) getNetworkContainerStatus(w http.ResponseWriter,
 
 	var hostVersion string
 	var vmVersion string
+	var state string
 
 	if ok {
 		savedReq := containerDetails.CreateNetworkContainerRequest
@@ -1147,9 +1057,16 @@ func (service *httpRestService) getNetworkContainerStatus(w http.ResponseWriter,
 		if err != nil {
 			returnCode = CallToHostFailed
 			returnMessage = err.Error()
-		} else {
-			hostVersion = containerVersion.ProgrammedVersion
+		} else if containerDetails.HostVersion != containerVersion.ProgrammedVersion {
+			containerDetails.HostVersion = containerVersion.ProgrammedVersion
+			containerInfo[req.NetworkContainerid] = containerDetails
+			service.saveState()
 		}
+
+		// Fall back to the version last seen by the reconciler if the host could not be reached.
+		hostVersion = containerDetails.HostVersion
+		vmVersion = containerDetails.VMVersion
+		state = getNetworkContainerState(containerDetails)
 	} else {
 		returnMessage = "[Azure CNS] Never received call to create this container."
 		returnCode = UnknownContainerID
@@ -1165,6 +1082,7 @@ func (service *httpRestService) getNetworkContainerStatus(w http.ResponseWriter,
 		NetworkContainerid: req.NetworkContainerid,
 		AzureHostVersion:   hostVersion,
 		Version:            vmVersion,
+		State:              state,
 	}
 
 	err = service.Listener.Encode(w, &networkContainerStatusReponse)
@@ -1226,6 +1144,196 @@ func (service *httpRestService) getInterfaceForContainer(w http.ResponseWriter,
 	log.Response(service.Name, getInterfaceForContainerResponse, err)
 }
 
+// Handles requests to enumerate all network containers.
+func (service *httpRestService) listNetworkContainers(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] listNetworkContainers")
+	log.Request(service.Name, "listNetworkContainers", nil)
+
+	returnMessage := ""
+	returnCode := 0
+	networkContainers := []cns.NetworkContainerInfo{}
+
+	service.lock.Lock()
+	for _, containerStatus := range service.state.ContainerStatus {
+		networkContainers = append(networkContainers, cns.NetworkContainerInfo{
+			NetworkContainerid:   containerStatus.ID,
+			NetworkContainerType: containerStatus.CreateNetworkContainerRequest.NetworkContainerType,
+			Version:              containerStatus.VMVersion,
+			AzureHostVersion:     containerStatus.HostVersion,
+			State:                getNetworkContainerState(containerStatus),
+		})
+	}
+	service.lock.Unlock()
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	listResp := &cns.ListNetworkContainersResponse{
+		NetworkContainers: networkContainers,
+		Response:          resp,
+	}
+
+	err := service.Listener.Encode(w, &listResp)
+
+	log.Response(service.Name, listResp, err)
+}
+
+// Handles requests to replace the set of network containers on the node.
+func (service *httpRestService) syncNetworkContainers(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[Azure CNS] syncNetworkContainers")
+
+	var req cns.SyncNetworkContainersRequest
+	returnMessage := ""
+	returnCode := 0
+	var results []cns.SyncNetworkContainerResult
+
+	err := service.Listener.Decode(w, r, &req)
+	log.Request(service.Name, &req, err)
+	if err != nil {
+		return
+	}
+
+	results, returnCode, returnMessage = service.syncNetworkContainerGoalStates(req.NetworkContainers, req.DeleteAll)
+
+	resp := cns.Response{
+		ReturnCode: returnCode,
+		Message:    returnMessage,
+	}
+
+	syncResp := &cns.SyncNetworkContainersResponse{
+		Results:  results,
+		Response: resp,
+	}
+
+	err = service.Listener.Encode(w, &syncResp)
+
+	log.Response(service.Name, syncResp, err)
+}
+
+// syncNetworkContainerGoalStates creates, updates and deletes network containers so that
+// the node holds exactly the requested set. The request is validated before any change is made,
+// and the changes are rolled back if any network container fails to sync.
+func (service *httpRestService) syncNetworkContainerGoalStates(reqs []cns.CreateNetworkContainerRequest, deleteAll bool) ([]cns.SyncNetworkContainerResult, int, string) {
+	if len(reqs) == 0 && !deleteAll {
+		return nil, InvalidParameter, "[Azure CNS] Error. NetworkContainers is empty and DeleteAll is not set"
+	}
+
+	if len(reqs) != 0 && deleteAll {
+		return nil, InvalidParameter, "[Azure CNS] Error. DeleteAll is set but NetworkContainers is not empty"
+	}
+
+	desired := make(map[string]bool)
+	for _, req := range reqs {
+		if req.NetworkContainerid == "" {
+			return nil, NetworkContainerNotSpecified, "[Azure CNS] Error. NetworkContainerid is empty"
+		}
+
+		if desired[req.NetworkContainerid] {
+			return nil, InvalidParameter, fmt.Sprintf("[Azure CNS] Error. Duplicate NetworkContainerid %v", req.NetworkContainerid)
+		}
+
+		desired[req.NetworkContainerid] = true
+	}
+
+	// Hold the network container lock for the whole sync so that it is not interleaved
+	// with create and delete requests or the reconciler.
+	service.ncLock.Lock()
+	defer service.ncLock.Unlock()
+
+	service.lock.Lock()
+	existing := make(map[string]containerstatus)
+	for networkContainerID, containerStatus := range service.state.ContainerStatus {
+		existing[networkContainerID] = containerStatus
+	}
+	service.lock.Unlock()
+
+	var results []cns.SyncNetworkContainerResult
+
+	for _, req := range reqs {
+		result := cns.SyncNetworkContainerResult{NetworkContainerid: req.NetworkContainerid}
+
+		containerStatus, ok := existing[req.NetworkContainerid]
+		switch {
+		case !ok:
+			result.Operation = cns.SyncOperationCreate
+		case containerStatus.VMVersion != req.Version:
+			result.Operation = cns.SyncOperationUpdate
+		default:
+			result.Operation = cns.SyncOperationNone
+		}
+
+		if result.Operation != cns.SyncOperationNone {
+			result.Response.ReturnCode, result.Response.Message = service.applyNetworkContainer(req)
+		}
+
+		results = append(results, result)
+
+		if result.Response.ReturnCode != 0 {
+			return service.rollbackNetworkContainers(results, existing)
+		}
+	}
+
+	for networkContainerID := range existing {
+		if desired[networkContainerID] {
+			continue
+		}
+
+		result := cns.SyncNetworkContainerResult{
+			NetworkContainerid: networkContainerID,
+			Operation:          cns.SyncOperationDelete,
+		}
+
+		result.Response.ReturnCode, result.Response.Message = service.removeNetworkContainer(networkContainerID)
+
+		results = append(results, result)
+
+		if result.Response.ReturnCode != 0 {
+			return service.rollbackNetworkContainers(results, existing)
+		}
+	}
+
+	return results, 0, ""
+}
+
+// rollbackNetworkContainers reverts the changes of a failed sync in reverse order, including
+// the partial change of the network container that failed. The caller must hold the network container lock.
+func (service *httpRestService) rollbackNetworkContainers(results []cns.SyncNetworkContainerResult, existing map[string]containerstatus) ([]cns.SyncNetworkContainerResult, int, string) {
+	failed := results[len(results)-1]
+	log.Printf("[Azure CNS] Failed to sync network container %v, rolling back: %v", failed.NetworkContainerid, failed.Response.Message)
+
+	rollbackFailures := 0
+
+	for i := len(results) - 1; i >= 0; i-- {
+		var returnCode int
+		var returnMessage string
+
+		switch results[i].Operation {
+		case cns.SyncOperationCreate:
+			returnCode, returnMessage = service.removeNetworkContainer(results[i].NetworkContainerid)
+		case cns.SyncOperationUpdate, cns.SyncOperationDelete:
+			returnCode, returnMessage = service.applyNetworkContainer(existing[results[i].NetworkContainerid].CreateNetworkContainerRequest)
+		default:
+			continue
+		}
+
+		if returnCode != 0 {
+			log.Printf("[Azure CNS] Failed to roll back %v of network container %v: %v",
+				results[i].Operation, results[i].NetworkContainerid, returnMessage)
+			rollbackFailures++
+		}
+	}
+
+	if rollbackFailures != 0 {
+		return results, UnexpectedError, fmt.Sprintf("[Azure CNS] Error. Failed to sync network container %v and to roll back %d changes",
+			failed.NetworkContainerid, rollbackFailures)
+	}
+
+	return results, UnexpectedError, fmt.Sprintf("[Azure CNS] Error. Failed to sync network container %v, changes were rolled back",
+		failed.NetworkContainerid)
+}
+
 // restoreNetworkState restores Network state that existed before reboot.
 func (service *httpRestService) restoreNetworkState() error {
 	log.Printf("[Azure CNS] Enter Restoring Network State")
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/cns"
@@ -54,16 +55,26 @@ func TestMain(m *testing.M) {
 }
 
 // Decodes service's responses to test requests.
+// Failed requests are expected to carry a structured error along with the response.
 func decodeResponse(w *httptest.ResponseRecorder, response interface{}) error {
-	if w.Code != http.StatusOK {
-		return fmt.Errorf("Request failed with HTTP error %d", w.Code)
-	}
-
 	if w.Result().Body == nil {
 		return fmt.Errorf("Response body is empty")
 	}
 
-	return json.NewDecoder(w.Body).Decode(&response)
+	body := w.Body.Bytes()
+
+	if w.Code != http.StatusOK {
+		var resp struct {
+			Error *cns.Error
+		}
+
+		err := json.Unmarshal(body, &resp)
+		if err != nil || resp.Error == nil {
+			return fmt.Errorf("Request failed with HTTP error %d and no error object", w.Code)
+		}
+	}
+
+	return json.Unmarshal(body, &response)
 }
 
 func setEnv(t *testing.T) *httptest.ResponseRecorder {
@@ -412,8 +423,8 @@ func getNonExistNetworkCotnainerByContext(t *testing.T, name string) error {
 	mux.ServeHTTP(w, req)
 
 	err = decodeResponse(w, &resp)
-	if err != nil || resp.Response.ReturnCode != UnknownContainerID {
-		t.Errorf("GetNetworkContainerByContext unexpected response %+v Err:%+v", resp, err)
+	if err != nil || resp.Response.ReturnCode != UnknownContainerID || w.Code != http.StatusNotFound {
+		t.Errorf("GetNetworkContainerByContext unexpected response %+v status %v Err:%+v", resp, w.Code, err)
 		t.Fatal(err)
 	}
 
@@ -604,3 +615,355 @@ func TestGetInterfaceForNetworkContainer(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestReconcileNetworkContainerHostVersion(t *testing.T) {
+	fmt.Println("Test: TestReconcileNetworkContainerHostVersion")
+
+	setEnv(t)
+
+	err := creatOrUpdateNetworkContainerWithName(t, "ethWebApp", "11.0.0.5", "AzureContainerInstance")
+	if err != nil {
+		t.Errorf("creatOrUpdateNetworkContainerWithName failed Err:%+v", err)
+		t.Fatal(err)
+	}
+
+	svc := service.(*httpRestService)
+	if state := getNetworkContainerState(svc.state.ContainerStatus["ethWebApp"]); state != cns.NetworkContainerPending {
+		t.Errorf("Expected network container to be %v but was %v", cns.NetworkContainerPending, state)
+	}
+
+	// A host version observed for a stale goal state must be ignored.
+	svc.reconcileNetworkContainer("ethWebApp", "0.0", "0.1")
+	if state := getNetworkContainerState(svc.state.ContainerStatus["ethWebApp"]); state != cns.NetworkContainerPending {
+		t.Errorf("Expected network container to be %v but was %v", cns.NetworkContainerPending, state)
+	}
+
+	// A host version that differs from the goal state leaves the network container pending.
+	svc.reconcileNetworkContainer("ethWebApp", "0.1", "0.2")
+	if state := getNetworkContainerState(svc.state.ContainerStatus["ethWebApp"]); state != cns.NetworkContainerPending {
+		t.Errorf("Expected network container to be %v but was %v", cns.NetworkContainerPending, state)
+	}
+
+	svc.reconcileNetworkContainer("ethWebApp", "0.1", "0.1")
+	if state := getNetworkContainerState(svc.state.ContainerStatus["ethWebApp"]); state != cns.NetworkContainerProgrammed {
+		t.Errorf("Expected network container to be %v but was %v", cns.NetworkContainerProgrammed, state)
+	}
+
+	err = deleteNetworkAdapterWithName(t, "ethWebApp")
+	if err != nil {
+		t.Errorf("Deleting interface failed Err:%+v", err)
+		t.Fatal(err)
+	}
+}
+
+func syncNetworkContainers(t *testing.T, syncReq cns.SyncNetworkContainersRequest) cns.SyncNetworkContainersResponse {
+	var body bytes.Buffer
+	var resp cns.SyncNetworkContainersResponse
+
+	json.NewEncoder(&body).Encode(&syncReq)
+	req, err := http.NewRequest(http.MethodPost, cns.SyncNetworkContainers, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	err = decodeResponse(w, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("SyncNetworkContainers responded with %+v\n", resp)
+	return resp
+}
+
+func listNetworkContainers(t *testing.T) cns.ListNetworkContainersResponse {
+	var resp cns.ListNetworkContainersResponse
+
+	req, err := http.NewRequest(http.MethodGet, cns.ListNetworkContainers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	err = decodeResponse(w, &resp)
+	if err != nil || resp.Response.ReturnCode != 0 {
+		t.Fatalf("ListNetworkContainers failed with response %+v Err:%+v", resp, err)
+	}
+
+	fmt.Printf("ListNetworkContainers responded with %+v\n", resp)
+	return resp
+}
+
+func TestSyncNetworkContainers(t *testing.T) {
+	fmt.Println("Test: TestSyncNetworkContainers")
+
+	setEnv(t)
+
+	nc := func(name string, version string) cns.CreateNetworkContainerRequest {
+		return cns.CreateNetworkContainerRequest{
+			Version:              version,
+			NetworkContainerType: cns.AzureContainerInstance,
+			NetworkContainerid:   name,
+		}
+	}
+
+	sync := func(reqs ...cns.CreateNetworkContainerRequest) cns.SyncNetworkContainersResponse {
+		return syncNetworkContainers(t, cns.SyncNetworkContainersRequest{NetworkContainers: reqs})
+	}
+
+	versions := func() map[string]string {
+		result := make(map[string]string)
+		for _, info := range listNetworkContainers(t).NetworkContainers {
+			result[info.NetworkContainerid] = info.Version
+		}
+		return result
+	}
+
+	resp := sync(nc("nc1", "1"), nc("nc2", "1"))
+	if resp.Response.ReturnCode != 0 || len(resp.Results) != 2 {
+		t.Fatalf("SyncNetworkContainers failed with response %+v", resp)
+	}
+
+	resp = sync(nc("nc2", "2"), nc("nc3", "1"))
+	if resp.Response.ReturnCode != 0 {
+		t.Fatalf("SyncNetworkContainers failed with response %+v", resp)
+	}
+
+	expected := map[string]string{
+		"nc1": cns.SyncOperationDelete,
+		"nc2": cns.SyncOperationUpdate,
+		"nc3": cns.SyncOperationCreate,
+	}
+	for _, result := range resp.Results {
+		if expected[result.NetworkContainerid] != result.Operation {
+			t.Errorf("Expected %v for %v but got %v", expected[result.NetworkContainerid], result.NetworkContainerid, result.Operation)
+		}
+	}
+
+	if list := versions(); !reflect.DeepEqual(list, map[string]string{"nc2": "2", "nc3": "1"}) {
+		t.Errorf("Unexpected network containers %+v", list)
+	}
+
+	// Invalid requests must not change the set of network containers.
+	resp = sync(nc("nc4", "1"), nc("nc4", "1"))
+	if resp.Response.ReturnCode != InvalidParameter {
+		t.Errorf("Expected duplicate network containers to be rejected but got %+v", resp)
+	}
+
+	for _, syncReq := range []cns.SyncNetworkContainersRequest{
+		{},
+		{NetworkContainers: []cns.CreateNetworkContainerRequest{}},
+		{NetworkContainers: []cns.CreateNetworkContainerRequest{nc("nc4", "1")}, DeleteAll: true},
+	} {
+		resp = syncNetworkContainers(t, syncReq)
+		if resp.Response.ReturnCode != InvalidParameter {
+			t.Errorf("Expected %+v to be rejected but got %+v", syncReq, resp)
+		}
+	}
+
+	// A network container that fails to sync rolls back the changes already made.
+	invalid := nc("nc6", "1")
+	invalid.OrchestratorContext = json.RawMessage(`"invalid"`)
+
+	resp = sync(nc("nc2", "3"), nc("nc5", "1"), invalid)
+	if resp.Response.ReturnCode != UnexpectedError {
+		t.Errorf("Expected sync to fail but got %+v", resp)
+	}
+
+	if list := versions(); !reflect.DeepEqual(list, map[string]string{"nc2": "2", "nc3": "1"}) {
+		t.Errorf("Expected sync to be rolled back but got %+v", list)
+	}
+
+	resp = syncNetworkContainers(t, cns.SyncNetworkContainersRequest{DeleteAll: true})
+	if resp.Response.ReturnCode != 0 || len(resp.Results) != 2 {
+		t.Fatalf("SyncNetworkContainers failed with response %+v", resp)
+	}
+
+	if list := versions(); len(list) != 0 {
+		t.Errorf("Expected no network containers but got %+v", list)
+	}
+}
+
+func createNetworkContainerForPod(t *testing.T, name string, podInfo cns.KubernetesPodInfo) {
+	var body bytes.Buffer
+	var resp cns.CreateNetworkContainerResponse
+
+	context, _ := json.Marshal(podInfo)
+	info := &cns.CreateNetworkContainerRequest{
+		Version:              "0.1",
+		NetworkContainerType: cns.AzureContainerInstance,
+		NetworkContainerid:   name,
+		OrchestratorContext:  context,
+	}
+
+	json.NewEncoder(&body).Encode(info)
+	req, err := http.NewRequest(http.MethodPost, cns.CreateOrUpdateNetworkContainer, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	err = decodeResponse(w, &resp)
+	if err != nil || resp.Response.ReturnCode != 0 {
+		t.Fatalf("CreateNetworkContainerRequest failed with response %+v Err:%+v", resp, err)
+	}
+}
+
+func getNetworkContainersByContext(t *testing.T, podInfo cns.KubernetesPodInfo) cns.GetNetworkContainersResponse {
+	var body bytes.Buffer
+	var resp cns.GetNetworkContainersResponse
+
+	podInfoBytes, _ := json.Marshal(podInfo)
+	json.NewEncoder(&body).Encode(&cns.GetNetworkContainerRequest{OrchestratorContext: podInfoBytes})
+	req, err := http.NewRequest(http.MethodPost, cns.GetNetworkContainersByOrchestratorContext, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	err = decodeResponse(w, &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fmt.Printf("GetNetworkContainersByContext responded with %+v\n", resp)
+	return resp
+}
+
+func TestGetNetworkContainersByOrchestratorContext(t *testing.T) {
+	fmt.Println("Test: TestGetNetworkContainersByOrchestratorContext")
+
+	setEnv(t)
+	setOrchestratorType(t, cns.Kubernetes)
+
+	eth0 := cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c", PodUID: "uid1", InterfaceName: "eth0"}
+	eth1 := cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c", PodUID: "uid1", InterfaceName: "eth1"}
+	other := cns.KubernetesPodInfo{PodName: "a", PodNamespace: "bc", PodUID: "uid2"}
+
+	createNetworkContainerForPod(t, "nc-eth0", eth0)
+	createNetworkContainerForPod(t, "nc-eth1", eth1)
+	createNetworkContainerForPod(t, "nc-other", other)
+
+	resp := getNetworkContainersByContext(t, cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c"})
+	if resp.Response.ReturnCode != 0 || len(resp.NetworkContainers) != 2 ||
+		resp.NetworkContainers[0].NetworkContainerID != "nc-eth0" ||
+		resp.NetworkContainers[1].NetworkContainerID != "nc-eth1" {
+		t.Errorf("Expected both network containers of the pod but got %+v", resp)
+	}
+
+	resp = getNetworkContainersByContext(t, eth1)
+	if len(resp.NetworkContainers) != 1 || resp.NetworkContainers[0].NetworkContainerID != "nc-eth1" {
+		t.Errorf("Expected network container of eth1 but got %+v", resp)
+	}
+
+	resp = getNetworkContainersByContext(t, cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c", PodUID: "uid3"})
+	if resp.Response.ReturnCode != UnknownContainerID {
+		t.Errorf("Expected no network containers for a different pod instance but got %+v", resp)
+	}
+
+	resp = getNetworkContainersByContext(t, cns.KubernetesPodInfo{PodName: "a", PodNamespace: "bc"})
+	if len(resp.NetworkContainers) != 1 || resp.NetworkContainers[0].NetworkContainerID != "nc-other" {
+		t.Errorf("Expected network container of the other pod but got %+v", resp)
+	}
+
+	// The network container of the primary interface comes first, also after it is updated
+	// and after the index is rebuilt.
+	eth1.Primary = true
+	createNetworkContainerForPod(t, "nc-eth1", eth1)
+	createNetworkContainerForPod(t, "nc-eth0", eth0)
+
+	svc := service.(*httpRestService)
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			svc.lock.Lock()
+			svc.rebuildOrchestratorContexts()
+			svc.lock.Unlock()
+		}
+
+		resp = getNetworkContainersByContext(t, cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c"})
+		if len(resp.NetworkContainers) != 2 ||
+			resp.NetworkContainers[0].NetworkContainerID != "nc-eth1" ||
+			resp.NetworkContainers[1].NetworkContainerID != "nc-eth0" {
+			t.Errorf("Expected primary network container first but got %+v", resp)
+		}
+	}
+
+	// A recreated pod with the same name does not get the network containers of the old instance.
+	createNetworkContainerForPod(t, "nc-recreated", cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c", PodUID: "uid4"})
+
+	resp = getNetworkContainersByContext(t, cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c", PodUID: "uid4"})
+	if len(resp.NetworkContainers) != 1 || resp.NetworkContainers[0].NetworkContainerID != "nc-recreated" {
+		t.Errorf("Expected network container of the recreated pod but got %+v", resp)
+	}
+
+	resp = getNetworkContainersByContext(t, cns.KubernetesPodInfo{PodName: "ab", PodNamespace: "c"})
+	if resp.Response.ReturnCode != InvalidParameter {
+		t.Errorf("Expected lookup without pod UID to be rejected but got %+v", resp)
+	}
+
+	syncNetworkContainers(t, cns.SyncNetworkContainersRequest{DeleteAll: true})
+}
+
+func TestHandlerErrors(t *testing.T) {
+	fmt.Println("Test: HandlerErrors")
+
+	// Methods other than the ones registered for a route are rejected.
+	req, err := http.NewRequest(http.MethodGet, cns.SyncNetworkContainers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(cns.RequestIDHeader, "test-request")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	var resp struct {
+		Error cns.Error
+	}
+
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil || w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost ||
+		resp.Error.RequestID != "test-request" || w.Header().Get(cns.RequestIDHeader) != "test-request" {
+		t.Errorf("Expected method not allowed but got status %v response %+v err:%v", w.Code, resp, err)
+	}
+
+	// Request bodies must be valid JSON.
+	req, err = http.NewRequest(http.MethodPost, cns.V2Prefix+cns.SyncNetworkContainers, bytes.NewBufferString("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil || w.Code != http.StatusBadRequest || resp.Error.ReturnCode != InvalidParameter ||
+		resp.Error.RequestID == "" || resp.Error.RequestID != w.Header().Get(cns.RequestIDHeader) {
+		t.Errorf("Expected bad request but got status %v response %+v err:%v", w.Code, resp, err)
+	}
+
+	// Return codes are mapped to HTTP statuses.
+	codes := map[int]int{
+		Success:                http.StatusOK,
+		InvalidParameter:       http.StatusBadRequest,
+		UnknownContainerID:     http.StatusNotFound,
+		AddressUnavailable:     http.StatusConflict,
+		UnsupportedEnvironment: http.StatusPreconditionFailed,
+		CallToHostFailed:       http.StatusBadGateway,
+		UnexpectedError:        http.StatusInternalServerError,
+	}
+
+	for returnCode, statusCode := range codes {
+		if getHTTPStatus(returnCode) != statusCode {
+			t.Errorf("Expected status %v for return code %v but got %v", statusCode, returnCode, getHTTPStatus(returnCode))
+		}
+	}
+}
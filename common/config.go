@@ -44,6 +44,10 @@ const (
 	OptIpamQueryInterval      = "ipam-query-interval"
 	OptIpamQueryIntervalAlias = "i"
 
+	// Backend of the bridge rules.
+	OptEbtablesBackend      = "ebtables-backend"
+	OptEbtablesBackendAlias = "eb"
+
 	// Don't Start CNM
 	OptStopAzureVnet      = "stop-azure-cnm"
 	OptStopAzureVnetAlias = "stopcnm"
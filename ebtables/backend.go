@@ -0,0 +1,224 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// Backend names.
+const (
+	EbtablesBackend = "ebtables"
+	NftablesBackend = "nftables"
+)
+
+const (
+	ebtablesSave    = "ebtables-save"
+	ebtablesRestore = "ebtables-restore"
+)
+
+// backend programs the rules owned by the plugin.
+type backend interface {
+	// list returns the rules owned by the plugin in a table.
+	list(table string) ([]*Rule, error)
+	// restore replaces the rules owned by the plugin in a table in a single transaction.
+	// Copies of stale rules left in built-in chains by earlier versions of the plugin are removed as well.
+	restore(table string, rules []*Rule, stale []*Rule) error
+}
+
+// newBackend returns the backend of the given name, ebtables by default.
+func newBackend(name string) backend {
+	switch name {
+	case NftablesBackend:
+		return &nftablesBackend{}
+	case EbtablesBackend, "":
+	default:
+		log.Printf("[ebtables] Unknown backend %s, using %s.", name, EbtablesBackend)
+	}
+
+	return &ebtablesBackend{}
+}
+
+// ebtablesBackend keeps the rules owned by the plugin in chains of their own, and replaces
+// whole tables through ebtables-restore.
+type ebtablesBackend struct{}
+
+// savedTable is a table in the format of ebtables-save.
+type savedTable struct {
+	chains []string
+	rules  []string
+}
+
+// save returns the contents of a table in the format of ebtables-save.
+// A table that has not been loaded yet is returned with its built-in chains.
+func (b *ebtablesBackend) save(table string) (*savedTable, error) {
+	out, err := exec.Command(ebtablesSave).CombinedOutput()
+	if err != nil {
+		log.Printf("[ebtables] Error running %s: %v %s", ebtablesSave, err, out)
+		return nil, err
+	}
+
+	return parseSavedTable(out, table), nil
+}
+
+// parseSavedTable returns a table from the output of ebtables-save.
+func parseSavedTable(out []byte, table string) *savedTable {
+	saved := &savedTable{}
+	found := false
+	inTable := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "*"):
+			inTable = line[1:] == table
+			found = found || inTable
+		case !inTable:
+		case strings.HasPrefix(line, ":"):
+			saved.chains = append(saved.chains, line)
+		case strings.HasPrefix(line, "-A "):
+			saved.rules = append(saved.rules, line)
+		}
+	}
+
+	if !found {
+		for _, chain := range builtinChains[table] {
+			saved.chains = append(saved.chains, fmt.Sprintf(":%s ACCEPT", chain))
+		}
+	}
+
+	return saved
+}
+
+// parseSavedRule returns the chain and arguments of a rule in the format of ebtables-save.
+func parseSavedRule(line string) (string, []string) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", nil
+	}
+	return fields[1], fields[2:]
+}
+
+func (b *ebtablesBackend) list(table string) ([]*Rule, error) {
+	saved, err := b.save(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+
+	for _, line := range saved.rules {
+		chain, args := parseSavedRule(line)
+		builtinChain, ok := isOwnedChain(chain)
+		if !ok {
+			continue
+		}
+
+		rule, err := parseRule(table, builtinChain, args)
+		if err != nil {
+			// The rule is dropped when the chain is restored.
+			log.Printf("[ebtables] Ignoring unsupported rule %s: %v.", line, err)
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (b *ebtablesBackend) restore(table string, rules []*Rule, stale []*Rule) error {
+	saved, err := b.save(table)
+	if err != nil {
+		return err
+	}
+
+	input := renderRestoreInput(table, saved, rules, stale)
+	log.Debugf("[ebtables] Restoring table %s:\n%s", table, input)
+
+	cmd := exec.Command(ebtablesRestore)
+	cmd.Stdin = bytes.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("[ebtables] Error running %s: %v %s\nInput:\n%s", ebtablesRestore, err, out, input)
+		return err
+	}
+
+	return nil
+}
+
+// renderRestoreInput returns the ebtables-restore input that replaces the owned rules of a saved table,
+// keeping the rules of other owners.
+func renderRestoreInput(table string, saved *savedTable, rules []*Rule, stale []*Rule) []byte {
+	staleKeys := make(map[string]bool)
+	for _, rule := range append(stale, rules...) {
+		staleKeys[rule.key()] = true
+	}
+
+	// Owned chains exist only while they have rules.
+	chainRules := make(map[string][]*Rule)
+	for _, rule := range rules {
+		chainRules[rule.Chain] = append(chainRules[rule.Chain], rule)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%s\n", table)
+
+	for _, line := range saved.chains {
+		chain := strings.Fields(line[1:])[0]
+		if _, ok := isOwnedChain(chain); !ok {
+			fmt.Fprintf(&buf, "%s\n", line)
+		}
+	}
+
+	for _, chain := range builtinChains[table] {
+		if len(chainRules[chain]) > 0 {
+			fmt.Fprintf(&buf, ":%s RETURN\n", getOwnedChain(chain))
+		}
+	}
+
+	// Built-in chains jump to owned chains before their other rules.
+	for _, chain := range builtinChains[table] {
+		if len(chainRules[chain]) > 0 {
+			fmt.Fprintf(&buf, "-A %s -j %s\n", chain, getOwnedChain(chain))
+		}
+	}
+
+	for _, line := range saved.rules {
+		chain, args := parseSavedRule(line)
+		if _, ok := isOwnedChain(chain); ok {
+			continue
+		}
+
+		// Skip jumps to owned chains, which were written above.
+		if len(args) == 2 && args[0] == "-j" {
+			if _, ok := isOwnedChain(args[1]); ok {
+				continue
+			}
+		}
+
+		if rule, err := parseRule(table, chain, args); err == nil && staleKeys[rule.key()] {
+			log.Printf("[ebtables] Removing stale rule %s.", rule)
+			continue
+		}
+
+		fmt.Fprintf(&buf, "%s\n", line)
+	}
+
+	for _, chain := range builtinChains[table] {
+		for _, rule := range chainRules[chain] {
+			fmt.Fprintf(&buf, "-A %s %s\n", getOwnedChain(chain), strings.Join(rule.args(), " "))
+		}
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"net"
+	"testing"
+)
+
+// Output of ebtables-save with rules of other owners, a rule of the owned chain, and a copy
+// of an ARP reply rule left in a built-in chain by an earlier version of the plugin.
+const savedTables = `# Generated by ebtables-save v1.0 on Thu Jan  2 10:00:00 UTC 2020
+*filter
+:INPUT ACCEPT
+:FORWARD ACCEPT
+:OUTPUT ACCEPT
+-A FORWARD -p IPv4 -j ACCEPT
+*nat
+:PREROUTING ACCEPT
+:OUTPUT ACCEPT
+:POSTROUTING ACCEPT
+:AZURE-CNI-PREROUTING RETURN
+-A PREROUTING -j AZURE-CNI-PREROUTING
+-A PREROUTING -p ARP --arp-op Request --arp-ip-dst 10.240.0.4 -j arpreply --arpreply-mac 0:d:3a:1:2:3 --arpreply-target DROP
+-A PREROUTING -p IPv4 -j mark --mark-set 0x1 --mark-target CONTINUE
+-A AZURE-CNI-PREROUTING -p IPv4 -i eth0 --ip-dst 10.240.0.5 -j dnat --to-dst 12:34:56:78:9a:bc --dnat-target ACCEPT
+`
+
+func TestParseSavedTable(t *testing.T) {
+	saved := parseSavedTable([]byte(savedTables), TableNat)
+
+	if len(saved.chains) != 4 || saved.chains[3] != ":AZURE-CNI-PREROUTING RETURN" {
+		t.Errorf("Unexpected chains %q", saved.chains)
+	}
+
+	if len(saved.rules) != 4 {
+		t.Errorf("Unexpected rules %q", saved.rules)
+	}
+
+	// Tables that are not loaded yet have their built-in chains.
+	saved = parseSavedTable([]byte(savedTables), TableBroute)
+	if len(saved.chains) != 1 || saved.chains[0] != ":BROUTING ACCEPT" || len(saved.rules) != 0 {
+		t.Errorf("Unexpected table %+v", saved)
+	}
+}
+
+func TestRenderRestoreInput(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+	arpReply := NewArpReplyRule(net.ParseIP("10.240.0.4"), mac)
+	snat := NewSnatRule("eth0", mac)
+
+	saved := parseSavedTable([]byte(savedTables), TableNat)
+	input := renderRestoreInput(TableNat, saved, []*Rule{arpReply, snat}, []*Rule{arpReply})
+
+	// The stale copy and the rule that is no longer owned are removed, rules of other owners are kept,
+	// and owned chains are declared and jumped to for the built-in chains that have rules.
+	expected := `*nat
+:PREROUTING ACCEPT
+:OUTPUT ACCEPT
+:POSTROUTING ACCEPT
+:AZURE-CNI-PREROUTING RETURN
+:AZURE-CNI-POSTROUTING RETURN
+-A PREROUTING -j AZURE-CNI-PREROUTING
+-A POSTROUTING -j AZURE-CNI-POSTROUTING
+-A PREROUTING -p IPv4 -j mark --mark-set 0x1 --mark-target CONTINUE
+-A AZURE-CNI-PREROUTING -p ARP --arp-op Request --arp-ip-dst 10.240.0.4 -j arpreply --arpreply-mac 00:0d:3a:01:02:03 --arpreply-target DROP
+-A AZURE-CNI-POSTROUTING -o eth0 -s unicast -j snat --to-src 00:0d:3a:01:02:03 --snat-arp --snat-target ACCEPT
+`
+	if string(input) != expected {
+		t.Errorf("Expected restore input:\n%s\nbut got:\n%s", expected, input)
+	}
+
+	// Owned chains are removed with their last rule.
+	input = renderRestoreInput(TableNat, saved, nil, nil)
+	expected = `*nat
+:PREROUTING ACCEPT
+:OUTPUT ACCEPT
+:POSTROUTING ACCEPT
+-A PREROUTING -p ARP --arp-op Request --arp-ip-dst 10.240.0.4 -j arpreply --arpreply-mac 0:d:3a:1:2:3 --arpreply-target DROP
+-A PREROUTING -p IPv4 -j mark --mark-set 0x1 --mark-target CONTINUE
+`
+	if string(input) != expected {
+		t.Errorf("Expected restore input:\n%s\nbut got:\n%s", expected, input)
+	}
+}
+
+func TestRenderNftInput(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+
+	input, err := renderNftInput(TableNat, []*Rule{NewSnatRule("eth0", mac), NewArpReplyDnatRule("eth0")})
+	if err != nil {
+		t.Fatalf("Failed to render nftables input: %v", err)
+	}
+
+	expected := `add table bridge azure-cni-nat
+delete table bridge azure-cni-nat
+add table bridge azure-cni-nat
+add chain bridge azure-cni-nat prerouting { type filter hook prerouting priority -300 ; policy accept ; }
+add chain bridge azure-cni-nat output { type filter hook output priority -100 ; policy accept ; }
+add chain bridge azure-cni-nat postrouting { type filter hook postrouting priority 300 ; policy accept ; }
+add rule bridge azure-cni-nat postrouting ether type arp oifname "eth0" ether saddr & 01:00:00:00:00:00 == 00:00:00:00:00:00 arp saddr ether set 00:0d:3a:01:02:03 ether saddr set 00:0d:3a:01:02:03 accept comment "-o eth0 -s unicast -j snat --to-src 00:0d:3a:01:02:03 --snat-arp --snat-target ACCEPT"
+add rule bridge azure-cni-nat postrouting oifname "eth0" ether saddr & 01:00:00:00:00:00 == 00:00:00:00:00:00 ether saddr set 00:0d:3a:01:02:03 accept comment "-o eth0 -s unicast -j snat --to-src 00:0d:3a:01:02:03 --snat-arp --snat-target ACCEPT"
+add rule bridge azure-cni-nat prerouting ether type arp iifname "eth0" arp operation reply ether daddr set ff:ff:ff:ff:ff:ff accept comment "-p ARP -i eth0 --arp-op Reply -j dnat --to-dst ff:ff:ff:ff:ff:ff --dnat-target ACCEPT"
+`
+	if string(input) != expected {
+		t.Errorf("Expected nftables input:\n%s\nbut got:\n%s", expected, input)
+	}
+
+	// An empty table is deleted.
+	input, err = renderNftInput(TableNat, nil)
+	if err != nil || string(input) != "add table bridge azure-cni-nat\ndelete table bridge azure-cni-nat\n" {
+		t.Errorf("Unexpected nftables input %q, err:%v", input, err)
+	}
+
+	// ARP reply rules are kept in ebtables chains by the nftables backend.
+	if _, err := renderNftInput(TableNat, []*Rule{NewArpReplyRule(net.ParseIP("10.240.0.4"), mac)}); err == nil {
+		t.Errorf("Expected ARP reply rules to be rejected by nftables")
+	}
+
+	if _, err := renderNftInput(TableBroute, nil); err == nil {
+		t.Errorf("Expected table %s to be rejected by nftables", TableBroute)
+	}
+}
+
+func TestSetBackend(t *testing.T) {
+	defer SetBackend(EbtablesBackend)
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "", valid: true},
+		{name: EbtablesBackend, valid: true},
+		{name: NftablesBackend, valid: true},
+		{name: "iptables"},
+	}
+
+	for _, test := range tests {
+		if err := SetBackend(test.name); (err == nil) != test.valid {
+			t.Errorf("Expected valid=%v for backend %q but got err:%v", test.valid, test.name, err)
+		}
+	}
+
+	SetBackend(NftablesBackend)
+	if _, ok := GetManager().backend.(*nftablesBackend); !ok {
+		t.Errorf("Expected the nftables backend but got %T", GetManager().backend)
+	}
+}
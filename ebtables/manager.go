@@ -0,0 +1,134 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"github.com/Azure/azure-container-networking/log"
+)
+
+// Manager programs ebtables rules owned by the plugin.
+// Adding and deleting rules is idempotent, and each call changes a table in a single transaction.
+type Manager struct {
+	backend backend
+}
+
+// NewManager creates a new rule manager with the backend of the given name.
+func NewManager(backendName string) *Manager {
+	return &Manager{backend: newBackend(backendName)}
+}
+
+// groupByTable returns the given rules grouped by their tables, in the order the tables appear.
+func groupByTable(rules []*Rule) ([]string, map[string][]*Rule) {
+	var tables []string
+	tableRules := make(map[string][]*Rule)
+
+	for _, rule := range rules {
+		if _, ok := tableRules[rule.Table]; !ok {
+			tables = append(tables, rule.Table)
+		}
+		tableRules[rule.Table] = append(tableRules[rule.Table], rule)
+	}
+
+	return tables, tableRules
+}
+
+// ListRules returns the rules owned by the plugin in a table.
+func (m *Manager) ListRules(table string) ([]*Rule, error) {
+	return m.backend.list(table)
+}
+
+// Exists returns whether a rule is owned by the plugin.
+func (m *Manager) Exists(rule *Rule) (bool, error) {
+	rules, err := m.backend.list(rule.Table)
+	if err != nil {
+		return false, err
+	}
+
+	for _, r := range rules {
+		if r.key() == rule.key() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// AddRules adds the given rules, skipping those that already exist.
+func (m *Manager) AddRules(rules ...*Rule) error {
+	tables, tableRules := groupByTable(rules)
+
+	for _, table := range tables {
+		current, err := m.backend.list(table)
+		if err != nil {
+			return err
+		}
+
+		keys := make(map[string]bool)
+		for _, rule := range current {
+			keys[rule.key()] = true
+		}
+
+		var added []*Rule
+		for _, rule := range tableRules[table] {
+			if !keys[rule.key()] {
+				keys[rule.key()] = true
+				added = append(added, rule)
+			}
+		}
+
+		if len(added) == 0 {
+			continue
+		}
+
+		for _, rule := range added {
+			log.Printf("[ebtables] Adding rule %s.", rule)
+		}
+
+		err = m.backend.restore(table, append(current, added...), added)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteRules deletes the given rules, skipping those that do not exist.
+func (m *Manager) DeleteRules(rules ...*Rule) error {
+	tables, tableRules := groupByTable(rules)
+
+	for _, table := range tables {
+		current, err := m.backend.list(table)
+		if err != nil {
+			return err
+		}
+
+		keys := make(map[string]bool)
+		for _, rule := range tableRules[table] {
+			keys[rule.key()] = true
+			log.Printf("[ebtables] Deleting rule %s.", rule)
+		}
+
+		var remaining []*Rule
+		for _, rule := range current {
+			if !keys[rule.key()] {
+				remaining = append(remaining, rule)
+			}
+		}
+
+		// Restore even if no owned rule was deleted, to remove copies left by earlier versions.
+		err = m.backend.restore(table, remaining, tableRules[table])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush deletes all rules owned by the plugin in a table.
+func (m *Manager) Flush(table string) error {
+	log.Printf("[ebtables] Flushing rules in table %s.", table)
+	return m.backend.restore(table, nil, nil)
+}
@@ -0,0 +1,295 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	nft            = "nft"
+	nftTablePrefix = "bridge azure-cni-"
+	nftNotFound    = "No such file or directory"
+)
+
+// Priorities of the base chains of each table, matching those of the ebtables chains they replace.
+var nftChainPriorities = map[string]map[string]int{
+	TableNat:    {ChainPrerouting: -300, ChainOutput: -100, ChainPostrouting: 300},
+	TableFilter: {ChainInput: -200, ChainForward: -200, ChainOutput: -200},
+}
+
+// nftablesBackend keeps the rules owned by the plugin in a table of the nftables bridge family
+// for each ebtables table, with a base chain for each built-in chain.
+// Each rule carries its ebtables arguments as a comment, from which it is listed.
+// nftables cannot reply to ARP requests, so ARP reply rules are kept in the chains of the ebtables backend.
+type nftablesBackend struct {
+	ebtables ebtablesBackend
+}
+
+// getNftTable returns the nftables family and table of the rules of an ebtables table.
+func getNftTable(table string) string {
+	return nftTablePrefix + table
+}
+
+// getNftVerdict returns the nftables verdict of an ebtables target or target policy.
+func getNftVerdict(target string) (string, error) {
+	switch target {
+	case TargetAccept, TargetDrop, TargetContinue, TargetReturn:
+		return strings.ToLower(target), nil
+	}
+	return "", fmt.Errorf("Unsupported ebtables verdict %s", target)
+}
+
+// translateInterface converts an ebtables interface name, which may end in a wildcard, to nftables.
+func translateInterface(name string) string {
+	return fmt.Sprintf("\"%s\"", strings.Replace(name, "+", "*", 1))
+}
+
+// translateRule returns the nftables statements of an ebtables rule.
+// Rules that translate the sender address of ARP packets need a separate statement for ARP packets.
+func translateRule(rule *Rule) ([]string, error) {
+	var matches []string
+
+	switch strings.ToUpper(rule.Protocol) {
+	case "":
+	case "ARP":
+		matches = append(matches, "ether type arp")
+	case "IPV4":
+		matches = append(matches, "ether type ip")
+	case "IPV6":
+		matches = append(matches, "ether type ip6")
+	default:
+		return nil, fmt.Errorf("Unsupported protocol %s", rule.Protocol)
+	}
+
+	if rule.InInterface != "" {
+		matches = append(matches, "iifname "+translateInterface(rule.InInterface))
+	}
+	if rule.OutInterface != "" {
+		matches = append(matches, "oifname "+translateInterface(rule.OutInterface))
+	}
+
+	switch rule.SourceMac {
+	case "":
+	case "unicast":
+		matches = append(matches, "ether saddr & 01:00:00:00:00:00 == 00:00:00:00:00:00")
+	default:
+		matches = append(matches, "ether saddr "+rule.SourceMac)
+	}
+
+	if rule.ArpOp != "" {
+		matches = append(matches, "arp operation "+strings.ToLower(rule.ArpOp))
+	}
+	if rule.ArpIpDst != nil {
+		matches = append(matches, "arp daddr ip "+rule.ArpIpDst.String())
+	}
+	if rule.IpDst != nil {
+		matches = append(matches, "ip daddr "+rule.IpDst.String())
+	}
+
+	match := strings.Join(matches, " ")
+
+	switch rule.Target {
+	case TargetSnat, TargetDnat:
+		verdict, err := getNftVerdict(rule.getTargetPolicy())
+		if err != nil {
+			return nil, err
+		}
+
+		field := "daddr"
+		if rule.Target == TargetSnat {
+			field = "saddr"
+		}
+		statement := fmt.Sprintf("ether %s set %s %s", field, rule.TargetMac, verdict)
+
+		if rule.Target == TargetSnat && rule.SnatArp {
+			arpMatch := match
+			switch strings.ToUpper(rule.Protocol) {
+			case "ARP":
+			case "":
+				arpMatch = strings.TrimSpace("ether type arp " + match)
+			default:
+				return []string{match + " " + statement}, nil
+			}
+
+			arpStatement := fmt.Sprintf("%s arp saddr ether set %s %s", arpMatch, rule.TargetMac, statement)
+			if arpMatch == match {
+				return []string{arpStatement}, nil
+			}
+
+			// ARP packets are accepted by the first statement.
+			return []string{arpStatement, match + " " + statement}, nil
+		}
+
+		return []string{match + " " + statement}, nil
+
+	case TargetArpReply:
+		return nil, fmt.Errorf("Target %s is not supported by nftables", rule.Target)
+	}
+
+	verdict, err := getNftVerdict(rule.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{match + " " + verdict}, nil
+}
+
+// list returns the rules of the nftables table, followed by the rules in ebtables chains.
+// Rules left in ebtables chains by the ebtables backend are listed as well, so that they are moved.
+func (b *nftablesBackend) list(table string) ([]*Rule, error) {
+	rules, err := b.listNft(table)
+	if err != nil {
+		return nil, err
+	}
+
+	ebtablesRules, err := b.ebtables.list(table)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]bool)
+	for _, rule := range rules {
+		keys[rule.key()] = true
+	}
+
+	for _, rule := range ebtablesRules {
+		if !keys[rule.key()] {
+			keys[rule.key()] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+// listNft returns the rules of the nftables table of an ebtables table.
+func (b *nftablesBackend) listNft(table string) ([]*Rule, error) {
+	out, err := exec.Command(nft, "list", "table", getNftTable(table)).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(out), nftNotFound) {
+			return nil, nil
+		}
+		log.Printf("[ebtables] Error running %s: %v %s", nft, err, out)
+		return nil, err
+	}
+
+	var rules []*Rule
+	keys := make(map[string]bool)
+	var chain string
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "chain ") {
+			chain = strings.ToUpper(strings.Fields(line)[1])
+			continue
+		}
+
+		index := strings.Index(line, "comment \"")
+		if index < 0 {
+			continue
+		}
+
+		comment := strings.TrimSuffix(line[index+len("comment \""):], "\"")
+		rule, err := parseRule(table, chain, strings.Fields(comment))
+		if err != nil {
+			log.Printf("[ebtables] Ignoring unsupported rule %s: %v.", line, err)
+			continue
+		}
+
+		// Rules with more than one statement are listed once.
+		if !keys[rule.key()] {
+			keys[rule.key()] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+// restore recreates the nftables table in a single transaction, then restores the ARP reply rules
+// in ebtables chains. Other rules left in ebtables chains, and copies of stale rules in built-in chains,
+// are removed from ebtables.
+func (b *nftablesBackend) restore(table string, rules []*Rule, stale []*Rule) error {
+	var nftRules, arpReplyRules []*Rule
+	for _, rule := range rules {
+		if rule.Target == TargetArpReply {
+			arpReplyRules = append(arpReplyRules, rule)
+		} else {
+			nftRules = append(nftRules, rule)
+		}
+	}
+
+	if err := b.restoreNft(table, nftRules); err != nil {
+		return err
+	}
+
+	return b.ebtables.restore(table, arpReplyRules, stale)
+}
+
+// restoreNft recreates the nftables table of an ebtables table in a single transaction.
+func (b *nftablesBackend) restoreNft(table string, rules []*Rule) error {
+	input, err := renderNftInput(table, rules)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("[ebtables] Restoring nftables table %s:\n%s", getNftTable(table), input)
+
+	cmd := exec.Command(nft, "-f", "-")
+	cmd.Stdin = bytes.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("[ebtables] Error running %s: %v %s\nInput:\n%s", nft, err, out, input)
+		return err
+	}
+
+	return nil
+}
+
+// renderNftInput returns the nft input that recreates the nftables table of an ebtables table with the given rules.
+func renderNftInput(table string, rules []*Rule) ([]byte, error) {
+	priorities, ok := nftChainPriorities[table]
+	if !ok {
+		return nil, fmt.Errorf("Table %s is not supported by nftables", table)
+	}
+
+	var buf bytes.Buffer
+
+	// Adding the table first makes its deletion succeed when it does not exist.
+	nftTable := getNftTable(table)
+	fmt.Fprintf(&buf, "add table %s\n", nftTable)
+	fmt.Fprintf(&buf, "delete table %s\n", nftTable)
+
+	if len(rules) > 0 {
+		fmt.Fprintf(&buf, "add table %s\n", nftTable)
+
+		for _, chain := range builtinChains[table] {
+			fmt.Fprintf(&buf, "add chain %s %s { type filter hook %s priority %d ; policy accept ; }\n",
+				nftTable, strings.ToLower(chain), strings.ToLower(chain), priorities[chain])
+		}
+
+		for _, rule := range rules {
+			statements, err := translateRule(rule)
+			if err != nil {
+				log.Printf("[ebtables] Error translating rule %s to nftables: %v.", rule, err)
+				return nil, err
+			}
+
+			for _, statement := range statements {
+				fmt.Fprintf(&buf, "add rule %s %s %s comment \"%s\"\n",
+					nftTable, strings.ToLower(rule.Chain), statement, strings.Join(rule.args(), " "))
+			}
+		}
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,319 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Ebtables tables.
+const (
+	TableNat    = "nat"
+	TableFilter = "filter"
+	TableBroute = "broute"
+)
+
+// Ebtables built-in chains.
+const (
+	ChainPrerouting  = "PREROUTING"
+	ChainPostrouting = "POSTROUTING"
+	ChainInput       = "INPUT"
+	ChainForward     = "FORWARD"
+	ChainOutput      = "OUTPUT"
+	ChainBrouting    = "BROUTING"
+)
+
+// Ebtables targets.
+const (
+	TargetAccept   = "ACCEPT"
+	TargetDrop     = "DROP"
+	TargetContinue = "CONTINUE"
+	TargetReturn   = "RETURN"
+	TargetSnat     = "snat"
+	TargetDnat     = "dnat"
+	TargetArpReply = "arpreply"
+)
+
+// Prefix of the chains owned by the plugin. Rules of a built-in chain are kept in the owned chain
+// named after it, which the built-in chain jumps to.
+const ownedChainPrefix = "AZURE-CNI-"
+
+// Built-in chains of each table, in the order they are declared.
+var builtinChains = map[string][]string{
+	TableNat:    {ChainPrerouting, ChainOutput, ChainPostrouting},
+	TableFilter: {ChainInput, ChainForward, ChainOutput},
+	TableBroute: {ChainBrouting},
+}
+
+// Names of the ethernet protocols that are listed by number when /etc/ethertypes is missing.
+var protocolNames = map[string]string{
+	"0x800":  "IPv4",
+	"0x0800": "IPv4",
+	"0x806":  "ARP",
+	"0x0806": "ARP",
+	"0x86dd": "IPv6",
+}
+
+// Rule represents an ebtables rule of a built-in chain.
+// SourceMac is a MAC address or one of the ebtables address types, such as "unicast".
+// TargetMac and TargetPolicy are the options of snat, dnat and arpreply targets,
+// and SnatArp also translates the sender address of ARP packets.
+type Rule struct {
+	Table        string
+	Chain        string
+	Protocol     string
+	InInterface  string
+	OutInterface string
+	SourceMac    string
+	ArpOp        string
+	ArpIpDst     net.IP
+	IpDst        net.IP
+	Target       string
+	TargetMac    net.HardwareAddr
+	TargetPolicy string
+	SnatArp      bool
+}
+
+// getOwnedChain returns the chain owned by the plugin that holds the rules of a built-in chain.
+func getOwnedChain(chain string) string {
+	return ownedChainPrefix + chain
+}
+
+// isOwnedChain returns whether a chain is owned by the plugin, and the built-in chain it belongs to.
+func isOwnedChain(chain string) (string, bool) {
+	if !strings.HasPrefix(chain, ownedChainPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(chain, ownedChainPrefix), true
+}
+
+// args returns the ebtables arguments of a rule, without its table and chain.
+func (rule *Rule) args() []string {
+	var args []string
+
+	if rule.Protocol != "" {
+		args = append(args, "-p", rule.Protocol)
+	}
+	if rule.InInterface != "" {
+		args = append(args, "-i", rule.InInterface)
+	}
+	if rule.OutInterface != "" {
+		args = append(args, "-o", rule.OutInterface)
+	}
+	if rule.SourceMac != "" {
+		args = append(args, "-s", rule.SourceMac)
+	}
+	if rule.ArpOp != "" {
+		args = append(args, "--arp-op", rule.ArpOp)
+	}
+	if rule.ArpIpDst != nil {
+		args = append(args, "--arp-ip-dst", rule.ArpIpDst.String())
+	}
+	if rule.IpDst != nil {
+		args = append(args, "--ip-dst", rule.IpDst.String())
+	}
+
+	args = append(args, "-j", rule.Target)
+
+	switch rule.Target {
+	case TargetSnat:
+		args = append(args, "--to-src", rule.TargetMac.String())
+		if rule.SnatArp {
+			args = append(args, "--snat-arp")
+		}
+		args = append(args, "--snat-target", rule.getTargetPolicy())
+	case TargetDnat:
+		args = append(args, "--to-dst", rule.TargetMac.String(), "--dnat-target", rule.getTargetPolicy())
+	case TargetArpReply:
+		args = append(args, "--arpreply-mac", rule.TargetMac.String(), "--arpreply-target", rule.getTargetPolicy())
+	}
+
+	return args
+}
+
+// getTargetPolicy returns the verdict of a snat, dnat or arpreply target.
+func (rule *Rule) getTargetPolicy() string {
+	if rule.TargetPolicy == "" {
+		return TargetAccept
+	}
+	return rule.TargetPolicy
+}
+
+// String returns the rule as the arguments of an ebtables append command.
+func (rule *Rule) String() string {
+	return fmt.Sprintf("-t %s -A %s %s", rule.Table, rule.Chain, strings.Join(rule.args(), " "))
+}
+
+// key identifies a rule regardless of how ebtables spells its protocol, address types and verdicts.
+func (rule *Rule) key() string {
+	return strings.ToLower(rule.String())
+}
+
+// parseRule parses the ebtables arguments of a rule of a built-in chain.
+// Rules with options that the plugin does not use are rejected.
+func parseRule(table string, chain string, args []string) (*Rule, error) {
+	rule := &Rule{Table: table, Chain: chain}
+
+	for i := 0; i < len(args); i++ {
+		flag := args[i]
+
+		// Options without a value.
+		if flag == "--snat-arp" {
+			rule.SnatArp = true
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("Missing value of ebtables option %s", flag)
+		}
+		i++
+		value := args[i]
+
+		switch flag {
+		case "-p", "--protocol":
+			if name, ok := protocolNames[strings.ToLower(value)]; ok {
+				value = name
+			}
+			rule.Protocol = value
+		case "-i", "--in-interface":
+			rule.InInterface = value
+		case "-o", "--out-interface":
+			rule.OutInterface = value
+		case "-s", "--source":
+			if mac, err := parseMAC(value); err == nil {
+				value = mac.String()
+			}
+			rule.SourceMac = strings.ToLower(value)
+		case "--arp-op":
+			rule.ArpOp = value
+		case "--arp-ip-dst":
+			if rule.ArpIpDst = parseIP(value); rule.ArpIpDst == nil {
+				return nil, fmt.Errorf("Invalid IP address %s", value)
+			}
+		case "--ip-dst", "--ip-destination":
+			if rule.IpDst = parseIP(value); rule.IpDst == nil {
+				return nil, fmt.Errorf("Invalid IP address %s", value)
+			}
+		case "-j", "--jump":
+			rule.Target = value
+		case "--to-src", "--to-source", "--to-dst", "--to-destination", "--arpreply-mac":
+			mac, err := parseMAC(value)
+			if err != nil {
+				return nil, err
+			}
+			rule.TargetMac = mac
+		case "--snat-target", "--dnat-target", "--arpreply-target":
+			rule.TargetPolicy = value
+		default:
+			return nil, fmt.Errorf("Unsupported ebtables option %s", flag)
+		}
+	}
+
+	if rule.Target == "" {
+		return nil, fmt.Errorf("Missing ebtables target")
+	}
+
+	return rule, nil
+}
+
+// parseMAC parses a MAC address, including the form without leading zeros that ebtables lists by default.
+func parseMAC(value string) (net.HardwareAddr, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) == 6 {
+		for i, part := range parts {
+			if len(part) == 1 {
+				parts[i] = "0" + part
+			}
+		}
+		value = strings.Join(parts, ":")
+	}
+
+	return net.ParseMAC(value)
+}
+
+// parseIP parses an IP address, ignoring a host prefix length.
+func parseIP(value string) net.IP {
+	return net.ParseIP(strings.TrimSuffix(value, "/32"))
+}
+
+// NewSnatRule returns a rule that translates the source MAC address of unicast frames sent on an interface.
+func NewSnatRule(interfaceName string, macAddress net.HardwareAddr) *Rule {
+	return &Rule{
+		Table:        TableNat,
+		Chain:        ChainPostrouting,
+		OutInterface: interfaceName,
+		SourceMac:    "unicast",
+		Target:       TargetSnat,
+		TargetMac:    macAddress,
+		TargetPolicy: TargetAccept,
+		SnatArp:      true,
+	}
+}
+
+// NewArpReplyRule returns a rule that replies to ARP requests for an IP address with a MAC address.
+func NewArpReplyRule(ipAddress net.IP, macAddress net.HardwareAddr) *Rule {
+	return &Rule{
+		Table:        TableNat,
+		Chain:        ChainPrerouting,
+		Protocol:     "ARP",
+		ArpOp:        "Request",
+		ArpIpDst:     ipAddress,
+		Target:       TargetArpReply,
+		TargetMac:    macAddress,
+		TargetPolicy: TargetDrop,
+	}
+}
+
+// NewArpReplyDnatRule returns a rule that broadcasts ARP replies received on an interface.
+func NewArpReplyDnatRule(interfaceName string) *Rule {
+	return &Rule{
+		Table:        TableNat,
+		Chain:        ChainPrerouting,
+		Protocol:     "ARP",
+		InInterface:  interfaceName,
+		ArpOp:        "Reply",
+		Target:       TargetDnat,
+		TargetMac:    net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		TargetPolicy: TargetAccept,
+	}
+}
+
+// NewDnatRule returns a rule that translates the destination MAC address of IPv4 packets
+// for an IP address received on an interface.
+func NewDnatRule(interfaceName string, ipAddress net.IP, macAddress net.HardwareAddr) *Rule {
+	return &Rule{
+		Table:        TableNat,
+		Chain:        ChainPrerouting,
+		Protocol:     "IPv4",
+		InInterface:  interfaceName,
+		IpDst:        ipAddress,
+		Target:       TargetDnat,
+		TargetMac:    macAddress,
+		TargetPolicy: TargetAccept,
+	}
+}
+
+// NewVepaRules returns the rules that send frames received on a bridge and its downstream ports upstream.
+func NewVepaRules(bridgeName string, downstreamIfNamePrefix string, upstreamMacAddress net.HardwareAddr) []*Rule {
+	var rules []*Rule
+
+	newRule := func(interfaceName string) *Rule {
+		return &Rule{
+			Table:        TableNat,
+			Chain:        ChainPrerouting,
+			InInterface:  interfaceName,
+			Target:       TargetDnat,
+			TargetMac:    upstreamMacAddress,
+			TargetPolicy: TargetAccept,
+		}
+	}
+
+	if !strings.HasPrefix(bridgeName, downstreamIfNamePrefix) {
+		rules = append(rules, newRule(bridgeName))
+	}
+
+	return append(rules, newRule(downstreamIfNamePrefix+"+"))
+}
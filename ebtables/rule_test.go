@@ -0,0 +1,102 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ebtables
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+	containerMac, _ := net.ParseMAC("12:34:56:78:9a:bc")
+	upstreamMac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+
+	// Rules as listed by ebtables-save, which drops leading zeros of MAC addresses, lists protocols
+	// by number when /etc/ethertypes is missing, and capitalizes address types.
+	tests := []struct {
+		args     string
+		expected *Rule
+	}{
+		{
+			args:     "-p ARP --arp-op Request --arp-ip-dst 10.240.0.4 -j arpreply --arpreply-mac 0:d:3a:1:2:3 --arpreply-target DROP",
+			expected: NewArpReplyRule(net.ParseIP("10.240.0.4"), mac),
+		},
+		{
+			args:     "-p 0x806 --arp-op Request --arp-ip-dst 10.240.0.4/32 -j arpreply --arpreply-mac 0:d:3a:1:2:3 --arpreply-target DROP",
+			expected: NewArpReplyRule(net.ParseIP("10.240.0.4"), mac),
+		},
+		{
+			args:     "-p 0x806 -i eth0 --arp-op Reply -j dnat --to-dst ff:ff:ff:ff:ff:ff --dnat-target ACCEPT",
+			expected: NewArpReplyDnatRule("eth0"),
+		},
+		{
+			args:     "-p IPv4 -i eth0 --ip-dst 10.240.0.5 -j dnat --to-dst 12:34:56:78:9a:bc --dnat-target ACCEPT",
+			expected: NewDnatRule("eth0", net.ParseIP("10.240.0.5"), containerMac),
+		},
+		{
+			args:     "-p 0x0800 -i eth0 --ip-destination 10.240.0.5 -j dnat --to-destination 12:34:56:78:9A:BC --dnat-target ACCEPT",
+			expected: NewDnatRule("eth0", net.ParseIP("10.240.0.5"), containerMac),
+		},
+		{
+			args:     "-s Unicast -o eth0 -j snat --to-src 0:d:3a:1:2:3 --snat-arp --snat-target ACCEPT",
+			expected: NewSnatRule("eth0", mac),
+		},
+		{
+			args:     "-i azv+ -j dnat --to-dst aa:bb:cc:dd:ee:ff --dnat-target ACCEPT",
+			expected: NewVepaRules("azure0", "azv", upstreamMac)[1],
+		},
+		{args: "-p IPv4 -j mark --mark-set 0x1 --mark-target CONTINUE"},
+		{args: "-p ARP --arp-op Request"},
+		{args: "-i eth0 -j"},
+		{args: "-j arpreply --arpreply-mac 0:d:3a:1:2"},
+		{args: "-p IPv4 --ip-dst 10.240.0 -j ACCEPT"},
+	}
+
+	for _, test := range tests {
+		rule, err := parseRule(TableNat, ChainPrerouting, strings.Fields(test.args))
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("Expected an error for %q but got %v", test.args, rule)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.args, err)
+			continue
+		}
+
+		// Parsed rules are compared in the chain of the expected rule.
+		rule.Chain = test.expected.Chain
+		if rule.key() != test.expected.key() {
+			t.Errorf("Expected %q to parse into %v but got %v", test.args, test.expected, rule)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+
+	tests := []struct {
+		rule     *Rule
+		expected string
+	}{
+		{
+			rule:     NewArpReplyRule(net.ParseIP("10.240.0.4"), mac),
+			expected: "-t nat -A PREROUTING -p ARP --arp-op Request --arp-ip-dst 10.240.0.4 -j arpreply --arpreply-mac 00:0d:3a:01:02:03 --arpreply-target DROP",
+		},
+		{
+			rule:     NewSnatRule("eth0", mac),
+			expected: "-t nat -A POSTROUTING -o eth0 -s unicast -j snat --to-src 00:0d:3a:01:02:03 --snat-arp --snat-target ACCEPT",
+		},
+	}
+
+	for _, test := range tests {
+		if test.rule.String() != test.expected {
+			t.Errorf("Expected rule %q but got %q", test.expected, test.rule.String())
+		}
+	}
+}
@@ -30,4 +30,5 @@ var (
 	OptAddressID          = "azure.address.id"
 	OptAddressType        = "azure.address.type"
 	OptAddressTypeGateway = "gateway"
+	OptNetNsPath          = "azure.netns.path"
 )
@@ -143,15 +143,7 @@ func (am *addressManager) restore() error {
 	// if rebooted mark the ip as not in use.
 	if rebooted {
 		log.Printf("[ipam] Rehydrating ipam state from persistent store")
-		for _, as := range am.AddrSpaces {
-			for _, ap := range as.Pools {
-				ap.as = as
-
-				for _, ar := range ap.Addresses {
-					ar.InUse = false
-				}
-			}
-		}
+		am.releaseAddressesAfterReboot()
 	}
 
 	log.Printf("[ipam] Restored state, %+v\n", am)
@@ -159,6 +151,26 @@ func (am *addressManager) restore() error {
 	return nil
 }
 
+// releaseAddressesAfterReboot marks addresses as not in use, except those of network namespaces
+// that survived the reboot, since the network manager restores their endpoints.
+func (am *addressManager) releaseAddressesAfterReboot() {
+	for _, as := range am.AddrSpaces {
+		for _, ap := range as.Pools {
+			ap.as = as
+
+			for _, ar := range ap.Addresses {
+				if ar.InUse && ar.NetNsPath != "" && platform.IsNetworkNamespace(ar.NetNsPath) {
+					log.Printf("[ipam] Keeping address %v of network namespace %v", ar.Addr, ar.NetNsPath)
+					continue
+				}
+
+				ar.InUse = false
+				ar.NetNsPath = ""
+			}
+		}
+	}
+}
+
 // Save writes address manager state to persistent store.
 func (am *addressManager) save() error {
 	// Skip if a store is not provided.
@@ -0,0 +1,60 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package ipam
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReleaseAddressesAfterReboot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, err:%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Bind mount the current network namespace, as ip netns does.
+	nsPath := filepath.Join(dir, "netns")
+	if err := ioutil.WriteFile(nsPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to create %v, err:%v", nsPath, err)
+	}
+	if err := unix.Mount("/proc/self/ns/net", nsPath, "", unix.MS_BIND, ""); err != nil {
+		t.Skipf("Mount is not permitted, err:%v", err)
+	}
+	defer unix.Unmount(nsPath, unix.MNT_DETACH)
+
+	records := map[string]*addressRecord{
+		"10.0.1.1": {Addr: addr11, InUse: true, NetNsPath: nsPath},
+		"10.0.1.2": {Addr: addr12, InUse: true, NetNsPath: filepath.Join(dir, "missing")},
+		"10.0.1.3": {Addr: addr13, InUse: true},
+		"10.0.1.4": {Addr: net.IPv4(10, 0, 1, 4), NetNsPath: nsPath},
+	}
+	expected := map[string]bool{"10.0.1.1": true}
+
+	am := &addressManager{
+		AddrSpaces: map[string]*addressSpace{
+			LocalDefaultAddressSpaceId: {
+				Id:    LocalDefaultAddressSpaceId,
+				Pools: map[string]*addressPool{subnet1.String(): {Id: subnet1.String(), Addresses: records}},
+			},
+		},
+	}
+
+	am.releaseAddressesAfterReboot()
+
+	for addr, ar := range records {
+		if ar.InUse != expected[addr] {
+			t.Errorf("Expected address %v in use %v but got %v", addr, expected[addr], ar.InUse)
+		}
+		if !ar.InUse && ar.NetNsPath != "" {
+			t.Errorf("Expected network namespace of released address %v to be cleared but got %v", addr, ar.NetNsPath)
+		}
+	}
+}
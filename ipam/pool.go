@@ -80,6 +80,7 @@ type addressRecord struct {
 	ID        string
 	Addr      net.IP
 	InUse     bool
+	NetNsPath string `json:",omitempty"`
 	unhealthy bool
 	epoch     int
 }
@@ -500,6 +501,7 @@ func (ap *addressPool) requestAddress(address string, options map[string]string)
 		ar.ID = id
 	} else {
 		ar.InUse = true
+		ar.NetNsPath = options[OptNetNsPath]
 	}
 
 	// Return address in CIDR notation.
@@ -552,6 +554,7 @@ func (ap *addressPool) releaseAddress(address string, options map[string]string)
 	}
 
 	ar.InUse = false
+	ar.NetNsPath = ""
 
 	if id != "" && ar.ID == id {
 		delete(ap.addrsByID, ar.ID)
@@ -0,0 +1,234 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/Azure/azure-container-networking/log"
+	"golang.org/x/sys/unix"
+)
+
+// Multicast groups of events.
+const (
+	GROUP_LINK       = unix.RTNLGRP_LINK
+	GROUP_IPV4_ADDR  = unix.RTNLGRP_IPV4_IFADDR
+	GROUP_IPV6_ADDR  = unix.RTNLGRP_IPV6_IFADDR
+	GROUP_IPV4_ROUTE = unix.RTNLGRP_IPV4_ROUTE
+	GROUP_IPV6_ROUTE = unix.RTNLGRP_IPV6_ROUTE
+)
+
+// Interval at which a subscription checks whether it was cancelled.
+const eventPollIntervalInMs = 500
+
+// Event represents a change notification received from the kernel.
+type Event interface {
+	event()
+}
+
+// LinkEvent represents a network interface being added, changed or deleted.
+type LinkEvent struct {
+	Deleted bool
+	Link    Link
+}
+
+// AddressEvent represents an IP address being added to or deleted from a network interface.
+type AddressEvent struct {
+	Deleted   bool
+	LinkIndex int
+	IPAddress net.IP
+	IPNet     *net.IPNet
+	Scope     int
+}
+
+// RouteEvent represents an IP route being added or deleted.
+type RouteEvent struct {
+	Deleted bool
+	Route   *Route
+}
+
+// OverrunEvent reports that the kernel dropped events that were not received in time.
+// Subscribers should resynchronize their state from the current links, addresses and routes.
+type OverrunEvent struct{}
+
+func (*LinkEvent) event()    {}
+func (*AddressEvent) event() {}
+func (*RouteEvent) event()   {}
+func (*OverrunEvent) event() {}
+
+// Subscribe subscribes to the given multicast groups and delivers their events on a channel.
+// Events are received in the background until done is closed, after which the channel is closed.
+func (h *Handle) Subscribe(groups []int, events chan<- Event, done <-chan struct{}) error {
+	var mask uint32
+	for _, group := range groups {
+		if group <= 0 || group > 32 {
+			return fmt.Errorf("Invalid multicast group %d", group)
+		}
+		mask |= 1 << uint(group-1)
+	}
+
+	s, err := newSocketAt(h.nsFd, mask)
+	if err != nil {
+		return err
+	}
+
+	// Time out receives so that cancellation is noticed while no events arrive.
+	tv := unix.NsecToTimeval(eventPollIntervalInMs * 1000 * 1000)
+	err = unix.SetsockoptTimeval(s.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	if err != nil {
+		s.close()
+		return err
+	}
+
+	log.Printf("[netlink] Subscribed to multicast groups %v.\n", groups)
+
+	go s.receiveEvents(events, done)
+
+	return nil
+}
+
+// Subscribe subscribes to the given multicast groups and delivers their events on a channel.
+// Events are received in the background until done is closed, after which the channel is closed.
+func Subscribe(groups []int, events chan<- Event, done <-chan struct{}) error {
+	return defaultHandle.Subscribe(groups, events, done)
+}
+
+// Receives events until done is closed.
+func (s *socket) receiveEvents(events chan<- Event, done <-chan struct{}) {
+	defer close(events)
+	defer s.close()
+
+	for {
+		select {
+		case <-done:
+			log.Printf("[netlink] Subscription cancelled.\n")
+			return
+		default:
+		}
+
+		nlMsgs, err := s.receive()
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+
+		if err == unix.ENOBUFS {
+			log.Printf("[netlink] Socket receive buffer overrun, events were lost.\n")
+			if !sendEvent(events, &OverrunEvent{}, done) {
+				return
+			}
+			continue
+		}
+
+		if err != nil {
+			log.Printf("[netlink] Failed to receive events, err=%v\n", err)
+			return
+		}
+
+		for _, nlMsg := range nlMsgs {
+			event, err := deserializeEvent(&nlMsg)
+			if err != nil {
+				log.Printf("[netlink] Ignoring invalid event, err=%v\n", err)
+				continue
+			}
+
+			if event != nil && !sendEvent(events, event, done) {
+				return
+			}
+		}
+	}
+}
+
+// Sends an event unless done is closed first. Returns whether the event was sent.
+func sendEvent(events chan<- Event, event Event, done <-chan struct{}) bool {
+	select {
+	case events <- event:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// deserializeEvent decodes a multicast netlink message into an event.
+// Messages of other types are ignored.
+func deserializeEvent(nlMsg *syscall.NetlinkMessage) (Event, error) {
+	msg := &message{
+		NlMsghdr: unix.NlMsghdr{
+			Len:   nlMsg.Header.Len,
+			Type:  nlMsg.Header.Type,
+			Flags: nlMsg.Header.Flags,
+			Seq:   nlMsg.Header.Seq,
+			Pid:   nlMsg.Header.Pid,
+		},
+		data: nlMsg.Data,
+	}
+
+	switch msg.Type {
+	case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+		msg.parseAttributes(nlMsg)
+		link, err := deserializeLink(msg)
+		if err != nil {
+			return nil, err
+		}
+		return &LinkEvent{Deleted: msg.Type == unix.RTM_DELLINK, Link: link}, nil
+
+	case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+		msg.parseAttributes(nlMsg)
+		return deserializeAddressEvent(msg)
+
+	case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+		if len(msg.data) < unix.SizeofRtMsg {
+			return nil, fmt.Errorf("Invalid route message")
+		}
+		msg.parseAttributes(nlMsg)
+		route, err := deserializeRoute(msg)
+		if err != nil {
+			return nil, err
+		}
+		return &RouteEvent{Deleted: msg.Type == unix.RTM_DELROUTE, Route: route}, nil
+	}
+
+	return nil, nil
+}
+
+// deserializeAddressEvent decodes an address message into an address event.
+func deserializeAddressEvent(msg *message) (*AddressEvent, error) {
+	if len(msg.data) < unix.SizeofIfAddrmsg {
+		return nil, fmt.Errorf("Invalid address message")
+	}
+
+	ifAddr := deserializeIfAddrMsg(msg.data)
+	attrs := msg.getAttributes(ifAddr)
+
+	event := &AddressEvent{
+		Deleted:   msg.Type == unix.RTM_DELADDR,
+		LinkIndex: int(ifAddr.Index),
+		Scope:     int(ifAddr.Scope),
+	}
+
+	// The local address is the address of the interface, and differs
+	// from the address attribute only on point-to-point interfaces.
+	for _, attr := range attrs {
+		switch attr.getType() {
+		case unix.IFA_ADDRESS:
+			if event.IPAddress == nil {
+				event.IPAddress = net.IP(attr.value)
+			}
+		case unix.IFA_LOCAL:
+			event.IPAddress = net.IP(attr.value)
+		}
+	}
+
+	if event.IPAddress == nil {
+		return nil, fmt.Errorf("Address message without address")
+	}
+
+	event.IPNet = &net.IPNet{
+		IP:   event.IPAddress.Mask(net.CIDRMask(int(ifAddr.Prefixlen), 8*len(event.IPAddress))),
+		Mask: net.CIDRMask(int(ifAddr.Prefixlen), 8*len(event.IPAddress)),
+	}
+
+	return event, nil
+}
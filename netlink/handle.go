@@ -0,0 +1,127 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package netlink
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/Azure/azure-container-networking/log"
+	"golang.org/x/sys/unix"
+)
+
+// Handle sends netlink requests on a socket of a network namespace.
+// Sockets stay in the namespace they were created in, so requests of a handle operate
+// on its namespace regardless of the namespace of the calling thread.
+type Handle struct {
+	s    *socket
+	nsFd int
+}
+
+// The default handle uses the process-wide socket of the calling thread's namespace.
+var defaultHandle = &Handle{nsFd: -1}
+
+// NewHandleAt creates a new handle for the network namespace at the given path.
+func NewHandleAt(nsPath string) (*Handle, error) {
+	file, err := os.Open(nsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewHandleAtFd(file.Fd())
+}
+
+// NewHandleAtFd creates a new handle for the network namespace of the given file descriptor.
+// The handle keeps its own copy of the file descriptor.
+func NewHandleAtFd(fd uintptr) (*Handle, error) {
+	nsFd, err := unix.Dup(int(fd))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newSocketAt(nsFd, 0)
+	if err != nil {
+		unix.Close(nsFd)
+		return nil, err
+	}
+
+	return &Handle{s: s, nsFd: nsFd}, nil
+}
+
+// Close releases the socket and the namespace of a handle.
+func (h *Handle) Close() {
+	if h.s != nil {
+		h.s.close()
+		h.s = nil
+	}
+
+	if h.nsFd >= 0 {
+		unix.Close(h.nsFd)
+		h.nsFd = -1
+	}
+}
+
+// Returns the socket of a handle.
+func (h *Handle) getSocket() (*socket, error) {
+	if h.s == nil {
+		if h != defaultHandle {
+			return nil, fmt.Errorf("Handle is closed")
+		}
+		return getSocket()
+	}
+
+	return h.s, nil
+}
+
+// Creates a new netlink socket in the network namespace of the given file descriptor,
+// or in the calling thread's namespace if the file descriptor is negative.
+func newSocketAt(nsFd int, groups uint32) (*socket, error) {
+	if nsFd < 0 {
+		return newSocketWithGroups(groups)
+	}
+
+	// The calling thread is in the namespace only while the socket is created.
+	runtime.LockOSThread()
+
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer origin.Close()
+
+	err = unix.Setns(nsFd, unix.CLONE_NEWNET)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+
+	s, err := newSocketWithGroups(groups)
+
+	if nsErr := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); nsErr != nil {
+		// Leave the thread locked so that it exits with the goroutine
+		// instead of running other goroutines in the wrong namespace.
+		log.Printf("[netlink] Failed to restore netns, err=%v\n", nsErr)
+		if s != nil {
+			s.close()
+		}
+		return nil, nsErr
+	}
+
+	runtime.UnlockOSThread()
+
+	return s, err
+}
+
+// Returns the index of a network interface in the namespace of a handle.
+func (h *Handle) getLinkIndex(name string) (int, error) {
+	link, err := h.GetLink(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return link.Info().Index, nil
+}
@@ -21,15 +21,15 @@ func GetIpAddressFamily(ip net.IP) int {
 }
 
 // setIpAddress sends an IP address set request.
-func setIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet, add bool) error {
+func (h *Handle) setIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet, add bool) error {
 	var msgType, flags int
 
-	s, err := getSocket()
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(ifName)
+	ifaceIndex, err := h.getLinkIndex(ifName)
 	if err != nil {
 		return err
 	}
@@ -47,7 +47,7 @@ func setIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet, add bool) e
 	family := GetIpAddressFamily(ipAddress)
 
 	ifAddr := newIfAddrMsg(family)
-	ifAddr.Index = uint32(iface.Index)
+	ifAddr.Index = uint32(ifaceIndex)
 	prefixLen, _ := ipNet.Mask.Size()
 	ifAddr.Prefixlen = uint8(prefixLen)
 	req.addPayload(ifAddr)
@@ -65,14 +65,24 @@ func setIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet, add bool) e
 	return s.sendAndWaitForAck(req)
 }
 
+// AddIpAddress adds an IP address to a network interface.
+func (h *Handle) AddIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet) error {
+	return h.setIpAddress(ifName, ipAddress, ipNet, true)
+}
+
 // AddIpAddress adds an IP address to a network interface.
 func AddIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet) error {
-	return setIpAddress(ifName, ipAddress, ipNet, true)
+	return defaultHandle.AddIpAddress(ifName, ipAddress, ipNet)
+}
+
+// DeleteIpAddress deletes an IP address from a network interface.
+func (h *Handle) DeleteIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet) error {
+	return h.setIpAddress(ifName, ipAddress, ipNet, false)
 }
 
 // DeleteIpAddress deletes an IP address from a network interface.
 func DeleteIpAddress(ifName string, ipAddress net.IP, ipNet *net.IPNet) error {
-	return setIpAddress(ifName, ipAddress, ipNet, false)
+	return defaultHandle.DeleteIpAddress(ifName, ipAddress, ipNet)
 }
 
 // Route represents a netlink route.
@@ -136,8 +146,8 @@ func deserializeRoute(msg *message) (*Route, error) {
 }
 
 // GetIpRoute returns a list of IP routes matching the given filter.
-func GetIpRoute(filter *Route) ([]*Route, error) {
-	s, err := getSocket()
+func (h *Handle) GetIpRoute(filter *Route) ([]*Route, error) {
+	s, err := h.getSocket()
 	if err != nil {
 		return nil, err
 	}
@@ -207,11 +217,16 @@ func GetIpRoute(filter *Route) ([]*Route, error) {
 	return routes, nil
 }
 
+// GetIpRoute returns a list of IP routes matching the given filter.
+func GetIpRoute(filter *Route) ([]*Route, error) {
+	return defaultHandle.GetIpRoute(filter)
+}
+
 // setIpRoute sends an IP route set request.
-func setIpRoute(route *Route, add bool) error {
+func (h *Handle) setIpRoute(route *Route, add bool) error {
 	var msgType, flags int
 
-	s, err := getSocket()
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
@@ -275,12 +290,22 @@ func setIpRoute(route *Route, add bool) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// AddIpRoute adds an IP route to the route table.
+func (h *Handle) AddIpRoute(route *Route) error {
+	return h.setIpRoute(route, true)
+}
+
 // AddIpRoute adds an IP route to the route table.
 func AddIpRoute(route *Route) error {
-	return setIpRoute(route, true)
+	return defaultHandle.AddIpRoute(route)
+}
+
+// DeleteIpRoute deletes an IP route from the route table.
+func (h *Handle) DeleteIpRoute(route *Route) error {
+	return h.setIpRoute(route, false)
 }
 
 // DeleteIpRoute deletes an IP route from the route table.
 func DeleteIpRoute(route *Route) error {
-	return setIpRoute(route, false)
+	return defaultHandle.DeleteIpRoute(route)
 }
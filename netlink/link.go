@@ -13,10 +13,13 @@ import (
 
 // Link types.
 const (
-	LINK_TYPE_BRIDGE = "bridge"
-	LINK_TYPE_VETH   = "veth"
-	LINK_TYPE_IPVLAN = "ipvlan"
-	LINK_TYPE_DUMMY  = "dummy"
+	LINK_TYPE_BRIDGE  = "bridge"
+	LINK_TYPE_VETH    = "veth"
+	LINK_TYPE_IPVLAN  = "ipvlan"
+	LINK_TYPE_DUMMY   = "dummy"
+	LINK_TYPE_VLAN    = "vlan"
+	LINK_TYPE_VXLAN   = "vxlan"
+	LINK_TYPE_MACVLAN = "macvlan"
 )
 
 // IPVLAN link attributes.
@@ -29,19 +32,47 @@ const (
 	IPVLAN_MODE_MAX
 )
 
+// MACVLAN link attributes.
+type MacvlanMode uint32
+
+const (
+	MACVLAN_MODE_PRIVATE  MacvlanMode = 1
+	MACVLAN_MODE_VEPA     MacvlanMode = 2
+	MACVLAN_MODE_BRIDGE   MacvlanMode = 4
+	MACVLAN_MODE_PASSTHRU MacvlanMode = 8
+)
+
+// Link operational states, as defined in RFC 2863.
+type LinkOperState uint8
+
+const (
+	OPER_UNKNOWN LinkOperState = iota
+	OPER_NOTPRESENT
+	OPER_DOWN
+	OPER_LOWERLAYERDOWN
+	OPER_TESTING
+	OPER_DORMANT
+	OPER_UP
+)
+
 // Link represents a network interface.
 type Link interface {
 	Info() *LinkInfo
 }
 
 // LinkInfo respresents the common properties of all network interfaces.
+// Index, HardwareAddr, MasterIndex and OperState are only reported by GetLink and ListLinks.
 type LinkInfo struct {
-	Type        string
-	Name        string
-	Flags       net.Flags
-	MTU         uint
-	TxQLen      uint
-	ParentIndex int
+	Type         string
+	Name         string
+	Flags        net.Flags
+	MTU          uint
+	TxQLen       uint
+	ParentIndex  int
+	Index        int
+	HardwareAddr net.HardwareAddr
+	MasterIndex  int
+	OperState    LinkOperState
 }
 
 func (linkInfo *LinkInfo) Info() *LinkInfo {
@@ -54,9 +85,11 @@ type BridgeLink struct {
 }
 
 // VEthLink represents a virtual ethernet network interface.
+// GetLink and ListLinks report the index of the peer, but not its name.
 type VEthLink struct {
 	LinkInfo
-	PeerName string
+	PeerName  string
+	PeerIndex int
 }
 
 // IPVlanLink represents an IPVlan network interface.
@@ -70,8 +103,33 @@ type DummyLink struct {
 	LinkInfo
 }
 
+// VlanLink represents an 802.1Q VLAN network interface.
+// ParentIndex is the index of the network interface that carries the tagged traffic.
+type VlanLink struct {
+	LinkInfo
+	VlanId uint16
+}
+
+// VxlanLink represents a VXLAN network interface.
+// ParentIndex is the index of the network interface used to reach remote endpoints.
+// Group is the remote endpoint or multicast group, and Port is the UDP destination port.
+type VxlanLink struct {
+	LinkInfo
+	VxlanId  uint32
+	Group    net.IP
+	Local    net.IP
+	Port     uint16
+	Learning bool
+}
+
+// MacvlanLink represents a MACVLAN network interface.
+type MacvlanLink struct {
+	LinkInfo
+	Mode MacvlanMode
+}
+
 // AddLink adds a new network interface of a specified type.
-func AddLink(link Link) error {
+func (h *Handle) AddLink(link Link) error {
 	var info *LinkInfo
 	info = link.Info()
 
@@ -79,7 +137,7 @@ func AddLink(link Link) error {
 		return fmt.Errorf("Invalid link name or type")
 	}
 
-	s, err := getSocket()
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
@@ -110,8 +168,8 @@ func AddLink(link Link) error {
 		req.addPayload(newAttributeUint32(unix.IFLA_TXQLEN, uint32(info.TxQLen)))
 	}
 
-	// Set parent interface index.
-	if info.ParentIndex != 0 {
+	// Set parent interface index. A VXLAN carries it in its own attributes.
+	if _, ok := link.(*VxlanLink); !ok && info.ParentIndex != 0 {
 		req.addPayload(newAttributeUint32(unix.IFLA_LINK, uint32(info.ParentIndex)))
 	}
 
@@ -136,6 +194,58 @@ func AddLink(link Link) error {
 		attrData := newAttribute(IFLA_INFO_DATA, nil)
 		attrData.addNested(newAttributeUint16(IFLA_IPVLAN_MODE, uint16(ipvlan.Mode)))
 
+		attrLinkInfo.addNested(attrData)
+
+	} else if vlan, ok := link.(*VlanLink); ok {
+		// Set Vlan attributes.
+		attrData := newAttribute(IFLA_INFO_DATA, nil)
+		attrData.addNested(newAttributeUint16(IFLA_VLAN_ID, vlan.VlanId))
+
+		attrLinkInfo.addNested(attrData)
+
+	} else if vxlan, ok := link.(*VxlanLink); ok {
+		// Set Vxlan attributes.
+		attrData := newAttribute(IFLA_INFO_DATA, nil)
+		attrData.addNested(newAttributeUint32(IFLA_VXLAN_ID, vxlan.VxlanId))
+
+		if vxlan.ParentIndex != 0 {
+			attrData.addNested(newAttributeUint32(IFLA_VXLAN_LINK, uint32(vxlan.ParentIndex)))
+		}
+
+		if vxlan.Group != nil {
+			if vxlan.Group.To4() != nil {
+				attrData.addNested(newAttributeIpAddress(IFLA_VXLAN_GROUP, vxlan.Group))
+			} else {
+				attrData.addNested(newAttributeIpAddress(IFLA_VXLAN_GROUP6, vxlan.Group))
+			}
+		}
+
+		if vxlan.Local != nil {
+			if vxlan.Local.To4() != nil {
+				attrData.addNested(newAttributeIpAddress(IFLA_VXLAN_LOCAL, vxlan.Local))
+			} else {
+				attrData.addNested(newAttributeIpAddress(IFLA_VXLAN_LOCAL6, vxlan.Local))
+			}
+		}
+
+		// The kernel enables learning unless told otherwise.
+		var learning uint8
+		if vxlan.Learning {
+			learning = 1
+		}
+		attrData.addNested(newAttributeUint8(IFLA_VXLAN_LEARNING, learning))
+
+		if vxlan.Port != 0 {
+			attrData.addNested(newAttributeUint16BE(IFLA_VXLAN_PORT, vxlan.Port))
+		}
+
+		attrLinkInfo.addNested(attrData)
+
+	} else if macvlan, ok := link.(*MacvlanLink); ok {
+		// Set Macvlan attributes.
+		attrData := newAttribute(IFLA_INFO_DATA, nil)
+		attrData.addNested(newAttributeUint32(IFLA_MACVLAN_MODE, uint32(macvlan.Mode)))
+
 		attrLinkInfo.addNested(attrData)
 	}
 
@@ -144,20 +254,248 @@ func AddLink(link Link) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// AddLink adds a new network interface of a specified type.
+func AddLink(link Link) error {
+	return defaultHandle.AddLink(link)
+}
+
+// getLinkFlags converts interface flags in a netlink message to net.Flags.
+func getLinkFlags(rawFlags uint32) net.Flags {
+	var flags net.Flags
+
+	if rawFlags&unix.IFF_UP != 0 {
+		flags |= net.FlagUp
+	}
+	if rawFlags&unix.IFF_BROADCAST != 0 {
+		flags |= net.FlagBroadcast
+	}
+	if rawFlags&unix.IFF_LOOPBACK != 0 {
+		flags |= net.FlagLoopback
+	}
+	if rawFlags&unix.IFF_POINTOPOINT != 0 {
+		flags |= net.FlagPointToPoint
+	}
+	if rawFlags&unix.IFF_MULTICAST != 0 {
+		flags |= net.FlagMulticast
+	}
+
+	return flags
+}
+
+// deserializeLink decodes a netlink message into a Link of the type of the network interface.
+// Network interfaces of unknown types are returned as a *LinkInfo.
+func deserializeLink(msg *message) (Link, error) {
+	if len(msg.data) < unix.SizeofIfInfomsg {
+		return nil, fmt.Errorf("Invalid link message")
+	}
+
+	// Parse interface info message.
+	ifInfo := deserializeIfInfoMsg(msg.data)
+	attrs := msg.getAttributes(ifInfo)
+
+	info := LinkInfo{
+		Index: int(ifInfo.Index),
+		Flags: getLinkFlags(ifInfo.Flags),
+	}
+
+	var linkIndex int
+	var infoData []*attribute
+
+	// Populate link attributes.
+	for _, attr := range attrs {
+		switch attr.getType() {
+		case unix.IFLA_IFNAME:
+			info.Name = attr.getString()
+		case unix.IFLA_MTU:
+			info.MTU = uint(attr.getUint32())
+		case unix.IFLA_TXQLEN:
+			info.TxQLen = uint(attr.getUint32())
+		case unix.IFLA_LINK:
+			linkIndex = int(attr.getUint32())
+		case unix.IFLA_MASTER:
+			info.MasterIndex = int(attr.getUint32())
+		case unix.IFLA_ADDRESS:
+			info.HardwareAddr = net.HardwareAddr(attr.value)
+		case unix.IFLA_OPERSTATE:
+			info.OperState = LinkOperState(attr.getUint8())
+		case unix.IFLA_LINKINFO:
+			for _, nested := range deserializeAttributes(attr.value) {
+				switch nested.getType() {
+				case IFLA_INFO_KIND:
+					info.Type = nested.getString()
+				case IFLA_INFO_DATA:
+					infoData = deserializeAttributes(nested.value)
+				}
+			}
+		}
+	}
+
+	// A veth reports the index of its peer as its link.
+	if info.Type == LINK_TYPE_VETH {
+		return &VEthLink{LinkInfo: info, PeerIndex: linkIndex}, nil
+	}
+
+	if linkIndex != int(ifInfo.Index) {
+		info.ParentIndex = linkIndex
+	}
+
+	// Populate link type-specific attributes.
+	switch info.Type {
+	case LINK_TYPE_BRIDGE:
+		return &BridgeLink{LinkInfo: info}, nil
+	case LINK_TYPE_DUMMY:
+		return &DummyLink{LinkInfo: info}, nil
+	case LINK_TYPE_IPVLAN:
+		link := &IPVlanLink{LinkInfo: info}
+		for _, attr := range infoData {
+			if attr.getType() == IFLA_IPVLAN_MODE {
+				link.Mode = IPVlanMode(attr.getUint16())
+			}
+		}
+		return link, nil
+	case LINK_TYPE_VLAN:
+		link := &VlanLink{LinkInfo: info}
+		for _, attr := range infoData {
+			if attr.getType() == IFLA_VLAN_ID {
+				link.VlanId = attr.getUint16()
+			}
+		}
+		return link, nil
+	case LINK_TYPE_VXLAN:
+		link := &VxlanLink{LinkInfo: info}
+		for _, attr := range infoData {
+			switch attr.getType() {
+			case IFLA_VXLAN_ID:
+				link.VxlanId = attr.getUint32()
+			case IFLA_VXLAN_LINK:
+				link.ParentIndex = int(attr.getUint32())
+			case IFLA_VXLAN_GROUP, IFLA_VXLAN_GROUP6:
+				link.Group = net.IP(attr.value)
+			case IFLA_VXLAN_LOCAL, IFLA_VXLAN_LOCAL6:
+				link.Local = net.IP(attr.value)
+			case IFLA_VXLAN_LEARNING:
+				link.Learning = attr.getUint8() != 0
+			case IFLA_VXLAN_PORT:
+				link.Port = attr.getUint16BE()
+			}
+		}
+		return link, nil
+	case LINK_TYPE_MACVLAN:
+		link := &MacvlanLink{LinkInfo: info}
+		for _, attr := range infoData {
+			if attr.getType() == IFLA_MACVLAN_MODE {
+				link.Mode = MacvlanMode(attr.getUint32())
+			}
+		}
+		return link, nil
+	}
+
+	return &info, nil
+}
+
+// getLink sends a link get request and decodes the network interfaces in its response.
+func (h *Handle) getLink(req *message) ([]Link, error) {
+	s, err := h.getSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := s.sendAndWaitForResponse(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []Link
+	for _, msg := range msgs {
+		if msg.Type != unix.RTM_NEWLINK {
+			continue
+		}
+
+		link, err := deserializeLink(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// GetLink returns the network interface with the given name.
+func (h *Handle) GetLink(name string) (Link, error) {
+	req := newRequest(unix.RTM_GETLINK, 0)
+	req.addPayload(newIfInfoMsg())
+	req.addPayload(newAttributeStringZ(unix.IFLA_IFNAME, name))
+
+	links, err := h.getLink(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(links) == 0 {
+		return nil, unix.ENODEV
+	}
+
+	return links[0], nil
+}
+
+// GetLink returns the network interface with the given name.
+func GetLink(name string) (Link, error) {
+	return defaultHandle.GetLink(name)
+}
+
+// GetLinkByIndex returns the network interface with the given index.
+func (h *Handle) GetLinkByIndex(index int) (Link, error) {
+	req := newRequest(unix.RTM_GETLINK, 0)
+	ifInfo := newIfInfoMsg()
+	ifInfo.Index = int32(index)
+	req.addPayload(ifInfo)
+
+	links, err := h.getLink(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(links) == 0 {
+		return nil, unix.ENODEV
+	}
+
+	return links[0], nil
+}
+
+// GetLinkByIndex returns the network interface with the given index.
+func GetLinkByIndex(index int) (Link, error) {
+	return defaultHandle.GetLinkByIndex(index)
+}
+
+// ListLinks returns all network interfaces.
+func (h *Handle) ListLinks() ([]Link, error) {
+	req := newRequest(unix.RTM_GETLINK, unix.NLM_F_DUMP)
+	req.addPayload(newIfInfoMsg())
+
+	return h.getLink(req)
+}
+
+// ListLinks returns all network interfaces.
+func ListLinks() ([]Link, error) {
+	return defaultHandle.ListLinks()
+}
+
 // DeleteLink deletes a network interface.
-func DeleteLink(name string) error {
+func (h *Handle) DeleteLink(name string) error {
 	if name == "" {
 		log.Printf("[net] Invalid link name. Not returning error")
 		return nil
 	}
 
-	iface, err := net.InterfaceByName(name)
+	ifaceIndex, err := h.getLinkIndex(name)
 	if err != nil {
 		log.Printf("[net] Interface not found. Not returning error")
 		return nil
 	}
 
-	s, err := getSocket()
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
@@ -165,20 +503,25 @@ func DeleteLink(name string) error {
 	req := newRequest(unix.RTM_DELLINK, unix.NLM_F_ACK)
 
 	ifInfo := newIfInfoMsg()
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 	req.addPayload(ifInfo)
 
 	return s.sendAndWaitForAck(req)
 }
 
+// DeleteLink deletes a network interface.
+func DeleteLink(name string) error {
+	return defaultHandle.DeleteLink(name)
+}
+
 // SetLinkName sets the name of a network interface.
-func SetLinkName(name string, newName string) error {
-	s, err := getSocket()
+func (h *Handle) SetLinkName(name string, newName string) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(name)
+	ifaceIndex, err := h.getLinkIndex(name)
 	if err != nil {
 		return err
 	}
@@ -187,7 +530,7 @@ func SetLinkName(name string, newName string) error {
 
 	ifInfo := newIfInfoMsg()
 	ifInfo.Type = unix.RTM_SETLINK
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 	ifInfo.Flags = unix.NLM_F_REQUEST
 	ifInfo.Change = DEFAULT_CHANGE
 	req.addPayload(ifInfo)
@@ -198,14 +541,19 @@ func SetLinkName(name string, newName string) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// SetLinkName sets the name of a network interface.
+func SetLinkName(name string, newName string) error {
+	return defaultHandle.SetLinkName(name, newName)
+}
+
 // SetLinkState sets the operational state of a network interface.
-func SetLinkState(name string, up bool) error {
-	s, err := getSocket()
+func (h *Handle) SetLinkState(name string, up bool) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(name)
+	ifaceIndex, err := h.getLinkIndex(name)
 	if err != nil {
 		return err
 	}
@@ -214,7 +562,7 @@ func SetLinkState(name string, up bool) error {
 
 	ifInfo := newIfInfoMsg()
 	ifInfo.Type = unix.RTM_SETLINK
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 
 	if up {
 		ifInfo.Flags = unix.IFF_UP
@@ -229,32 +577,37 @@ func SetLinkState(name string, up bool) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// SetLinkState sets the operational state of a network interface.
+func SetLinkState(name string, up bool) error {
+	return defaultHandle.SetLinkState(name, up)
+}
+
 // SetLinkMaster sets the master (upper) device of a network interface.
-func SetLinkMaster(name string, master string) error {
-	s, err := getSocket()
+func (h *Handle) SetLinkMaster(name string, master string) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(name)
+	ifaceIndex, err := h.getLinkIndex(name)
 	if err != nil {
 		return err
 	}
 
 	var masterIndex uint32
 	if master != "" {
-		masterIface, err := net.InterfaceByName(master)
+		masterIfaceIndex, err := h.getLinkIndex(master)
 		if err != nil {
 			return err
 		}
-		masterIndex = uint32(masterIface.Index)
+		masterIndex = uint32(masterIfaceIndex)
 	}
 
 	req := newRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
 
 	ifInfo := newIfInfoMsg()
 	ifInfo.Type = unix.RTM_SETLINK
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 	ifInfo.Flags = unix.NLM_F_REQUEST
 	ifInfo.Change = DEFAULT_CHANGE
 	req.addPayload(ifInfo)
@@ -265,14 +618,19 @@ func SetLinkMaster(name string, master string) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// SetLinkMaster sets the master (upper) device of a network interface.
+func SetLinkMaster(name string, master string) error {
+	return defaultHandle.SetLinkMaster(name, master)
+}
+
 // SetLinkNetNs sets the network namespace of a network interface.
-func SetLinkNetNs(name string, fd uintptr) error {
-	s, err := getSocket()
+func (h *Handle) SetLinkNetNs(name string, fd uintptr) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(name)
+	ifaceIndex, err := h.getLinkIndex(name)
 	if err != nil {
 		return err
 	}
@@ -281,7 +639,7 @@ func SetLinkNetNs(name string, fd uintptr) error {
 
 	ifInfo := newIfInfoMsg()
 	ifInfo.Type = unix.RTM_SETLINK
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 	ifInfo.Flags = unix.NLM_F_REQUEST
 	ifInfo.Change = DEFAULT_CHANGE
 	req.addPayload(ifInfo)
@@ -292,14 +650,19 @@ func SetLinkNetNs(name string, fd uintptr) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// SetLinkNetNs sets the network namespace of a network interface.
+func SetLinkNetNs(name string, fd uintptr) error {
+	return defaultHandle.SetLinkNetNs(name, fd)
+}
+
 // SetLinkAddress sets the link layer hardware address of a network interface.
-func SetLinkAddress(ifName string, hwAddress net.HardwareAddr) error {
-	s, err := getSocket()
+func (h *Handle) SetLinkAddress(ifName string, hwAddress net.HardwareAddr) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(ifName)
+	ifaceIndex, err := h.getLinkIndex(ifName)
 	if err != nil {
 		return err
 	}
@@ -308,7 +671,7 @@ func SetLinkAddress(ifName string, hwAddress net.HardwareAddr) error {
 
 	ifInfo := newIfInfoMsg()
 	ifInfo.Type = unix.RTM_SETLINK
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 	ifInfo.Flags = unix.NLM_F_REQUEST
 	ifInfo.Change = DEFAULT_CHANGE
 	req.addPayload(ifInfo)
@@ -318,14 +681,19 @@ func SetLinkAddress(ifName string, hwAddress net.HardwareAddr) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// SetLinkAddress sets the link layer hardware address of a network interface.
+func SetLinkAddress(ifName string, hwAddress net.HardwareAddr) error {
+	return defaultHandle.SetLinkAddress(ifName, hwAddress)
+}
+
 // SetLinkPromisc sets the promiscuous mode of a network interface.
-func SetLinkPromisc(ifName string, on bool) error {
-	s, err := getSocket()
+func (h *Handle) SetLinkPromisc(ifName string, on bool) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(ifName)
+	ifaceIndex, err := h.getLinkIndex(ifName)
 	if err != nil {
 		return err
 	}
@@ -334,7 +702,7 @@ func SetLinkPromisc(ifName string, on bool) error {
 
 	ifInfo := newIfInfoMsg()
 	ifInfo.Type = unix.RTM_SETLINK
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 
 	if on {
 		ifInfo.Flags = unix.IFF_PROMISC
@@ -349,14 +717,19 @@ func SetLinkPromisc(ifName string, on bool) error {
 	return s.sendAndWaitForAck(req)
 }
 
+// SetLinkPromisc sets the promiscuous mode of a network interface.
+func SetLinkPromisc(ifName string, on bool) error {
+	return defaultHandle.SetLinkPromisc(ifName, on)
+}
+
 // SetLinkHairpin sets the hairpin (reflective relay) mode of a bridged interface.
-func SetLinkHairpin(bridgeName string, on bool) error {
-	s, err := getSocket()
+func (h *Handle) SetLinkHairpin(bridgeName string, on bool) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
 
-	iface, err := net.InterfaceByName(bridgeName)
+	ifaceIndex, err := h.getLinkIndex(bridgeName)
 	if err != nil {
 		return err
 	}
@@ -366,7 +739,7 @@ func SetLinkHairpin(bridgeName string, on bool) error {
 	ifInfo := newIfInfoMsg()
 	ifInfo.Family = unix.AF_BRIDGE
 	ifInfo.Type = unix.RTM_SETLINK
-	ifInfo.Index = int32(iface.Index)
+	ifInfo.Index = int32(ifaceIndex)
 	ifInfo.Flags = unix.NLM_F_REQUEST
 	ifInfo.Change = DEFAULT_CHANGE
 	req.addPayload(ifInfo)
@@ -382,3 +755,8 @@ func SetLinkHairpin(bridgeName string, on bool) error {
 
 	return s.sendAndWaitForAck(req)
 }
+
+// SetLinkHairpin sets the hairpin (reflective relay) mode of a bridged interface.
+func SetLinkHairpin(bridgeName string, on bool) error {
+	return defaultHandle.SetLinkHairpin(bridgeName, on)
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Neighbor states.
+const (
+	NUD_INCOMPLETE = 0x01
+	NUD_REACHABLE  = 0x02
+	NUD_STALE      = 0x04
+	NUD_DELAY      = 0x08
+	NUD_PROBE      = 0x10
+	NUD_FAILED     = 0x20
+	NUD_NOARP      = 0x40
+	NUD_PERMANENT  = 0x80
+)
+
+// Neighbor flags.
+const (
+	NTF_SELF   = 0x02
+	NTF_MASTER = 0x04
+)
+
+// Neighbor represents an entry of the neighbor (ARP and NDP) table, or of the forwarding
+// database (FDB) of a bridge or VXLAN interface when its family is AF_BRIDGE.
+// FDB entries of VXLAN interfaces map a hardware address to the IP address of a remote VTEP.
+type Neighbor struct {
+	Family       int
+	LinkIndex    int
+	State        int
+	Flags        int
+	IP           net.IP
+	HardwareAddr net.HardwareAddr
+	MasterIndex  int
+	Vlan         int
+	Vni          int
+}
+
+// deserializeNeighbor decodes a netlink message into a Neighbor struct.
+func deserializeNeighbor(msg *message) (*Neighbor, error) {
+	if len(msg.data) < SizeofNdMsg {
+		return nil, fmt.Errorf("Invalid neighbor message")
+	}
+
+	// Parse neighbor discovery message.
+	ndmsg := deserializeNdMsg(msg.data)
+	attrs := deserializeAttributes(msg.data[SizeofNdMsg:])
+
+	// Initialize a new neighbor object.
+	neigh := Neighbor{
+		Family:    int(ndmsg.Family),
+		LinkIndex: int(ndmsg.Index),
+		State:     int(ndmsg.State),
+		Flags:     int(ndmsg.Flags),
+	}
+
+	// Populate neighbor attributes.
+	for _, attr := range attrs {
+		switch attr.getType() {
+		case NDA_DST:
+			neigh.IP = net.IP(attr.value)
+		case NDA_LLADDR:
+			neigh.HardwareAddr = net.HardwareAddr(attr.value)
+		case NDA_MASTER:
+			neigh.MasterIndex = int(attr.getUint32())
+		case NDA_VLAN:
+			neigh.Vlan = int(attr.getUint16())
+		case NDA_VNI:
+			neigh.Vni = int(attr.getUint32())
+		}
+	}
+
+	return &neigh, nil
+}
+
+// GetNeighbor returns a list of neighbors of the family of the given filter.
+// Neighbors are filtered by link index when it is set in the filter.
+func (h *Handle) GetNeighbor(filter *Neighbor) ([]*Neighbor, error) {
+	s, err := h.getSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	req := newRequest(unix.RTM_GETNEIGH, unix.NLM_F_DUMP)
+	req.addPayload(newNdMsg(filter.Family))
+
+	msgs, err := s.sendAndWaitForResponse(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var neighs []*Neighbor
+
+	// For each neighbor in the list...
+	for _, msg := range msgs {
+		neigh, err := deserializeNeighbor(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		// Filter by link index.
+		if filter.LinkIndex != 0 && filter.LinkIndex != neigh.LinkIndex {
+			continue
+		}
+
+		neighs = append(neighs, neigh)
+	}
+
+	return neighs, nil
+}
+
+// GetNeighbor returns a list of neighbors of the family of the given filter.
+// Neighbors are filtered by link index when it is set in the filter.
+func GetNeighbor(filter *Neighbor) ([]*Neighbor, error) {
+	return defaultHandle.GetNeighbor(filter)
+}
+
+// setNeighbor sends a neighbor set request.
+func (h *Handle) setNeighbor(neigh *Neighbor, add bool) error {
+	var msgType, flags int
+
+	s, err := h.getSocket()
+	if err != nil {
+		return err
+	}
+
+	if add {
+		// Existing entries are replaced, as the kernel may have learned them already.
+		msgType = unix.RTM_NEWNEIGH
+		flags = unix.NLM_F_CREATE | unix.NLM_F_REPLACE | unix.NLM_F_ACK
+	} else {
+		msgType = unix.RTM_DELNEIGH
+		flags = unix.NLM_F_ACK
+	}
+
+	req := newRequest(msgType, flags)
+
+	family := neigh.Family
+	if family == 0 && neigh.IP != nil {
+		family = GetIpAddressFamily(neigh.IP)
+	}
+
+	msg := newNdMsg(family)
+	msg.Index = int32(neigh.LinkIndex)
+	msg.State = uint16(neigh.State)
+	msg.Flags = uint8(neigh.Flags)
+	req.addPayload(msg)
+
+	if neigh.IP != nil {
+		req.addPayload(newAttributeIpAddress(NDA_DST, neigh.IP))
+	}
+
+	if neigh.HardwareAddr != nil {
+		req.addPayload(newAttribute(NDA_LLADDR, neigh.HardwareAddr))
+	}
+
+	if neigh.Vlan != 0 {
+		req.addPayload(newAttributeUint16(NDA_VLAN, uint16(neigh.Vlan)))
+	}
+
+	if neigh.Vni != 0 {
+		req.addPayload(newAttributeUint32(NDA_VNI, uint32(neigh.Vni)))
+	}
+
+	return s.sendAndWaitForAck(req)
+}
+
+// AddNeighbor adds or replaces a neighbor or FDB entry.
+func (h *Handle) AddNeighbor(neigh *Neighbor) error {
+	return h.setNeighbor(neigh, true)
+}
+
+// AddNeighbor adds or replaces a neighbor or FDB entry.
+func AddNeighbor(neigh *Neighbor) error {
+	return defaultHandle.AddNeighbor(neigh)
+}
+
+// DeleteNeighbor deletes a neighbor or FDB entry.
+func (h *Handle) DeleteNeighbor(neigh *Neighbor) error {
+	return h.setNeighbor(neigh, false)
+}
+
+// DeleteNeighbor deletes a neighbor or FDB entry.
+func DeleteNeighbor(neigh *Neighbor) error {
+	return defaultHandle.DeleteNeighbor(neigh)
+}
@@ -13,8 +13,8 @@ func init() {
 }
 
 // Echo sends a netlink echo request message.
-func Echo(text string) error {
-	s, err := getSocket()
+func (h *Handle) Echo(text string) error {
+	s, err := h.getSocket()
 	if err != nil {
 		return err
 	}
@@ -28,3 +28,8 @@ func Echo(text string) error {
 
 	return s.sendAndWaitForAck(req)
 }
+
+// Echo sends a netlink echo request message.
+func Echo(text string) error {
+	return defaultHandle.Echo(text)
+}
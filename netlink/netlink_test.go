@@ -4,14 +4,20 @@
 package netlink
 
 import (
+	"fmt"
 	"net"
+	"runtime"
 	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 const (
-	ifName    = "nltest"
-	ifName2   = "nltest2"
-	dummyName = "dummy0"
+	ifName     = "nltest"
+	ifName2    = "nltest2"
+	dummyName  = "dummy0"
+	bridgeName = "nltestbr"
 )
 
 // AddDummyInterface creates a dummy test interface used during actual tests.
@@ -232,3 +238,833 @@ func TestSetLinkHairpin(t *testing.T) {
 		t.Errorf("DeleteLink failed: %+v", err)
 	}
 }
+
+// TestGetLink tests getting and listing network interfaces with their attributes.
+func TestGetLink(t *testing.T) {
+	err := AddLink(&BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: bridgeName,
+		},
+	})
+	if err != nil {
+		t.Errorf("AddLink failed: %+v", err)
+	}
+
+	err = AddLink(&VEthLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_VETH,
+			Name: ifName,
+			MTU:  1400,
+		},
+		PeerName: ifName2,
+	})
+	if err != nil {
+		t.Errorf("AddLink failed: %+v", err)
+	}
+
+	err = SetLinkMaster(ifName, bridgeName)
+	if err != nil {
+		t.Errorf("SetLinkMaster failed: %+v", err)
+	}
+
+	bridge, _ := net.InterfaceByName(bridgeName)
+	veth, _ := net.InterfaceByName(ifName)
+	peer, _ := net.InterfaceByName(ifName2)
+	if bridge == nil || veth == nil || peer == nil {
+		t.Fatalf("Interfaces not created")
+	}
+
+	link, err := GetLink(ifName)
+	if err != nil {
+		t.Fatalf("GetLink failed: %+v", err)
+	}
+
+	vethLink, ok := link.(*VEthLink)
+	if !ok {
+		t.Fatalf("GetLink returned %+v instead of a veth", link)
+	}
+
+	if vethLink.Index != veth.Index || vethLink.Name != ifName || vethLink.MTU != 1400 ||
+		vethLink.PeerIndex != peer.Index || vethLink.MasterIndex != bridge.Index ||
+		vethLink.HardwareAddr.String() != veth.HardwareAddr.String() {
+		t.Errorf("GetLink returned unexpected attributes %+v", vethLink)
+	}
+
+	link, err = GetLinkByIndex(bridge.Index)
+	if err != nil {
+		t.Errorf("GetLinkByIndex failed: %+v", err)
+	} else if _, ok := link.(*BridgeLink); !ok || link.Info().Name != bridgeName {
+		t.Errorf("GetLinkByIndex returned %+v instead of the bridge", link)
+	}
+
+	links, err := ListLinks()
+	if err != nil {
+		t.Errorf("ListLinks failed: %+v", err)
+	}
+
+	found := 0
+	for _, link := range links {
+		switch link.Info().Name {
+		case bridgeName, ifName, ifName2:
+			found++
+		}
+	}
+	if found != 3 {
+		t.Errorf("ListLinks returned %d of the 3 test interfaces", found)
+	}
+
+	err = DeleteLink(ifName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	err = DeleteLink(bridgeName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	_, err = GetLink(ifName)
+	if err == nil {
+		t.Errorf("GetLink of a deleted interface succeeded")
+	}
+}
+
+// TestDeserializeLink tests decoding the type-specific attributes of a network interface.
+func TestDeserializeLink(t *testing.T) {
+	ifInfo := newIfInfoMsg()
+	ifInfo.Index = 5
+	ifInfo.Flags = unix.IFF_UP | unix.IFF_BROADCAST
+
+	attrLinkInfo := newAttribute(unix.IFLA_LINKINFO, nil)
+	attrLinkInfo.addNested(newAttributeString(IFLA_INFO_KIND, LINK_TYPE_VLAN))
+	attrData := newAttribute(IFLA_INFO_DATA, nil)
+	attrData.addNested(newAttributeUint16(IFLA_VLAN_ID, 100))
+	attrLinkInfo.addNested(attrData)
+
+	attrs := []*attribute{
+		newAttributeStringZ(unix.IFLA_IFNAME, "eth0.100"),
+		newAttributeUint32(unix.IFLA_LINK, 2),
+		newAttribute(unix.IFLA_OPERSTATE, []byte{byte(OPER_UP)}),
+		attrLinkInfo,
+	}
+
+	msg := &message{data: ifInfo.serialize()}
+	msg.payload = append(msg.payload, nil)
+	for _, attr := range attrs {
+		msg.data = append(msg.data, attr.serialize()...)
+	}
+	for _, attr := range deserializeAttributes(msg.data[unix.SizeofIfInfomsg:]) {
+		msg.payload = append(msg.payload, attr)
+	}
+
+	link, err := deserializeLink(msg)
+	if err != nil {
+		t.Fatalf("deserializeLink failed: %+v", err)
+	}
+
+	vlan, ok := link.(*VlanLink)
+	if !ok {
+		t.Fatalf("deserializeLink returned %+v instead of a VLAN", link)
+	}
+
+	if vlan.Index != 5 || vlan.Name != "eth0.100" || vlan.ParentIndex != 2 || vlan.VlanId != 100 ||
+		vlan.OperState != OPER_UP || vlan.Flags != net.FlagUp|net.FlagBroadcast {
+		t.Errorf("deserializeLink returned unexpected attributes %+v", vlan)
+	}
+}
+
+// waitForEvent returns the first event matching a condition, or nil if none is received in time.
+func waitForEvent(events <-chan Event, match func(Event) bool) Event {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if match(event) {
+				return event
+			}
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+// TestSubscribe tests receiving link and address events.
+func TestSubscribe(t *testing.T) {
+	events := make(chan Event, 16)
+	done := make(chan struct{})
+
+	err := Subscribe([]int{GROUP_LINK, GROUP_IPV4_ADDR}, events, done)
+	if err != nil {
+		t.Fatalf("Subscribe failed: %+v", err)
+	}
+
+	err = AddLink(&BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: bridgeName,
+		},
+	})
+	if err != nil {
+		t.Errorf("AddLink failed: %+v", err)
+	}
+
+	event := waitForEvent(events, func(event Event) bool {
+		linkEvent, ok := event.(*LinkEvent)
+		return ok && !linkEvent.Deleted && linkEvent.Link.Info().Name == bridgeName
+	})
+	if event == nil {
+		t.Errorf("Link added event not received")
+	} else if _, ok := event.(*LinkEvent).Link.(*BridgeLink); !ok {
+		t.Errorf("Link added event has unexpected link %+v", event.(*LinkEvent).Link)
+	}
+
+	ipAddress, ipNet, _ := net.ParseCIDR("10.99.0.1/24")
+	err = AddIpAddress(bridgeName, ipAddress, ipNet)
+	if err != nil {
+		t.Errorf("AddIpAddress failed: %+v", err)
+	}
+
+	event = waitForEvent(events, func(event Event) bool {
+		addressEvent, ok := event.(*AddressEvent)
+		return ok && !addressEvent.Deleted && addressEvent.IPAddress.Equal(ipAddress)
+	})
+	if event == nil {
+		t.Errorf("Address added event not received")
+	} else if event.(*AddressEvent).IPNet.String() != ipNet.String() {
+		t.Errorf("Address added event has unexpected prefix %v", event.(*AddressEvent).IPNet)
+	}
+
+	err = DeleteLink(bridgeName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	event = waitForEvent(events, func(event Event) bool {
+		linkEvent, ok := event.(*LinkEvent)
+		return ok && linkEvent.Deleted && linkEvent.Link.Info().Name == bridgeName
+	})
+	if event == nil {
+		t.Errorf("Link deleted event not received")
+	}
+
+	close(done)
+	for range events {
+	}
+}
+
+// TestAddDeleteIpRule tests adding, getting and deleting a policy routing rule.
+func TestAddDeleteIpRule(t *testing.T) {
+	_, src, _ := net.ParseCIDR("10.99.0.0/24")
+	rule := &Rule{
+		Family:   unix.AF_INET,
+		Priority: 30000,
+		Table:    1000,
+		Src:      src,
+		IifName:  ifName,
+	}
+
+	err := AddIpRule(rule)
+	if err != nil {
+		t.Fatalf("AddIpRule failed: %+v", err)
+	}
+
+	rules, err := GetIpRule(&Rule{Family: unix.AF_INET, Table: 1000})
+	if err != nil || len(rules) != 1 {
+		t.Errorf("GetIpRule failed: %+v %+v", rules, err)
+	} else if rules[0].Priority != 30000 || rules[0].Src.String() != src.String() || rules[0].IifName != ifName {
+		t.Errorf("GetIpRule returned unexpected rule %+v", rules[0])
+	}
+
+	err = DeleteIpRule(rule)
+	if err != nil {
+		t.Errorf("DeleteIpRule failed: %+v", err)
+	}
+
+	rules, err = GetIpRule(&Rule{Family: unix.AF_INET, Table: 1000})
+	if err != nil || len(rules) != 0 {
+		t.Errorf("Rule not deleted: %+v %+v", rules, err)
+	}
+}
+
+// TestAddDeleteNeighbor tests adding, getting and deleting neighbor and bridge FDB entries.
+func TestAddDeleteNeighbor(t *testing.T) {
+	err := AddLink(&BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: bridgeName,
+		},
+	})
+	if err != nil {
+		t.Errorf("AddLink failed: %+v", err)
+	}
+
+	err = AddLink(&VEthLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_VETH,
+			Name: ifName,
+		},
+		PeerName: ifName2,
+	})
+	if err != nil {
+		t.Errorf("AddLink failed: %+v", err)
+	}
+
+	err = SetLinkMaster(ifName, bridgeName)
+	if err != nil {
+		t.Errorf("SetLinkMaster failed: %+v", err)
+	}
+
+	bridge, _ := net.InterfaceByName(bridgeName)
+	veth, _ := net.InterfaceByName(ifName)
+	if bridge == nil || veth == nil {
+		t.Fatalf("Interfaces not created")
+	}
+
+	hwAddr, _ := net.ParseMAC("12:34:56:78:9a:bc")
+
+	// Static ARP entry.
+	neigh := &Neighbor{
+		LinkIndex:    bridge.Index,
+		State:        NUD_PERMANENT,
+		IP:           net.ParseIP("10.99.0.2").To4(),
+		HardwareAddr: hwAddr,
+	}
+
+	err = AddNeighbor(neigh)
+	if err != nil {
+		t.Errorf("AddNeighbor failed: %+v", err)
+	}
+
+	neighs, err := GetNeighbor(&Neighbor{Family: unix.AF_INET, LinkIndex: bridge.Index})
+	if err != nil || len(neighs) != 1 {
+		t.Errorf("GetNeighbor failed: %+v %+v", neighs, err)
+	} else if !neighs[0].IP.Equal(neigh.IP) || neighs[0].HardwareAddr.String() != hwAddr.String() ||
+		neighs[0].State != NUD_PERMANENT {
+		t.Errorf("GetNeighbor returned unexpected neighbor %+v", neighs[0])
+	}
+
+	err = DeleteNeighbor(neigh)
+	if err != nil {
+		t.Errorf("DeleteNeighbor failed: %+v", err)
+	}
+
+	// Static FDB entry of a bridge port.
+	fdb := &Neighbor{
+		Family:       unix.AF_BRIDGE,
+		LinkIndex:    veth.Index,
+		State:        NUD_NOARP,
+		Flags:        NTF_MASTER,
+		HardwareAddr: hwAddr,
+	}
+
+	err = AddNeighbor(fdb)
+	if err != nil {
+		t.Errorf("AddNeighbor of FDB entry failed: %+v", err)
+	}
+
+	found := false
+	neighs, err = GetNeighbor(&Neighbor{Family: unix.AF_BRIDGE, LinkIndex: veth.Index})
+	for _, neigh := range neighs {
+		if neigh.HardwareAddr.String() == hwAddr.String() && neigh.MasterIndex == bridge.Index {
+			found = true
+		}
+	}
+	if err != nil || !found {
+		t.Errorf("GetNeighbor of FDB entries failed: %+v %+v", neighs, err)
+	}
+
+	err = DeleteNeighbor(fdb)
+	if err != nil {
+		t.Errorf("DeleteNeighbor of FDB entry failed: %+v", err)
+	}
+
+	err = DeleteLink(ifName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	err = DeleteLink(bridgeName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+}
+
+// Creates a new network namespace and returns a file descriptor for it.
+func newTestNs() (int, error) {
+	var nsFd int
+	var err error
+
+	done := make(chan struct{})
+
+	// Unshare on a thread that exits with its goroutine, so no other goroutine runs in the new namespace.
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+
+		err = unix.Unshare(unix.CLONE_NEWNET)
+		if err != nil {
+			runtime.UnlockOSThread()
+			return
+		}
+
+		nsFd, err = unix.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()), unix.O_RDONLY, 0)
+	}()
+
+	<-done
+	return nsFd, err
+}
+
+// Tests that a handle operates on its namespace without changing the caller's namespace.
+func TestHandle(t *testing.T) {
+	nsFd, err := newTestNs()
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %+v", err)
+	}
+	defer unix.Close(nsFd)
+
+	h, err := NewHandleAtFd(uintptr(nsFd))
+	if err != nil {
+		t.Fatalf("NewHandleAtFd failed: %+v", err)
+	}
+	defer h.Close()
+
+	err = h.Echo("this is a test")
+	if err != nil {
+		t.Errorf("Echo failed: %+v", err)
+	}
+
+	link := BridgeLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_BRIDGE,
+			Name: ifName,
+		},
+	}
+
+	err = h.AddLink(&link)
+	if err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	_, err = net.InterfaceByName(ifName)
+	if err == nil {
+		DeleteLink(ifName)
+		t.Fatalf("Interface created in the caller's namespace")
+	}
+
+	err = h.SetLinkState(ifName, true)
+	if err != nil {
+		t.Errorf("SetLinkState failed: %+v", err)
+	}
+
+	ip, ipNet, _ := net.ParseCIDR("10.10.10.1/24")
+	err = h.AddIpAddress(ifName, ip, ipNet)
+	if err != nil {
+		t.Errorf("AddIpAddress failed: %+v", err)
+	}
+
+	l, err := h.GetLink(ifName)
+	if err != nil {
+		t.Fatalf("GetLink failed: %+v", err)
+	}
+	if l.Info().Type != LINK_TYPE_BRIDGE {
+		t.Errorf("GetLink returned link type %v", l.Info().Type)
+	}
+
+	err = h.DeleteLink(ifName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+
+	_, err = h.GetLink(ifName)
+	if err == nil {
+		t.Errorf("Interface not deleted")
+	}
+
+	h.Close()
+
+	err = h.Echo("this is a test")
+	if err == nil {
+		t.Errorf("Echo succeeded on a closed handle")
+	}
+}
+
+// Creates a veth pair used as the parent of test interfaces.
+func addParentLink(t *testing.T) int {
+	link := VEthLink{
+		LinkInfo: LinkInfo{
+			Type: LINK_TYPE_VETH,
+			Name: ifName,
+		},
+		PeerName: ifName2,
+	}
+
+	err := AddLink(&link)
+	if err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	parent, err := GetLink(ifName)
+	if err != nil {
+		DeleteLink(ifName)
+		t.Fatalf("GetLink failed: %+v", err)
+	}
+
+	return parent.Info().Index
+}
+
+func TestAddDeleteVlan(t *testing.T) {
+	parentIndex := addParentLink(t)
+	defer DeleteLink(ifName)
+
+	link := VlanLink{
+		LinkInfo: LinkInfo{
+			Type:        LINK_TYPE_VLAN,
+			Name:        bridgeName,
+			ParentIndex: parentIndex,
+		},
+		VlanId: 5,
+	}
+
+	err := AddLink(&link)
+	if err == unix.EOPNOTSUPP {
+		t.Skip("VLAN links are not supported by the kernel")
+	}
+	if err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	l, err := GetLink(bridgeName)
+	if err != nil {
+		t.Errorf("GetLink failed: %+v", err)
+	} else if vlan, ok := l.(*VlanLink); !ok || vlan.VlanId != 5 || vlan.ParentIndex != parentIndex {
+		t.Errorf("GetLink returned %+v", l)
+	}
+
+	err = DeleteLink(bridgeName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+}
+
+func TestAddDeleteVxlan(t *testing.T) {
+	parentIndex := addParentLink(t)
+	defer DeleteLink(ifName)
+
+	link := VxlanLink{
+		LinkInfo: LinkInfo{
+			Type:        LINK_TYPE_VXLAN,
+			Name:        bridgeName,
+			ParentIndex: parentIndex,
+		},
+		VxlanId: 42,
+		Group:   net.ParseIP("10.0.0.2"),
+		Local:   net.ParseIP("10.0.0.1"),
+		Port:    4789,
+	}
+
+	err := AddLink(&link)
+	if err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	l, err := GetLink(bridgeName)
+	if err != nil {
+		t.Errorf("GetLink failed: %+v", err)
+	} else if vxlan, ok := l.(*VxlanLink); !ok {
+		t.Errorf("GetLink returned %+v", l)
+	} else if vxlan.VxlanId != 42 || vxlan.ParentIndex != parentIndex ||
+		!vxlan.Group.Equal(link.Group) || !vxlan.Local.Equal(link.Local) ||
+		vxlan.Port != 4789 || vxlan.Learning {
+		t.Errorf("GetLink returned %+v", vxlan)
+	}
+
+	err = DeleteLink(bridgeName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+}
+
+func TestAddDeleteMacvlan(t *testing.T) {
+	parentIndex := addParentLink(t)
+	defer DeleteLink(ifName)
+
+	link := MacvlanLink{
+		LinkInfo: LinkInfo{
+			Type:        LINK_TYPE_MACVLAN,
+			Name:        bridgeName,
+			ParentIndex: parentIndex,
+		},
+		Mode: MACVLAN_MODE_PRIVATE,
+	}
+
+	err := AddLink(&link)
+	if err != nil {
+		t.Fatalf("AddLink failed: %+v", err)
+	}
+
+	l, err := GetLink(bridgeName)
+	if err != nil {
+		t.Errorf("GetLink failed: %+v", err)
+	} else if macvlan, ok := l.(*MacvlanLink); !ok || macvlan.Mode != MACVLAN_MODE_PRIVATE || macvlan.ParentIndex != parentIndex {
+		t.Errorf("GetLink returned %+v", l)
+	}
+
+	err = DeleteLink(bridgeName)
+	if err != nil {
+		t.Errorf("DeleteLink failed: %+v", err)
+	}
+}
+
+func TestAddDeleteQdisc(t *testing.T) {
+	linkIndex := addParentLink(t)
+	defer DeleteLink(ifName)
+
+	tbf := TbfQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:      QDISC_TYPE_TBF,
+			LinkIndex: linkIndex,
+			Handle:    MakeTcHandle(1, 0),
+		},
+		Rate:  125000,
+		Burst: 32000,
+		Limit: 64000,
+	}
+
+	err := AddQdisc(&tbf)
+	if err != nil {
+		t.Fatalf("AddQdisc failed: %+v", err)
+	}
+
+	fqCodel := FqCodelQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:      QDISC_TYPE_FQ_CODEL,
+			LinkIndex: linkIndex,
+			Handle:    MakeTcHandle(10, 0),
+			Parent:    MakeTcHandle(1, 1),
+		},
+		Target: 5000,
+		Limit:  1000,
+	}
+
+	// Kernels without fq_codel report that the qdisc kind is unknown.
+	err = AddQdisc(&fqCodel)
+	fqCodelSupported := err != unix.ENOENT
+	if err != nil && fqCodelSupported {
+		t.Errorf("AddQdisc failed: %+v", err)
+	}
+
+	qdiscs, err := GetQdisc(linkIndex)
+	if err != nil {
+		t.Fatalf("GetQdisc failed: %+v", err)
+	}
+
+	var foundTbf, foundFqCodel bool
+	for _, qdisc := range qdiscs {
+		switch q := qdisc.(type) {
+		case *TbfQdisc:
+			foundTbf = q.Rate == tbf.Rate && q.Limit == tbf.Limit && q.Handle == tbf.Handle && q.Burst > 0
+		case *FqCodelQdisc:
+			foundFqCodel = q.Target == 5000 && q.Limit == 1000 && !q.ECN && q.Parent == fqCodel.Parent
+		}
+	}
+
+	if !foundTbf || (fqCodelSupported && !foundFqCodel) {
+		t.Errorf("GetQdisc returned %+v", qdiscs)
+	}
+
+	err = DeleteQdisc(&tbf)
+	if err != nil {
+		t.Errorf("DeleteQdisc failed: %+v", err)
+	}
+
+	qdiscs, _ = GetQdisc(linkIndex)
+	for _, qdisc := range qdiscs {
+		if qdisc.Info().Type == QDISC_TYPE_TBF {
+			t.Errorf("Qdisc not deleted")
+		}
+	}
+}
+
+func TestAddDeleteClass(t *testing.T) {
+	linkIndex := addParentLink(t)
+	defer DeleteLink(ifName)
+
+	htb := HtbQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:      QDISC_TYPE_HTB,
+			LinkIndex: linkIndex,
+			Handle:    MakeTcHandle(1, 0),
+		},
+		DefaultClass: 0x20,
+	}
+
+	err := AddQdisc(&htb)
+	if err != nil {
+		t.Fatalf("AddQdisc failed: %+v", err)
+	}
+
+	class := HtbClass{
+		ClassInfo: ClassInfo{
+			Type:      CLASS_TYPE_HTB,
+			LinkIndex: linkIndex,
+			Handle:    MakeTcHandle(1, 0x10),
+			Parent:    MakeTcHandle(1, 0),
+		},
+		Rate: 1250000,
+		Ceil: 2500000,
+	}
+
+	err = AddClass(&class)
+	if err != nil {
+		t.Fatalf("AddClass failed: %+v", err)
+	}
+
+	classes, err := GetClass(linkIndex)
+	if err != nil {
+		t.Fatalf("GetClass failed: %+v", err)
+	}
+
+	var found bool
+	for _, c := range classes {
+		if c, ok := c.(*HtbClass); ok && c.Handle == class.Handle {
+			found = c.Rate == class.Rate && c.Ceil == class.Ceil && c.Buffer > 0
+		}
+	}
+
+	if !found {
+		t.Errorf("GetClass returned %+v", classes)
+	}
+
+	// Classify traffic to 10.0.0.0/8.
+	filter := U32Filter{
+		FilterInfo: FilterInfo{
+			Type:      FILTER_TYPE_U32,
+			LinkIndex: linkIndex,
+			Parent:    MakeTcHandle(1, 0),
+			Priority:  1,
+			Protocol:  unix.ETH_P_IP,
+		},
+		ClassId: class.Handle,
+		Keys:    []U32Key{{Mask: 0xFF000000, Value: 0x0A000000, Offset: 16}},
+	}
+
+	err = AddFilter(&filter)
+	if err != nil {
+		t.Fatalf("AddFilter failed: %+v", err)
+	}
+
+	filters, err := GetFilter(linkIndex, MakeTcHandle(1, 0))
+	if err != nil {
+		t.Fatalf("GetFilter failed: %+v", err)
+	}
+
+	found = false
+	for _, f := range filters {
+		if f, ok := f.(*U32Filter); ok && f.ClassId == class.Handle {
+			found = f.Priority == 1 && f.Protocol == unix.ETH_P_IP &&
+				len(f.Keys) == 1 && f.Keys[0] == filter.Keys[0]
+		}
+	}
+
+	if !found {
+		t.Errorf("GetFilter returned %+v", filters)
+	}
+
+	err = DeleteFilter(&filter)
+	if err != nil {
+		t.Errorf("DeleteFilter failed: %+v", err)
+	}
+
+	err = DeleteClass(&class)
+	if err != nil {
+		t.Errorf("DeleteClass failed: %+v", err)
+	}
+
+	err = DeleteQdisc(&htb)
+	if err != nil {
+		t.Errorf("DeleteQdisc failed: %+v", err)
+	}
+}
+
+func TestIngressFilter(t *testing.T) {
+	linkIndex := addParentLink(t)
+	defer DeleteLink(ifName)
+
+	peer, err := GetLink(ifName2)
+	if err != nil {
+		t.Fatalf("GetLink failed: %+v", err)
+	}
+
+	ingress := IngressQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:      QDISC_TYPE_INGRESS,
+			LinkIndex: linkIndex,
+		},
+	}
+
+	err = AddQdisc(&ingress)
+	if err != nil {
+		t.Fatalf("AddQdisc failed: %+v", err)
+	}
+
+	// Mirror all received traffic to the peer.
+	filter := U32Filter{
+		FilterInfo: FilterInfo{
+			Type:      FILTER_TYPE_U32,
+			LinkIndex: linkIndex,
+			Parent:    MakeTcHandle(0xFFFF, 0),
+			Priority:  1,
+		},
+		Actions: []Action{
+			&MirredAction{Mode: MIRRED_EGRESS_MIRROR, LinkIndex: peer.Info().Index},
+		},
+	}
+
+	err = AddFilter(&filter)
+	if err != nil {
+		t.Fatalf("AddFilter failed: %+v", err)
+	}
+
+	filters, err := GetFilter(linkIndex, MakeTcHandle(0xFFFF, 0))
+	if err != nil {
+		t.Fatalf("GetFilter failed: %+v", err)
+	}
+
+	var found bool
+	for _, f := range filters {
+		if f, ok := f.(*U32Filter); ok && len(f.Actions) == 1 {
+			mirred, ok := f.Actions[0].(*MirredAction)
+			found = ok && *mirred == *filter.Actions[0].(*MirredAction) && f.Protocol == unix.ETH_P_ALL
+		}
+	}
+
+	if !found {
+		t.Errorf("GetFilter returned %+v", filters)
+	}
+
+	err = DeleteQdisc(&ingress)
+	if err != nil {
+		t.Errorf("DeleteQdisc failed: %+v", err)
+	}
+
+	clsact := ClsactQdisc{
+		QdiscInfo: QdiscInfo{
+			Type:      QDISC_TYPE_CLSACT,
+			LinkIndex: linkIndex,
+		},
+	}
+
+	err = AddQdisc(&clsact)
+	if err != nil {
+		t.Fatalf("AddQdisc failed: %+v", err)
+	}
+
+	err = DeleteQdisc(&clsact)
+	if err != nil {
+		t.Errorf("DeleteQdisc failed: %+v", err)
+	}
+}
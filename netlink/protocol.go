@@ -6,6 +6,7 @@ package netlink
 import (
 	"encoding/binary"
 	"net"
+	"strings"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -13,13 +14,92 @@ import (
 
 // Netlink protocol constants that are not already defined in unix package.
 const (
-	IFLA_INFO_KIND   = 1
-	IFLA_INFO_DATA   = 2
-	IFLA_NET_NS_FD   = 28
-	IFLA_IPVLAN_MODE = 1
-	IFLA_BRPORT_MODE = 4
-	VETH_INFO_PEER   = 1
-	DEFAULT_CHANGE   = 0xFFFFFFFF
+	IFLA_INFO_KIND    = 1
+	IFLA_INFO_DATA    = 2
+	IFLA_NET_NS_FD    = 28
+	IFLA_IPVLAN_MODE  = 1
+	IFLA_VLAN_ID      = 1
+	IFLA_MACVLAN_MODE = 1
+	IFLA_BRPORT_MODE  = 4
+	VETH_INFO_PEER    = 1
+	DEFAULT_CHANGE    = 0xFFFFFFFF
+)
+
+// VXLAN link attributes.
+const (
+	IFLA_VXLAN_ID       = 1
+	IFLA_VXLAN_GROUP    = 2
+	IFLA_VXLAN_LINK     = 3
+	IFLA_VXLAN_LOCAL    = 4
+	IFLA_VXLAN_LEARNING = 7
+	IFLA_VXLAN_PORT     = 15
+	IFLA_VXLAN_GROUP6   = 16
+	IFLA_VXLAN_LOCAL6   = 17
+)
+
+// Policy routing rule attributes.
+const (
+	FRA_DST       = 1
+	FRA_SRC       = 2
+	FRA_IIFNAME   = 3
+	FRA_PRIORITY  = 6
+	FRA_FWMARK    = 10
+	FRA_TABLE     = 15
+	FRA_OIFNAME   = 17
+	FR_ACT_TO_TBL = 1
+)
+
+// Neighbor attributes.
+const (
+	NDA_DST     = 1
+	NDA_LLADDR  = 2
+	NDA_VLAN    = 5
+	NDA_VNI     = 7
+	NDA_MASTER  = 9
+	SizeofNdMsg = 12
+)
+
+// Traffic control attributes.
+const (
+	TCA_KIND                = 1
+	TCA_OPTIONS             = 2
+	TCA_TBF_PARMS           = 1
+	TCA_TBF_RATE64          = 4
+	TCA_TBF_BURST           = 6
+	TCA_HTB_PARMS           = 1
+	TCA_HTB_INIT            = 2
+	TCA_HTB_RATE64          = 6
+	TCA_HTB_CEIL64          = 7
+	TCA_FQ_CODEL_TARGET     = 1
+	TCA_FQ_CODEL_LIMIT      = 2
+	TCA_FQ_CODEL_INTERVAL   = 3
+	TCA_FQ_CODEL_ECN        = 4
+	TCA_FQ_CODEL_FLOWS      = 5
+	TCA_FQ_CODEL_QUANTUM    = 6
+	TCA_U32_CLASSID         = 1
+	TCA_U32_SEL             = 5
+	TCA_U32_ACT             = 7
+	TCA_BPF_ACT             = 1
+	TCA_BPF_CLASSID         = 3
+	TCA_BPF_FD              = 6
+	TCA_BPF_NAME            = 7
+	TCA_BPF_FLAGS           = 8
+	TCA_BPF_FLAG_ACT_DIRECT = 1
+	TCA_ACT_KIND            = 1
+	TCA_ACT_OPTIONS         = 2
+	TCA_GACT_PARMS          = 2
+	TCA_MIRRED_PARMS        = 2
+	TC_U32_TERMINAL         = 1
+	TC_LINKLAYER_ETHERNET   = 1
+	SizeofTcMsg             = 20
+	SizeofTcRateSpec        = 12
+	SizeofTcTbfQopt         = 36
+	SizeofTcHtbOpt          = 44
+	SizeofTcHtbGlob         = 20
+	SizeofTcU32Sel          = 16
+	SizeofTcU32Key          = 16
+	SizeofTcGen             = 20
+	SizeofTcMirred          = 28
 )
 
 // Serializable types are used to construct netlink messages.
@@ -168,6 +248,25 @@ func newAttributeUint16(attrType int, value uint16) *attribute {
 	return newAttribute(attrType, buf)
 }
 
+// Creates a new attribute with a uint16 value in network byte order.
+func newAttributeUint16BE(attrType int, value uint16) *attribute {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, value)
+	return newAttribute(attrType, buf)
+}
+
+// Creates a new attribute with a uint8 value.
+func newAttributeUint8(attrType int, value uint8) *attribute {
+	return newAttribute(attrType, []byte{value})
+}
+
+// Creates a new attribute with a uint64 value.
+func newAttributeUint64(attrType int, value uint64) *attribute {
+	buf := make([]byte, 8)
+	encoder.PutUint64(buf, value)
+	return newAttribute(attrType, buf)
+}
+
 // Creates a new attribute with a net.IP value.
 func newAttributeIpAddress(attrType int, value net.IP) *attribute {
 	addr := value.To4()
@@ -178,6 +277,85 @@ func newAttributeIpAddress(attrType int, value net.IP) *attribute {
 	}
 }
 
+// Deserializes a list of attributes, such as the nested attributes of an attribute.
+func deserializeAttributes(b []byte) []*attribute {
+	var attrs []*attribute
+
+	for len(b) >= unix.SizeofNlAttr {
+		length := int(encoder.Uint16(b[0:2]))
+		if length < unix.SizeofNlAttr || length > len(b) {
+			break
+		}
+
+		attr := &attribute{
+			NlAttr: unix.NlAttr{
+				Len:  uint16(length),
+				Type: encoder.Uint16(b[2:4]),
+			},
+			value: b[unix.SizeofNlAttr:length],
+		}
+		attrs = append(attrs, attr)
+
+		length = (length + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
+		if length >= len(b) {
+			break
+		}
+		b = b[length:]
+	}
+
+	return attrs
+}
+
+// Returns the type of an attribute without the nested and byte order flags.
+func (attr *attribute) getType() uint16 {
+	return attr.Type &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+}
+
+// Returns the value of an attribute as a string, without any null terminator.
+func (attr *attribute) getString() string {
+	return strings.TrimRight(string(attr.value), "\000")
+}
+
+// Returns the value of an attribute as a uint64, or zero if the value is too short.
+func (attr *attribute) getUint64() uint64 {
+	if len(attr.value) < 8 {
+		return 0
+	}
+	return encoder.Uint64(attr.value[0:8])
+}
+
+// Returns the value of an attribute as a uint32, or zero if the value is too short.
+func (attr *attribute) getUint32() uint32 {
+	if len(attr.value) < 4 {
+		return 0
+	}
+	return encoder.Uint32(attr.value[0:4])
+}
+
+// Returns the value of an attribute as a uint16, or zero if the value is too short.
+func (attr *attribute) getUint16() uint16 {
+	if len(attr.value) < 2 {
+		return 0
+	}
+	return encoder.Uint16(attr.value[0:2])
+}
+
+// Returns the value of an attribute as a uint16 in network byte order, or zero if the value is too short.
+func (attr *attribute) getUint16BE() uint16 {
+	if len(attr.value) < 2 {
+		return 0
+	}
+	return binary.BigEndian.Uint16(attr.value[0:2])
+}
+
+// Returns the value of an attribute as a uint8, or zero if the value is empty.
+func (attr *attribute) getUint8() uint8 {
+	if len(attr.value) < 1 {
+		return 0
+	}
+	return attr.value[0]
+}
+
 // Adds a nested attribute to an attribute.
 func (attr *attribute) addNested(nested serializable) {
 	attr.children = append(attr.children, nested)
@@ -188,8 +366,8 @@ func (attr *attribute) serialize() []byte {
 	length := attr.length()
 	buf := make([]byte, length)
 
-	// Encode length.
-	if l := uint16(length); l != 0 {
+	// Encode length, which excludes the padding of the value.
+	if l := uint16(attr.unalignedLength()); l != 0 {
 		encoder.PutUint16(buf[0:2], l)
 	}
 
@@ -212,14 +390,20 @@ func (attr *attribute) serialize() []byte {
 	return buf
 }
 
-// Returns the aligned length of an attribute.
-func (attr *attribute) length() int {
+// Returns the length of an attribute without padding.
+func (attr *attribute) unalignedLength() int {
 	len := unix.SizeofNlAttr + len(attr.value)
 
 	for _, child := range attr.children {
 		len += child.length()
 	}
 
+	return len
+}
+
+// Returns the aligned length of an attribute.
+func (attr *attribute) length() int {
+	len := attr.unalignedLength()
 	return (len + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
 }
 
@@ -253,6 +437,11 @@ func (ifInfo *ifInfoMsg) serialize() []byte {
 	return b
 }
 
+// Deserializes an interface info message.
+func deserializeIfInfoMsg(b []byte) *ifInfoMsg {
+	return (*ifInfoMsg)(unsafe.Pointer(&b[0:unix.SizeofIfInfomsg][0]))
+}
+
 // Returns the length of an interface info message.
 func (ifInfo *ifInfoMsg) length() int {
 	return unix.SizeofIfInfomsg
@@ -287,6 +476,11 @@ func (ifAddr *ifAddrMsg) serialize() []byte {
 	return b
 }
 
+// Deserializes an interface address message.
+func deserializeIfAddrMsg(b []byte) *ifAddrMsg {
+	return (*ifAddrMsg)(unsafe.Pointer(&b[0:unix.SizeofIfAddrmsg][0]))
+}
+
 // Returns the length of an interface address message.
 func (ifAddr *ifAddrMsg) length() int {
 	return unix.SizeofIfAddrmsg
@@ -337,3 +531,132 @@ func (rt *rtMsg) serialize() []byte {
 func (rt *rtMsg) length() int {
 	return unix.SizeofRtMsg
 }
+
+//
+// Policy routing rule service module
+//
+
+// Creates a new policy routing rule message.
+// A rule message header has the layout of a route message, with the rule action in place of the route type.
+func newRuleMsg(family int) *rtMsg {
+	return &rtMsg{
+		RtMsg: unix.RtMsg{
+			Family: uint8(family),
+			Type:   FR_ACT_TO_TBL,
+		},
+	}
+}
+
+//
+// Neighbor service module
+//
+
+// Neighbor discovery message
+type ndMsg struct {
+	Family uint8
+	Index  int32
+	State  uint16
+	Flags  uint8
+	Type   uint8
+}
+
+// Creates a new neighbor discovery message.
+func newNdMsg(family int) *ndMsg {
+	return &ndMsg{
+		Family: uint8(family),
+	}
+}
+
+// Deserializes a neighbor discovery message.
+func deserializeNdMsg(b []byte) *ndMsg {
+	return &ndMsg{
+		Family: b[0],
+		Index:  int32(encoder.Uint32(b[4:8])),
+		State:  encoder.Uint16(b[8:10]),
+		Flags:  b[10],
+		Type:   b[11],
+	}
+}
+
+// Serializes a neighbor discovery message.
+func (nd *ndMsg) serialize() []byte {
+	b := make([]byte, nd.length())
+	b[0] = nd.Family
+	encoder.PutUint32(b[4:8], uint32(nd.Index))
+	encoder.PutUint16(b[8:10], nd.State)
+	b[10] = nd.Flags
+	b[11] = nd.Type
+	return b
+}
+
+// Returns the length of a neighbor discovery message.
+func (nd *ndMsg) length() int {
+	return SizeofNdMsg
+}
+
+//
+// Traffic control service module
+//
+
+// Traffic control message
+type tcMsg struct {
+	Family  uint8
+	Ifindex int32
+	Handle  uint32
+	Parent  uint32
+	Info    uint32
+}
+
+// Creates a new traffic control message.
+func newTcMsg(ifIndex int, handle uint32, parent uint32) *tcMsg {
+	return &tcMsg{
+		Family:  unix.AF_UNSPEC,
+		Ifindex: int32(ifIndex),
+		Handle:  handle,
+		Parent:  parent,
+	}
+}
+
+// Deserializes a traffic control message.
+func deserializeTcMsg(b []byte) *tcMsg {
+	return &tcMsg{
+		Family:  b[0],
+		Ifindex: int32(encoder.Uint32(b[4:8])),
+		Handle:  encoder.Uint32(b[8:12]),
+		Parent:  encoder.Uint32(b[12:16]),
+		Info:    encoder.Uint32(b[16:20]),
+	}
+}
+
+// Serializes a traffic control message.
+func (tc *tcMsg) serialize() []byte {
+	b := make([]byte, tc.length())
+	b[0] = tc.Family
+	encoder.PutUint32(b[4:8], uint32(tc.Ifindex))
+	encoder.PutUint32(b[8:12], tc.Handle)
+	encoder.PutUint32(b[12:16], tc.Parent)
+	encoder.PutUint32(b[16:20], tc.Info)
+	return b
+}
+
+// Returns the length of a traffic control message.
+func (tc *tcMsg) length() int {
+	return SizeofTcMsg
+}
+
+// Converts a uint16 from host to network byte order.
+func htons(value uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, value)
+	return encoder.Uint16(b)
+}
+
+// Writes a uint32 in network byte order.
+func putUint32BE(b []byte, value uint32) {
+	binary.BigEndian.PutUint32(b, value)
+}
+
+// Reads a uint32 in network byte order.
+func getUint32BE(b []byte) uint32 {
+	return binary.BigEndian.Uint32(b)
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package netlink
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Rule represents a policy routing rule.
+// Packets matching all the selectors of a rule are routed with the routes of its table.
+type Rule struct {
+	Family   int
+	Priority int
+	Table    int
+	Src      *net.IPNet
+	Dst      *net.IPNet
+	Mark     int
+	IifName  string
+	OifName  string
+}
+
+// deserializeRule decodes a netlink message into a Rule struct.
+func deserializeRule(msg *message) (*Rule, error) {
+	if len(msg.data) < unix.SizeofRtMsg {
+		return nil, fmt.Errorf("Invalid rule message")
+	}
+
+	// Parse rule message.
+	rtmsg := deserializeRtMsg(msg.data)
+	attrs := deserializeAttributes(msg.data[unix.SizeofRtMsg:])
+
+	// Initialize a new rule object.
+	rule := Rule{
+		Family: int(rtmsg.Family),
+		Table:  int(rtmsg.Table),
+	}
+
+	// Populate rule attributes.
+	for _, attr := range attrs {
+		switch attr.getType() {
+		case FRA_DST:
+			rule.Dst = &net.IPNet{
+				IP:   net.IP(attr.value),
+				Mask: net.CIDRMask(int(rtmsg.Dst_len), 8*len(attr.value)),
+			}
+		case FRA_SRC:
+			rule.Src = &net.IPNet{
+				IP:   net.IP(attr.value),
+				Mask: net.CIDRMask(int(rtmsg.Src_len), 8*len(attr.value)),
+			}
+		case FRA_PRIORITY:
+			rule.Priority = int(attr.getUint32())
+		case FRA_TABLE:
+			rule.Table = int(attr.getUint32())
+		case FRA_FWMARK:
+			rule.Mark = int(attr.getUint32())
+		case FRA_IIFNAME:
+			rule.IifName = attr.getString()
+		case FRA_OIFNAME:
+			rule.OifName = attr.getString()
+		}
+	}
+
+	return &rule, nil
+}
+
+// GetIpRule returns a list of policy routing rules matching the given filter.
+// Rules are filtered by table and priority when they are set in the filter.
+func (h *Handle) GetIpRule(filter *Rule) ([]*Rule, error) {
+	s, err := h.getSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	req := newRequest(unix.RTM_GETRULE, unix.NLM_F_DUMP)
+	req.addPayload(newRuleMsg(filter.Family))
+
+	msgs, err := s.sendAndWaitForResponse(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+
+	// For each rule in the list...
+	for _, msg := range msgs {
+		rule, err := deserializeRule(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		// Filter by table.
+		if filter.Table != 0 && filter.Table != rule.Table {
+			continue
+		}
+
+		// Filter by priority.
+		if filter.Priority != 0 && filter.Priority != rule.Priority {
+			continue
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// GetIpRule returns a list of policy routing rules matching the given filter.
+// Rules are filtered by table and priority when they are set in the filter.
+func GetIpRule(filter *Rule) ([]*Rule, error) {
+	return defaultHandle.GetIpRule(filter)
+}
+
+// setIpRule sends a policy routing rule set request.
+func (h *Handle) setIpRule(rule *Rule, add bool) error {
+	var msgType, flags int
+
+	s, err := h.getSocket()
+	if err != nil {
+		return err
+	}
+
+	if add {
+		msgType = unix.RTM_NEWRULE
+		flags = unix.NLM_F_CREATE | unix.NLM_F_EXCL | unix.NLM_F_ACK
+	} else {
+		msgType = unix.RTM_DELRULE
+		flags = unix.NLM_F_ACK
+	}
+
+	req := newRequest(msgType, flags)
+
+	msg := newRuleMsg(rule.Family)
+
+	// Tables beyond the range of the message header are only set in the table attribute.
+	if rule.Table < 256 {
+		msg.Table = uint8(rule.Table)
+	} else {
+		msg.Table = unix.RT_TABLE_UNSPEC
+	}
+
+	req.addPayload(msg)
+
+	if rule.Dst != nil {
+		prefixLength, _ := rule.Dst.Mask.Size()
+		msg.Dst_len = uint8(prefixLength)
+		req.addPayload(newAttributeIpAddress(FRA_DST, rule.Dst.IP))
+	}
+
+	if rule.Src != nil {
+		prefixLength, _ := rule.Src.Mask.Size()
+		msg.Src_len = uint8(prefixLength)
+		req.addPayload(newAttributeIpAddress(FRA_SRC, rule.Src.IP))
+	}
+
+	if rule.Priority != 0 {
+		req.addPayload(newAttributeUint32(FRA_PRIORITY, uint32(rule.Priority)))
+	}
+
+	if rule.Table != 0 {
+		req.addPayload(newAttributeUint32(FRA_TABLE, uint32(rule.Table)))
+	}
+
+	if rule.Mark != 0 {
+		req.addPayload(newAttributeUint32(FRA_FWMARK, uint32(rule.Mark)))
+	}
+
+	if rule.IifName != "" {
+		req.addPayload(newAttributeStringZ(FRA_IIFNAME, rule.IifName))
+	}
+
+	if rule.OifName != "" {
+		req.addPayload(newAttributeStringZ(FRA_OIFNAME, rule.OifName))
+	}
+
+	return s.sendAndWaitForAck(req)
+}
+
+// AddIpRule adds a policy routing rule.
+func (h *Handle) AddIpRule(rule *Rule) error {
+	return h.setIpRule(rule, true)
+}
+
+// AddIpRule adds a policy routing rule.
+func AddIpRule(rule *Rule) error {
+	return defaultHandle.AddIpRule(rule)
+}
+
+// DeleteIpRule deletes a policy routing rule.
+func (h *Handle) DeleteIpRule(rule *Rule) error {
+	return h.setIpRule(rule, false)
+}
+
+// DeleteIpRule deletes a policy routing rule.
+func DeleteIpRule(rule *Rule) error {
+	return defaultHandle.DeleteIpRule(rule)
+}
@@ -50,6 +50,11 @@ func ResetSocket() {
 
 // Creates a new netlink socket object.
 func newSocket() (*socket, error) {
+	return newSocketWithGroups(0)
+}
+
+// Creates a new netlink socket object subscribed to the given bitmask of multicast groups.
+func newSocketWithGroups(groups uint32) (*socket, error) {
 	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW, unix.NETLINK_ROUTE)
 	if err != nil {
 		log.Debugf("[netlink] Failed to create socket, err=%v\n", err)
@@ -63,6 +68,7 @@ func newSocket() (*socket, error) {
 	}
 
 	s.sa.Family = unix.AF_NETLINK
+	s.sa.Groups = groups
 
 	err = unix.Bind(fd, &s.sa)
 	if err != nil {
@@ -71,6 +77,19 @@ func newSocket() (*socket, error) {
 		return nil, err
 	}
 
+	// The kernel assigns the process ID only to the first socket of the process in a namespace,
+	// so use the assigned port ID to match responses.
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		log.Debugf("[netlink] Failed to get socket address, err=%v\n", err)
+		return nil, err
+	}
+
+	if nlsa, ok := sa.(*unix.SockaddrNetlink); ok {
+		s.pid = nlsa.Pid
+	}
+
 	log.Debugf("[netlink] Socket created.\n")
 	return s, nil
 }
@@ -84,6 +103,7 @@ func (s *socket) close() {
 // Sends a netlink message.
 func (s *socket) send(msg *message) error {
 	msg.Seq = atomic.AddUint32(&s.seq, 1)
+	msg.Pid = s.pid
 	err := unix.Sendto(s.fd, msg.serialize(), 0, &s.sa)
 	log.Debugf("[netlink] Sent %+v, err=%v\n", *msg, err)
 	return err
@@ -175,24 +195,7 @@ func (s *socket) receiveResponse(sent *message) ([]*message, error) {
 			// Log response message.
 			log.Debugf("[netlink] Received %+v\n", msg)
 
-			// Parse body.
-			msg.payload = append(msg.payload, nil)
-
-			// Parse attributes.
-			// Ignore failures as not all messages have attributes.
-			nlAttrs, _ := syscall.ParseNetlinkRouteAttr(&nlMsg)
-
-			// Convert to attribute objects.
-			for _, nlAttr := range nlAttrs {
-				attr := attribute{
-					NlAttr: unix.NlAttr{
-						Len:  nlAttr.Attr.Len,
-						Type: nlAttr.Attr.Type,
-					},
-					value: nlAttr.Value,
-				}
-				msg.payload = append(msg.payload, &attr)
-			}
+			msg.parseAttributes(&nlMsg)
 
 			multi = ((msg.Flags & unix.NLM_F_MULTI) != 0)
 			done = (msg.Type == unix.NLMSG_DONE)
@@ -214,3 +217,25 @@ func (s *socket) receiveResponse(sent *message) ([]*message, error) {
 
 	return messages, nil
 }
+
+// Parses the attributes of a received message into its payload.
+func (msg *message) parseAttributes(nlMsg *syscall.NetlinkMessage) {
+	// Parse body.
+	msg.payload = append(msg.payload, nil)
+
+	// Parse attributes.
+	// Ignore failures as not all messages have attributes.
+	nlAttrs, _ := syscall.ParseNetlinkRouteAttr(nlMsg)
+
+	// Convert to attribute objects.
+	for _, nlAttr := range nlAttrs {
+		attr := attribute{
+			NlAttr: unix.NlAttr{
+				Len:  nlAttr.Attr.Len,
+				Type: nlAttr.Attr.Type,
+			},
+			value: nlAttr.Value,
+		}
+		msg.payload = append(msg.payload, &attr)
+	}
+}
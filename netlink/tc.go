@@ -0,0 +1,996 @@
+// Copyright 2017 Microsoft. All rights reserved.
+// MIT License
+
+package netlink
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Queueing discipline types.
+const (
+	QDISC_TYPE_TBF      = "tbf"
+	QDISC_TYPE_HTB      = "htb"
+	QDISC_TYPE_INGRESS  = "ingress"
+	QDISC_TYPE_CLSACT   = "clsact"
+	QDISC_TYPE_FQ_CODEL = "fq_codel"
+)
+
+// Traffic class types.
+const (
+	CLASS_TYPE_HTB = "htb"
+)
+
+// Traffic filter types.
+const (
+	FILTER_TYPE_U32 = "u32"
+	FILTER_TYPE_BPF = "bpf"
+)
+
+// Traffic control handles.
+// Filters of an ingress qdisc are attached to its handle, MakeTcHandle(0xFFFF, 0).
+// Filters of a clsact qdisc are attached to MakeTcHandle(0xFFFF, TC_H_MIN_INGRESS or TC_H_MIN_EGRESS).
+const (
+	TC_H_ROOT        = 0xFFFFFFFF
+	TC_H_INGRESS     = 0xFFFFFFF1
+	TC_H_CLSACT      = TC_H_INGRESS
+	TC_H_MIN_INGRESS = 0xFFF2
+	TC_H_MIN_EGRESS  = 0xFFF3
+)
+
+// Traffic control action results.
+const (
+	TC_ACT_OK     = 0
+	TC_ACT_SHOT   = 2
+	TC_ACT_PIPE   = 3
+	TC_ACT_STOLEN = 4
+)
+
+// Mirred action modes.
+type MirredMode int32
+
+const (
+	MIRRED_EGRESS_REDIRECT  MirredMode = 1
+	MIRRED_EGRESS_MIRROR    MirredMode = 2
+	MIRRED_INGRESS_REDIRECT MirredMode = 3
+	MIRRED_INGRESS_MIRROR   MirredMode = 4
+)
+
+// The kernel measures token bucket sizes in scheduler ticks of 64ns.
+const tcTickInNs = 64
+
+// Burst size of HTB classes that do not specify one, large enough for a full-sized packet.
+const defaultHtbBurst = 1600
+
+// MakeTcHandle returns the traffic control handle with the given major and minor numbers.
+func MakeTcHandle(major, minor uint16) uint32 {
+	return uint32(major)<<16 | uint32(minor)
+}
+
+// Qdisc represents a queueing discipline.
+type Qdisc interface {
+	Info() *QdiscInfo
+}
+
+// QdiscInfo represents the common properties of all queueing disciplines.
+// Parent defaults to TC_H_ROOT, or to TC_H_INGRESS for ingress and clsact qdiscs.
+type QdiscInfo struct {
+	Type      string
+	LinkIndex int
+	Handle    uint32
+	Parent    uint32
+}
+
+func (qdiscInfo *QdiscInfo) Info() *QdiscInfo {
+	return qdiscInfo
+}
+
+// TbfQdisc represents a token bucket filter that shapes traffic to a rate.
+// Rate is in bytes per second, and Burst and Limit are in bytes.
+type TbfQdisc struct {
+	QdiscInfo
+	Rate  uint64
+	Burst uint32
+	Limit uint32
+}
+
+// HtbQdisc represents a hierarchy token bucket that shares a rate among its classes.
+// DefaultClass is the minor number of the class of unclassified traffic.
+type HtbQdisc struct {
+	QdiscInfo
+	DefaultClass uint32
+	Rate2Quantum uint32
+}
+
+// IngressQdisc represents the qdisc that filters received traffic.
+type IngressQdisc struct {
+	QdiscInfo
+}
+
+// ClsactQdisc represents the qdisc that filters received and transmitted traffic.
+type ClsactQdisc struct {
+	QdiscInfo
+}
+
+// FqCodelQdisc represents a fair queueing controlled delay qdisc.
+// Target and Interval are in microseconds. Zero values are replaced by kernel defaults.
+type FqCodelQdisc struct {
+	QdiscInfo
+	Target   uint32
+	Interval uint32
+	Limit    uint32
+	Flows    uint32
+	Quantum  uint32
+	ECN      bool
+}
+
+// Class represents a traffic class of a classful queueing discipline.
+type Class interface {
+	Info() *ClassInfo
+}
+
+// ClassInfo represents the common properties of all traffic classes.
+type ClassInfo struct {
+	Type      string
+	LinkIndex int
+	Handle    uint32
+	Parent    uint32
+}
+
+func (classInfo *ClassInfo) Info() *ClassInfo {
+	return classInfo
+}
+
+// HtbClass represents a class of a hierarchy token bucket.
+// Rate and Ceil are in bytes per second, and Buffer and Cbuffer are burst sizes in bytes.
+// Ceil defaults to Rate.
+type HtbClass struct {
+	ClassInfo
+	Rate    uint64
+	Ceil    uint64
+	Buffer  uint32
+	Cbuffer uint32
+	Quantum uint32
+	Prio    uint32
+}
+
+// Filter represents a traffic filter.
+type Filter interface {
+	Info() *FilterInfo
+}
+
+// FilterInfo represents the common properties of all traffic filters.
+// Protocol is an ethernet protocol number and defaults to ETH_P_ALL.
+type FilterInfo struct {
+	Type      string
+	LinkIndex int
+	Handle    uint32
+	Parent    uint32
+	Priority  uint16
+	Protocol  uint16
+}
+
+func (filterInfo *FilterInfo) Info() *FilterInfo {
+	return filterInfo
+}
+
+// U32Key matches the 32 bits at Offset from the network header against Value under Mask.
+type U32Key struct {
+	Mask       uint32
+	Value      uint32
+	Offset     int32
+	OffsetMask int32
+}
+
+// U32Filter represents a filter that matches packets by their contents.
+// A filter without keys matches all packets.
+type U32Filter struct {
+	FilterInfo
+	ClassId uint32
+	Keys    []U32Key
+	Actions []Action
+}
+
+// BpfFilter represents a filter that classifies packets with an eBPF program.
+// GetFilter reports the name of the program, but not its file descriptor.
+type BpfFilter struct {
+	FilterInfo
+	Fd           int
+	Name         string
+	ClassId      uint32
+	DirectAction bool
+	Actions      []Action
+}
+
+// Action represents an action taken on packets matched by a filter.
+type Action interface {
+	getKind() string
+	getParms() *attribute
+}
+
+// GenericAction represents an action that returns a fixed result, such as TC_ACT_SHOT.
+type GenericAction struct {
+	Result int32
+}
+
+// MirredAction represents an action that mirrors or redirects packets to a network interface.
+type MirredAction struct {
+	Mode      MirredMode
+	LinkIndex int
+}
+
+func (action *GenericAction) getKind() string {
+	return "gact"
+}
+
+func (action *GenericAction) getParms() *attribute {
+	b := make([]byte, SizeofTcGen)
+	encoder.PutUint32(b[8:12], uint32(action.Result))
+	return newAttribute(TCA_GACT_PARMS, b)
+}
+
+func (action *MirredAction) getKind() string {
+	return "mirred"
+}
+
+func (action *MirredAction) getParms() *attribute {
+	result := int32(TC_ACT_STOLEN)
+	if action.Mode == MIRRED_EGRESS_MIRROR || action.Mode == MIRRED_INGRESS_MIRROR {
+		result = TC_ACT_PIPE
+	}
+
+	b := make([]byte, SizeofTcMirred)
+	encoder.PutUint32(b[8:12], uint32(result))
+	encoder.PutUint32(b[20:24], uint32(action.Mode))
+	encoder.PutUint32(b[24:28], uint32(action.LinkIndex))
+	return newAttribute(TCA_MIRRED_PARMS, b)
+}
+
+// Converts a burst size in bytes at a rate in bytes per second to scheduler ticks.
+func getTicks(burst uint32, rate uint64) uint32 {
+	if rate == 0 {
+		return 0
+	}
+	return uint32(uint64(burst) * 1000000000 / rate / tcTickInNs)
+}
+
+// Converts scheduler ticks at a rate in bytes per second to a burst size in bytes.
+func getBurst(ticks uint32, rate uint64) uint32 {
+	return uint32(float64(ticks) * tcTickInNs * float64(rate) / 1000000000)
+}
+
+// Serializes a rate into a tc_ratespec. Rates that do not fit are carried by a separate attribute.
+func serializeRateSpec(b []byte, rate uint64) {
+	b[1] = TC_LINKLAYER_ETHERNET
+	if rate >= 1<<32 {
+		encoder.PutUint32(b[8:12], ^uint32(0))
+	} else {
+		encoder.PutUint32(b[8:12], uint32(rate))
+	}
+}
+
+// Serializes a list of actions into an attribute of the given type.
+func serializeActions(attrType int, actions []Action) *attribute {
+	attrActions := newAttribute(attrType, nil)
+
+	for i, action := range actions {
+		attrAction := newAttribute(i+1, nil)
+		attrAction.addNested(newAttributeStringZ(TCA_ACT_KIND, action.getKind()))
+
+		attrOptions := newAttribute(TCA_ACT_OPTIONS, nil)
+		attrOptions.addNested(action.getParms())
+		attrAction.addNested(attrOptions)
+
+		attrActions.addNested(attrAction)
+	}
+
+	return attrActions
+}
+
+// Deserializes a list of actions. Actions of unknown kinds are skipped.
+func deserializeActions(b []byte) []Action {
+	var actions []Action
+
+	for _, attrAction := range deserializeAttributes(b) {
+		var kind string
+		var parms []byte
+
+		for _, attr := range deserializeAttributes(attrAction.value) {
+			switch attr.getType() {
+			case TCA_ACT_KIND:
+				kind = attr.getString()
+			case TCA_ACT_OPTIONS:
+				for _, opt := range deserializeAttributes(attr.value) {
+					if opt.getType() == TCA_GACT_PARMS || opt.getType() == TCA_MIRRED_PARMS {
+						parms = opt.value
+					}
+				}
+			}
+		}
+
+		switch {
+		case kind == "gact" && len(parms) >= SizeofTcGen:
+			actions = append(actions, &GenericAction{
+				Result: int32(encoder.Uint32(parms[8:12])),
+			})
+		case kind == "mirred" && len(parms) >= SizeofTcMirred:
+			actions = append(actions, &MirredAction{
+				Mode:      MirredMode(encoder.Uint32(parms[20:24])),
+				LinkIndex: int(encoder.Uint32(parms[24:28])),
+			})
+		}
+	}
+
+	return actions
+}
+
+// Returns the options attribute of a queueing discipline, or nil if it has none.
+func getQdiscOptions(qdisc Qdisc) *attribute {
+	attrOptions := newAttribute(TCA_OPTIONS, nil)
+
+	switch q := qdisc.(type) {
+	case *TbfQdisc:
+		opt := make([]byte, SizeofTcTbfQopt)
+		serializeRateSpec(opt[0:SizeofTcRateSpec], q.Rate)
+		encoder.PutUint32(opt[24:28], q.Limit)
+		encoder.PutUint32(opt[28:32], getTicks(q.Burst, q.Rate))
+		attrOptions.addNested(newAttribute(TCA_TBF_PARMS, opt))
+		attrOptions.addNested(newAttributeUint32(TCA_TBF_BURST, q.Burst))
+		if q.Rate >= 1<<32 {
+			attrOptions.addNested(newAttributeUint64(TCA_TBF_RATE64, q.Rate))
+		}
+
+	case *HtbQdisc:
+		rate2Quantum := q.Rate2Quantum
+		if rate2Quantum == 0 {
+			rate2Quantum = 10
+		}
+
+		glob := make([]byte, SizeofTcHtbGlob)
+		encoder.PutUint32(glob[0:4], 3)
+		encoder.PutUint32(glob[4:8], rate2Quantum)
+		encoder.PutUint32(glob[8:12], q.DefaultClass)
+		attrOptions.addNested(newAttribute(TCA_HTB_INIT, glob))
+
+	case *FqCodelQdisc:
+		if q.Target != 0 {
+			attrOptions.addNested(newAttributeUint32(TCA_FQ_CODEL_TARGET, q.Target))
+		}
+		if q.Interval != 0 {
+			attrOptions.addNested(newAttributeUint32(TCA_FQ_CODEL_INTERVAL, q.Interval))
+		}
+		if q.Limit != 0 {
+			attrOptions.addNested(newAttributeUint32(TCA_FQ_CODEL_LIMIT, q.Limit))
+		}
+		if q.Flows != 0 {
+			attrOptions.addNested(newAttributeUint32(TCA_FQ_CODEL_FLOWS, q.Flows))
+		}
+		if q.Quantum != 0 {
+			attrOptions.addNested(newAttributeUint32(TCA_FQ_CODEL_QUANTUM, q.Quantum))
+		}
+
+		// The kernel enables ECN unless told otherwise.
+		var ecn uint32
+		if q.ECN {
+			ecn = 1
+		}
+		attrOptions.addNested(newAttributeUint32(TCA_FQ_CODEL_ECN, ecn))
+
+	default:
+		return nil
+	}
+
+	return attrOptions
+}
+
+// deserializeQdisc decodes a netlink message into a Qdisc of the type of the queueing discipline.
+// Queueing disciplines of unknown types are returned as a *QdiscInfo.
+func deserializeQdisc(msg *message) (Qdisc, error) {
+	if len(msg.data) < SizeofTcMsg {
+		return nil, fmt.Errorf("Invalid qdisc message")
+	}
+
+	// Parse traffic control message.
+	tcmsg := deserializeTcMsg(msg.data)
+	attrs := deserializeAttributes(msg.data[SizeofTcMsg:])
+
+	info := QdiscInfo{
+		LinkIndex: int(tcmsg.Ifindex),
+		Handle:    tcmsg.Handle,
+		Parent:    tcmsg.Parent,
+	}
+
+	var options []byte
+
+	for _, attr := range attrs {
+		switch attr.getType() {
+		case TCA_KIND:
+			info.Type = attr.getString()
+		case TCA_OPTIONS:
+			options = attr.value
+		}
+	}
+
+	// Populate qdisc type-specific attributes.
+	switch info.Type {
+	case QDISC_TYPE_TBF:
+		qdisc := &TbfQdisc{QdiscInfo: info}
+		var ticks uint32
+		for _, attr := range deserializeAttributes(options) {
+			switch attr.getType() {
+			case TCA_TBF_PARMS:
+				if len(attr.value) >= SizeofTcTbfQopt {
+					qdisc.Rate = uint64(encoder.Uint32(attr.value[8:12]))
+					qdisc.Limit = encoder.Uint32(attr.value[24:28])
+					ticks = encoder.Uint32(attr.value[28:32])
+				}
+			case TCA_TBF_RATE64:
+				qdisc.Rate = attr.getUint64()
+			}
+		}
+		qdisc.Burst = getBurst(ticks, qdisc.Rate)
+		return qdisc, nil
+
+	case QDISC_TYPE_HTB:
+		qdisc := &HtbQdisc{QdiscInfo: info}
+		for _, attr := range deserializeAttributes(options) {
+			if attr.getType() == TCA_HTB_INIT && len(attr.value) >= SizeofTcHtbGlob {
+				qdisc.Rate2Quantum = encoder.Uint32(attr.value[4:8])
+				qdisc.DefaultClass = encoder.Uint32(attr.value[8:12])
+			}
+		}
+		return qdisc, nil
+
+	case QDISC_TYPE_INGRESS:
+		return &IngressQdisc{QdiscInfo: info}, nil
+
+	case QDISC_TYPE_CLSACT:
+		return &ClsactQdisc{QdiscInfo: info}, nil
+
+	case QDISC_TYPE_FQ_CODEL:
+		qdisc := &FqCodelQdisc{QdiscInfo: info}
+		for _, attr := range deserializeAttributes(options) {
+			switch attr.getType() {
+			case TCA_FQ_CODEL_TARGET:
+				qdisc.Target = attr.getUint32()
+			case TCA_FQ_CODEL_INTERVAL:
+				qdisc.Interval = attr.getUint32()
+			case TCA_FQ_CODEL_LIMIT:
+				qdisc.Limit = attr.getUint32()
+			case TCA_FQ_CODEL_FLOWS:
+				qdisc.Flows = attr.getUint32()
+			case TCA_FQ_CODEL_QUANTUM:
+				qdisc.Quantum = attr.getUint32()
+			case TCA_FQ_CODEL_ECN:
+				qdisc.ECN = attr.getUint32() != 0
+			}
+		}
+		return qdisc, nil
+	}
+
+	return &info, nil
+}
+
+// GetQdisc returns a list of queueing disciplines.
+// Queueing disciplines are filtered by link index when it is not zero.
+func (h *Handle) GetQdisc(linkIndex int) ([]Qdisc, error) {
+	s, err := h.getSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	req := newRequest(unix.RTM_GETQDISC, unix.NLM_F_DUMP)
+	req.addPayload(newTcMsg(linkIndex, 0, 0))
+
+	msgs, err := s.sendAndWaitForResponse(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var qdiscs []Qdisc
+
+	// For each qdisc in the list...
+	for _, msg := range msgs {
+		qdisc, err := deserializeQdisc(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		// Filter by link index.
+		if linkIndex != 0 && linkIndex != qdisc.Info().LinkIndex {
+			continue
+		}
+
+		qdiscs = append(qdiscs, qdisc)
+	}
+
+	return qdiscs, nil
+}
+
+// GetQdisc returns a list of queueing disciplines.
+// Queueing disciplines are filtered by link index when it is not zero.
+func GetQdisc(linkIndex int) ([]Qdisc, error) {
+	return defaultHandle.GetQdisc(linkIndex)
+}
+
+// setQdisc sends a queueing discipline set request.
+func (h *Handle) setQdisc(qdisc Qdisc, add bool) error {
+	var msgType, flags int
+
+	info := qdisc.Info()
+	if info.Type == "" {
+		return fmt.Errorf("Invalid qdisc type")
+	}
+
+	s, err := h.getSocket()
+	if err != nil {
+		return err
+	}
+
+	if add {
+		msgType = unix.RTM_NEWQDISC
+		flags = unix.NLM_F_CREATE | unix.NLM_F_EXCL | unix.NLM_F_ACK
+	} else {
+		msgType = unix.RTM_DELQDISC
+		flags = unix.NLM_F_ACK
+	}
+
+	req := newRequest(msgType, flags)
+
+	handle := info.Handle
+	parent := info.Parent
+
+	if info.Type == QDISC_TYPE_INGRESS || info.Type == QDISC_TYPE_CLSACT {
+		if parent == 0 {
+			parent = TC_H_INGRESS
+		}
+		if handle == 0 {
+			handle = MakeTcHandle(0xFFFF, 0)
+		}
+	} else if parent == 0 {
+		parent = TC_H_ROOT
+	}
+
+	req.addPayload(newTcMsg(info.LinkIndex, handle, parent))
+	req.addPayload(newAttributeStringZ(TCA_KIND, info.Type))
+
+	if add {
+		if attrOptions := getQdiscOptions(qdisc); attrOptions != nil {
+			req.addPayload(attrOptions)
+		}
+	}
+
+	return s.sendAndWaitForAck(req)
+}
+
+// AddQdisc adds a queueing discipline to a network interface.
+func (h *Handle) AddQdisc(qdisc Qdisc) error {
+	return h.setQdisc(qdisc, true)
+}
+
+// AddQdisc adds a queueing discipline to a network interface.
+func AddQdisc(qdisc Qdisc) error {
+	return defaultHandle.AddQdisc(qdisc)
+}
+
+// DeleteQdisc deletes a queueing discipline from a network interface.
+func (h *Handle) DeleteQdisc(qdisc Qdisc) error {
+	return h.setQdisc(qdisc, false)
+}
+
+// DeleteQdisc deletes a queueing discipline from a network interface.
+func DeleteQdisc(qdisc Qdisc) error {
+	return defaultHandle.DeleteQdisc(qdisc)
+}
+
+// deserializeClass decodes a netlink message into a Class of the type of the traffic class.
+// Traffic classes of unknown types are returned as a *ClassInfo.
+func deserializeClass(msg *message) (Class, error) {
+	if len(msg.data) < SizeofTcMsg {
+		return nil, fmt.Errorf("Invalid class message")
+	}
+
+	// Parse traffic control message.
+	tcmsg := deserializeTcMsg(msg.data)
+	attrs := deserializeAttributes(msg.data[SizeofTcMsg:])
+
+	info := ClassInfo{
+		LinkIndex: int(tcmsg.Ifindex),
+		Handle:    tcmsg.Handle,
+		Parent:    tcmsg.Parent,
+	}
+
+	var options []byte
+
+	for _, attr := range attrs {
+		switch attr.getType() {
+		case TCA_KIND:
+			info.Type = attr.getString()
+		case TCA_OPTIONS:
+			options = attr.value
+		}
+	}
+
+	if info.Type != CLASS_TYPE_HTB {
+		return &info, nil
+	}
+
+	class := &HtbClass{ClassInfo: info}
+	var ticks, cticks uint32
+
+	for _, attr := range deserializeAttributes(options) {
+		switch attr.getType() {
+		case TCA_HTB_PARMS:
+			if len(attr.value) >= SizeofTcHtbOpt {
+				class.Rate = uint64(encoder.Uint32(attr.value[8:12]))
+				class.Ceil = uint64(encoder.Uint32(attr.value[20:24]))
+				ticks = encoder.Uint32(attr.value[24:28])
+				cticks = encoder.Uint32(attr.value[28:32])
+				class.Quantum = encoder.Uint32(attr.value[32:36])
+				class.Prio = encoder.Uint32(attr.value[40:44])
+			}
+		case TCA_HTB_RATE64:
+			class.Rate = attr.getUint64()
+		case TCA_HTB_CEIL64:
+			class.Ceil = attr.getUint64()
+		}
+	}
+
+	class.Buffer = getBurst(ticks, class.Rate)
+	class.Cbuffer = getBurst(cticks, class.Ceil)
+
+	return class, nil
+}
+
+// GetClass returns a list of traffic classes of a network interface.
+func (h *Handle) GetClass(linkIndex int) ([]Class, error) {
+	s, err := h.getSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	req := newRequest(unix.RTM_GETTCLASS, unix.NLM_F_DUMP)
+	req.addPayload(newTcMsg(linkIndex, 0, 0))
+
+	msgs, err := s.sendAndWaitForResponse(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var classes []Class
+
+	// For each class in the list...
+	for _, msg := range msgs {
+		class, err := deserializeClass(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		classes = append(classes, class)
+	}
+
+	return classes, nil
+}
+
+// GetClass returns a list of traffic classes of a network interface.
+func GetClass(linkIndex int) ([]Class, error) {
+	return defaultHandle.GetClass(linkIndex)
+}
+
+// setClass sends a traffic class set request.
+func (h *Handle) setClass(class Class, add bool) error {
+	var msgType, flags int
+
+	info := class.Info()
+	if info.Type == "" {
+		return fmt.Errorf("Invalid class type")
+	}
+
+	s, err := h.getSocket()
+	if err != nil {
+		return err
+	}
+
+	if add {
+		msgType = unix.RTM_NEWTCLASS
+		flags = unix.NLM_F_CREATE | unix.NLM_F_EXCL | unix.NLM_F_ACK
+	} else {
+		msgType = unix.RTM_DELTCLASS
+		flags = unix.NLM_F_ACK
+	}
+
+	req := newRequest(msgType, flags)
+	req.addPayload(newTcMsg(info.LinkIndex, info.Handle, info.Parent))
+	req.addPayload(newAttributeStringZ(TCA_KIND, info.Type))
+
+	if htb, ok := class.(*HtbClass); ok && add {
+		ceil := htb.Ceil
+		if ceil == 0 {
+			ceil = htb.Rate
+		}
+
+		buffer := htb.Buffer
+		if buffer == 0 {
+			buffer = defaultHtbBurst
+		}
+
+		cbuffer := htb.Cbuffer
+		if cbuffer == 0 {
+			cbuffer = defaultHtbBurst
+		}
+
+		opt := make([]byte, SizeofTcHtbOpt)
+		serializeRateSpec(opt[0:SizeofTcRateSpec], htb.Rate)
+		serializeRateSpec(opt[SizeofTcRateSpec:2*SizeofTcRateSpec], ceil)
+		encoder.PutUint32(opt[24:28], getTicks(buffer, htb.Rate))
+		encoder.PutUint32(opt[28:32], getTicks(cbuffer, ceil))
+		encoder.PutUint32(opt[32:36], htb.Quantum)
+		encoder.PutUint32(opt[40:44], htb.Prio)
+
+		attrOptions := newAttribute(TCA_OPTIONS, nil)
+		attrOptions.addNested(newAttribute(TCA_HTB_PARMS, opt))
+		if htb.Rate >= 1<<32 {
+			attrOptions.addNested(newAttributeUint64(TCA_HTB_RATE64, htb.Rate))
+		}
+		if ceil >= 1<<32 {
+			attrOptions.addNested(newAttributeUint64(TCA_HTB_CEIL64, ceil))
+		}
+		req.addPayload(attrOptions)
+	}
+
+	return s.sendAndWaitForAck(req)
+}
+
+// AddClass adds a traffic class to a classful queueing discipline.
+func (h *Handle) AddClass(class Class) error {
+	return h.setClass(class, true)
+}
+
+// AddClass adds a traffic class to a classful queueing discipline.
+func AddClass(class Class) error {
+	return defaultHandle.AddClass(class)
+}
+
+// DeleteClass deletes a traffic class from a classful queueing discipline.
+func (h *Handle) DeleteClass(class Class) error {
+	return h.setClass(class, false)
+}
+
+// DeleteClass deletes a traffic class from a classful queueing discipline.
+func DeleteClass(class Class) error {
+	return defaultHandle.DeleteClass(class)
+}
+
+// Returns the options attribute of a traffic filter, or nil if it has none.
+func getFilterOptions(filter Filter) *attribute {
+	attrOptions := newAttribute(TCA_OPTIONS, nil)
+
+	switch f := filter.(type) {
+	case *U32Filter:
+		keys := f.Keys
+		if len(keys) == 0 {
+			// Match all packets.
+			keys = []U32Key{{}}
+		}
+
+		sel := make([]byte, SizeofTcU32Sel+len(keys)*SizeofTcU32Key)
+		if f.ClassId != 0 || len(f.Actions) > 0 {
+			sel[0] = TC_U32_TERMINAL
+		}
+		sel[2] = uint8(len(keys))
+
+		for i, key := range keys {
+			b := sel[SizeofTcU32Sel+i*SizeofTcU32Key:]
+			putUint32BE(b[0:4], key.Mask)
+			putUint32BE(b[4:8], key.Value&key.Mask)
+			encoder.PutUint32(b[8:12], uint32(key.Offset))
+			encoder.PutUint32(b[12:16], uint32(key.OffsetMask))
+		}
+
+		if f.ClassId != 0 {
+			attrOptions.addNested(newAttributeUint32(TCA_U32_CLASSID, f.ClassId))
+		}
+		attrOptions.addNested(newAttribute(TCA_U32_SEL, sel))
+		if len(f.Actions) > 0 {
+			attrOptions.addNested(serializeActions(TCA_U32_ACT, f.Actions))
+		}
+
+	case *BpfFilter:
+		attrOptions.addNested(newAttributeUint32(TCA_BPF_FD, uint32(f.Fd)))
+		if f.Name != "" {
+			attrOptions.addNested(newAttributeStringZ(TCA_BPF_NAME, f.Name))
+		}
+		if f.ClassId != 0 {
+			attrOptions.addNested(newAttributeUint32(TCA_BPF_CLASSID, f.ClassId))
+		}
+		if f.DirectAction {
+			attrOptions.addNested(newAttributeUint32(TCA_BPF_FLAGS, TCA_BPF_FLAG_ACT_DIRECT))
+		}
+		if len(f.Actions) > 0 {
+			attrOptions.addNested(serializeActions(TCA_BPF_ACT, f.Actions))
+		}
+
+	default:
+		return nil
+	}
+
+	return attrOptions
+}
+
+// deserializeFilter decodes a netlink message into a Filter of the type of the traffic filter.
+// Traffic filters of unknown types are returned as a *FilterInfo.
+func deserializeFilter(msg *message) (Filter, error) {
+	if len(msg.data) < SizeofTcMsg {
+		return nil, fmt.Errorf("Invalid filter message")
+	}
+
+	// Parse traffic control message.
+	tcmsg := deserializeTcMsg(msg.data)
+	attrs := deserializeAttributes(msg.data[SizeofTcMsg:])
+
+	info := FilterInfo{
+		LinkIndex: int(tcmsg.Ifindex),
+		Handle:    tcmsg.Handle,
+		Parent:    tcmsg.Parent,
+		Priority:  uint16(tcmsg.Info >> 16),
+		Protocol:  htons(uint16(tcmsg.Info)),
+	}
+
+	var options []byte
+
+	for _, attr := range attrs {
+		switch attr.getType() {
+		case TCA_KIND:
+			info.Type = attr.getString()
+		case TCA_OPTIONS:
+			options = attr.value
+		}
+	}
+
+	// Populate filter type-specific attributes.
+	switch info.Type {
+	case FILTER_TYPE_U32:
+		filter := &U32Filter{FilterInfo: info}
+		for _, attr := range deserializeAttributes(options) {
+			switch attr.getType() {
+			case TCA_U32_CLASSID:
+				filter.ClassId = attr.getUint32()
+			case TCA_U32_SEL:
+				if len(attr.value) < SizeofTcU32Sel {
+					continue
+				}
+				nkeys := int(attr.value[2])
+				for i := 0; i < nkeys && SizeofTcU32Sel+(i+1)*SizeofTcU32Key <= len(attr.value); i++ {
+					b := attr.value[SizeofTcU32Sel+i*SizeofTcU32Key:]
+					filter.Keys = append(filter.Keys, U32Key{
+						Mask:       getUint32BE(b[0:4]),
+						Value:      getUint32BE(b[4:8]),
+						Offset:     int32(encoder.Uint32(b[8:12])),
+						OffsetMask: int32(encoder.Uint32(b[12:16])),
+					})
+				}
+			case TCA_U32_ACT:
+				filter.Actions = deserializeActions(attr.value)
+			}
+		}
+		return filter, nil
+
+	case FILTER_TYPE_BPF:
+		filter := &BpfFilter{FilterInfo: info}
+		for _, attr := range deserializeAttributes(options) {
+			switch attr.getType() {
+			case TCA_BPF_NAME:
+				filter.Name = attr.getString()
+			case TCA_BPF_CLASSID:
+				filter.ClassId = attr.getUint32()
+			case TCA_BPF_FLAGS:
+				filter.DirectAction = attr.getUint32()&TCA_BPF_FLAG_ACT_DIRECT != 0
+			case TCA_BPF_ACT:
+				filter.Actions = deserializeActions(attr.value)
+			}
+		}
+		return filter, nil
+	}
+
+	return &info, nil
+}
+
+// GetFilter returns a list of traffic filters attached to a parent on a network interface.
+func (h *Handle) GetFilter(linkIndex int, parent uint32) ([]Filter, error) {
+	s, err := h.getSocket()
+	if err != nil {
+		return nil, err
+	}
+
+	req := newRequest(unix.RTM_GETTFILTER, unix.NLM_F_DUMP)
+	req.addPayload(newTcMsg(linkIndex, 0, parent))
+
+	msgs, err := s.sendAndWaitForResponse(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []Filter
+
+	// For each filter in the list...
+	for _, msg := range msgs {
+		filter, err := deserializeFilter(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// GetFilter returns a list of traffic filters attached to a parent on a network interface.
+func GetFilter(linkIndex int, parent uint32) ([]Filter, error) {
+	return defaultHandle.GetFilter(linkIndex, parent)
+}
+
+// setFilter sends a traffic filter set request.
+func (h *Handle) setFilter(filter Filter, add bool) error {
+	var msgType, flags int
+
+	info := filter.Info()
+	if info.Type == "" {
+		return fmt.Errorf("Invalid filter type")
+	}
+
+	s, err := h.getSocket()
+	if err != nil {
+		return err
+	}
+
+	if add {
+		msgType = unix.RTM_NEWTFILTER
+		flags = unix.NLM_F_CREATE | unix.NLM_F_EXCL | unix.NLM_F_ACK
+	} else {
+		msgType = unix.RTM_DELTFILTER
+		flags = unix.NLM_F_ACK
+	}
+
+	req := newRequest(msgType, flags)
+
+	protocol := info.Protocol
+	if protocol == 0 {
+		protocol = unix.ETH_P_ALL
+	}
+
+	tcmsg := newTcMsg(info.LinkIndex, info.Handle, info.Parent)
+	tcmsg.Info = uint32(info.Priority)<<16 | uint32(htons(protocol))
+	req.addPayload(tcmsg)
+	req.addPayload(newAttributeStringZ(TCA_KIND, info.Type))
+
+	if add {
+		if attrOptions := getFilterOptions(filter); attrOptions != nil {
+			req.addPayload(attrOptions)
+		}
+	}
+
+	return s.sendAndWaitForAck(req)
+}
+
+// AddFilter adds a traffic filter to a queueing discipline or traffic class.
+func (h *Handle) AddFilter(filter Filter) error {
+	return h.setFilter(filter, true)
+}
+
+// AddFilter adds a traffic filter to a queueing discipline or traffic class.
+func AddFilter(filter Filter) error {
+	return defaultHandle.AddFilter(filter)
+}
+
+// DeleteFilter deletes a traffic filter from a queueing discipline or traffic class.
+func (h *Handle) DeleteFilter(filter Filter) error {
+	return h.setFilter(filter, false)
+}
+
+// DeleteFilter deletes a traffic filter from a queueing discipline or traffic class.
+func DeleteFilter(filter Filter) error {
+	return defaultHandle.DeleteFilter(filter)
+}
@@ -92,10 +92,69 @@ func (client *LinuxBridgeClient) AddL2Rules(extIf *externalInterface) error {
 }
 
 func (client *LinuxBridgeClient) DeleteL2Rules(extIf *externalInterface) {
-	ebtables.SetVepaMode(client.bridgeName, commonInterfacePrefix, virtualMacAddress, ebtables.Delete)
-	ebtables.SetDnatForArpReplies(extIf.Name, ebtables.Delete)
-	ebtables.SetArpReply(extIf.IPAddresses[0].IP, extIf.MacAddress, ebtables.Delete)
-	ebtables.SetSnatForInterface(extIf.Name, extIf.MacAddress, ebtables.Delete)
+	if err := ebtables.SetVepaMode(client.bridgeName, commonInterfacePrefix, virtualMacAddress, ebtables.Delete); err != nil {
+		log.Printf("[net] Failed to delete VEPA rules for %v: %v.", client.bridgeName, err)
+	}
+
+	if err := ebtables.SetDnatForArpReplies(extIf.Name, ebtables.Delete); err != nil {
+		log.Printf("[net] Failed to delete DNAT rule for ingress ARP traffic on interface %v: %v.", extIf.Name, err)
+	}
+
+	if err := ebtables.SetArpReply(extIf.IPAddresses[0].IP, extIf.MacAddress, ebtables.Delete); err != nil {
+		log.Printf("[net] Failed to delete ARP reply rule for primary IP address %v: %v.", extIf.IPAddresses[0].IP, err)
+	}
+
+	if err := ebtables.SetSnatForInterface(extIf.Name, extIf.MacAddress, ebtables.Delete); err != nil {
+		log.Printf("[net] Failed to delete SNAT rule for egress traffic on %v: %v.", extIf.Name, err)
+	}
+
+	deleteOrphanedRules(extIf)
+}
+
+// isExternalInterfaceRule returns whether an ebtables rule belongs to an external interface or to one of
+// its endpoints, whose ARP reply rules are for addresses in the subnets of the interface.
+func isExternalInterfaceRule(extIf *externalInterface, rule *ebtables.Rule) bool {
+	if rule.InInterface == extIf.Name || rule.OutInterface == extIf.Name {
+		return true
+	}
+
+	if rule.Target == ebtables.TargetArpReply {
+		for _, ipAddr := range extIf.IPAddresses {
+			if ipAddr.Contains(rule.ArpIpDst) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// deleteOrphanedRules deletes the rules that are left for an external interface once it is disconnected,
+// such as those of endpoints that failed to be deleted.
+func deleteOrphanedRules(extIf *externalInterface) {
+	manager := ebtables.GetManager()
+
+	rules, err := manager.ListRules(ebtables.TableNat)
+	if err != nil {
+		log.Printf("[net] Failed to list ebtables rules, err:%v.", err)
+		return
+	}
+
+	var orphans []*ebtables.Rule
+	for _, rule := range rules {
+		if isExternalInterfaceRule(extIf, rule) {
+			log.Printf("[net] Deleting orphaned ebtables rule %v.", rule)
+			orphans = append(orphans, rule)
+		}
+	}
+
+	if len(orphans) == 0 {
+		return
+	}
+
+	if err := manager.DeleteRules(orphans...); err != nil {
+		log.Printf("[net] Failed to delete orphaned ebtables rules of interface %v, err:%v.", extIf.Name, err)
+	}
 }
 
 func (client *LinuxBridgeClient) SetBridgeMasterToHostInterface() error {
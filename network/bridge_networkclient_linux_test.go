@@ -0,0 +1,36 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/ebtables"
+)
+
+func TestIsExternalInterfaceRule(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0d:3a:01:02:03")
+	_, subnet, _ := net.ParseCIDR("10.240.0.0/16")
+	extIf := &externalInterface{
+		Name:        "eth0",
+		IPAddresses: []*net.IPNet{{IP: net.ParseIP("10.240.0.4"), Mask: subnet.Mask}},
+	}
+
+	tests := []struct {
+		rule     *ebtables.Rule
+		expected bool
+	}{
+		{rule: ebtables.NewSnatRule("eth0", mac), expected: true},
+		{rule: ebtables.NewArpReplyDnatRule("eth0"), expected: true},
+		{rule: ebtables.NewDnatRule("eth0", net.ParseIP("10.240.0.5"), mac), expected: true},
+		{rule: ebtables.NewArpReplyRule(net.ParseIP("10.240.0.5"), mac), expected: true},
+		{rule: ebtables.NewSnatRule("eth1", mac)},
+		{rule: ebtables.NewDnatRule("eth1", net.ParseIP("10.241.0.5"), mac)},
+		{rule: ebtables.NewArpReplyRule(net.ParseIP("10.241.0.5"), mac)},
+	}
+
+	for _, test := range tests {
+		if isExternalInterfaceRule(extIf, test.rule) != test.expected {
+			t.Errorf("Expected %v for rule %v of interface %v", test.expected, test.rule, extIf.Name)
+		}
+	}
+}
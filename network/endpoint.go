@@ -15,6 +15,7 @@ type endpoint struct {
 	Id               string
 	HnsId            string `json:",omitempty"`
 	SandboxKey       string
+	NetNsPath        string `json:",omitempty"`
 	IfName           string
 	HostIfName       string
 	MacAddress       net.HardwareAddr
@@ -23,6 +24,8 @@ type endpoint struct {
 	DNS              DNSInfo
 	Routes           []RouteInfo
 	VlanID           int
+	VxlanID          int
+	VxlanRemoteIP    net.IP `json:",omitempty"`
 	EnableSnatOnHost bool
 }
 
@@ -12,6 +12,7 @@ import (
 	"net"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/platform"
 )
 
 const (
@@ -54,6 +55,8 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 	var contIfName string
 	var epClient EndpointClient
 	var vlanid int = 0
+	var vxlanid int = 0
+	var vxlanRemoteIP net.IP
 
 	if nw.Endpoints[epInfo.Id] != nil {
 		log.Printf("[net] Endpoint alreday exists.")
@@ -65,6 +68,11 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		if _, ok := epInfo.Data[VlanIDKey]; ok {
 			vlanid = epInfo.Data[VlanIDKey].(int)
 		}
+
+		if _, ok := epInfo.Data[VxlanIDKey]; ok {
+			vxlanid = epInfo.Data[VxlanIDKey].(int)
+			vxlanRemoteIP, _ = getVxlanRemoteIP(epInfo.Data)
+		}
 	}
 
 	if _, ok := epInfo.Data[OptVethName]; ok {
@@ -80,7 +88,16 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		contIfName = fmt.Sprintf("%s%s-2", hostVEthInterfacePrefix, epInfo.Id[:7])
 	}
 
-	if vlanid != 0 {
+	if nw.Mode == opModeTransparent {
+		epClient = NewTransparentEndpointClient(nw.extIf, hostIfName, contIfName)
+	} else if vxlanid != 0 {
+		epClient = NewOVSVxlanEndpointClient(
+			nw.extIf,
+			epInfo,
+			hostIfName,
+			contIfName,
+			vxlanid)
+	} else if vlanid != 0 {
 		epClient = NewOVSEndpointClient(
 			nw.extIf,
 			epInfo,
@@ -103,6 +120,7 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 				Gateways:         []net.IP{nw.extIf.IPv4Gateway},
 				DNS:              epInfo.DNS,
 				VlanID:           vlanid,
+				VxlanID:          vxlanid,
 				EnableSnatOnHost: epInfo.EnableSnatOnHost,
 			}
 
@@ -169,16 +187,25 @@ func (nw *network) newEndpointImpl(epInfo *EndpointInfo) (*endpoint, error) {
 		return nil, err
 	}
 
+	// Containers in transparent mode are routed through a link-local gateway.
+	gateway := nw.extIf.IPv4Gateway
+	if nw.Mode == opModeTransparent {
+		gateway = net.ParseIP(transparentGatewayIP)
+	}
+
 	// Create the endpoint object.
 	ep = &endpoint{
 		Id:               epInfo.Id,
+		NetNsPath:        epInfo.NetNsPath,
 		IfName:           epInfo.IfName,
 		HostIfName:       hostIfName,
 		MacAddress:       containerIf.HardwareAddr,
 		IPAddresses:      epInfo.IPAddresses,
-		Gateways:         []net.IP{nw.extIf.IPv4Gateway},
+		Gateways:         []net.IP{gateway},
 		DNS:              epInfo.DNS,
 		VlanID:           vlanid,
+		VxlanID:          vxlanid,
+		VxlanRemoteIP:    vxlanRemoteIP,
 		EnableSnatOnHost: epInfo.EnableSnatOnHost,
 	}
 
@@ -196,7 +223,12 @@ func (nw *network) deleteEndpointImpl(ep *endpoint) error {
 	// Delete the veth pair by deleting one of the peer interfaces.
 	// Deleting the host interface is more convenient since it does not require
 	// entering the container netns and hence works both for CNI and CNM.
-	if ep.VlanID != 0 {
+	if nw.Mode == opModeTransparent {
+		epClient = NewTransparentEndpointClient(nw.extIf, ep.HostIfName, "")
+	} else if ep.VxlanID != 0 {
+		epInfo := ep.getInfo()
+		epClient = NewOVSVxlanEndpointClient(nw.extIf, epInfo, ep.HostIfName, "", ep.VxlanID)
+	} else if ep.VlanID != 0 {
 		epInfo := ep.getInfo()
 		epClient = NewOVSEndpointClient(nw.extIf, epInfo, ep.HostIfName, "", ep.VlanID)
 	} else {
@@ -209,6 +241,64 @@ func (nw *network) deleteEndpointImpl(ep *endpoint) error {
 	return nil
 }
 
+// getEndpointNetNsPath returns the network namespace an endpoint is recreated in after a reboot.
+// Endpoints whose namespace is not a bind mount that survived the reboot cannot be verified.
+func getEndpointNetNsPath(ep *endpoint) (string, error) {
+	nsPath := ep.NetNsPath
+	if nsPath == "" {
+		nsPath = ep.SandboxKey
+	}
+
+	if nsPath == "" || !platform.IsNetworkNamespace(nsPath) {
+		return "", errNamespaceNotFound
+	}
+
+	return nsPath, nil
+}
+
+// rehydrateEndpointImpl recreates an endpoint in its network namespace after a reboot.
+// Endpoints without a network namespace cannot be verified and are not recreated.
+func (nw *network) rehydrateEndpointImpl(ep *endpoint) (*endpoint, error) {
+	// Remove what is left of the endpoint, so that it is recreated from scratch.
+	if _, err := net.InterfaceByName(ep.HostIfName); err == nil {
+		log.Printf("[net] Deleting stale host interface %v of endpoint %v.", ep.HostIfName, ep.Id)
+		if err := nw.deleteEndpointImpl(ep); err != nil {
+			log.Printf("[net] Failed to delete stale host interface %v of endpoint %v, err:%v.", ep.HostIfName, ep.Id, err)
+		}
+	}
+
+	nsPath, err := getEndpointNetNsPath(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	epInfo := ep.getInfo()
+	epInfo.IfName = ep.IfName
+	epInfo.NetNsPath = nsPath
+
+	if ep.VlanID != 0 {
+		epInfo.Data[VlanIDKey] = ep.VlanID
+	}
+
+	if ep.VxlanID != 0 {
+		epInfo.Data[VxlanIDKey] = ep.VxlanID
+	}
+
+	if ep.VxlanRemoteIP != nil {
+		epInfo.Data[VxlanRemoteIPKey] = ep.VxlanRemoteIP.String()
+	}
+
+	newEp, err := nw.newEndpointImpl(epInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	newEp.NetNsPath = ep.NetNsPath
+	newEp.SandboxKey = ep.SandboxKey
+
+	return newEp, nil
+}
+
 // getInfoImpl returns information about the endpoint.
 func (ep *endpoint) getInfoImpl(epInfo *EndpointInfo) {
 }
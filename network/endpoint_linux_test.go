@@ -0,0 +1,76 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountNetworkNamespace bind mounts the current network namespace on a file in dir, as ip netns does.
+func mountNetworkNamespace(t *testing.T, dir string) string {
+	nsPath := filepath.Join(dir, "netns")
+	if err := ioutil.WriteFile(nsPath, nil, 0644); err != nil {
+		t.Fatalf("Failed to create %v, err:%v", nsPath, err)
+	}
+
+	if err := unix.Mount("/proc/self/ns/net", nsPath, "", unix.MS_BIND, ""); err != nil {
+		return ""
+	}
+
+	return nsPath
+}
+
+func TestGetEndpointNetNsPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, err:%v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	regularFile := filepath.Join(dir, "regular")
+	if err := ioutil.WriteFile(regularFile, nil, 0644); err != nil {
+		t.Fatalf("Failed to create %v, err:%v", regularFile, err)
+	}
+
+	tests := []struct {
+		name     string
+		ep       endpoint
+		expected string
+	}{
+		{name: "no namespace", ep: endpoint{}},
+		{name: "missing namespace", ep: endpoint{NetNsPath: filepath.Join(dir, "missing")}},
+		{name: "regular file", ep: endpoint{NetNsPath: regularFile}},
+		{name: "process namespace", ep: endpoint{NetNsPath: "/proc/self/ns/net"}},
+		{name: "missing sandbox key", ep: endpoint{SandboxKey: filepath.Join(dir, "missing")}},
+	}
+
+	if nsPath := mountNetworkNamespace(t, dir); nsPath != "" {
+		defer unix.Unmount(nsPath, unix.MNT_DETACH)
+		tests = append(tests,
+			struct {
+				name     string
+				ep       endpoint
+				expected string
+			}{name: "bind mounted namespace", ep: endpoint{NetNsPath: nsPath}, expected: nsPath},
+			struct {
+				name     string
+				ep       endpoint
+				expected string
+			}{name: "bind mounted sandbox key", ep: endpoint{SandboxKey: nsPath}, expected: nsPath})
+	} else {
+		t.Log("Skipping bind mounted namespaces, mount is not permitted")
+	}
+
+	for _, test := range tests {
+		nsPath, err := getEndpointNetNsPath(&test.ep)
+		if nsPath != test.expected {
+			t.Errorf("%v: expected %q but got %q", test.name, test.expected, nsPath)
+		}
+		if test.expected == "" && err != errNamespaceNotFound {
+			t.Errorf("%v: expected %v but got %v", test.name, errNamespaceNotFound, err)
+		}
+	}
+}
@@ -126,6 +126,11 @@ func (nw *network) deleteEndpointImpl(ep *endpoint) error {
 	return err
 }
 
+// rehydrateEndpointImpl keeps the endpoint as it is, since HNS persists endpoints across reboots.
+func (nw *network) rehydrateEndpointImpl(ep *endpoint) (*endpoint, error) {
+	return ep, nil
+}
+
 // getInfoImpl returns information about the endpoint.
 func (ep *endpoint) getInfoImpl(epInfo *EndpointInfo) {
 	epInfo.Data["hnsid"] = ep.HnsId
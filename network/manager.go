@@ -15,8 +15,9 @@ import (
 
 const (
 	// Network store key.
-	storeKey  = "Network"
-	VlanIDKey = "VlanID"
+	storeKey   = "Network"
+	VlanIDKey  = "VlanID"
+	VxlanIDKey = "VxlanID"
 )
 
 type NetworkClient interface {
@@ -147,8 +148,16 @@ func (nm *networkManager) restore() error {
 					log.Printf("[net] Restoring network failed for nwInfo %v extif %v. This should not happen %v", nwInfo, extIf, err)
 					return err
 				}
+
+				nm.rehydrateEndpoints(nw)
 			}
 		}
+
+		// Save the rehydrated state so that it is not rehydrated again.
+		if err := nm.save(); err != nil {
+			log.Printf("[net] Failed to save rehydrated state, err:%v.", err)
+			return err
+		}
 	}
 
 	log.Printf("[net] Restored state, %+v\n", nm)
@@ -165,6 +174,40 @@ func (nm *networkManager) restore() error {
 	return nil
 }
 
+// rehydrateEndpoints recreates the endpoints of a network after a reboot, and removes the endpoints
+// whose network namespace no longer exists.
+func (nm *networkManager) rehydrateEndpoints(nw *network) {
+	var restored, removed, failed []string
+
+	endpoints := nw.Endpoints
+	nw.Endpoints = make(map[string]*endpoint)
+
+	// Endpoints are added back once they are recreated.
+	for id, ep := range endpoints {
+		newEp, err := nw.rehydrateEndpointImpl(ep)
+		switch {
+		case err == nil:
+			nw.Endpoints[id] = newEp
+			restored = append(restored, id)
+
+		case err == errNamespaceNotFound:
+			// After a reboot IPAM only keeps the addresses of namespaces that still exist,
+			// so the addresses of endpoints whose namespace is gone are already available.
+			log.Printf("[net] Removing endpoint %v with addresses %v, err:%v.", id, ep.IPAddresses, err)
+			removed = append(removed, id)
+
+		default:
+			// Keep the endpoint, so that its addresses are released when its container is deleted.
+			log.Printf("[net] Failed to recreate endpoint %v with addresses %v, err:%v.", id, ep.IPAddresses, err)
+			nw.Endpoints[id] = ep
+			failed = append(failed, id)
+		}
+	}
+
+	log.Printf("[net] Rehydrated network %v, restored endpoints %v, removed endpoints %v, failed endpoints %v.",
+		nw.Id, restored, removed, failed)
+}
+
 // Save writes network manager state to persistent store.
 func (nm *networkManager) save() error {
 	// Skip if a store is not provided.
@@ -287,6 +330,13 @@ func (nm *networkManager) CreateEndpoint(networkId string, epInfo *EndpointInfo)
 		}
 	}
 
+	if nw.VxlanId != 0 {
+		if epInfo.Data[VxlanIDKey] == nil {
+			log.Printf("overriding endpoint vxlanid with network vxlanid")
+			epInfo.Data[VxlanIDKey] = nw.VxlanId
+		}
+	}
+
 	_, err = nw.newEndpoint(epInfo)
 	if err != nil {
 		return err
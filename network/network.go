@@ -13,9 +13,10 @@ import (
 
 const (
 	// Operational modes.
-	opModeBridge  = "bridge"
-	opModeTunnel  = "tunnel"
-	opModeDefault = opModeTunnel
+	opModeBridge      = "bridge"
+	opModeTunnel      = "tunnel"
+	opModeTransparent = "transparent"
+	opModeDefault     = opModeTunnel
 )
 
 // ExternalInterface is a host network interface that bridges containers to external networks.
@@ -37,6 +38,7 @@ type network struct {
 	HnsId            string `json:",omitempty"`
 	Mode             string
 	VlanId           int
+	VxlanId          int
 	Subnets          []SubnetInfo
 	Endpoints        map[string]*endpoint
 	extIf            *externalInterface
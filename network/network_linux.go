@@ -29,6 +29,8 @@ const (
 
 	LocalIPKey = "localIP"
 
+	VxlanRemoteIPKey = "vxlanRemoteIP"
+
 	OptVethName = "vethname"
 )
 
@@ -39,6 +41,7 @@ type route netlink.Route
 func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInterface) (*network, error) {
 	// Connect the external interface.
 	var vlanid int
+	var vxlanid int
 	opt, _ := nwInfo.Options[genericData].(map[string]interface{})
 	log.Printf("opt %+v options %+v", opt, nwInfo.Options)
 
@@ -55,6 +58,21 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 			vlanid, _ = strconv.Atoi(opt[VlanIDKey].(string))
 		}
 
+		if opt != nil && opt[VxlanIDKey] != nil {
+			vxlanid, _ = strconv.Atoi(opt[VxlanIDKey].(string))
+		}
+
+	case opModeTransparent:
+		log.Printf("[net] Routing container traffic through interface %v.", extIf.Name)
+		networkClient := NewTransparentNetworkClient(extIf.Name)
+		if err := networkClient.CreateBridge(); err != nil {
+			return nil, err
+		}
+
+		if err := networkClient.AddL2Rules(extIf); err != nil {
+			return nil, err
+		}
+
 	default:
 		return nil, errNetworkModeInvalid
 	}
@@ -66,6 +84,7 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 		Endpoints:        make(map[string]*endpoint),
 		extIf:            extIf,
 		VlanId:           vlanid,
+		VxlanId:          vxlanid,
 		EnableSnatOnHost: nwInfo.EnableSnatOnHost,
 	}
 
@@ -76,7 +95,13 @@ func (nm *networkManager) newNetworkImpl(nwInfo *NetworkInfo, extIf *externalInt
 func (nm *networkManager) deleteNetworkImpl(nw *network) error {
 	var networkClient NetworkClient
 
-	if nw.VlanId != 0 {
+	// There is no bridge to disconnect in transparent mode.
+	if nw.Mode == opModeTransparent {
+		NewTransparentNetworkClient(nw.extIf.Name).DeleteL2Rules(nw.extIf)
+		return nil
+	}
+
+	if nw.VlanId != 0 || nw.VxlanId != 0 {
 		networkClient = NewOVSClient(nw.extIf.BridgeName, nw.extIf.Name, "", nw.EnableSnatOnHost)
 	} else {
 		networkClient = NewLinuxBridgeClient(nw.extIf.BridgeName, nw.extIf.Name, nw.Mode)
@@ -194,7 +219,7 @@ func (nm *networkManager) connectExternalInterface(extIf *externalInterface, nwI
 	}
 
 	opt, _ := nwInfo.Options[genericData].(map[string]interface{})
-	if opt != nil && opt[VlanIDKey] != nil {
+	if opt != nil && (opt[VlanIDKey] != nil || opt[VxlanIDKey] != nil) {
 		snatBridgeIP := ""
 
 		if opt != nil && opt[SnatBridgeIPKey] != nil {
@@ -325,4 +350,10 @@ func getNetworkInfoImpl(nwInfo *NetworkInfo, nw *network) {
 		vlanMap[VlanIDKey] = strconv.Itoa(nw.VlanId)
 		nwInfo.Options[genericData] = vlanMap
 	}
+
+	if nw.VxlanId != 0 {
+		vxlanMap := make(map[string]interface{})
+		vxlanMap[VxlanIDKey] = strconv.Itoa(nw.VxlanId)
+		nwInfo.Options[genericData] = vxlanMap
+	}
 }
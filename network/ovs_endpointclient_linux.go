@@ -20,12 +20,17 @@ type OVSEndpointClient struct {
 	snatBridgeIP      string
 	localIP           string
 	vlanID            int
+	vxlanID           int
 	enableSnatOnHost  bool
 }
 
 const (
 	snatVethInterfacePrefix = commonInterfacePrefix + "vint"
 	azureSnatIfName         = "eth1"
+	vxlanTunnelPortName     = commonInterfacePrefix + "vxlan0"
+
+	// VXLAN network identifiers are 24 bits wide.
+	maxVxlanID = 1<<24 - 1
 )
 
 func NewOVSEndpointClient(
@@ -57,6 +62,35 @@ func NewOVSEndpointClient(
 	return client
 }
 
+func NewOVSVxlanEndpointClient(
+	extIf *externalInterface,
+	epInfo *EndpointInfo,
+	hostVethName string,
+	containerVethName string,
+	vxlanid int,
+) *OVSEndpointClient {
+
+	client := NewOVSEndpointClient(extIf, epInfo, hostVethName, containerVethName, 0)
+	client.vxlanID = vxlanid
+
+	return client
+}
+
+// getVxlanRemoteIP returns the remote tunnel endpoint of a vxlan endpoint, which must be an IPv4 address.
+func getVxlanRemoteIP(data map[string]interface{}) (net.IP, error) {
+	remoteIP, ok := data[VxlanRemoteIPKey].(string)
+	if !ok || remoteIP == "" {
+		return nil, fmt.Errorf("Vxlan remote endpoint is not specified")
+	}
+
+	ip := net.ParseIP(remoteIP).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("Vxlan remote endpoint %v is not an IPv4 address", remoteIP)
+	}
+
+	return ip, nil
+}
+
 func (client *OVSEndpointClient) AddEndpoints(epInfo *EndpointInfo) error {
 	if err := createEndpoint(client.hostVethName, client.containerVethName); err != nil {
 		return err
@@ -108,6 +142,10 @@ func (client *OVSEndpointClient) AddEndpoints(epInfo *EndpointInfo) error {
 }
 
 func (client *OVSEndpointClient) AddEndpointRules(epInfo *EndpointInfo) error {
+	if client.vxlanID != 0 {
+		return client.addVxlanEndpointRules(epInfo)
+	}
+
 	log.Printf("[ovs] Setting link %v master %v.", client.hostVethName, client.bridgeName)
 	if err := ovsctl.AddPortOnOVSBridge(client.hostVethName, client.bridgeName, client.vlanID); err != nil {
 		return err
@@ -150,34 +188,141 @@ func (client *OVSEndpointClient) AddEndpointRules(epInfo *EndpointInfo) error {
 	return nil
 }
 
-func (client *OVSEndpointClient) DeleteEndpointRules(ep *endpoint) {
+func (client *OVSEndpointClient) addVxlanEndpointRules(epInfo *EndpointInfo) error {
+	if client.vxlanID < 0 || client.vxlanID > maxVxlanID {
+		return fmt.Errorf("Invalid vxlan id %v", client.vxlanID)
+	}
+
+	remoteIP, err := getVxlanRemoteIP(epInfo.Data)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[ovs] Adding vxlan tunnel port %v on bridge %v.", vxlanTunnelPortName, client.bridgeName)
+	if err := ovsctl.AddVxlanTunnelPort(client.bridgeName, vxlanTunnelPortName); err != nil {
+		return err
+	}
+
+	log.Printf("[ovs] Setting link %v master %v.", client.hostVethName, client.bridgeName)
+	if err := ovsctl.AddPortOnOVSBridge(client.hostVethName, client.bridgeName, 0); err != nil {
+		return err
+	}
+
+	log.Printf("[ovs] Get ovs port for interface %v.", client.hostVethName)
+	containerPort, err := ovsctl.GetOVSPortNumber(client.hostVethName)
+	if err != nil {
+		log.Printf("[ovs] Get ofport failed with error %v", err)
+		return err
+	}
+
+	log.Printf("[ovs] Get ovs port for interface %v.", vxlanTunnelPortName)
+	tunnelPort, err := ovsctl.GetOVSPortNumber(vxlanTunnelPortName)
+	if err != nil {
+		log.Printf("[ovs] Get ofport failed with error %v", err)
+		return err
+	}
+
+	// Encapsulate egress traffic with the tenant VNI.
+	log.Printf("[ovs] Adding vxlan encap rule for vni %v on %v.", client.vxlanID, containerPort)
+	if err := ovsctl.AddVxlanEncapRule(client.bridgeName, containerPort, tunnelPort, client.vxlanID, remoteIP); err != nil {
+		return err
+	}
+
+	for _, ipAddr := range epInfo.IPAddresses {
+		// Add Arp Reply Rules
+		if err := ovsctl.AddFakeArpReply(client.bridgeName, ipAddr.IP); err != nil {
+			return err
+		}
+
+		// Deliver decapsulated traffic based on dst ip and vni
+		log.Printf("[ovs] Adding vxlan decap rule for IP address %v and vni %v.", ipAddr.IP.String(), client.vxlanID)
+		if err := ovsctl.AddVxlanDecapRule(client.bridgeName, tunnelPort, ipAddr.IP, client.containerMac, containerPort, client.vxlanID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (client *OVSEndpointClient) deleteVxlanEndpointRules(ep *endpoint) {
 	log.Printf("[ovs] Get ovs port for interface %v.", ep.HostIfName)
 	containerPort, err := ovsctl.GetOVSPortNumber(client.hostVethName)
 	if err != nil {
 		log.Printf("[ovs] Get portnum failed with error %v", err)
+	} else {
+		// Delete vxlan encap rules, which match egress IP traffic of the container port.
+		log.Printf("[ovs] Deleting vxlan encap rule for port %v", containerPort)
+		if err := ovsctl.DeleteIPSnatRule(client.bridgeName, containerPort); err != nil {
+			log.Printf("[ovs] Failed to delete vxlan encap rule for port %v: %v.", containerPort, err)
+		}
 	}
 
-	log.Printf("[ovs] Get ovs port for interface %v.", client.hostPrimaryIfName)
-	hostPort, err := ovsctl.GetOVSPortNumber(client.hostPrimaryIfName)
+	log.Printf("[ovs] Get ovs port for interface %v.", vxlanTunnelPortName)
+	tunnelPort, err := ovsctl.GetOVSPortNumber(vxlanTunnelPortName)
 	if err != nil {
 		log.Printf("[ovs] Get portnum failed with error %v", err)
+	} else {
+		// Delete vxlan decap rules.
+		for _, ipAddr := range ep.IPAddresses {
+			log.Printf("[ovs] Deleting vxlan decap rule for IP address %v and vni %v.", ipAddr.IP.String(), ep.VxlanID)
+			if err := ovsctl.DeleteVxlanDecapRule(client.bridgeName, tunnelPort, ipAddr.IP, ep.VxlanID); err != nil {
+				log.Printf("[ovs] Failed to delete vxlan decap rule for IP address %v: %v.", ipAddr.IP.String(), err)
+			}
+		}
 	}
 
-	// Delete IP SNAT
-	log.Printf("[ovs] Deleting IP SNAT for port %v", containerPort)
-	ovsctl.DeleteIPSnatRule(client.bridgeName, containerPort)
+	// Delete port from ovs bridge
+	log.Printf("[ovs] Deleting interface %v from bridge %v", client.hostVethName, client.bridgeName)
+	if err := ovsctl.DeletePortFromOVS(client.bridgeName, client.hostVethName); err != nil {
+		log.Printf("[ovs] Failed to delete interface %v from bridge %v: %v.", client.hostVethName, client.bridgeName, err)
+	}
+}
 
-	// Delete Arp Reply Rules for container
-	log.Printf("[ovs] Deleting ARP reply rule for ip %v vlanid %v for container port", ep.IPAddresses[0].IP.String(), ep.VlanID, containerPort)
-	ovsctl.DeleteArpReplyRule(client.bridgeName, containerPort, ep.IPAddresses[0].IP, ep.VlanID)
+func (client *OVSEndpointClient) DeleteEndpointRules(ep *endpoint) {
+	if client.vxlanID != 0 {
+		client.deleteVxlanEndpointRules(ep)
+		return
+	}
 
-	// Delete MAC address translation rule.
-	log.Printf("[ovs] Deleting MAC DNAT rule for IP address %v and vlan %v.", ep.IPAddresses[0].IP.String(), ep.VlanID)
-	ovsctl.DeleteMacDnatRule(client.bridgeName, hostPort, ep.IPAddresses[0].IP, ep.VlanID)
+	log.Printf("[ovs] Get ovs port for interface %v.", ep.HostIfName)
+	containerPort, err := ovsctl.GetOVSPortNumber(client.hostVethName)
+	if err != nil {
+		log.Printf("[ovs] Get portnum failed with error %v", err)
+	} else {
+		// Delete IP SNAT
+		log.Printf("[ovs] Deleting IP SNAT for port %v", containerPort)
+		if err := ovsctl.DeleteIPSnatRule(client.bridgeName, containerPort); err != nil {
+			log.Printf("[ovs] Failed to delete IP SNAT for port %v: %v.", containerPort, err)
+		}
+
+		// Delete Arp Reply Rules for container
+		for _, ipAddr := range ep.IPAddresses {
+			log.Printf("[ovs] Deleting ARP reply rule for ip %v vlanid %v for container port %v", ipAddr.IP.String(), ep.VlanID, containerPort)
+			if err := ovsctl.DeleteArpReplyRule(client.bridgeName, containerPort, ipAddr.IP, ep.VlanID); err != nil {
+				log.Printf("[ovs] Failed to delete ARP reply rule for IP address %v: %v.", ipAddr.IP.String(), err)
+			}
+		}
+	}
+
+	log.Printf("[ovs] Get ovs port for interface %v.", client.hostPrimaryIfName)
+	hostPort, err := ovsctl.GetOVSPortNumber(client.hostPrimaryIfName)
+	if err != nil {
+		log.Printf("[ovs] Get portnum failed with error %v", err)
+	} else {
+		// Delete MAC address translation rules.
+		for _, ipAddr := range ep.IPAddresses {
+			log.Printf("[ovs] Deleting MAC DNAT rule for IP address %v and vlan %v.", ipAddr.IP.String(), ep.VlanID)
+			if err := ovsctl.DeleteMacDnatRule(client.bridgeName, hostPort, ipAddr.IP, ep.VlanID); err != nil {
+				log.Printf("[ovs] Failed to delete MAC DNAT rule for IP address %v: %v.", ipAddr.IP.String(), err)
+			}
+		}
+	}
 
 	// Delete port from ovs bridge
 	log.Printf("[ovs] Deleting interface %v from bridge %v", client.hostVethName, client.bridgeName)
-	ovsctl.DeletePortFromOVS(client.bridgeName, client.hostVethName)
+	if err := ovsctl.DeletePortFromOVS(client.bridgeName, client.hostVethName); err != nil {
+		log.Printf("[ovs] Failed to delete interface %v from bridge %v: %v.", client.hostVethName, client.bridgeName, err)
+	}
 }
 
 func (client *OVSEndpointClient) MoveEndpointsToContainerNS(epInfo *EndpointInfo, nsID uintptr) error {
@@ -0,0 +1,34 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGetVxlanRemoteIP(t *testing.T) {
+	tests := []struct {
+		data     map[string]interface{}
+		expected net.IP
+	}{
+		{data: map[string]interface{}{VxlanRemoteIPKey: "10.0.0.4"}, expected: net.ParseIP("10.0.0.4").To4()},
+		{data: map[string]interface{}{}},
+		{data: map[string]interface{}{VxlanRemoteIPKey: ""}},
+		{data: map[string]interface{}{VxlanRemoteIPKey: "invalid"}},
+		{data: map[string]interface{}{VxlanRemoteIPKey: "fd00::4"}},
+		{data: map[string]interface{}{VxlanRemoteIPKey: 4}},
+	}
+
+	for _, test := range tests {
+		remoteIP, err := getVxlanRemoteIP(test.data)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("Expected %+v to be rejected but got %v", test.data, remoteIP)
+			}
+			continue
+		}
+
+		if err != nil || !remoteIP.Equal(test.expected) {
+			t.Errorf("Expected %v for %+v but got %v err:%v", test.expected, test.data, remoteIP, err)
+		}
+	}
+}
@@ -314,7 +314,9 @@ func (client *OVSNetworkClient) AddL2Rules(extIf *externalInterface) error {
 }
 
 func (client *OVSNetworkClient) DeleteL2Rules(extIf *externalInterface) {
-	ovsctl.DeletePortFromOVS(client.bridgeName, client.hostInterfaceName)
+	if err := ovsctl.DeletePortFromOVS(client.bridgeName, client.hostInterfaceName); err != nil {
+		log.Printf("[ovs] Failed to delete interface %v from bridge %v: %v.", client.hostInterfaceName, client.bridgeName, err)
+	}
 }
 
 func (client *OVSNetworkClient) SetBridgeMasterToHostInterface() error {
@@ -0,0 +1,203 @@
+package network
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Link-local address of the gateway of containers in transparent mode, answered by proxy ARP on the host veth.
+	transparentGatewayIP = "169.254.1.1"
+)
+
+// TransparentEndpointClient connects a container to the host through a veth pair, routing its
+// IP addresses to the host veth with host routes.
+type TransparentEndpointClient struct {
+	hostPrimaryIfName string
+	hostVethName      string
+	containerVethName string
+	containerMac      net.HardwareAddr
+}
+
+func NewTransparentEndpointClient(
+	extIf *externalInterface,
+	hostVethName string,
+	containerVethName string,
+) *TransparentEndpointClient {
+
+	client := &TransparentEndpointClient{
+		hostPrimaryIfName: extIf.Name,
+		hostVethName:      hostVethName,
+		containerVethName: containerVethName,
+	}
+
+	return client
+}
+
+// getHostRoute returns the host route of a container IP address.
+func getHostRoute(ip net.IP, linkIndex int) *netlink.Route {
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+
+	return &netlink.Route{
+		Family:    netlink.GetIpAddressFamily(ip),
+		Dst:       &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)},
+		Scope:     unix.RT_SCOPE_LINK,
+		LinkIndex: linkIndex,
+	}
+}
+
+// validateTransparentEndpoint rejects IPv6 addresses and routes, since the host only answers
+// ARP for the gateway and does not proxy IPv6 neighbor discovery.
+func validateTransparentEndpoint(epInfo *EndpointInfo) error {
+	for _, ipAddr := range epInfo.IPAddresses {
+		if ipAddr.IP.To4() == nil {
+			return fmt.Errorf("IPv6 address %v is not supported in transparent mode", ipAddr.IP)
+		}
+	}
+
+	for _, route := range epInfo.Routes {
+		if route.Dst.IP.To4() == nil || (route.Gw != nil && route.Gw.To4() == nil) {
+			return fmt.Errorf("IPv6 route %v via %v is not supported in transparent mode", route.Dst.String(), route.Gw)
+		}
+	}
+
+	return nil
+}
+
+// getTransparentRoutes returns the routes of a container with the link-local gateway as next hop.
+// A default route is added if no routes are specified.
+func getTransparentRoutes(routes []RouteInfo, gateway net.IP) []RouteInfo {
+	var result []RouteInfo
+	for _, route := range routes {
+		route.Gw = gateway
+		result = append(result, route)
+	}
+
+	if len(result) == 0 {
+		result = append(result, RouteInfo{Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}, Gw: gateway})
+	}
+
+	return result
+}
+
+func (client *TransparentEndpointClient) AddEndpoints(epInfo *EndpointInfo) error {
+	if err := validateTransparentEndpoint(epInfo); err != nil {
+		log.Printf("[net] Invalid transparent endpoint: %v.", err)
+		return err
+	}
+
+	if err := createEndpoint(client.hostVethName, client.containerVethName); err != nil {
+		return err
+	}
+
+	containerIf, err := net.InterfaceByName(client.containerVethName)
+	if err != nil {
+		return err
+	}
+
+	client.containerMac = containerIf.HardwareAddr
+	return nil
+}
+
+func (client *TransparentEndpointClient) AddEndpointRules(epInfo *EndpointInfo) error {
+	hostIf, err := net.InterfaceByName(client.hostVethName)
+	if err != nil {
+		return err
+	}
+
+	// Answer ARP requests of the container for the gateway.
+	log.Printf("[net] Enabling proxy ARP on %v.", client.hostVethName)
+	if err := enableProxyArp(client.hostVethName); err != nil {
+		return err
+	}
+
+	for _, ipAddr := range epInfo.IPAddresses {
+		log.Printf("[net] Adding host route for IP address %v to %v.", ipAddr.IP, client.hostVethName)
+		if err := netlink.AddIpRoute(getHostRoute(ipAddr.IP, hostIf.Index)); err != nil {
+			log.Printf("[net] Failed to add host route for IP address %v: %v.", ipAddr.IP, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (client *TransparentEndpointClient) DeleteEndpointRules(ep *endpoint) {
+	hostIf, err := net.InterfaceByName(client.hostVethName)
+	if err != nil {
+		log.Printf("[net] Failed to find host veth %v: %v.", client.hostVethName, err)
+		return
+	}
+
+	for _, ipAddr := range ep.IPAddresses {
+		log.Printf("[net] Deleting host route for IP address %v on %v.", ipAddr.IP, ep.Id)
+		if err := netlink.DeleteIpRoute(getHostRoute(ipAddr.IP, hostIf.Index)); err != nil {
+			log.Printf("[net] Failed to delete host route for IP address %v: %v.", ipAddr.IP, err)
+		}
+	}
+}
+
+func (client *TransparentEndpointClient) MoveEndpointsToContainerNS(epInfo *EndpointInfo, nsID uintptr) error {
+	// Move the container interface to container's network namespace.
+	log.Printf("[net] Setting link %v netns %v.", client.containerVethName, epInfo.NetNsPath)
+	if err := netlink.SetLinkNetNs(client.containerVethName, nsID); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (client *TransparentEndpointClient) SetupContainerInterfaces(epInfo *EndpointInfo) error {
+	if err := setupContainerInterface(client.containerVethName, epInfo.IfName); err != nil {
+		return err
+	}
+
+	client.containerVethName = epInfo.IfName
+	return nil
+}
+
+// ConfigureContainerInterfacesAndRoutes assigns host addresses to the container interface, and sends
+// all traffic through the link-local gateway so that the host routes it.
+func (client *TransparentEndpointClient) ConfigureContainerInterfacesAndRoutes(epInfo *EndpointInfo) error {
+	var ipAddresses []net.IPNet
+	for _, ipAddr := range epInfo.IPAddresses {
+		ipAddresses = append(ipAddresses, *getHostRoute(ipAddr.IP, 0).Dst)
+	}
+
+	if err := assignIPToInterface(client.containerVethName, ipAddresses); err != nil {
+		return err
+	}
+
+	containerIf, err := net.InterfaceByName(client.containerVethName)
+	if err != nil {
+		return err
+	}
+
+	gateway := net.ParseIP(transparentGatewayIP)
+
+	log.Printf("[net] Adding route to gateway %v on link %v.", gateway, client.containerVethName)
+	if err := netlink.AddIpRoute(getHostRoute(gateway, containerIf.Index)); err != nil {
+		log.Printf("[net] Failed to add route to gateway %v: %v.", gateway, err)
+		return err
+	}
+
+	return addRoutes(client.containerVethName, getTransparentRoutes(epInfo.Routes, gateway))
+}
+
+func (client *TransparentEndpointClient) DeleteEndpoints(ep *endpoint) error {
+	log.Printf("[net] Deleting veth pair %v %v.", ep.HostIfName, ep.IfName)
+	err := netlink.DeleteLink(ep.HostIfName)
+	if err != nil {
+		log.Printf("[net] Failed to delete veth pair %v: %v.", ep.HostIfName, err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestGetHostRoute(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{ip: "10.0.0.4", expected: "10.0.0.4/32"},
+		{ip: "fd00::4", expected: "fd00::4/128"},
+	}
+
+	for _, test := range tests {
+		route := getHostRoute(net.ParseIP(test.ip), 3)
+		if route.Dst.String() != test.expected || route.LinkIndex != 3 {
+			t.Errorf("Expected host route %v for %v but got %+v", test.expected, test.ip, route)
+		}
+	}
+}
+
+func TestValidateTransparentEndpoint(t *testing.T) {
+	_, ipv4Net, _ := net.ParseCIDR("10.0.0.0/16")
+	_, ipv6Net, _ := net.ParseCIDR("fd00::/64")
+
+	tests := []struct {
+		epInfo EndpointInfo
+		valid  bool
+	}{
+		{
+			epInfo: EndpointInfo{
+				IPAddresses: []net.IPNet{{IP: net.ParseIP("10.0.0.4"), Mask: ipv4Net.Mask}},
+				Routes:      []RouteInfo{{Dst: *ipv4Net, Gw: net.ParseIP("10.0.0.1")}},
+			},
+			valid: true,
+		},
+		{
+			epInfo: EndpointInfo{IPAddresses: []net.IPNet{{IP: net.ParseIP("fd00::4"), Mask: ipv6Net.Mask}}},
+		},
+		{
+			epInfo: EndpointInfo{Routes: []RouteInfo{{Dst: *ipv6Net}}},
+		},
+		{
+			epInfo: EndpointInfo{Routes: []RouteInfo{{Dst: *ipv4Net, Gw: net.ParseIP("fd00::1")}}},
+		},
+	}
+
+	for _, test := range tests {
+		err := validateTransparentEndpoint(&test.epInfo)
+		if (err == nil) != test.valid {
+			t.Errorf("Expected valid=%v for %+v but got err:%v", test.valid, test.epInfo, err)
+		}
+	}
+}
+
+func TestGetTransparentRoutes(t *testing.T) {
+	gateway := net.ParseIP(transparentGatewayIP)
+	_, dst, _ := net.ParseCIDR("10.1.0.0/16")
+
+	routes := getTransparentRoutes([]RouteInfo{{Dst: *dst, Gw: net.ParseIP("10.0.0.1")}}, gateway)
+	if !reflect.DeepEqual(routes, []RouteInfo{{Dst: *dst, Gw: gateway}}) {
+		t.Errorf("Expected route through the gateway but got %+v", routes)
+	}
+
+	routes = getTransparentRoutes(nil, gateway)
+	if len(routes) != 1 || routes[0].Dst.String() != "0.0.0.0/0" || !routes[0].Gw.Equal(gateway) {
+		t.Errorf("Expected default route through the gateway but got %+v", routes)
+	}
+}
@@ -0,0 +1,69 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	ipv4ForwardingSysctl = "/proc/sys/net/ipv4/ip_forward"
+	proxyArpSysctl       = "/proc/sys/net/ipv4/conf/%s/proxy_arp"
+)
+
+// TransparentNetworkClient connects containers to the host network without a bridge.
+// The host routes traffic between containers and the external interface.
+type TransparentNetworkClient struct {
+	hostInterfaceName string
+}
+
+func NewTransparentNetworkClient(hostInterfaceName string) *TransparentNetworkClient {
+	client := &TransparentNetworkClient{
+		hostInterfaceName: hostInterfaceName,
+	}
+
+	return client
+}
+
+// writeSysctl sets a kernel parameter.
+func writeSysctl(path string, value string) error {
+	log.Printf("[net] Setting %v to %v.", path, value)
+	if err := ioutil.WriteFile(path, []byte(value), 0644); err != nil {
+		log.Printf("[net] Failed to set %v, err:%v.", path, err)
+		return err
+	}
+
+	return nil
+}
+
+// CreateBridge enables IP forwarding on the host, since there is no bridge in transparent mode.
+func (client *TransparentNetworkClient) CreateBridge() error {
+	return writeSysctl(ipv4ForwardingSysctl, "1")
+}
+
+// DeleteBridge leaves IP forwarding enabled, as other components of the host may rely on it.
+func (client *TransparentNetworkClient) DeleteBridge() error {
+	return nil
+}
+
+func (client *TransparentNetworkClient) AddL2Rules(extIf *externalInterface) error {
+	// Container addresses are resolved by proxy ARP on each host veth, so no ebtables rules are needed.
+	return nil
+}
+
+func (client *TransparentNetworkClient) DeleteL2Rules(extIf *externalInterface) {
+}
+
+func (client *TransparentNetworkClient) SetBridgeMasterToHostInterface() error {
+	return nil
+}
+
+func (client *TransparentNetworkClient) SetHairpinOnHostInterface(enable bool) error {
+	return nil
+}
+
+// enableProxyArp makes an interface answer ARP requests for addresses routed through other interfaces.
+func enableProxyArp(interfaceName string) error {
+	return writeSysctl(fmt.Sprintf(proxyArpSysctl, interfaceName), "1")
+}
@@ -0,0 +1,33 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSysctl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ip_forward")
+	if err := ioutil.WriteFile(path, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeSysctl(path, "1"); err != nil {
+		t.Fatalf("writeSysctl failed with %v", err)
+	}
+
+	if value, err := ioutil.ReadFile(path); err != nil || string(value) != "1" {
+		t.Errorf("Expected sysctl to be 1 but got %q err:%v", value, err)
+	}
+
+	if err := writeSysctl(filepath.Join(dir, "missing", "proxy_arp"), "1"); err == nil {
+		t.Errorf("Expected writing a missing sysctl to fail")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+// GetIpsetNames returns the names of the ipsets and ipset lists NPM creates for the given objects.
+// They are the origins of the hashed set names found in iptables and ipset.
+func GetIpsetNames(nsObjs []*corev1.Namespace, podObjs []*corev1.Pod, npObjs []*networkingv1.NetworkPolicy) ([]string, error) {
+	// The manager only records the sets, nothing is programmed.
+	ipsMgr := ipsm.NewIpsetManager()
+	ipsMgr.DryRun = true
+
+	if err := ipsMgr.CreateSet(util.KubeSystemFlag); err != nil {
+		return nil, err
+	}
+
+	for _, nsObj := range nsObjs {
+		if err := addNsToIpsets(ipsMgr, nsObj); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, podObj := range podObjs {
+		if !isValidPod(podObj) {
+			continue
+		}
+
+		if err := addPodToIpsets(ipsMgr, podObj); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, npObj := range npObjs {
+		podSets, nsLists, _ := parsePolicy(npObj)
+		for _, set := range podSets {
+			if err := ipsMgr.CreateSet(set); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, list := range nsLists {
+			if err := ipsMgr.CreateList(list); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return ipsMgr.GetNames(), nil
+}
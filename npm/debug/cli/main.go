@@ -0,0 +1,316 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/common"
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/debug"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Command line options of the NPM debug tool.
+const (
+	optSrc               = "src"
+	optSrcAlias          = "s"
+	optDst               = "dst"
+	optDstAlias          = "d"
+	optProtocol          = "protocol"
+	optProtocolAlias     = "p"
+	optPort              = "port"
+	optPortAlias         = "dp"
+	optLookup            = "lookup"
+	optLookupAlias       = "l"
+	optIptablesSave      = "iptables-save"
+	optIptablesSaveAlias = "ipt"
+	optIpsetSave         = "ipset-save"
+	optIpsetSaveAlias    = "ips"
+)
+
+// Version is populated by make during build.
+var version string
+
+// Command line arguments for the NPM debug tool.
+var args = common.ArgumentList{
+	{
+		Name:         optSrc,
+		Shorthand:    optSrcAlias,
+		Description:  "Set the source pod as namespace/name, or the source IP",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         optDst,
+		Shorthand:    optDstAlias,
+		Description:  "Set the destination pod as namespace/name, or the destination IP",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         optProtocol,
+		Shorthand:    optProtocolAlias,
+		Description:  "Set the protocol",
+		Type:         "string",
+		DefaultValue: "tcp",
+		ValueMap: map[string]interface{}{
+			"tcp":  0,
+			"udp":  0,
+			"sctp": 0,
+		},
+	},
+	{
+		Name:         optPort,
+		Shorthand:    optPortAlias,
+		Description:  "Set the destination port",
+		Type:         "int",
+		DefaultValue: "0",
+	},
+	{
+		Name:         optLookup,
+		Shorthand:    optLookupAlias,
+		Description:  "Print the origin of a hashed set name",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         optIptablesSave,
+		Shorthand:    optIptablesSaveAlias,
+		Description:  "Read iptables-save output from a file instead of the host",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         optIpsetSave,
+		Shorthand:    optIpsetSaveAlias,
+		Description:  "Read ipset save output from a file instead of the host",
+		Type:         "string",
+		DefaultValue: "",
+	},
+	{
+		Name:         common.OptVersion,
+		Shorthand:    common.OptVersionAlias,
+		Description:  "Print version information",
+		Type:         "bool",
+		DefaultValue: false,
+	},
+}
+
+// Prints description and version information.
+func printVersion() {
+	fmt.Printf("Azure network policy manager debug tool\n")
+	fmt.Printf("Version %v\n", version)
+}
+
+// clusterObjects holds the objects NPM derives its ipsets from.
+type clusterObjects struct {
+	namespaces []*corev1.Namespace
+	pods       []*corev1.Pod
+	policies   []*networkingv1.NetworkPolicy
+}
+
+// getClusterObjects lists namespaces, pods and network policies through the in-cluster config.
+func getClusterObjects() (*clusterObjects, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := &clusterObjects{}
+
+	nsList, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range nsList.Items {
+		objs.namespaces = append(objs.namespaces, &nsList.Items[i])
+	}
+
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range podList.Items {
+		objs.pods = append(objs.pods, &podList.Items[i])
+	}
+
+	npList, err := clientset.NetworkingV1().NetworkPolicies(metav1.NamespaceAll).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range npList.Items {
+		objs.policies = append(objs.policies, &npList.Items[i])
+	}
+
+	return objs, nil
+}
+
+// resolveIP returns the IP of an endpoint given as an IP or as a namespace/name pod reference.
+func resolveIP(endpoint string, objs *clusterObjects) (string, error) {
+	if net.ParseIP(endpoint) != nil {
+		return endpoint, nil
+	}
+
+	s := strings.SplitN(endpoint, "/", 2)
+	if len(s) != 2 {
+		return "", fmt.Errorf("%s is neither an IP nor a namespace/name pod reference", endpoint)
+	}
+
+	if objs == nil {
+		return "", fmt.Errorf("Cannot resolve pod %s without access to the cluster", endpoint)
+	}
+
+	for _, podObj := range objs.pods {
+		if podObj.ObjectMeta.Namespace == s[0] && podObj.ObjectMeta.Name == s[1] {
+			if podObj.Status.PodIP == "" {
+				return "", fmt.Errorf("Pod %s has no IP", endpoint)
+			}
+
+			return podObj.Status.PodIP, nil
+		}
+	}
+
+	return "", fmt.Errorf("Pod %s not found", endpoint)
+}
+
+// readState returns the content of a file, or the output of a command if no file is given.
+func readState(file string, name string, arg ...string) ([]byte, error) {
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+
+	return exec.Command(name, arg...).Output()
+}
+
+func main() {
+	common.ParseArgs(&args, printVersion)
+
+	src := common.GetArg(optSrc).(string)
+	dst := common.GetArg(optDst).(string)
+	protocol := common.GetArg(optProtocol).(string)
+	port := common.GetArg(optPort).(int)
+	lookup := common.GetArg(optLookup).(string)
+	iptablesSaveFile := common.GetArg(optIptablesSave).(string)
+	ipsetSaveFile := common.GetArg(optIpsetSave).(string)
+	vers := common.GetArg(common.OptVersion).(bool)
+
+	if vers {
+		printVersion()
+		os.Exit(0)
+	}
+
+	log.SetName("azure-npm-debug")
+	log.SetLevel(log.LevelInfo)
+	if err := log.SetTarget(log.TargetLogfile); err != nil {
+		fmt.Printf("Failed to configure logging, err:%v.\n", err)
+	}
+
+	if lookup == "" && (src == "" || dst == "") {
+		fmt.Printf("Either --%s or both --%s and --%s are required.\n", optLookup, optSrc, optDst)
+		os.Exit(1)
+	}
+
+	// Origins of hashed set names and pod IPs come from the cluster, when reachable.
+	objs, err := getClusterObjects()
+	if err != nil {
+		fmt.Printf("Warning: cluster objects are unavailable, set names won't be resolved. err:%v.\n", err)
+		objs = nil
+	}
+
+	var names []string
+	if objs != nil {
+		if names, err = npm.GetIpsetNames(objs.namespaces, objs.pods, objs.policies); err != nil {
+			fmt.Printf("Failed to compute ipset names, err:%v.\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if lookup != "" {
+		state := debug.NewState(nil, nil)
+		state.AddOrigins(names)
+
+		origin, found := state.GetOrigin(lookup)
+		if !found {
+			fmt.Printf("%s doesn't match any label, namespace or named port.\n", lookup)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: %s\n", lookup, origin)
+		return
+	}
+
+	srcIP, err := resolveIP(src, objs)
+	if err != nil {
+		fmt.Printf("%v.\n", err)
+		os.Exit(1)
+	}
+
+	dstIP, err := resolveIP(dst, objs)
+	if err != nil {
+		fmt.Printf("%v.\n", err)
+		os.Exit(1)
+	}
+
+	saveCmd := util.IptablesSave
+	if util.GetIPFamily(dstIP) == util.IPv6Family {
+		saveCmd = util.Ip6tablesSave
+	}
+
+	iptablesSave, err := readState(iptablesSaveFile, saveCmd, util.IptablesTableFlag, util.IptablesFilterTable)
+	if err != nil {
+		fmt.Printf("Failed to read iptables state, err:%v.\n", err)
+		os.Exit(1)
+	}
+
+	ipsetSave, err := readState(ipsetSaveFile, util.Ipset, util.IpsetSaveFlag)
+	if err != nil {
+		fmt.Printf("Failed to read ipset state, err:%v.\n", err)
+		os.Exit(1)
+	}
+
+	state := debug.NewState(iptablesSave, ipsetSave)
+	state.AddOrigins(names)
+
+	packet := &debug.Packet{
+		SrcIP:    srcIP,
+		DstIP:    dstIP,
+		Protocol: protocol,
+		DstPort:  port,
+	}
+	verdict := state.Evaluate(packet)
+
+	fmt.Printf("Traffic from %s to %s on %s port %d:\n", srcIP, dstIP, protocol, port)
+	for _, rule := range verdict.Trace {
+		fmt.Printf("  matched %s\n", state.Describe(rule))
+	}
+
+	action := "DROPPED"
+	if verdict.Allowed {
+		action = "ALLOWED"
+	}
+
+	if verdict.Rule == nil {
+		fmt.Printf("%s: no NPM rule applies.\n", action)
+		return
+	}
+
+	fmt.Printf("%s by %s\n", action, state.Describe(verdict.Rule))
+}
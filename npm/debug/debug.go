@@ -0,0 +1,335 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+
+// Package debug evaluates the iptables chains and ipsets programmed by NPM to troubleshoot network policies.
+package debug
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+const (
+	// maxJumpDepth bounds chain traversal in case of jump loops.
+	maxJumpDepth = 16
+)
+
+// Set is an ipset in ipset save output.
+type Set struct {
+	Name    string
+	Type    string
+	Members []string
+}
+
+// Rule is a rule of an iptables chain in iptables-save output.
+type Rule struct {
+	Chain string
+	Specs []string
+}
+
+// State is a snapshot of the iptables chains and ipsets programmed by NPM.
+type State struct {
+	Chains  map[string][]*Rule
+	Sets    map[string]*Set
+	origins map[string]string
+}
+
+// Packet is the first packet of a connection.
+type Packet struct {
+	SrcIP    string
+	DstIP    string
+	Protocol string
+	DstPort  int
+}
+
+// Verdict is the result of the evaluation of a packet by NPM chains.
+type Verdict struct {
+	Allowed bool
+	// Rule is the rule that accepted or dropped the packet, nil if no NPM rule did.
+	Rule *Rule
+	// Trace lists the matching rules in the order they were evaluated.
+	Trace []*Rule
+}
+
+// NewState creates a State from iptables-save and ipset save output.
+func NewState(iptablesSave []byte, ipsetSave []byte) *State {
+	return &State{
+		Chains:  parseIptablesSave(iptablesSave),
+		Sets:    parseIpsetSave(ipsetSave),
+		origins: make(map[string]string),
+	}
+}
+
+// parseIptablesSave returns the rules of each chain in iptables-save output.
+func parseIptablesSave(out []byte) map[string][]*Rule {
+	chains := make(map[string][]*Rule)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(fields[0], ":"):
+			chain := strings.TrimPrefix(fields[0], ":")
+			if _, exists := chains[chain]; !exists {
+				chains[chain] = nil
+			}
+		case fields[0] == util.IptablesAppendFlag && len(fields) > 1:
+			rule := &Rule{Chain: fields[1]}
+			for _, field := range fields[2:] {
+				rule.Specs = append(rule.Specs, strings.Trim(field, "\""))
+			}
+			chains[rule.Chain] = append(chains[rule.Chain], rule)
+		}
+	}
+
+	return chains
+}
+
+// parseIpsetSave returns the sets in ipset save output.
+func parseIpsetSave(out []byte) map[string]*Set {
+	sets := make(map[string]*Set)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		switch fields[0] {
+		case "create":
+			sets[fields[1]] = &Set{Name: fields[1], Type: fields[2]}
+		case "add":
+			set, exists := sets[fields[1]]
+			if !exists {
+				set = &Set{Name: fields[1]}
+				sets[fields[1]] = set
+			}
+			set.Members = append(set.Members, fields[2])
+		}
+	}
+
+	return sets
+}
+
+// AddOrigins records the names of ipsets so that their hashed names can be mapped back to them.
+func (s *State) AddOrigins(names []string) {
+	for _, name := range names {
+		s.origins[util.GetHashedNameForFamily(name, util.IPv4Family)] = name
+		s.origins[util.GetHashedNameForFamily(name, util.IPv6Family)] = name + " (IPv6)"
+	}
+}
+
+// GetOrigin returns the name of the label, namespace or port that a hashed set name was derived from.
+func (s *State) GetOrigin(hashedName string) (string, bool) {
+	origin, found := s.origins[hashedName]
+	return origin, found
+}
+
+// Describe returns a rule in iptables-save form with hashed set names annotated with their origin.
+func (s *State) Describe(rule *Rule) string {
+	specs := []string{util.IptablesAppendFlag, rule.Chain}
+	for _, spec := range rule.Specs {
+		if origin, found := s.GetOrigin(spec); found {
+			spec = fmt.Sprintf("%s(%s)", spec, origin)
+		}
+		specs = append(specs, spec)
+	}
+
+	return strings.Join(specs, " ")
+}
+
+// Evaluate evaluates a packet against the NPM chains, starting from AZURE-NPM.
+// Packets that no NPM rule accepts or drops are allowed.
+func (s *State) Evaluate(p *Packet) *Verdict {
+	verdict := &Verdict{}
+
+	decided, allowed := s.evaluateChain(util.IptablesAzureChain, p, verdict, 0)
+	verdict.Allowed = !decided || allowed
+
+	return verdict
+}
+
+// evaluateChain evaluates a packet against a chain and the chains it jumps to.
+// It returns whether a rule accepted or dropped the packet, and whether the packet was accepted.
+func (s *State) evaluateChain(chain string, p *Packet, verdict *Verdict, depth int) (bool, bool) {
+	if depth > maxJumpDepth {
+		return false, false
+	}
+
+	for _, rule := range s.Chains[chain] {
+		target, matched := s.match(rule, p)
+		if !matched {
+			continue
+		}
+
+		verdict.Trace = append(verdict.Trace, rule)
+
+		switch target {
+		case util.IptablesAccept:
+			verdict.Rule = rule
+			return true, true
+		case util.IptablesDrop, util.IptablesReject:
+			verdict.Rule = rule
+			return true, false
+		case "RETURN":
+			return false, false
+		case util.IptablesLog, "":
+			continue
+		}
+
+		if _, exists := s.Chains[target]; exists {
+			if decided, allowed := s.evaluateChain(target, p, verdict, depth+1); decided {
+				return decided, allowed
+			}
+		}
+	}
+
+	return false, false
+}
+
+// match checks whether a packet matches a rule and returns the target of the rule.
+// Options that can't be evaluated for the first packet of a connection are considered matching.
+func (s *State) match(rule *Rule, p *Packet) (string, bool) {
+	target := ""
+	negated := false
+	specs := rule.Specs
+
+	for i := 0; i < len(specs); i++ {
+		spec := specs[i]
+		matched := true
+
+		switch {
+		case spec == util.IptablesNotFlag:
+			negated = true
+			continue
+		case spec == util.IptablesMatchFlag && i+1 < len(specs):
+			i++
+			continue
+		case spec == util.IptablesJumpFlag && i+1 < len(specs):
+			target = specs[i+1]
+			i++
+			continue
+		case spec == util.IptablesMatchSetFlag && i+2 < len(specs):
+			matched = s.isInSet(specs[i+1], specs[i+2], p, 0)
+			i += 2
+		case spec == util.IptablesSFlag && i+1 < len(specs):
+			matched = containsIP(specs[i+1], p.SrcIP)
+			i++
+		case spec == util.IptablesDFlag && i+1 < len(specs):
+			matched = containsIP(specs[i+1], p.DstIP)
+			i++
+		case spec == util.IptablesProtFlag && i+1 < len(specs):
+			matched = strings.EqualFold(specs[i+1], p.Protocol)
+			i++
+		case spec == util.IptablesDstPortFlag && i+1 < len(specs):
+			matched = isInPortRange(specs[i+1], p.DstPort)
+			i++
+		case spec == util.IptablesDstPortsFlag && i+1 < len(specs):
+			matched = false
+			for _, portRange := range strings.Split(specs[i+1], ",") {
+				if isInPortRange(portRange, p.DstPort) {
+					matched = true
+					break
+				}
+			}
+			i++
+		case spec == util.IPtablesMatchStateFlag && i+1 < len(specs):
+			// The first packet of a connection is in the NEW state.
+			matched = strings.Contains(strings.ToUpper(specs[i+1]), "NEW")
+			i++
+		case strings.HasPrefix(spec, "--") && i+1 < len(specs) && !strings.HasPrefix(specs[i+1], "-"):
+			// Skip the value of options that don't affect the verdict, such as --log-prefix.
+			i++
+			continue
+		default:
+			continue
+		}
+
+		if matched == negated {
+			return "", false
+		}
+		negated = false
+	}
+
+	return target, true
+}
+
+// isInSet checks whether a packet matches an ipset in the given directions, e.g. "src" or "dst,dst".
+func (s *State) isInSet(name string, directions string, p *Packet, depth int) bool {
+	set, exists := s.Sets[name]
+	if !exists || depth > maxJumpDepth {
+		return false
+	}
+
+	dirs := strings.Split(directions, ",")
+	ip := p.DstIP
+	if dirs[0] == util.IptablesSrcFlag {
+		ip = p.SrcIP
+	}
+
+	for _, member := range set.Members {
+		switch {
+		case strings.HasPrefix(set.Type, "list:"):
+			if s.isInSet(member, directions, p, depth+1) {
+				return true
+			}
+		case strings.Contains(set.Type, ",port"):
+			// Only destination ports of the packet are known.
+			if len(dirs) < 2 || dirs[1] != util.IptablesDstFlag {
+				continue
+			}
+
+			entry := fmt.Sprintf("%s,%s:%d", ip, strings.ToLower(p.Protocol), p.DstPort)
+			if member == entry {
+				return true
+			}
+		default:
+			if containsIP(member, ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// containsIP checks whether an IP is equal to an address or within a CIDR.
+func containsIP(addressOrCIDR string, ip string) bool {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return false
+	}
+
+	if strings.Contains(addressOrCIDR, "/") {
+		_, ipNet, err := net.ParseCIDR(addressOrCIDR)
+		return err == nil && ipNet.Contains(parsedIP)
+	}
+
+	return parsedIP.Equal(net.ParseIP(addressOrCIDR))
+}
+
+// isInPortRange checks whether a port is equal to a port or within a port range such as "8000:8080".
+func isInPortRange(portRange string, port int) bool {
+	bounds := strings.SplitN(portRange, ":", 2)
+
+	low, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return false
+	}
+
+	high := low
+	if len(bounds) == 2 {
+		if high, err = strconv.Atoi(bounds[1]); err != nil {
+			return false
+		}
+	}
+
+	return port >= low && port <= high
+}
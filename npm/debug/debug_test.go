@@ -0,0 +1,87 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package debug
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func getTestState() *State {
+	ns, app := util.GetHashedName("test-ns"), util.GetHashedName("all-namespace-app:web")
+	http := util.GetHashedName("namedport:http")
+
+	iptablesSave := "*filter\n" +
+		":AZURE-NPM - [0:0]\n" +
+		":AZURE-NPM-INGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-TARGET-SETS - [0:0]\n" +
+		"-A AZURE-NPM -m state --state RELATED,ESTABLISHED -j ACCEPT\n" +
+		"-A AZURE-NPM -j AZURE-NPM-INGRESS-PORT\n" +
+		"-A AZURE-NPM -j AZURE-NPM-TARGET-SETS\n" +
+		"-A AZURE-NPM-INGRESS-PORT -p tcp -m set --match-set " + http + " dst,dst -m set --match-set " + app + " dst -j ACCEPT\n" +
+		"-A AZURE-NPM-INGRESS-PORT -s 10.1.0.0/16 -m set --match-set " + app + " dst -j ACCEPT\n" +
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + app + " dst -m limit --limit 10/sec -j LOG --log-prefix \"azure-npm-drop-1:\"\n" +
+		"-A AZURE-NPM-TARGET-SETS -m set --match-set " + app + " dst -j DROP\n" +
+		"COMMIT\n"
+
+	ipsetSave := "create " + ns + " hash:net family inet hashsize 1024 maxelem 65536\n" +
+		"add " + ns + " 10.0.0.5\n" +
+		"create " + app + " hash:net family inet hashsize 1024 maxelem 65536\n" +
+		"add " + app + " 10.0.0.5\n" +
+		"create " + http + " hash:ip,port family inet hashsize 1024 maxelem 65536\n" +
+		"add " + http + " 10.0.0.5,tcp:8080\n"
+
+	state := NewState([]byte(iptablesSave), []byte(ipsetSave))
+	state.AddOrigins([]string{"test-ns", "all-namespace-app:web", "namedport:http"})
+
+	return state
+}
+
+func TestEvaluate(t *testing.T) {
+	state := getTestState()
+
+	// Allowed by the named port rule.
+	verdict := state.Evaluate(&Packet{SrcIP: "10.0.0.9", DstIP: "10.0.0.5", Protocol: "TCP", DstPort: 8080})
+	if !verdict.Allowed || verdict.Rule == nil || verdict.Rule.Chain != util.IptablesAzureIngressPortChain {
+		t.Errorf("TestEvaluate failed @ named port %+v", verdict)
+	}
+
+	// Allowed by the ipBlock rule.
+	verdict = state.Evaluate(&Packet{SrcIP: "10.1.2.3", DstIP: "10.0.0.5", Protocol: "TCP", DstPort: 443})
+	if !verdict.Allowed || verdict.Rule == nil || verdict.Rule.Specs[1] != "10.1.0.0/16" {
+		t.Errorf("TestEvaluate failed @ ipBlock %+v", verdict)
+	}
+
+	// Dropped by the default drop rule after being logged.
+	verdict = state.Evaluate(&Packet{SrcIP: "10.0.0.9", DstIP: "10.0.0.5", Protocol: "TCP", DstPort: 443})
+	if verdict.Allowed || verdict.Rule == nil || verdict.Rule.Chain != util.IptablesAzureTargetSetsChain {
+		t.Errorf("TestEvaluate failed @ default drop %+v", verdict)
+	}
+
+	if len(verdict.Trace) != 4 {
+		t.Errorf("TestEvaluate failed @ trace %+v", verdict.Trace)
+	}
+
+	// Pods that aren't targeted by a policy are allowed.
+	verdict = state.Evaluate(&Packet{SrcIP: "10.0.0.9", DstIP: "10.0.0.6", Protocol: "TCP", DstPort: 443})
+	if !verdict.Allowed || verdict.Rule != nil {
+		t.Errorf("TestEvaluate failed @ untargeted pod %+v", verdict)
+	}
+}
+
+func TestGetOrigin(t *testing.T) {
+	state := getTestState()
+
+	if origin, found := state.GetOrigin(util.GetHashedName("all-namespace-app:web")); !found || origin != "all-namespace-app:web" {
+		t.Errorf("TestGetOrigin failed @ IPv4 set %s", origin)
+	}
+
+	if origin, found := state.GetOrigin(util.GetHashedNameForFamily("test-ns", util.IPv6Family)); !found || origin != "test-ns (IPv6)" {
+		t.Errorf("TestGetOrigin failed @ IPv6 set %s", origin)
+	}
+
+	if _, found := state.GetOrigin("azure-npm-0"); found {
+		t.Errorf("TestGetOrigin failed @ unknown set")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// dropLog is a packet drop logged by the LOG rules of a network policy.
+type dropLog struct {
+	prefix string
+	fields map[string]string
+}
+
+// parseDropLog parses a kernel log record written by the LOG rules of NPM.
+// Records look like "<prefix>IN=eth0 OUT=azure0 SRC=10.0.0.1 DST=10.0.0.2 PROTO=TCP SPT=1234 DPT=80 ...".
+func parseDropLog(record string) (*dropLog, bool) {
+	start := strings.Index(record, util.IptablesDropLogPrefix)
+	if start < 0 {
+		return nil, false
+	}
+
+	record = record[start:]
+	end := strings.Index(record, ":")
+	if end < 0 {
+		return nil, false
+	}
+
+	dl := &dropLog{
+		prefix: record[:end+1],
+		fields: make(map[string]string),
+	}
+
+	for _, field := range strings.Fields(record[end+1:]) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) == 2 {
+			dl.fields[kv[0]] = kv[1]
+		}
+	}
+
+	return dl, true
+}
+
+// getDropLogPolicy returns the namespace and name of the network policy that logged a drop.
+func (npMgr *NetworkPolicyManager) getDropLogPolicy(prefix string) (string, string, bool) {
+	for _, npObj := range npMgr.getNetworkPolicies() {
+		npNs, npName := npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name
+		if getDropLogPrefix(npNs, npName) == prefix {
+			return npNs, npName, true
+		}
+	}
+
+	return "", "", false
+}
+
+// logDrop turns a drop log record into a structured log line.
+func (npMgr *NetworkPolicyManager) logDrop(record string) {
+	dl, ok := parseDropLog(record)
+	if !ok {
+		return
+	}
+
+	npNs, npName, found := npMgr.getDropLogPolicy(dl.prefix)
+	if !found {
+		npNs, npName = "unknown", dl.prefix
+	}
+
+	action := "dropped"
+	if npMgr.isAuditMode {
+		action = "audited"
+	}
+
+	log.Printf(
+		"[Azure-NPM] Packet %s. policy=%s/%s proto=%s src=%s sport=%s dst=%s dport=%s in=%s out=%s",
+		action, npNs, npName,
+		dl.fields["PROTO"], dl.fields["SRC"], dl.fields["SPT"], dl.fields["DST"], dl.fields["DPT"],
+		dl.fields["IN"], dl.fields["OUT"],
+	)
+}
+
+// RunDropLogCollector reads the drops logged by NPM from the kernel log and logs them with their network policy.
+func (npMgr *NetworkPolicyManager) RunDropLogCollector() {
+	if !npMgr.isDropLoggingEnabled && !npMgr.isAuditMode {
+		return
+	}
+
+	f, err := os.Open(util.KernelLogFile)
+	if err != nil {
+		log.Printf("Error opening %s: %v", util.KernelLogFile, err)
+		return
+	}
+	defer f.Close()
+
+	// Only drops logged from now on are collected.
+	if _, err = f.Seek(0, io.SeekEnd); err != nil {
+		log.Printf("Error seeking to the end of %s: %v", util.KernelLogFile, err)
+		return
+	}
+
+	// Each read returns a single kernel log record.
+	buf := make([]byte, 8192)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			// Records overwritten before being read are skipped.
+			if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EPIPE {
+				continue
+			}
+
+			log.Printf("Error reading %s: %v", util.KernelLogFile, err)
+			return
+		}
+
+		npMgr.logDrop(string(buf[:n]))
+	}
+}
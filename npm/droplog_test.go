@@ -0,0 +1,36 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDropLog(t *testing.T) {
+	logPrefix := getDropLogPrefix("test-ns", "test-policy")
+	record := "4,1024,5000000,-;" + logPrefix + "IN=eth0 OUT=azure0 SRC=10.0.0.1 DST=10.0.0.2 LEN=60 PROTO=TCP SPT=4321 DPT=80 SYN\n"
+
+	dl, ok := parseDropLog(record)
+	if !ok || dl.prefix != logPrefix {
+		t.Fatalf("TestParseDropLog failed @ parseDropLog %+v", dl)
+	}
+
+	expectedFields := map[string]string{
+		"IN":    "eth0",
+		"OUT":   "azure0",
+		"SRC":   "10.0.0.1",
+		"DST":   "10.0.0.2",
+		"LEN":   "60",
+		"PROTO": "TCP",
+		"SPT":   "4321",
+		"DPT":   "80",
+	}
+	if !reflect.DeepEqual(dl.fields, expectedFields) {
+		t.Errorf("TestParseDropLog failed @ fields %+v", dl.fields)
+	}
+
+	if _, ok := parseDropLog("4,1025,5000001,-;eth0: link up\n"); ok {
+		t.Errorf("TestParseDropLog failed @ unrelated record")
+	}
+}
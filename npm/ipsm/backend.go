@@ -0,0 +1,141 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package ipsm
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// backend programs the sets and lists kept by an IpsetManager.
+type backend interface {
+	// render returns the input of restore that programs the pending operations of the manager.
+	render(ipsMgr *IpsetManager) []byte
+	// restore programs the output of render in a single transaction.
+	restore(ipsMgr *IpsetManager, input []byte) error
+	// run runs a single operation. It returns 1 with an error if the set is in use or doesn't exist.
+	run(ipsMgr *IpsetManager, entry *ipsEntry) (int, error)
+	// save returns the members of each NPM set in the kernel, keyed by hashed name.
+	save(ipsMgr *IpsetManager) (map[string]map[string]bool, error)
+	// getListMembers returns the members of a list as they are saved.
+	getListMembers(ipsMgr *IpsetManager, listName string) []string
+}
+
+// newBackend returns the backend of the given name, ipset by default.
+func newBackend(name string) backend {
+	switch name {
+	case util.NftablesBackend:
+		return &nftablesBackend{}
+	case util.IptablesBackend, "":
+	default:
+		log.Printf("Unknown NPM backend %s, using %s\n", name, util.IptablesBackend)
+	}
+
+	return &ipsetBackend{}
+}
+
+// ipsetBackend programs sets and lists through ipset.
+type ipsetBackend struct{}
+
+func (b *ipsetBackend) render(ipsMgr *IpsetManager) []byte {
+	var buf bytes.Buffer
+	for _, entry := range ipsMgr.pending {
+		buf.WriteString(strings.Join(entry.getArgs(), " "))
+		buf.WriteString("\n")
+	}
+
+	return buf.Bytes()
+}
+
+// restore runs ipset restore with the input.
+// In dry run mode the input is only logged.
+func (b *ipsetBackend) restore(ipsMgr *IpsetManager, input []byte) error {
+	if ipsMgr.DryRun {
+		log.Printf("Dry run of %s %s:\n%s", util.Ipset, util.IpsetRestoreFlag, input)
+		return nil
+	}
+
+	cmd := exec.Command(util.Ipset, util.IpsetRestoreFlag)
+	cmd.Stdin = bytes.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Error running %s %s: %v %s\nInput:\n%s", util.Ipset, util.IpsetRestoreFlag, err, out, input)
+		return err
+	}
+
+	return nil
+}
+
+func (b *ipsetBackend) run(ipsMgr *IpsetManager, entry *ipsEntry) (int, error) {
+	cmdName := util.Ipset
+	cmdArgs := entry.getArgs()
+
+	cmdOut, err := exec.Command(cmdName, cmdArgs...).Output()
+	log.Printf("%s\n", string(cmdOut))
+
+	if msg, failed := err.(*exec.ExitError); failed {
+		errCode := msg.Sys().(syscall.WaitStatus).ExitStatus()
+		if errCode > 1 {
+			log.Printf("There was an error running command: %s\nArguments:%+v", err, cmdArgs)
+		}
+
+		return errCode, err
+	}
+
+	return 0, nil
+}
+
+func (b *ipsetBackend) save(ipsMgr *IpsetManager) (map[string]map[string]bool, error) {
+	out, err := exec.Command(util.Ipset, util.IpsetSaveFlag).Output()
+	if err != nil {
+		log.Printf("Error running %s %s: %v", util.Ipset, util.IpsetSaveFlag, err)
+		return nil, err
+	}
+
+	return parseIpsetSave(out), nil
+}
+
+// getListMembers returns the hashed names of the sets of a list.
+func (b *ipsetBackend) getListMembers(ipsMgr *IpsetManager, listName string) []string {
+	var members []string
+	for _, setName := range ipsMgr.listMap[listName].elements {
+		members = append(members, ipsMgr.getHashedName(setName))
+	}
+
+	return members
+}
+
+// parseIpsetSave returns the members of each NPM set in ipset save output.
+func parseIpsetSave(out []byte) map[string]map[string]bool {
+	sets := make(map[string]map[string]bool)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], util.AzureNpmPrefix) {
+			continue
+		}
+
+		switch fields[0] {
+		case "create":
+			if _, exists := sets[fields[1]]; !exists {
+				sets[fields[1]] = make(map[string]bool)
+			}
+		case "add":
+			if len(fields) < 3 {
+				continue
+			}
+
+			if _, exists := sets[fields[1]]; !exists {
+				sets[fields[1]] = make(map[string]bool)
+			}
+			member := strings.TrimSuffix(strings.TrimSuffix(fields[2], "/32"), "/128")
+			sets[fields[1]][member] = true
+		}
+	}
+
+	return sets
+}
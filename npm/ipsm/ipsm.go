@@ -5,8 +5,8 @@ package ipsm
 import (
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
-	"syscall"
 
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/npm/util"
@@ -17,12 +17,19 @@ type ipsEntry struct {
 	name          string
 	set           string
 	spec          string
+	family        string
 }
 
 // IpsetManager stores ipset states.
+// Creations, additions and deletions are queued and programmed in batches by Apply.
+// Each manager programs the sets of one IP family, through ipset or nftables.
 type IpsetManager struct {
 	listMap map[string]*Ipset //tracks all set lists.
 	setMap  map[string]*Ipset //label -> []ip
+	pending []*ipsEntry       //operations waiting for Apply.
+	backend backend
+	Family  string
+	DryRun  bool
 }
 
 // Ipset represents one ipset entry.
@@ -41,10 +48,47 @@ func NewIpset(setName string) *Ipset {
 
 // NewIpsetManager creates a new instance for IpsetManager object.
 func NewIpsetManager() *IpsetManager {
+	return NewIpsetManagerForFamily(util.IPv4Family)
+}
+
+// NewIpsetManagerForFamily creates a new instance for IpsetManager object of an IP family.
+func NewIpsetManagerForFamily(family string) *IpsetManager {
+	return NewIpsetManagerWithBackend(family, util.IptablesBackend)
+}
+
+// NewIpsetManagerWithBackend creates a new instance for IpsetManager object of an IP family,
+// programming sets through the given backend.
+func NewIpsetManagerWithBackend(family string, backendName string) *IpsetManager {
 	return &IpsetManager{
 		listMap: make(map[string]*Ipset),
 		setMap:  make(map[string]*Ipset),
+		backend: newBackend(backendName),
+		Family:  family,
+	}
+}
+
+// getBackend returns the backend of the manager, ipset by default.
+func (ipsMgr *IpsetManager) getBackend() backend {
+	if ipsMgr.backend == nil {
+		ipsMgr.backend = &ipsetBackend{}
 	}
+
+	return ipsMgr.backend
+}
+
+// getHashedName returns the hashed name of a set or list of the manager's family.
+func (ipsMgr *IpsetManager) getHashedName(name string) string {
+	return util.GetHashedNameForFamily(name, ipsMgr.Family)
+}
+
+// getSetFamily returns the family flag value of sets created by the manager.
+// Sets default to inet, so the family is only specified for IPv6.
+func (ipsMgr *IpsetManager) getSetFamily() string {
+	if ipsMgr.Family == util.IPv6Family {
+		return util.IPv6Family
+	}
+
+	return ""
 }
 
 // Exists checks if an element exists in setMap/listMap.
@@ -67,10 +111,30 @@ func (ipsMgr *IpsetManager) Exists(key string, val string, kind string) bool {
 	return false
 }
 
+// GetNames returns the names of all sets and lists in setMap/listMap.
+func (ipsMgr *IpsetManager) GetNames() []string {
+	var names []string
+	for setName := range ipsMgr.setMap {
+		names = append(names, setName)
+	}
+
+	for listName := range ipsMgr.listMap {
+		names = append(names, listName)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
 func isNsSet(setName string) bool {
 	return !strings.Contains(setName, "-") && !strings.Contains(setName, ":")
 }
 
+func isNamedPortSet(setName string) bool {
+	return strings.HasPrefix(setName, util.NamedPortIpsetPrefix)
+}
+
 // CreateList creates an ipset list. npm maintains one setlist per namespace label.
 func (ipsMgr *IpsetManager) CreateList(listName string) error {
 	if _, exists := ipsMgr.listMap[listName]; exists {
@@ -80,14 +144,11 @@ func (ipsMgr *IpsetManager) CreateList(listName string) error {
 	entry := &ipsEntry{
 		name:          listName,
 		operationFlag: util.IpsetCreationFlag,
-		set:           util.GetHashedName(listName),
+		set:           ipsMgr.getHashedName(listName),
 		spec:          util.IpsetSetListFlag,
 	}
 	log.Printf("Creating List: %+v\n", entry)
-	if _, err := ipsMgr.Run(entry); err != nil {
-		log.Printf("Error creating ipset list %s.\n", listName)
-		return err
-	}
+	ipsMgr.queue(entry)
 
 	ipsMgr.listMap[listName] = NewIpset(listName)
 
@@ -96,9 +157,14 @@ func (ipsMgr *IpsetManager) CreateList(listName string) error {
 
 // DeleteList removes an ipset list.
 func (ipsMgr *IpsetManager) DeleteList(listName string) error {
+	// Destroying a list fails while it's referenced, so pending operations are applied first.
+	if err := ipsMgr.Apply(); err != nil {
+		return err
+	}
+
 	entry := &ipsEntry{
 		operationFlag: util.IpsetDestroyFlag,
-		set:           util.GetHashedName(listName),
+		set:           ipsMgr.getHashedName(listName),
 	}
 
 	errCode, err := ipsMgr.Run(entry)
@@ -130,14 +196,11 @@ func (ipsMgr *IpsetManager) AddToList(listName string, setName string) error {
 
 	entry := &ipsEntry{
 		operationFlag: util.IpsetAppendFlag,
-		set:           util.GetHashedName(listName),
-		spec:          util.GetHashedName(setName),
+		set:           ipsMgr.getHashedName(listName),
+		spec:          ipsMgr.getHashedName(setName),
 	}
 
-	if _, err := ipsMgr.Run(entry); err != nil {
-		log.Printf("Error creating ipset rules. rule: %+v", entry)
-		return err
-	}
+	ipsMgr.queue(entry)
 
 	ipsMgr.listMap[listName].elements = append(ipsMgr.listMap[listName].elements, setName)
 
@@ -157,18 +220,13 @@ func (ipsMgr *IpsetManager) DeleteFromList(listName string, setName string) erro
 		}
 	}
 
-	hashedListName, hashedSetName := util.GetHashedName(listName), util.GetHashedName(setName)
+	hashedListName, hashedSetName := ipsMgr.getHashedName(listName), ipsMgr.getHashedName(setName)
 	entry := &ipsEntry{
 		operationFlag: util.IpsetDeletionFlag,
 		set:           hashedListName,
 		spec:          hashedSetName,
 	}
-	errCode, err := ipsMgr.Run(entry)
-	if errCode > 1 && err != nil {
-		log.Printf("Error deleting ipset entry.\n")
-		log.Printf("%+v\n", entry)
-		return err
-	}
+	ipsMgr.queue(entry)
 
 	if len(ipsMgr.listMap[listName].elements) == 0 {
 		if err := ipsMgr.DeleteList(listName); err != nil {
@@ -186,18 +244,22 @@ func (ipsMgr *IpsetManager) CreateSet(setName string) error {
 		return nil
 	}
 
+	spec := util.IpsetNetHashFlag
+	if isNamedPortSet(setName) {
+		// Named port sets hold ip,protocol:port pairs of the pods exposing the port.
+		spec = util.IpsetIPPortHashFlag
+	}
+
 	entry := &ipsEntry{
 		name:          setName,
 		operationFlag: util.IpsetCreationFlag,
 		// Use hashed string for set name to avoid string length limit of ipset.
-		set:  util.GetHashedName(setName),
-		spec: util.IpsetNetHashFlag,
+		set:    ipsMgr.getHashedName(setName),
+		spec:   spec,
+		family: ipsMgr.getSetFamily(),
 	}
 	log.Printf("Creating Set: %+v\n", entry)
-	if _, err := ipsMgr.Run(entry); err != nil {
-		log.Printf("Error creating ipset.\n")
-		return err
-	}
+	ipsMgr.queue(entry)
 
 	ipsMgr.setMap[setName] = NewIpset(setName)
 
@@ -215,9 +277,14 @@ func (ipsMgr *IpsetManager) DeleteSet(setName string) error {
 		return nil
 	}
 
+	// Destroying a set fails while it's referenced, so pending operations are applied first.
+	if err := ipsMgr.Apply(); err != nil {
+		return err
+	}
+
 	entry := &ipsEntry{
 		operationFlag: util.IpsetDestroyFlag,
-		set:           util.GetHashedName(setName),
+		set:           ipsMgr.getHashedName(setName),
 	}
 	errCode, err := ipsMgr.Run(entry)
 	if err != nil {
@@ -236,6 +303,7 @@ func (ipsMgr *IpsetManager) DeleteSet(setName string) error {
 }
 
 // AddToSet inserts an ip to an entry in setMap, and creates/updates the corresponding ipset.
+// Entries of named port sets are ip,protocol:port pairs.
 func (ipsMgr *IpsetManager) AddToSet(setName string, ip string) error {
 	if ipsMgr.Exists(setName, ip, util.IpsetNetHashFlag) {
 		return nil
@@ -247,15 +315,11 @@ func (ipsMgr *IpsetManager) AddToSet(setName string, ip string) error {
 
 	entry := &ipsEntry{
 		operationFlag: util.IpsetAppendFlag,
-		set:           util.GetHashedName(setName),
+		set:           ipsMgr.getHashedName(setName),
 		spec:          ip,
 	}
 
-	if _, err := ipsMgr.Run(entry); err != nil {
-		log.Printf("Error creating ipset rules.\n")
-		log.Printf("rule: %+v\n", entry)
-		return err
-	}
+	ipsMgr.queue(entry)
 
 	ipsMgr.setMap[setName].elements = append(ipsMgr.setMap[setName].elements, ip)
 
@@ -277,13 +341,10 @@ func (ipsMgr *IpsetManager) DeleteFromSet(setName string, ip string) error {
 
 	entry := &ipsEntry{
 		operationFlag: util.IpsetDeletionFlag,
-		set:           util.GetHashedName(setName),
+		set:           ipsMgr.getHashedName(setName),
 		spec:          ip,
 	}
-	if _, err := ipsMgr.Run(entry); err != nil {
-		log.Printf("Error deleting ipset entry.\n Entry: %+v", entry)
-		return err
-	}
+	ipsMgr.queue(entry)
 
 	return nil
 }
@@ -317,6 +378,9 @@ func (ipsMgr *IpsetManager) Clean() error {
 
 // Destroy completely cleans ipset.
 func (ipsMgr *IpsetManager) Destroy() error {
+	// Pending operations are moot once everything is destroyed.
+	ipsMgr.pending = nil
+
 	entry := &ipsEntry{
 		operationFlag: util.IpsetFlushFlag,
 	}
@@ -334,30 +398,129 @@ func (ipsMgr *IpsetManager) Destroy() error {
 	return nil
 }
 
-// Run execute an ipset command to update ipset.
-func (ipsMgr *IpsetManager) Run(entry *ipsEntry) (int, error) {
-	cmdName := util.Ipset
-	cmdArgs := []string{entry.operationFlag, util.IpsetExistFlag}
+// getArgs returns the ipset arguments of an entry.
+func (entry *ipsEntry) getArgs() []string {
+	args := []string{entry.operationFlag, util.IpsetExistFlag}
 	if len(entry.set) > 0 {
-		cmdArgs = append(cmdArgs, entry.set)
+		args = append(args, entry.set)
 	}
 	if len(entry.spec) > 0 {
-		cmdArgs = append(cmdArgs, entry.spec)
+		args = append(args, entry.spec)
+	}
+	if len(entry.family) > 0 {
+		args = append(args, util.IpsetFamilyFlag, entry.family)
+	}
+
+	return args
+}
+
+// queue adds an operation to the next batch applied by Apply.
+func (ipsMgr *IpsetManager) queue(entry *ipsEntry) {
+	ipsMgr.pending = append(ipsMgr.pending, entry)
+}
+
+// Render returns the input of the backend that programs all pending operations.
+func (ipsMgr *IpsetManager) Render() []byte {
+	return ipsMgr.getBackend().render(ipsMgr)
+}
+
+// Apply programs all pending operations in a single transaction.
+// Operations stay pending if the transaction fails, so that the next Apply retries them.
+func (ipsMgr *IpsetManager) Apply() error {
+	if len(ipsMgr.pending) == 0 {
+		return nil
+	}
+
+	if err := ipsMgr.getBackend().restore(ipsMgr, ipsMgr.Render()); err != nil {
+		return err
+	}
+
+	ipsMgr.pending = nil
+
+	return nil
+}
+
+// Reconcile compares the desired sets and lists with ipset and repairs missing or unexpected members.
+// It returns the number of repaired sets and members.
+func (ipsMgr *IpsetManager) Reconcile() (int, error) {
+	actual, err := ipsMgr.getBackend().save(ipsMgr)
+	if err != nil {
+		return 0, err
 	}
 
-	cmdOut, err := exec.Command(cmdName, cmdArgs...).Output()
-	log.Printf("%s\n", string(cmdOut))
+	return ipsMgr.reconcile(actual)
+}
+
+func (ipsMgr *IpsetManager) reconcile(actual map[string]map[string]bool) (int, error) {
+	// The desired state is programmed from scratch, so queued operations are dropped.
+	ipsMgr.pending = nil
+
+	var creates, adds, deletes []*ipsEntry
+	diff := func(name string, spec string, family string, members []string) {
+		hashedName := ipsMgr.getHashedName(name)
+		actualMembers, exists := actual[hashedName]
+		if !exists {
+			creates = append(creates, &ipsEntry{
+				name:          name,
+				operationFlag: util.IpsetCreationFlag,
+				set:           hashedName,
+				spec:          spec,
+				family:        family,
+			})
+		}
 
-	if msg, failed := err.(*exec.ExitError); failed {
-		errCode := msg.Sys().(syscall.WaitStatus).ExitStatus()
-		if errCode > 1 {
-			log.Printf("There was an error running command: %s\nArguments:%+v", err, cmdArgs)
+		desiredMembers := make(map[string]bool)
+		for _, member := range members {
+			desiredMembers[member] = true
+			if !actualMembers[member] {
+				adds = append(adds, &ipsEntry{
+					operationFlag: util.IpsetAppendFlag,
+					set:           hashedName,
+					spec:          member,
+				})
+			}
 		}
 
-		return errCode, err
+		for member := range actualMembers {
+			if !desiredMembers[member] {
+				deletes = append(deletes, &ipsEntry{
+					operationFlag: util.IpsetDeletionFlag,
+					set:           hashedName,
+					spec:          member,
+				})
+			}
+		}
 	}
 
-	return 0, nil
+	for setName, set := range ipsMgr.setMap {
+		spec := util.IpsetNetHashFlag
+		if isNamedPortSet(setName) {
+			spec = util.IpsetIPPortHashFlag
+		}
+
+		diff(setName, spec, ipsMgr.getSetFamily(), set.elements)
+	}
+
+	for listName := range ipsMgr.listMap {
+		diff(listName, util.IpsetSetListFlag, "", ipsMgr.getBackend().getListMembers(ipsMgr, listName))
+	}
+
+	// Sets are created before being added to lists.
+	for _, entries := range [][]*ipsEntry{creates, adds, deletes} {
+		for _, entry := range entries {
+			log.Printf("Repairing ipset drift: %+v\n", entry)
+			ipsMgr.queue(entry)
+		}
+	}
+
+	drift := len(ipsMgr.pending)
+
+	return drift, ipsMgr.Apply()
+}
+
+// Run execute an ipset command to update ipset.
+func (ipsMgr *IpsetManager) Run(entry *ipsEntry) (int, error) {
+	return ipsMgr.getBackend().run(ipsMgr, entry)
 }
 
 // Save saves ipset to file.
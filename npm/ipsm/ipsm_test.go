@@ -3,6 +3,7 @@
 package ipsm
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/npm/util"
@@ -242,6 +243,89 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRender(t *testing.T) {
+	ipsMgr := NewIpsetManager()
+	ipsMgr.DryRun = true
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestRender failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.AddToList("test-list", "test-set"); err != nil {
+		t.Errorf("TestRender failed @ ipsMgr.AddToList")
+	}
+
+	set, list := util.GetHashedName("test-set"), util.GetHashedName("test-list")
+	expected := "-N -exist " + set + " nethash\n" +
+		"-A -exist " + set + " 1.2.3.4\n" +
+		"-N -exist " + list + " setlist\n" +
+		"-A -exist " + list + " " + set + "\n"
+	if rendered := string(ipsMgr.Render()); rendered != expected {
+		t.Errorf("TestRender failed @ ipsMgr.Render %s", rendered)
+	}
+
+	if err := ipsMgr.Apply(); err != nil || len(ipsMgr.pending) != 0 {
+		t.Errorf("TestRender failed @ ipsMgr.Apply")
+	}
+}
+
+func TestRenderIPv6(t *testing.T) {
+	ipsMgr := NewIpsetManagerForFamily(util.IPv6Family)
+	ipsMgr.DryRun = true
+
+	if err := ipsMgr.AddToSet("test-set", "fd00::1"); err != nil {
+		t.Errorf("TestRenderIPv6 failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.AddToList("test-list", "test-set"); err != nil {
+		t.Errorf("TestRenderIPv6 failed @ ipsMgr.AddToList")
+	}
+
+	set := util.GetHashedNameForFamily("test-set", util.IPv6Family)
+	list := util.GetHashedNameForFamily("test-list", util.IPv6Family)
+	expected := "-N -exist " + set + " nethash family inet6\n" +
+		"-A -exist " + set + " fd00::1\n" +
+		"-N -exist " + list + " setlist\n" +
+		"-A -exist " + list + " " + set + "\n"
+	if rendered := string(ipsMgr.Render()); rendered != expected {
+		t.Errorf("TestRenderIPv6 failed @ ipsMgr.Render %s", rendered)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ipsMgr := NewIpsetManager()
+	ipsMgr.DryRun = true
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestReconcile failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.AddToList("test-list", "test-set"); err != nil {
+		t.Errorf("TestReconcile failed @ ipsMgr.AddToList")
+	}
+
+	set, list := util.GetHashedName("test-set"), util.GetHashedName("test-list")
+	save := "create " + set + " hash:net family inet hashsize 1024 maxelem 65536\n" +
+		"add " + set + " 1.2.3.4\n" +
+		"add " + set + " 5.6.7.8\n" +
+		"create other-set hash:net family inet hashsize 1024 maxelem 65536\n"
+
+	// The list is missing and the set has an unexpected member.
+	drift, err := ipsMgr.reconcile(parseIpsetSave([]byte(save)))
+	if err != nil || drift != 3 {
+		t.Errorf("TestReconcile failed @ ipsMgr.reconcile of drifted state, drift %d", drift)
+	}
+
+	save += "create " + list + " list:set size 8\n" +
+		"add " + list + " " + set + "\n"
+	save = strings.Replace(save, "add "+set+" 5.6.7.8\n", "", 1)
+
+	drift, err = ipsMgr.reconcile(parseIpsetSave([]byte(save)))
+	if err != nil || drift != 0 {
+		t.Errorf("TestReconcile failed @ ipsMgr.reconcile of desired state, drift %d", drift)
+	}
+}
+
 func TestMain(m *testing.M) {
 	ipsMgr := NewIpsetManager()
 	ipsMgr.Save(util.IpsetConfigFile)
@@ -0,0 +1,268 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package ipsm
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// nftablesBackend programs sets and lists as sets of the nftables table of NPM chains.
+// nftables has no sets of sets, so a list is programmed as a set of the members of its sets.
+// Each Apply programs the pending operations and reprograms the lists they touch in a single transaction.
+type nftablesBackend struct{}
+
+// getNftTable returns the nftables family and table of the sets of the manager's family.
+func getNftTable(ipsMgr *IpsetManager) string {
+	return util.GetNftFamily(ipsMgr.Family) + " " + util.NftTable
+}
+
+// getNftSetType returns the declaration of the type of a set.
+// Named port sets hold address, protocol and port concatenations, other sets and lists hold addresses.
+func getNftSetType(ipsMgr *IpsetManager, setName string) string {
+	addrType := "ipv4_addr"
+	if ipsMgr.Family == util.IPv6Family {
+		addrType = "ipv6_addr"
+	}
+
+	if isNamedPortSet(setName) {
+		return fmt.Sprintf("{ type %s . inet_proto . inet_service ; }", addrType)
+	}
+
+	return fmt.Sprintf("{ type %s ; flags interval ; }", addrType)
+}
+
+// toNftElement converts an ip,protocol:port member of a named port set to an nftables concatenation.
+func toNftElement(member string) string {
+	s := strings.SplitN(member, ",", 2)
+	if len(s) != 2 {
+		return member
+	}
+
+	protocolPort := strings.SplitN(s[1], ":", 2)
+	if len(protocolPort) != 2 {
+		return member
+	}
+
+	return s[0] + " . " + protocolPort[0] + " . " + protocolPort[1]
+}
+
+// fromNftElement converts an nftables concatenation back to an ip,protocol:port member.
+func fromNftElement(element string) string {
+	fields := strings.Split(element, " . ")
+	if len(fields) != 3 {
+		return element
+	}
+
+	return fields[0] + "," + fields[1] + ":" + fields[2]
+}
+
+// getSortedNames returns the names of sets or lists in a stable order.
+func getSortedNames(m map[string]*Ipset) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// writeNftSet writes the declaration of a set and replaces its elements with members.
+func writeNftSet(buf *bytes.Buffer, table string, hashedName string, setType string, members []string) {
+	fmt.Fprintf(buf, "add set %s %s %s\n", table, hashedName, setType)
+	fmt.Fprintf(buf, "flush set %s %s\n", table, hashedName)
+
+	if len(members) == 0 {
+		return
+	}
+
+	elements := make([]string, len(members))
+	for i, member := range members {
+		elements[i] = toNftElement(member)
+	}
+	fmt.Fprintf(buf, "add element %s %s { %s }\n", table, hashedName, strings.Join(elements, ", "))
+}
+
+// writeNftOperation writes the nft input of a pending operation on a set.
+// Deleted elements are added first, as nft fails to delete missing elements.
+func writeNftOperation(buf *bytes.Buffer, ipsMgr *IpsetManager, table string, entry *ipsEntry) {
+	switch entry.operationFlag {
+	case util.IpsetCreationFlag:
+		fmt.Fprintf(buf, "add set %s %s %s\n", table, entry.set, getNftSetType(ipsMgr, entry.name))
+	case util.IpsetAppendFlag:
+		fmt.Fprintf(buf, "add element %s %s { %s }\n", table, entry.set, toNftElement(entry.spec))
+	case util.IpsetDeletionFlag:
+		fmt.Fprintf(buf, "add element %s %s { %s }\n", table, entry.set, toNftElement(entry.spec))
+		fmt.Fprintf(buf, "delete element %s %s { %s }\n", table, entry.set, toNftElement(entry.spec))
+	}
+}
+
+// render returns the nft input that programs the pending operations on sets.
+// Operations can't be replayed on lists, so the lists they touch and the lists
+// containing the sets they touch are reprogrammed with all their members.
+func (b *nftablesBackend) render(ipsMgr *IpsetManager) []byte {
+	var buf bytes.Buffer
+
+	table := getNftTable(ipsMgr)
+	fmt.Fprintf(&buf, "add table %s\n", table)
+
+	isList := make(map[string]bool)
+	for listName := range ipsMgr.listMap {
+		isList[ipsMgr.getHashedName(listName)] = true
+	}
+
+	isTouched := make(map[string]bool)
+	for _, entry := range ipsMgr.pending {
+		isTouched[entry.set] = true
+		if !isList[entry.set] {
+			writeNftOperation(&buf, ipsMgr, table, entry)
+		}
+	}
+
+	for _, listName := range getSortedNames(ipsMgr.listMap) {
+		hashedName := ipsMgr.getHashedName(listName)
+
+		isListTouched := isTouched[hashedName]
+		for _, setName := range ipsMgr.listMap[listName].elements {
+			isListTouched = isListTouched || isTouched[ipsMgr.getHashedName(setName)]
+		}
+
+		if isListTouched {
+			writeNftSet(&buf, table, hashedName, getNftSetType(ipsMgr, listName), b.getListMembers(ipsMgr, listName))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// restore runs nft with the input as a single transaction.
+// In dry run mode the input is only logged.
+func (b *nftablesBackend) restore(ipsMgr *IpsetManager, input []byte) error {
+	if ipsMgr.DryRun {
+		log.Printf("Dry run of %s:\n%s", util.Nft, input)
+		return nil
+	}
+
+	cmd := exec.Command(util.Nft, util.NftFileFlag, util.NftStdin)
+	cmd.Stdin = bytes.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Error running %s: %v %s\nInput:\n%s", util.Nft, err, out, input)
+		return err
+	}
+
+	return nil
+}
+
+// run runs flush and destroy operations, on a set or on all sets when the entry has no set.
+// nft fails with 1 on any error, so failures are reported as the set being in use.
+func (b *nftablesBackend) run(ipsMgr *IpsetManager, entry *ipsEntry) (int, error) {
+	table := getNftTable(ipsMgr)
+
+	var input string
+	switch {
+	case entry.operationFlag == util.IpsetFlushFlag && len(entry.set) > 0:
+		input = fmt.Sprintf("flush set %s %s\n", table, entry.set)
+	case entry.operationFlag == util.IpsetDestroyFlag && len(entry.set) > 0:
+		input = fmt.Sprintf("delete set %s %s\n", table, entry.set)
+	case entry.operationFlag == util.IpsetFlushFlag:
+		input = fmt.Sprintf("add table %s\nflush table %s\n", table, table)
+	case entry.operationFlag == util.IpsetDestroyFlag:
+		input = fmt.Sprintf("add table %s\ndelete table %s\n", table, table)
+	default:
+		return 2, fmt.Errorf("Unsupported nftables set operation %s", entry.operationFlag)
+	}
+
+	cmd := exec.Command(util.Nft, util.NftFileFlag, util.NftStdin)
+	cmd.Stdin = strings.NewReader(input)
+	cmdOut, err := cmd.CombinedOutput()
+	if msg, failed := err.(*exec.ExitError); failed {
+		log.Printf("Error running %s: %v %s\nInput:\n%s", util.Nft, err, cmdOut, input)
+		return msg.Sys().(syscall.WaitStatus).ExitStatus(), err
+	}
+
+	return 0, err
+}
+
+func (b *nftablesBackend) save(ipsMgr *IpsetManager) (map[string]map[string]bool, error) {
+	family := util.GetNftFamily(ipsMgr.Family)
+	out, err := exec.Command(util.Nft, util.NftList, util.NftTableFlag, family, util.NftTable).Output()
+	if err != nil {
+		// The table may have been deleted, in which case all sets are missing.
+		log.Printf("Error running %s %s %s %s %s: %v", util.Nft, util.NftList, util.NftTableFlag, family, util.NftTable, err)
+		out = nil
+	}
+
+	return parseNftSets(out), nil
+}
+
+// getListMembers returns the members of the sets of a list, without duplicates.
+func (b *nftablesBackend) getListMembers(ipsMgr *IpsetManager, listName string) []string {
+	isMember := make(map[string]bool)
+	for _, setName := range ipsMgr.listMap[listName].elements {
+		if set, exists := ipsMgr.setMap[setName]; exists {
+			for _, member := range set.elements {
+				isMember[member] = true
+			}
+		}
+	}
+
+	var members []string
+	for member := range isMember {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	return members
+}
+
+// parseNftSets returns the members of each set in nft list table output.
+func parseNftSets(out []byte) map[string]map[string]bool {
+	sets := make(map[string]map[string]bool)
+
+	set := ""
+	inElements := false
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(line, "set ") && strings.HasSuffix(line, "{"):
+			set = strings.Fields(line)[1]
+			sets[set] = make(map[string]bool)
+			continue
+		case set == "":
+			continue
+		case line == "}" && !inElements:
+			set = ""
+			continue
+		case strings.HasPrefix(line, "elements = {"):
+			inElements = true
+			line = strings.TrimPrefix(line, "elements = {")
+		}
+
+		if !inElements {
+			continue
+		}
+
+		// Elements are wrapped over several lines.
+		if strings.HasSuffix(line, "}") {
+			inElements = false
+			line = strings.TrimSuffix(line, "}")
+		}
+
+		for _, element := range strings.Split(line, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				sets[set][fromNftElement(element)] = true
+			}
+		}
+	}
+
+	return sets
+}
@@ -0,0 +1,125 @@
+package ipsm
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestRenderNftables(t *testing.T) {
+	ipsMgr := NewIpsetManagerWithBackend(util.IPv4Family, util.NftablesBackend)
+	ipsMgr.DryRun = true
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.AddToSet("namedport:http", "1.2.3.4,tcp:80"); err != nil {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.AddToSet of named port")
+	}
+
+	if err := ipsMgr.AddToList("test-list", "test-set"); err != nil {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.AddToList")
+	}
+
+	set, namedPortSet, list := util.GetHashedName("test-set"), util.GetHashedName("namedport:http"), util.GetHashedName("test-list")
+	expected := "add table ip azure-npm\n" +
+		"add set ip azure-npm " + set + " { type ipv4_addr ; flags interval ; }\n" +
+		"add element ip azure-npm " + set + " { 1.2.3.4 }\n" +
+		"add set ip azure-npm " + namedPortSet + " { type ipv4_addr . inet_proto . inet_service ; }\n" +
+		"add element ip azure-npm " + namedPortSet + " { 1.2.3.4 . tcp . 80 }\n" +
+		"add set ip azure-npm " + list + " { type ipv4_addr ; flags interval ; }\n" +
+		"flush set ip azure-npm " + list + "\n" +
+		"add element ip azure-npm " + list + " { 1.2.3.4 }\n"
+	if rendered := string(ipsMgr.Render()); rendered != expected {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.Render %s", rendered)
+	}
+
+	if err := ipsMgr.Apply(); err != nil || len(ipsMgr.pending) != 0 {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.Apply")
+	}
+
+	// Only the operations of the batch are rendered, lists without touched sets are left as they are.
+	if err := ipsMgr.AddToSet("other-set", "5.6.7.8"); err != nil {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.AddToSet of other set")
+	}
+
+	otherSet := util.GetHashedName("other-set")
+	expected = "add table ip azure-npm\n" +
+		"add set ip azure-npm " + otherSet + " { type ipv4_addr ; flags interval ; }\n" +
+		"add element ip azure-npm " + otherSet + " { 5.6.7.8 }\n"
+	if rendered := string(ipsMgr.Render()); rendered != expected {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.Render of other set %s", rendered)
+	}
+
+	if err := ipsMgr.Apply(); err != nil {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.Apply of other set")
+	}
+
+	// Lists containing a touched set are reprogrammed.
+	if err := ipsMgr.DeleteFromSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.DeleteFromSet")
+	}
+
+	expected = "add table ip azure-npm\n" +
+		"add element ip azure-npm " + set + " { 1.2.3.4 }\n" +
+		"delete element ip azure-npm " + set + " { 1.2.3.4 }\n" +
+		"add set ip azure-npm " + list + " { type ipv4_addr ; flags interval ; }\n" +
+		"flush set ip azure-npm " + list + "\n"
+	if rendered := string(ipsMgr.Render()); rendered != expected {
+		t.Errorf("TestRenderNftables failed @ ipsMgr.Render of deletion %s", rendered)
+	}
+}
+
+func TestParseNftSets(t *testing.T) {
+	ipsMgr := NewIpsetManagerWithBackend(util.IPv4Family, util.NftablesBackend)
+	ipsMgr.DryRun = true
+
+	if err := ipsMgr.AddToSet("namedport:http", "1.2.3.4,tcp:80"); err != nil {
+		t.Errorf("TestParseNftSets failed @ ipsMgr.AddToSet of named port")
+	}
+
+	if err := ipsMgr.AddToSet("test-set", "1.2.3.4"); err != nil {
+		t.Errorf("TestParseNftSets failed @ ipsMgr.AddToSet")
+	}
+
+	if err := ipsMgr.AddToList("test-list", "test-set"); err != nil {
+		t.Errorf("TestParseNftSets failed @ ipsMgr.AddToList")
+	}
+
+	set, namedPortSet, list := util.GetHashedName("test-set"), util.GetHashedName("namedport:http"), util.GetHashedName("test-list")
+	out := "table ip azure-npm {\n" +
+		"\tset " + namedPortSet + " {\n" +
+		"\t\ttype ipv4_addr . inet_proto . inet_service\n" +
+		"\t\telements = { 1.2.3.4 . tcp . 80 }\n" +
+		"\t}\n" +
+		"\n" +
+		"\tset " + set + " {\n" +
+		"\t\ttype ipv4_addr\n" +
+		"\t\tflags interval\n" +
+		"\t\telements = { 1.2.3.4, 5.6.7.8,\n" +
+		"\t\t\t     9.10.11.12 }\n" +
+		"\t}\n" +
+		"\n" +
+		"\tchain AZURE-NPM {\n" +
+		"\t\tip daddr @" + set + " accept\n" +
+		"\t}\n" +
+		"}\n"
+
+	sets := parseNftSets([]byte(out))
+	if len(sets) != 2 || !sets[namedPortSet]["1.2.3.4,tcp:80"] || len(sets[set]) != 3 || !sets[set]["9.10.11.12"] {
+		t.Errorf("TestParseNftSets failed @ parseNftSets %+v", sets)
+	}
+
+	// The list is missing and the set has two unexpected members.
+	drift, err := ipsMgr.reconcile(sets)
+	if err != nil || drift != 4 {
+		t.Errorf("TestParseNftSets failed @ ipsMgr.reconcile, drift %d", drift)
+	}
+
+	sets[set] = map[string]bool{"1.2.3.4": true}
+	sets[list] = map[string]bool{"1.2.3.4": true}
+	if drift, err = ipsMgr.reconcile(sets); err != nil || drift != 0 {
+		t.Errorf("TestParseNftSets failed @ ipsMgr.reconcile of desired state, drift %d", drift)
+	}
+}
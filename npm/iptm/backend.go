@@ -0,0 +1,289 @@
+package iptm
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// backend programs the desired state of NPM chains kept by an IptablesManager.
+type backend interface {
+	// render returns the input of restore that replaces the rules of all NPM chains.
+	// It fails if a rule can't be programmed through the backend.
+	render(iptMgr *IptablesManager) ([]byte, error)
+	// renderUninit returns the input of restore that deletes all NPM chains.
+	renderUninit(iptMgr *IptablesManager) []byte
+	// restore programs the output of render or renderUninit in a single transaction.
+	restore(iptMgr *IptablesManager, input []byte) error
+	// ensureHook makes forwarded traffic traverse AZURE-NPM chain.
+	ensureHook(iptMgr *IptablesManager) error
+	// removeHook stops forwarded traffic from traversing AZURE-NPM chain.
+	removeHook(iptMgr *IptablesManager) error
+	// drift returns the number of missing or unexpected rules in NPM chains and hooks.
+	drift(iptMgr *IptablesManager) (int, error)
+}
+
+// newBackend returns the backend of the given name, iptables by default.
+func newBackend(name string) backend {
+	switch name {
+	case util.NftablesBackend:
+		return &nftablesBackend{}
+	case util.IptablesBackend, "":
+	default:
+		log.Printf("Unknown NPM backend %s, using %s\n", name, util.IptablesBackend)
+	}
+
+	return &iptablesBackend{}
+}
+
+// iptablesBackend programs NPM chains through iptables, or ip6tables for IPv6.
+type iptablesBackend struct{}
+
+func (b *iptablesBackend) render(iptMgr *IptablesManager) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString("*filter\n")
+
+	// Declaring a chain flushes it, so the rules below replace the current contents of NPM chains.
+	for _, chain := range npmChains {
+		fmt.Fprintf(&buf, ":%s - [0:0]\n", chain)
+	}
+
+	for _, chain := range npmChains {
+		for _, rule := range iptMgr.chainRules[chain] {
+			fmt.Fprintf(&buf, "%s %s %s\n", util.IptablesAppendFlag, chain, strings.Join(iptMgr.getSpecs(rule.entry), " "))
+		}
+	}
+
+	buf.WriteString("COMMIT\n")
+
+	return buf.Bytes(), nil
+}
+
+func (b *iptablesBackend) renderUninit(iptMgr *IptablesManager) []byte {
+	var buf bytes.Buffer
+
+	// Flush and delete all NPM chains in a single transaction.
+	buf.WriteString("*filter\n")
+	for _, chain := range npmChains {
+		fmt.Fprintf(&buf, ":%s - [0:0]\n", chain)
+	}
+	for _, chain := range npmChains {
+		fmt.Fprintf(&buf, "%s %s\n", util.IptablesDestroyFlag, chain)
+	}
+	buf.WriteString("COMMIT\n")
+
+	return buf.Bytes()
+}
+
+// restore runs iptables-restore without flushing chains that are not declared in the input.
+// In dry run mode the input is only logged.
+func (b *iptablesBackend) restore(iptMgr *IptablesManager, input []byte) error {
+	_, _, restoreCmd := iptMgr.getCommands()
+	if iptMgr.DryRun {
+		log.Printf("Dry run of %s:\n%s", restoreCmd, input)
+		return nil
+	}
+
+	cmd := exec.Command(restoreCmd, util.IptablesNoFlushFlag)
+	cmd.Stdin = bytes.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Error running %s: %v %s\nInput:\n%s", restoreCmd, err, out, input)
+		return err
+	}
+
+	return nil
+}
+
+// ensureHook inserts AZURE-NPM chain to FORWARD chain.
+// The FORWARD chain is not owned by NPM, so the rule is inserted directly instead of through restore.
+func (b *iptablesBackend) ensureHook(iptMgr *IptablesManager) error {
+	if err := iptMgr.AddChain(util.IptablesAzureChain); err != nil {
+		return err
+	}
+
+	entry := getForwardJump()
+	exists, err := iptMgr.Exists(entry)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		iptMgr.OperationFlag = util.IptablesInsertionFlag
+		if _, err = iptMgr.Run(entry); err != nil {
+			log.Printf("Error adding AZURE-NPM chain to FORWARD chain\n")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeHook removes AZURE-NPM chain from FORWARD chain.
+func (b *iptablesBackend) removeHook(iptMgr *IptablesManager) error {
+	iptMgr.OperationFlag = util.IptablesDeletionFlag
+	errCode, err := iptMgr.Run(getForwardJump())
+	if errCode != 1 && err != nil {
+		log.Printf("Error removing default rule from FORWARD chain\n")
+		return err
+	}
+
+	return nil
+}
+
+// getForwardJump returns the rule of FORWARD chain that jumps to AZURE-NPM chain.
+func getForwardJump() *IptEntry {
+	return &IptEntry{
+		Chain: util.IptablesForwardChain,
+		Specs: []string{
+			util.IptablesJumpFlag,
+			util.IptablesAzureChain,
+		},
+	}
+}
+
+func (b *iptablesBackend) drift(iptMgr *IptablesManager) (int, error) {
+	_, saveCmd, _ := iptMgr.getCommands()
+	out, err := exec.Command(saveCmd, util.IptablesTableFlag, util.IptablesFilterTable).Output()
+	if err != nil {
+		log.Printf("Error running %s: %v", saveCmd, err)
+		return 0, err
+	}
+
+	return diffChains(iptMgr, parseIptablesSave(out)), nil
+}
+
+// normalizeRule converts the specs of a rule to the form printed by iptables-save,
+// which lists addresses and protocol before matches and loads protocol matches explicitly.
+func normalizeRule(specs []string) string {
+	var addresses, protocol, matches []string
+	protocolName := ""
+
+	for i := 0; i < len(specs); i++ {
+		// iptables-save quotes strings such as log prefixes.
+		spec := strings.Trim(strings.ToLower(specs[i]), "\"")
+
+		switch {
+		case (spec == util.IptablesSFlag || spec == util.IptablesDFlag) && i+1 < len(specs):
+			address := strings.ToLower(specs[i+1])
+			if !strings.Contains(address, "/") {
+				if util.GetIPFamily(address) == util.IPv6Family {
+					address += "/128"
+				} else {
+					address += "/32"
+				}
+			}
+			addresses = append(addresses, spec, address)
+			i++
+		case spec == util.IptablesProtFlag && i+1 < len(specs):
+			protocolName = strings.ToLower(specs[i+1])
+			protocol = []string{spec, protocolName}
+			i++
+		case spec == util.IptablesDstPortFlag || spec == util.IptablesSrcPortFlag:
+			n := len(matches)
+			if n < 2 || matches[n-2] != util.IptablesMatchFlag || matches[n-1] != protocolName {
+				matches = append(matches, util.IptablesMatchFlag, protocolName)
+			}
+			matches = append(matches, spec)
+		default:
+			matches = append(matches, spec)
+		}
+	}
+
+	normalized := append(append(addresses, protocol...), matches...)
+
+	return strings.Join(normalized, " ")
+}
+
+// parseIptablesSave returns the normalized rules of each chain in iptables-save output.
+func parseIptablesSave(out []byte) map[string][]string {
+	chains := make(map[string][]string)
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(fields[0], ":"):
+			chain := strings.TrimPrefix(fields[0], ":")
+			if _, exists := chains[chain]; !exists {
+				chains[chain] = []string{}
+			}
+		case fields[0] == util.IptablesAppendFlag && len(fields) > 1:
+			chains[fields[1]] = append(chains[fields[1]], normalizeRule(fields[2:]))
+		}
+	}
+
+	return chains
+}
+
+// diffRules returns the number of rules that are missing or unexpected in a chain.
+// A chain with the right rules in the wrong order counts as one drifted rule.
+func diffRules(desired []string, actual []string) int {
+	counts := make(map[string]int)
+	for _, rule := range desired {
+		counts[rule]++
+	}
+	for _, rule := range actual {
+		counts[rule]--
+	}
+
+	drift := 0
+	for _, count := range counts {
+		if count < 0 {
+			count = -count
+		}
+		drift += count
+	}
+
+	if drift == 0 && strings.Join(desired, "\n") != strings.Join(actual, "\n") {
+		drift = 1
+	}
+
+	return drift
+}
+
+// diffChains returns the number of missing or unexpected rules of NPM chains and FORWARD jump in saved chains.
+func diffChains(iptMgr *IptablesManager, chains map[string][]string) int {
+	drift := 0
+
+	forwardJump := normalizeRule(getForwardJump().Specs)
+	hasForwardJump := false
+	for _, rule := range chains[util.IptablesForwardChain] {
+		if rule == forwardJump {
+			hasForwardJump = true
+			break
+		}
+	}
+
+	if !hasForwardJump {
+		log.Printf("AZURE-NPM chain is missing from FORWARD chain")
+		drift++
+	}
+
+	for _, chain := range npmChains {
+		var desired []string
+		for _, rule := range iptMgr.chainRules[chain] {
+			desired = append(desired, normalizeRule(iptMgr.getSpecs(rule.entry)))
+		}
+
+		actual, exists := chains[chain]
+		if !exists {
+			log.Printf("Chain %s is missing", chain)
+			drift++
+		}
+
+		if chainDrift := diffRules(desired, actual); chainDrift > 0 {
+			log.Printf("Chain %s drifted by %d rules", chain, chainDrift)
+			drift += chainDrift
+		}
+	}
+
+	return drift
+}
@@ -1,8 +1,10 @@
 package iptm
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"syscall"
 
 	"github.com/Azure/azure-container-networking/log"
@@ -18,185 +20,180 @@ type IptEntry struct {
 	Specs      []string
 }
 
+// iptRule is a rule in the desired state of an NPM chain.
+type iptRule struct {
+	entry      *IptEntry
+	referCount int
+}
+
 // IptablesManager stores iptables entries.
+// Rules of NPM chains are kept as desired state and programmed in batches by Apply.
+// Each manager programs one IP family, through iptables or ip6tables, or through nftables.
 type IptablesManager struct {
 	OperationFlag string
+	Family        string
+	DryRun        bool
+
+	backend    backend
+	chainRules map[string][]*iptRule
+	isDirty    bool
 }
 
 // NewIptablesManager creates a new instance for IptablesManager object.
 func NewIptablesManager() *IptablesManager {
+	return NewIptablesManagerForFamily(util.IPv4Family)
+}
+
+// NewIptablesManagerForFamily creates a new instance for IptablesManager object of an IP family.
+func NewIptablesManagerForFamily(family string) *IptablesManager {
+	return NewIptablesManagerWithBackend(family, util.IptablesBackend)
+}
+
+// NewIptablesManagerWithBackend creates a new instance for IptablesManager object of an IP family,
+// programming NPM chains through the given backend.
+func NewIptablesManagerWithBackend(family string, backendName string) *IptablesManager {
 	iptMgr := &IptablesManager{
 		OperationFlag: "",
+		Family:        family,
+		backend:       newBackend(backendName),
 	}
 
 	return iptMgr
 }
 
-// InitNpmChains initializes Azure NPM chains in iptables.
-func (iptMgr *IptablesManager) InitNpmChains() error {
-	log.Printf("Initializing AZURE-NPM chains")
-
-	if err := iptMgr.AddChain(util.IptablesAzureChain); err != nil {
-		return err
+// getBackend returns the backend of the manager, iptables by default.
+func (iptMgr *IptablesManager) getBackend() backend {
+	if iptMgr.backend == nil {
+		iptMgr.backend = &iptablesBackend{}
 	}
 
-	// Insert AZURE-NPM chain to FORWARD chain.
-	entry := &IptEntry{
-		Chain: util.IptablesForwardChain,
-		Specs: []string{
-			util.IptablesJumpFlag,
-			util.IptablesAzureChain,
-		},
-	}
-	exists, err := iptMgr.Exists(entry)
-	if err != nil {
-		return err
-	}
+	return iptMgr.backend
+}
 
-	if !exists {
-		iptMgr.OperationFlag = util.IptablesInsertionFlag
-		if _, err = iptMgr.Run(entry); err != nil {
-			log.Printf("Error adding AZURE-NPM chain to FORWARD chain\n")
-			return err
-		}
+// getCommands returns the iptables, iptables-save and iptables-restore binaries of the manager's family.
+func (iptMgr *IptablesManager) getCommands() (string, string, string) {
+	if iptMgr.Family == util.IPv6Family {
+		return util.Ip6tables, util.Ip6tablesSave, util.Ip6tablesRestore
 	}
 
-	// Add default allow CONNECTED/RELATED rule to AZURE-NPM chain.
-	entry.Chain = util.IptablesAzureChain
-	entry.Specs = []string{
-		util.IptablesMatchFlag,
-		util.IptablesStateFlag,
-		util.IPtablesMatchStateFlag,
-		util.IptablesRelatedState + "," + util.IptablesEstablishedState,
-		util.IptablesJumpFlag,
-		util.IptablesAccept,
-	}
-	exists, err = iptMgr.Exists(entry)
-	if err != nil {
-		return err
+	return util.Iptables, util.IptablesSave, util.IptablesRestore
+}
+
+// getSpecs returns the specs of an entry with ipsets renamed to those of the manager's family.
+func (iptMgr *IptablesManager) getSpecs(entry *IptEntry) []string {
+	if iptMgr.Family != util.IPv6Family {
+		return entry.Specs
 	}
 
-	if !exists {
-		iptMgr.OperationFlag = util.IptablesInsertionFlag
-		if _, err = iptMgr.Run(entry); err != nil {
-			log.Printf("Error adding default allow CONNECTED/RELATED rule to AZURE-NPM chain\n")
-			return err
+	specs := make([]string, len(entry.Specs))
+	copy(specs, entry.Specs)
+	for i := 1; i < len(specs); i++ {
+		if specs[i-1] == util.IptablesMatchSetFlag {
+			specs[i] += util.IPv6IpsetSuffix
 		}
 	}
 
-	// Add default allow kube-system rules to AZURE-NPM chain.
-	entry.Specs = []string{
-		util.IptablesMatchFlag,
-		util.IptablesSetFlag,
-		util.IptablesMatchSetFlag,
-		util.GetHashedName(util.KubeSystemFlag),
-		util.IptablesDstFlag,
-		util.IptablesJumpFlag,
-		util.IptablesAccept,
-	}
-	exists, err = iptMgr.Exists(entry)
-	if err != nil {
-		return err
-	}
+	return specs
+}
 
-	if !exists {
-		iptMgr.OperationFlag = util.IptablesAppendFlag
-		if _, err := iptMgr.Run(entry); err != nil {
-			log.Printf("Error adding default allow kube-system rule to AZURE-NPM chain\n")
-			return err
-		}
+// isOfFamily checks whether the addresses of an entry belong to the manager's family.
+// Entries without addresses belong to both families.
+func (iptMgr *IptablesManager) isOfFamily(entry *IptEntry) bool {
+	family := iptMgr.Family
+	if family == "" {
+		family = util.IPv4Family
 	}
 
-	entry.Specs = []string{
-		util.IptablesMatchFlag,
-		util.IptablesSetFlag,
-		util.IptablesMatchSetFlag,
-		util.GetHashedName(util.KubeSystemFlag),
-		util.IptablesSrcFlag,
-		util.IptablesJumpFlag,
-		util.IptablesAccept,
-	}
-	exists, err = iptMgr.Exists(entry)
-	if err != nil {
-		return err
-	}
+	for i := 1; i < len(entry.Specs); i++ {
+		if entry.Specs[i-1] != util.IptablesSFlag && entry.Specs[i-1] != util.IptablesDFlag {
+			continue
+		}
 
-	if !exists {
-		iptMgr.OperationFlag = util.IptablesAppendFlag
-		if _, err := iptMgr.Run(entry); err != nil {
-			log.Printf("Error adding default allow kube-system rule to AZURE-NPM chain\n")
-			return err
+		if util.GetIPFamily(entry.Specs[i]) != family {
+			return false
 		}
 	}
-	// Create AZURE-NPM-INGRESS-PORT chain.
-	if err := iptMgr.AddChain(util.IptablesAzureIngressPortChain); err != nil {
-		return err
-	}
 
-	// Insert AZURE-NPM-INGRESS-PORT chain to AZURE-NPM chain.
-	entry.Chain = util.IptablesAzureChain
-	entry.Specs = []string{util.IptablesJumpFlag, util.IptablesAzureIngressPortChain}
-	exists, err = iptMgr.Exists(entry)
-	if err != nil {
-		return err
-	}
+	return true
+}
 
-	if !exists {
-		iptMgr.OperationFlag = util.IptablesAppendFlag
-		if _, err := iptMgr.Run(entry); err != nil {
-			log.Printf("Error adding AZURE-NPM-INGRESS-PORT chain to AZURE-NPM chain\n")
-			return err
+// npmChains are the chains owned by NPM, whose contents are rewritten on every Apply.
+var npmChains = []string{
+	util.IptablesAzureChain,
+	util.IptablesAzureIngressPortChain,
+	util.IptablesAzureIngressFromChain,
+	util.IptablesAzureEgressPortChain,
+	util.IptablesAzureEgressToChain,
+	util.IptablesAzureTargetSetsChain,
+}
+
+func isNpmChain(chain string) bool {
+	for _, npmChain := range npmChains {
+		if chain == npmChain {
+			return true
 		}
 	}
 
-	// Create AZURE-NPM-INGRESS-FROM chain.
-	if err := iptMgr.AddChain(util.IptablesAzureIngressFromChain); err != nil {
-		return err
-	}
+	return false
+}
 
-	// Create AZURE-NPM-EGRESS-PORT chain.
-	if err := iptMgr.AddChain(util.IptablesAzureEgressPortChain); err != nil {
-		return err
-	}
+// InitNpmChains initializes Azure NPM chains in iptables.
+func (iptMgr *IptablesManager) InitNpmChains() error {
+	log.Printf("Initializing AZURE-NPM chains")
 
-	// Insert AZURE-NPM-EGRESS-PORT chain to AZURE-NPM chain.
-	entry.Chain = util.IptablesAzureChain
-	entry.Specs = []string{util.IptablesJumpFlag, util.IptablesAzureEgressPortChain}
-	exists, err = iptMgr.Exists(entry)
-	if err != nil {
+	if err := iptMgr.getBackend().ensureHook(iptMgr); err != nil {
 		return err
 	}
 
-	if !exists {
-		iptMgr.OperationFlag = util.IptablesAppendFlag
-		if _, err := iptMgr.Run(entry); err != nil {
-			log.Printf("Error adding AZURE-NPM-EGRESS-PORT chain to AZURE-NPM chain\n")
-			return err
-		}
-	}
-
-	// Create AZURE-NPM-EGRESS-FROM chain.
-	if err := iptMgr.AddChain(util.IptablesAzureEgressToChain); err != nil {
+	if err := iptMgr.AddDefaultRules(); err != nil {
 		return err
 	}
 
-	// Create AZURE-NPM-TARGET-SETS chain.
-	if err := iptMgr.AddChain(util.IptablesAzureTargetSetsChain); err != nil {
-		return err
-	}
+	return iptMgr.Apply()
+}
 
-	// Insert AZURE-NPM-TARGET-SETS chain to AZURE-NPM chain.
-	entry.Chain = util.IptablesAzureChain
-	entry.Specs = []string{util.IptablesJumpFlag, util.IptablesAzureTargetSetsChain}
-	exists, err = iptMgr.Exists(entry)
-	if err != nil {
-		return err
+// AddDefaultRules adds the default rules of AZURE-NPM chain to the desired state.
+func (iptMgr *IptablesManager) AddDefaultRules() error {
+	defaultSpecs := [][]string{
+		// Default allow CONNECTED/RELATED rule.
+		{
+			util.IptablesMatchFlag,
+			util.IptablesStateFlag,
+			util.IPtablesMatchStateFlag,
+			util.IptablesRelatedState + "," + util.IptablesEstablishedState,
+			util.IptablesJumpFlag,
+			util.IptablesAccept,
+		},
+		// Default allow kube-system rules.
+		{
+			util.IptablesMatchFlag,
+			util.IptablesSetFlag,
+			util.IptablesMatchSetFlag,
+			util.GetHashedName(util.KubeSystemFlag),
+			util.IptablesDstFlag,
+			util.IptablesJumpFlag,
+			util.IptablesAccept,
+		},
+		{
+			util.IptablesMatchFlag,
+			util.IptablesSetFlag,
+			util.IptablesMatchSetFlag,
+			util.GetHashedName(util.KubeSystemFlag),
+			util.IptablesSrcFlag,
+			util.IptablesJumpFlag,
+			util.IptablesAccept,
+		},
+		{util.IptablesJumpFlag, util.IptablesAzureIngressPortChain},
+		{util.IptablesJumpFlag, util.IptablesAzureEgressPortChain},
+		{util.IptablesJumpFlag, util.IptablesAzureTargetSetsChain},
 	}
 
-	if !exists {
-		iptMgr.OperationFlag = util.IptablesAppendFlag
-		if _, err := iptMgr.Run(entry); err != nil {
-			log.Printf("Error adding AZURE-NPM-TARGET-SETS chain to AZURE-NPM chain\n")
+	for _, specs := range defaultSpecs {
+		entry := &IptEntry{
+			Chain: util.IptablesAzureChain,
+			Specs: specs,
+		}
+		if err := iptMgr.Add(entry); err != nil {
 			return err
 		}
 	}
@@ -206,45 +203,17 @@ func (iptMgr *IptablesManager) InitNpmChains() error {
 
 // UninitNpmChains uninitializes Azure NPM chains in iptables.
 func (iptMgr *IptablesManager) UninitNpmChains() error {
-	IptablesAzureChainList := []string{
-		util.IptablesAzureChain,
-		util.IptablesAzureIngressPortChain,
-		util.IptablesAzureIngressFromChain,
-		util.IptablesAzureEgressPortChain,
-		util.IptablesAzureEgressToChain,
-		util.IptablesAzureTargetSetsChain,
-	}
-
 	// Remove AZURE-NPM chain from FORWARD chain.
-	entry := &IptEntry{
-		Chain: util.IptablesForwardChain,
-		Specs: []string{
-			util.IptablesJumpFlag,
-			util.IptablesAzureChain,
-		},
-	}
-	iptMgr.OperationFlag = util.IptablesDeletionFlag
-	errCode, err := iptMgr.Run(entry)
-	if errCode != 1 && err != nil {
-		log.Printf("Error removing default rule from FORWARD chain\n")
+	if err := iptMgr.getBackend().removeHook(iptMgr); err != nil {
 		return err
 	}
 
-	iptMgr.OperationFlag = util.IptablesFlushFlag
-	for _, chain := range IptablesAzureChainList {
-		entry := &IptEntry{
-			Chain: chain,
-		}
-		if _, err := iptMgr.Run(entry); err != nil {
-			log.Printf("Error flushing iptables chain %s\n", chain)
-		}
+	if err := iptMgr.restore(iptMgr.getBackend().renderUninit(iptMgr)); err != nil {
+		log.Printf("Error deleting AZURE-NPM chains\n")
+		return err
 	}
 
-	for _, chain := range IptablesAzureChainList {
-		if err := iptMgr.DeleteChain(chain); err != nil {
-			return err
-		}
-	}
+	iptMgr.chainRules = nil
 
 	return nil
 }
@@ -305,54 +274,129 @@ func (iptMgr *IptablesManager) DeleteChain(chain string) error {
 	return nil
 }
 
-// Add adds a rule in iptables.
-func (iptMgr *IptablesManager) Add(entry *IptEntry) error {
-	log.Printf("Add iptables entry: %+v\n", entry)
+// getRuleKey returns the key that identifies a rule within its chain.
+func getRuleKey(entry *IptEntry) string {
+	return strings.Join(entry.Specs, " ")
+}
 
-	exists, err := iptMgr.Exists(entry)
-	if err != nil {
-		return err
+// Add adds a rule to the desired state of an NPM chain. It's programmed on the next Apply.
+// A rule added multiple times is kept until it's deleted as many times.
+// Rules with addresses of the other IP family are ignored.
+func (iptMgr *IptablesManager) Add(entry *IptEntry) error {
+	if !isNpmChain(entry.Chain) {
+		return fmt.Errorf("Chain %s is not managed by NPM", entry.Chain)
 	}
 
-	if exists {
+	if !iptMgr.isOfFamily(entry) {
 		return nil
 	}
 
-	iptMgr.OperationFlag = util.IptablesAppendFlag
-	if _, err := iptMgr.Run(entry); err != nil {
-		log.Printf("Error creating iptables rules.\n")
-		return err
+	log.Printf("Add iptables entry: %+v\n", entry)
+
+	if iptMgr.chainRules == nil {
+		iptMgr.chainRules = make(map[string][]*iptRule)
+	}
+
+	key := getRuleKey(entry)
+	for _, rule := range iptMgr.chainRules[entry.Chain] {
+		if getRuleKey(rule.entry) == key {
+			rule.referCount++
+			return nil
+		}
 	}
 
+	iptMgr.chainRules[entry.Chain] = append(iptMgr.chainRules[entry.Chain], &iptRule{entry: entry, referCount: 1})
+	iptMgr.isDirty = true
+
 	return nil
 }
 
-// Delete removes a rule in iptables.
+// Delete removes a rule from the desired state of an NPM chain. It's unprogrammed on the next Apply.
 func (iptMgr *IptablesManager) Delete(entry *IptEntry) error {
 	log.Printf("Deleting iptables entry: %+v\n", entry)
 
-	exists, err := iptMgr.Exists(entry)
-	if err != nil {
-		return err
+	key := getRuleKey(entry)
+	rules := iptMgr.chainRules[entry.Chain]
+	for i, rule := range rules {
+		if getRuleKey(rule.entry) != key {
+			continue
+		}
+
+		rule.referCount--
+		if rule.referCount == 0 {
+			iptMgr.chainRules[entry.Chain] = append(rules[:i], rules[i+1:]...)
+			iptMgr.isDirty = true
+		}
+
+		return nil
 	}
 
-	if !exists {
+	return nil
+}
+
+// Render returns the input of the backend that programs the desired state of all NPM chains.
+func (iptMgr *IptablesManager) Render() ([]byte, error) {
+	return iptMgr.getBackend().render(iptMgr)
+}
+
+// Apply programs the desired state of all NPM chains in a single transaction.
+func (iptMgr *IptablesManager) Apply() error {
+	if !iptMgr.isDirty {
 		return nil
 	}
 
-	iptMgr.OperationFlag = util.IptablesDeletionFlag
-	if _, err := iptMgr.Run(entry); err != nil {
-		log.Printf("Error deleting iptables rules.\n")
+	input, err := iptMgr.Render()
+	if err != nil {
+		log.Printf("Error rendering iptables rules.\n")
+		return err
+	}
+
+	if err := iptMgr.restore(input); err != nil {
+		log.Printf("Error applying iptables rules.\n")
 		return err
 	}
 
+	iptMgr.isDirty = false
+
 	return nil
 }
 
+// restore programs the input of the backend in a single transaction.
+func (iptMgr *IptablesManager) restore(input []byte) error {
+	return iptMgr.getBackend().restore(iptMgr, input)
+}
+
+// Reconcile compares the desired state of NPM chains with the kernel and reprograms them on drift.
+// It returns the number of missing or unexpected rules.
+func (iptMgr *IptablesManager) Reconcile() (int, error) {
+	drift, err := iptMgr.getBackend().drift(iptMgr)
+	if err != nil {
+		return 0, err
+	}
+
+	return iptMgr.reconcile(drift)
+}
+
+func (iptMgr *IptablesManager) reconcile(drift int) (int, error) {
+	if drift == 0 {
+		return 0, nil
+	}
+
+	if !iptMgr.DryRun {
+		if err := iptMgr.getBackend().ensureHook(iptMgr); err != nil {
+			return drift, err
+		}
+	}
+
+	iptMgr.isDirty = true
+
+	return drift, iptMgr.Apply()
+}
+
 // Run execute an iptables command to update iptables.
 func (iptMgr *IptablesManager) Run(entry *IptEntry) (int, error) {
-	cmdName := util.Iptables
-	cmdArgs := append([]string{iptMgr.OperationFlag, entry.Chain}, entry.Specs...)
+	cmdName, _, _ := iptMgr.getCommands()
+	cmdArgs := append([]string{iptMgr.OperationFlag, entry.Chain}, iptMgr.getSpecs(entry)...)
 
 	cmdOut, err := exec.Command(cmdName, cmdArgs...).Output()
 	log.Printf("%s\n", string(cmdOut))
@@ -383,7 +427,8 @@ func (iptMgr *IptablesManager) Save(configFile string) error {
 	}
 	defer f.Close()
 
-	cmd := exec.Command(util.IptablesSave)
+	_, saveCmd, _ := iptMgr.getCommands()
+	cmd := exec.Command(saveCmd)
 	cmd.Stdout = f
 	if err := cmd.Start(); err != nil {
 		log.Printf("Error running iptables-save.\n")
@@ -408,7 +453,8 @@ func (iptMgr *IptablesManager) Restore(configFile string) error {
 	}
 	defer f.Close()
 
-	cmd := exec.Command(util.IptablesRestore)
+	_, _, restoreCmd := iptMgr.getCommands()
+	cmd := exec.Command(restoreCmd)
 	cmd.Stdin = f
 	if err := cmd.Start(); err != nil {
 		log.Printf("Error running iptables-restore.\n")
@@ -1,6 +1,7 @@
 package iptm
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/npm/util"
@@ -140,7 +141,7 @@ func TestAdd(t *testing.T) {
 	}()
 
 	entry := &IptEntry{
-		Chain: util.IptablesForwardChain,
+		Chain: util.IptablesAzureChain,
 		Specs: []string{
 			util.IptablesJumpFlag,
 			util.IptablesReject,
@@ -149,6 +150,15 @@ func TestAdd(t *testing.T) {
 	if err := iptMgr.Add(entry); err != nil {
 		t.Errorf("TestAdd failed @ iptMgr.Add")
 	}
+
+	if err := iptMgr.Apply(); err != nil {
+		t.Errorf("TestAdd failed @ iptMgr.Apply")
+	}
+
+	entry.Chain = util.IptablesForwardChain
+	if err := iptMgr.Add(entry); err == nil {
+		t.Errorf("TestAdd failed @ iptMgr.Add to a chain not managed by NPM")
+	}
 }
 
 func TestDelete(t *testing.T) {
@@ -164,7 +174,7 @@ func TestDelete(t *testing.T) {
 	}()
 
 	entry := &IptEntry{
-		Chain: util.IptablesForwardChain,
+		Chain: util.IptablesAzureChain,
 		Specs: []string{
 			util.IptablesJumpFlag,
 			util.IptablesReject,
@@ -177,6 +187,174 @@ func TestDelete(t *testing.T) {
 	if err := iptMgr.Delete(entry); err != nil {
 		t.Errorf("TestDelete failed @ iptMgr.Delete")
 	}
+
+	if err := iptMgr.Apply(); err != nil {
+		t.Errorf("TestDelete failed @ iptMgr.Apply")
+	}
+}
+
+func TestRender(t *testing.T) {
+	iptMgr := &IptablesManager{DryRun: true}
+
+	entry := &IptEntry{
+		Chain: util.IptablesAzureIngressPortChain,
+		Specs: []string{
+			util.IptablesJumpFlag,
+			util.IptablesDrop,
+		},
+	}
+
+	// A rule added twice is kept until it's deleted twice.
+	for i := 0; i < 2; i++ {
+		if err := iptMgr.Add(entry); err != nil {
+			t.Errorf("TestRender failed @ iptMgr.Add")
+		}
+	}
+
+	if err := iptMgr.Delete(entry); err != nil {
+		t.Errorf("TestRender failed @ iptMgr.Delete")
+	}
+
+	expected := "*filter\n" +
+		":AZURE-NPM - [0:0]\n" +
+		":AZURE-NPM-INGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-INGRESS-FROM - [0:0]\n" +
+		":AZURE-NPM-EGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-EGRESS-TO - [0:0]\n" +
+		":AZURE-NPM-TARGET-SETS - [0:0]\n" +
+		"-A AZURE-NPM-INGRESS-PORT -j DROP\n" +
+		"COMMIT\n"
+	if rendered, err := iptMgr.Render(); err != nil || string(rendered) != expected {
+		t.Errorf("TestRender failed @ iptMgr.Render %s, err: %v", rendered, err)
+	}
+
+	if err := iptMgr.Apply(); err != nil || iptMgr.isDirty {
+		t.Errorf("TestRender failed @ iptMgr.Apply")
+	}
+}
+
+func TestRenderIPv6(t *testing.T) {
+	iptMgr := NewIptablesManagerForFamily(util.IPv6Family)
+	iptMgr.DryRun = true
+
+	entries := []*IptEntry{
+		{
+			Chain: util.IptablesAzureIngressFromChain,
+			Specs: []string{
+				util.IptablesMatchFlag,
+				util.IptablesSetFlag,
+				util.IptablesMatchSetFlag,
+				util.GetHashedName("test-ns"),
+				util.IptablesDstFlag,
+				util.IptablesSFlag,
+				"fd00::/64",
+				util.IptablesJumpFlag,
+				util.IptablesAccept,
+			},
+		},
+		// Rules with IPv4 addresses aren't programmed by ip6tables.
+		{
+			Chain: util.IptablesAzureIngressFromChain,
+			Specs: []string{util.IptablesSFlag, "10.0.0.0/16", util.IptablesJumpFlag, util.IptablesAccept},
+		},
+	}
+
+	for _, entry := range entries {
+		if err := iptMgr.Add(entry); err != nil {
+			t.Errorf("TestRenderIPv6 failed @ iptMgr.Add")
+		}
+	}
+
+	expected := "*filter\n" +
+		":AZURE-NPM - [0:0]\n" +
+		":AZURE-NPM-INGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-INGRESS-FROM - [0:0]\n" +
+		":AZURE-NPM-EGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-EGRESS-TO - [0:0]\n" +
+		":AZURE-NPM-TARGET-SETS - [0:0]\n" +
+		"-A AZURE-NPM-INGRESS-FROM -m set --match-set " + util.GetHashedNameForFamily("test-ns", util.IPv6Family) + " dst -s fd00::/64 -j ACCEPT\n" +
+		"COMMIT\n"
+	if rendered, err := iptMgr.Render(); err != nil || string(rendered) != expected {
+		t.Errorf("TestRenderIPv6 failed @ iptMgr.Render %s, err: %v", rendered, err)
+	}
+}
+
+func TestNormalizeRule(t *testing.T) {
+	specs := []string{
+		util.IptablesMatchFlag,
+		util.IptablesSetFlag,
+		util.IptablesMatchSetFlag,
+		"azure-npm-123",
+		util.IptablesDstFlag,
+		util.IptablesProtFlag,
+		"TCP",
+		util.IptablesDstPortFlag,
+		"80",
+		util.IptablesSFlag,
+		"10.0.0.1",
+		util.IptablesJumpFlag,
+		util.IptablesAccept,
+	}
+
+	expected := "-s 10.0.0.1/32 -p tcp -m set --match-set azure-npm-123 dst -m tcp --dport 80 -j accept"
+	if normalized := normalizeRule(specs); normalized != expected {
+		t.Errorf("TestNormalizeRule failed @ normalizeRule %s", normalized)
+	}
+
+	saved := strings.Fields("-s 10.0.0.1/32 -p tcp -m set --match-set azure-npm-123 dst -m tcp --dport 80 -j ACCEPT")
+	if normalized := normalizeRule(saved); normalized != expected {
+		t.Errorf("TestNormalizeRule failed @ normalizeRule of saved rule %s", normalized)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	iptMgr := &IptablesManager{DryRun: true}
+
+	entry := &IptEntry{
+		Chain: util.IptablesAzureIngressPortChain,
+		Specs: []string{util.IptablesProtFlag, "TCP", util.IptablesDstPortFlag, "80", util.IptablesJumpFlag, util.IptablesDrop},
+	}
+	if err := iptMgr.Add(entry); err != nil {
+		t.Errorf("TestReconcile failed @ iptMgr.Add")
+	}
+
+	save := "*filter\n" +
+		":FORWARD ACCEPT [0:0]\n" +
+		":AZURE-NPM - [0:0]\n" +
+		":AZURE-NPM-INGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-INGRESS-FROM - [0:0]\n" +
+		":AZURE-NPM-EGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-EGRESS-TO - [0:0]\n" +
+		":AZURE-NPM-TARGET-SETS - [0:0]\n" +
+		"-A FORWARD -j AZURE-NPM\n" +
+		"-A AZURE-NPM-INGRESS-PORT -p tcp -m tcp --dport 80 -j DROP\n" +
+		"COMMIT\n"
+
+	drift, err := iptMgr.reconcile(diffChains(iptMgr, parseIptablesSave([]byte(save))))
+	if err != nil || drift != 0 {
+		t.Errorf("TestReconcile failed @ iptMgr.reconcile of desired state, drift %d", drift)
+	}
+
+	// A missing FORWARD jump, a missing chain and an unexpected rule are drift.
+	save = "*filter\n" +
+		":FORWARD ACCEPT [0:0]\n" +
+		":AZURE-NPM - [0:0]\n" +
+		":AZURE-NPM-INGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-INGRESS-FROM - [0:0]\n" +
+		":AZURE-NPM-EGRESS-PORT - [0:0]\n" +
+		":AZURE-NPM-EGRESS-TO - [0:0]\n" +
+		"-A AZURE-NPM-INGRESS-PORT -p tcp -m tcp --dport 80 -j DROP\n" +
+		"-A AZURE-NPM-EGRESS-TO -j ACCEPT\n" +
+		"COMMIT\n"
+
+	drift, err = iptMgr.reconcile(diffChains(iptMgr, parseIptablesSave([]byte(save))))
+	if err != nil || drift != 3 {
+		t.Errorf("TestReconcile failed @ iptMgr.reconcile of drifted state, drift %d", drift)
+	}
+
+	if iptMgr.isDirty {
+		t.Errorf("TestReconcile failed @ iptMgr.Apply")
+	}
 }
 
 func TestRun(t *testing.T) {
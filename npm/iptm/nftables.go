@@ -0,0 +1,377 @@
+package iptm
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+// nftablesBackend programs NPM chains as regular chains of a dedicated nftables table,
+// which also holds the sets of the ipset manager's nftables backend.
+// A base chain hooked on forward jumps to AZURE-NPM chain.
+type nftablesBackend struct{}
+
+// getNftTable returns the nftables family and table of NPM chains of the manager's family.
+func getNftTable(iptMgr *IptablesManager) string {
+	return util.GetNftFamily(iptMgr.Family) + " " + util.NftTable
+}
+
+// getNftHookChain returns the declaration of the base chain hooked on forward.
+func getNftHookChain(table string) string {
+	return fmt.Sprintf("add chain %s %s { type filter hook forward priority 0 ; policy accept ; }\n", table, util.NftForwardChain)
+}
+
+func (b *nftablesBackend) render(iptMgr *IptablesManager) ([]byte, error) {
+	var buf bytes.Buffer
+
+	table := getNftTable(iptMgr)
+	fmt.Fprintf(&buf, "add table %s\n", table)
+
+	// Chains are flushed in the same transaction, so the rules below replace the current contents of NPM chains.
+	for _, chain := range npmChains {
+		fmt.Fprintf(&buf, "add chain %s %s\n", table, chain)
+		fmt.Fprintf(&buf, "flush chain %s %s\n", table, chain)
+	}
+
+	for _, chain := range npmChains {
+		for _, rule := range iptMgr.chainRules[chain] {
+			expr, err := translateRule(iptMgr.getSpecs(rule.entry), iptMgr.Family)
+			if err != nil {
+				log.Printf("Error translating iptables rule to nftables: %v. Rule: %+v\n", err, rule.entry)
+				return nil, err
+			}
+
+			fmt.Fprintf(&buf, "add rule %s %s %s\n", table, chain, expr)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (b *nftablesBackend) renderUninit(iptMgr *IptablesManager) []byte {
+	var buf bytes.Buffer
+
+	// Chains are flushed before being deleted, as chains referenced by jumps can't be deleted.
+	table := getNftTable(iptMgr)
+	fmt.Fprintf(&buf, "add table %s\n", table)
+	for _, chain := range npmChains {
+		fmt.Fprintf(&buf, "add chain %s %s\n", table, chain)
+		fmt.Fprintf(&buf, "flush chain %s %s\n", table, chain)
+	}
+	for _, chain := range npmChains {
+		fmt.Fprintf(&buf, "delete chain %s %s\n", table, chain)
+	}
+
+	return buf.Bytes()
+}
+
+// restore runs nft with the input as a single transaction.
+// In dry run mode the input is only logged.
+func (b *nftablesBackend) restore(iptMgr *IptablesManager, input []byte) error {
+	if iptMgr.DryRun {
+		log.Printf("Dry run of %s:\n%s", util.Nft, input)
+		return nil
+	}
+
+	cmd := exec.Command(util.Nft, util.NftFileFlag, util.NftStdin)
+	cmd.Stdin = bytes.NewReader(input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Error running %s: %v %s\nInput:\n%s", util.Nft, err, out, input)
+		return err
+	}
+
+	return nil
+}
+
+// ensureHook declares the base chain hooked on forward with a single jump to AZURE-NPM chain.
+func (b *nftablesBackend) ensureHook(iptMgr *IptablesManager) error {
+	var buf bytes.Buffer
+
+	table := getNftTable(iptMgr)
+	fmt.Fprintf(&buf, "add table %s\n", table)
+	fmt.Fprintf(&buf, "add chain %s %s\n", table, util.IptablesAzureChain)
+	buf.WriteString(getNftHookChain(table))
+	fmt.Fprintf(&buf, "flush chain %s %s\n", table, util.NftForwardChain)
+	fmt.Fprintf(&buf, "add rule %s %s jump %s\n", table, util.NftForwardChain, util.IptablesAzureChain)
+
+	if err := b.restore(iptMgr, buf.Bytes()); err != nil {
+		log.Printf("Error adding AZURE-NPM chain to forward hook\n")
+		return err
+	}
+
+	return nil
+}
+
+// removeHook deletes the base chain hooked on forward.
+func (b *nftablesBackend) removeHook(iptMgr *IptablesManager) error {
+	var buf bytes.Buffer
+
+	// The chain is declared first so that deleting it doesn't fail if it doesn't exist.
+	table := getNftTable(iptMgr)
+	fmt.Fprintf(&buf, "add table %s\n", table)
+	buf.WriteString(getNftHookChain(table))
+	fmt.Fprintf(&buf, "delete chain %s %s\n", table, util.NftForwardChain)
+
+	if err := b.restore(iptMgr, buf.Bytes()); err != nil {
+		log.Printf("Error removing AZURE-NPM chain from forward hook\n")
+		return err
+	}
+
+	return nil
+}
+
+// drift compares the normalized rules of each chain with the translation of the desired rules.
+func (b *nftablesBackend) drift(iptMgr *IptablesManager) (int, error) {
+	desired := map[string][]string{
+		util.NftForwardChain: {normalizeNftRule("jump " + util.IptablesAzureChain)},
+	}
+	for _, chain := range npmChains {
+		desired[chain] = []string{}
+		for _, rule := range iptMgr.chainRules[chain] {
+			expr, err := translateRule(iptMgr.getSpecs(rule.entry), iptMgr.Family)
+			if err != nil {
+				log.Printf("Error translating iptables rule to nftables: %v. Rule: %+v\n", err, rule.entry)
+				return 0, err
+			}
+
+			desired[chain] = append(desired[chain], normalizeNftRule(expr))
+		}
+	}
+
+	family := util.GetNftFamily(iptMgr.Family)
+	out, err := exec.Command(util.Nft, util.NftList, util.NftTableFlag, family, util.NftTable).Output()
+	if err != nil {
+		// The table may have been deleted, in which case all its chains are missing.
+		log.Printf("Error running %s %s %s %s %s: %v", util.Nft, util.NftList, util.NftTableFlag, family, util.NftTable, err)
+		out = nil
+	}
+
+	return diffNftChains(desired, parseNftTable(out)), nil
+}
+
+// normalizeNftRule converts a rule to the form listed by nft, which drops protocol matches implied by port matches,
+// prints host addresses without prefix length and orders connection states.
+func normalizeNftRule(rule string) string {
+	fields := strings.Fields(rule)
+
+	var normalized []string
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		switch {
+		case field == "meta" && i+4 < len(fields) && fields[i+1] == "l4proto" &&
+			fields[i+3] == fields[i+2] && (fields[i+4] == "dport" || fields[i+4] == "sport"):
+			i += 2
+			continue
+		case field == "state" && i > 0 && fields[i-1] == "ct" && i+1 < len(fields):
+			states := strings.Split(fields[i+1], ",")
+			sort.Strings(states)
+			normalized = append(normalized, field, strings.Join(states, ","))
+			i++
+			continue
+		case strings.HasSuffix(field, "/32") && !strings.Contains(field, ":"):
+			field = strings.TrimSuffix(field, "/32")
+		case strings.HasSuffix(field, "/128") && strings.Contains(field, ":"):
+			field = strings.TrimSuffix(field, "/128")
+		}
+
+		normalized = append(normalized, field)
+	}
+
+	return strings.Join(normalized, " ")
+}
+
+// parseNftTable returns the normalized rules of each chain in nft list table output.
+func parseNftTable(out []byte) map[string][]string {
+	chains := make(map[string][]string)
+
+	chain := ""
+	depth := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "}":
+			depth--
+			if depth == 1 {
+				chain = ""
+			}
+		case fields[len(fields)-1] == "{":
+			depth++
+			if depth == 2 && fields[0] == "chain" && len(fields) == 3 {
+				chain = fields[1]
+				chains[chain] = []string{}
+			}
+		case chain != "" && depth == 2 && fields[0] != "type" && fields[0] != "policy":
+			chains[chain] = append(chains[chain], normalizeNftRule(strings.Join(fields, " ")))
+		}
+	}
+
+	return chains
+}
+
+// diffNftChains returns the number of rules that are missing or unexpected in chains, counting missing chains once more.
+func diffNftChains(desired map[string][]string, actual map[string][]string) int {
+	drift := 0
+	for chain, rules := range desired {
+		actualRules, exists := actual[chain]
+		if !exists {
+			log.Printf("Chain %s is missing", chain)
+			drift++
+		}
+
+		if chainDrift := diffRules(rules, actualRules); chainDrift > 0 {
+			log.Printf("Chain %s drifted by %d rules", chain, chainDrift)
+			drift += chainDrift
+		}
+	}
+
+	return drift
+}
+
+// translateRule translates the iptables specs of an NPM rule to an nftables rule.
+func translateRule(specs []string, family string) (string, error) {
+	ipFamily := util.GetNftFamily(family)
+
+	var exprs []string
+	negated := false
+	protocol, target, logPrefix := "", "", ""
+
+	// getOperator returns the operator of the current match, which is negated by a preceding "!".
+	getOperator := func() string {
+		if negated {
+			negated = false
+			return "!= "
+		}
+
+		return ""
+	}
+
+	for i := 0; i < len(specs); i++ {
+		spec := specs[i]
+		hasValue := i+1 < len(specs)
+
+		switch {
+		case spec == util.IptablesNotFlag:
+			negated = true
+		case spec == util.IptablesMatchFlag && hasValue:
+			// Modules are implied by their options.
+			i++
+		case spec == util.IptablesMatchSetFlag && i+2 < len(specs):
+			name, directions := specs[i+1], strings.Split(specs[i+2], ",")
+			key := ipFamily + " daddr"
+			if directions[0] == util.IptablesSrcFlag {
+				key = ipFamily + " saddr"
+			}
+
+			// Named port sets match ip,protocol:port pairs.
+			if len(directions) > 1 {
+				port := "th dport"
+				if directions[1] == util.IptablesSrcFlag {
+					port = "th sport"
+				}
+				key += " . meta l4proto . " + port
+			}
+
+			exprs = append(exprs, fmt.Sprintf("%s %s@%s", key, getOperator(), name))
+			i += 2
+		case (spec == util.IptablesSFlag || spec == util.IptablesDFlag) && hasValue:
+			key := ipFamily + " daddr"
+			if spec == util.IptablesSFlag {
+				key = ipFamily + " saddr"
+			}
+
+			exprs = append(exprs, fmt.Sprintf("%s %s%s", key, getOperator(), specs[i+1]))
+			i++
+		case spec == util.IptablesProtFlag && hasValue:
+			protocol = strings.ToLower(specs[i+1])
+			exprs = append(exprs, fmt.Sprintf("meta l4proto %s%s", getOperator(), protocol))
+			i++
+		case (spec == util.IptablesDstPortFlag || spec == util.IptablesSrcPortFlag) && hasValue:
+			if protocol == "" {
+				return "", fmt.Errorf("Port match without protocol")
+			}
+
+			key := "dport"
+			if spec == util.IptablesSrcPortFlag {
+				key = "sport"
+			}
+
+			// iptables port ranges are written low:high.
+			exprs = append(exprs, fmt.Sprintf("%s %s %s%s", protocol, key, getOperator(), strings.Replace(specs[i+1], ":", "-", 1)))
+			i++
+		case spec == util.IptablesDstPortsFlag && hasValue:
+			if protocol == "" {
+				return "", fmt.Errorf("Port match without protocol")
+			}
+
+			// Multiport lists are matched as anonymous sets.
+			ports := strings.Split(strings.Replace(specs[i+1], ":", "-", -1), ",")
+			exprs = append(exprs, fmt.Sprintf("%s dport %s{ %s }", protocol, getOperator(), strings.Join(ports, ", ")))
+			i++
+		case spec == util.IPtablesMatchStateFlag && hasValue:
+			exprs = append(exprs, fmt.Sprintf("ct state %s%s", getOperator(), strings.ToLower(specs[i+1])))
+			i++
+		case spec == util.IptablesLimitRateFlag && hasValue:
+			exprs = append(exprs, "limit rate "+translateRate(specs[i+1]))
+			i++
+		case spec == util.IptablesLogPrefixFlag && hasValue:
+			logPrefix = strings.Trim(specs[i+1], "\"")
+			i++
+		case spec == util.IptablesJumpFlag && hasValue:
+			target = specs[i+1]
+			i++
+		default:
+			return "", fmt.Errorf("Unsupported option %s", spec)
+		}
+	}
+
+	switch target {
+	case "":
+	case util.IptablesAccept:
+		exprs = append(exprs, "accept")
+	case util.IptablesDrop:
+		exprs = append(exprs, "drop")
+	case util.IptablesReject:
+		exprs = append(exprs, "reject")
+	case "RETURN":
+		exprs = append(exprs, "return")
+	case util.IptablesLog:
+		exprs = append(exprs, fmt.Sprintf("log prefix \"%s\"", logPrefix))
+	default:
+		exprs = append(exprs, "jump "+target)
+	}
+
+	return strings.Join(exprs, " "), nil
+}
+
+// translateRate translates an iptables rate such as 10/sec to an nftables rate such as 10/second.
+func translateRate(rate string) string {
+	units := map[string]string{
+		"s":    "second",
+		"sec":  "second",
+		"m":    "minute",
+		"min":  "minute",
+		"h":    "hour",
+		"d":    "day",
+		"hour": "hour",
+		"day":  "day",
+	}
+
+	s := strings.SplitN(rate, "/", 2)
+	if len(s) == 2 {
+		if unit, found := units[s[1]]; found {
+			return s[0] + "/" + unit
+		}
+	}
+
+	return rate
+}
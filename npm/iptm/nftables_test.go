@@ -0,0 +1,217 @@
+package iptm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+)
+
+func TestTranslateRule(t *testing.T) {
+	tests := []struct {
+		specs    []string
+		family   string
+		expected string
+	}{
+		{
+			specs: []string{
+				util.IptablesMatchFlag, util.IptablesStateFlag,
+				util.IPtablesMatchStateFlag, util.IptablesRelatedState + "," + util.IptablesEstablishedState,
+				util.IptablesJumpFlag, util.IptablesAccept,
+			},
+			family:   util.IPv4Family,
+			expected: "ct state related,established accept",
+		},
+		{
+			specs: []string{
+				util.IptablesMatchFlag, util.IptablesSetFlag, util.IptablesNotFlag,
+				util.IptablesMatchSetFlag, "azure-npm-1", util.IptablesSrcFlag,
+				util.IptablesProtFlag, "TCP", util.IptablesDstPortFlag, "8000:8080",
+				util.IptablesJumpFlag, util.IptablesAzureIngressFromChain,
+			},
+			family:   util.IPv4Family,
+			expected: "ip saddr != @azure-npm-1 meta l4proto tcp tcp dport 8000-8080 jump AZURE-NPM-INGRESS-FROM",
+		},
+		{
+			specs: []string{
+				util.IptablesMatchFlag, util.IptablesSetFlag,
+				util.IptablesMatchSetFlag, "azure-npm-2-v6", util.IptablesDstDstFlag,
+				util.IptablesSFlag, "fd00::/64",
+				util.IptablesJumpFlag, util.IptablesDrop,
+			},
+			family:   util.IPv6Family,
+			expected: "ip6 daddr . meta l4proto . th dport @azure-npm-2-v6 ip6 saddr fd00::/64 drop",
+		},
+		{
+			specs: []string{
+				util.IptablesProtFlag, "SCTP", util.IptablesMatchFlag, util.IptablesMultiportFlag,
+				util.IptablesDstPortsFlag, "80,8000:8080",
+				util.IptablesJumpFlag, util.IptablesAzureEgressToChain,
+			},
+			family:   util.IPv4Family,
+			expected: "meta l4proto sctp sctp dport { 80, 8000-8080 } jump AZURE-NPM-EGRESS-TO",
+		},
+		{
+			specs: []string{
+				util.IptablesMatchFlag, util.IptablesLimitFlag, util.IptablesLimitRateFlag, util.IptablesDropLogRate,
+				util.IptablesJumpFlag, util.IptablesLog, util.IptablesLogPrefixFlag, "\"azure-npm-drop-1:\"",
+			},
+			family:   util.IPv4Family,
+			expected: "limit rate 10/second log prefix \"azure-npm-drop-1:\"",
+		},
+	}
+
+	for _, test := range tests {
+		rule, err := translateRule(test.specs, test.family)
+		if err != nil || rule != test.expected {
+			t.Errorf("TestTranslateRule failed @ translateRule %s, err: %v", rule, err)
+		}
+	}
+
+	if _, err := translateRule([]string{util.IptablesDstPortFlag, "80"}, util.IPv4Family); err == nil {
+		t.Errorf("TestTranslateRule failed @ translateRule of port without protocol")
+	}
+}
+
+func TestRenderNftables(t *testing.T) {
+	iptMgr := NewIptablesManagerWithBackend(util.IPv4Family, util.NftablesBackend)
+	iptMgr.DryRun = true
+
+	entry := &IptEntry{
+		Chain: util.IptablesAzureIngressPortChain,
+		Specs: []string{util.IptablesSFlag, "10.0.0.0/16", util.IptablesJumpFlag, util.IptablesDrop},
+	}
+	if err := iptMgr.Add(entry); err != nil {
+		t.Errorf("TestRenderNftables failed @ iptMgr.Add")
+	}
+
+	expected := "add table ip azure-npm\n" +
+		"add chain ip azure-npm AZURE-NPM\n" +
+		"flush chain ip azure-npm AZURE-NPM\n" +
+		"add chain ip azure-npm AZURE-NPM-INGRESS-PORT\n" +
+		"flush chain ip azure-npm AZURE-NPM-INGRESS-PORT\n" +
+		"add chain ip azure-npm AZURE-NPM-INGRESS-FROM\n" +
+		"flush chain ip azure-npm AZURE-NPM-INGRESS-FROM\n" +
+		"add chain ip azure-npm AZURE-NPM-EGRESS-PORT\n" +
+		"flush chain ip azure-npm AZURE-NPM-EGRESS-PORT\n" +
+		"add chain ip azure-npm AZURE-NPM-EGRESS-TO\n" +
+		"flush chain ip azure-npm AZURE-NPM-EGRESS-TO\n" +
+		"add chain ip azure-npm AZURE-NPM-TARGET-SETS\n" +
+		"flush chain ip azure-npm AZURE-NPM-TARGET-SETS\n" +
+		"add rule ip azure-npm AZURE-NPM-INGRESS-PORT ip saddr 10.0.0.0/16 drop\n"
+	if rendered, err := iptMgr.Render(); err != nil || string(rendered) != expected {
+		t.Errorf("TestRenderNftables failed @ iptMgr.Render %s, err: %v", rendered, err)
+	}
+
+	if err := iptMgr.Apply(); err != nil || iptMgr.isDirty {
+		t.Errorf("TestRenderNftables failed @ iptMgr.Apply")
+	}
+
+	// A rule that can't be translated fails the transaction instead of being left out.
+	unsupported := &IptEntry{
+		Chain: util.IptablesAzureIngressPortChain,
+		Specs: []string{"--unsupported", util.IptablesJumpFlag, util.IptablesAccept},
+	}
+	if err := iptMgr.Add(unsupported); err != nil {
+		t.Errorf("TestRenderNftables failed @ iptMgr.Add of unsupported rule")
+	}
+
+	if _, err := iptMgr.Render(); err == nil {
+		t.Errorf("TestRenderNftables failed @ iptMgr.Render of unsupported rule")
+	}
+
+	if err := iptMgr.Apply(); err == nil || !iptMgr.isDirty {
+		t.Errorf("TestRenderNftables failed @ iptMgr.Apply of unsupported rule")
+	}
+}
+
+func TestParseNftTable(t *testing.T) {
+	out := "table ip azure-npm {\n" +
+		"\tset azure-npm-1 {\n" +
+		"\t\ttype ipv4_addr\n" +
+		"\t\tflags interval\n" +
+		"\t\telements = { 10.0.0.1, 10.0.0.2 }\n" +
+		"\t}\n" +
+		"\n" +
+		"\tchain FORWARD {\n" +
+		"\t\ttype filter hook forward priority filter; policy accept;\n" +
+		"\t\tjump AZURE-NPM\n" +
+		"\t}\n" +
+		"\n" +
+		"\tchain AZURE-NPM {\n" +
+		"\t\tct state established,related accept\n" +
+		"\t\tip daddr @azure-npm-1 accept\n" +
+		"\t}\n" +
+		"\n" +
+		"\tchain AZURE-NPM-INGRESS-PORT {\n" +
+		"\t}\n" +
+		"}\n"
+
+	chains := parseNftTable([]byte(out))
+	expectedChains := map[string][]string{
+		util.NftForwardChain:               {"jump AZURE-NPM"},
+		util.IptablesAzureChain:            {"ct state established,related accept", "ip daddr @azure-npm-1 accept"},
+		util.IptablesAzureIngressPortChain: {},
+	}
+	if !reflect.DeepEqual(chains, expectedChains) {
+		t.Errorf("TestParseNftTable failed @ parseNftTable %+v", chains)
+	}
+
+	// The translation of the desired rules matches nft's listing.
+	desired := map[string][]string{
+		util.NftForwardChain: {normalizeNftRule("jump AZURE-NPM")},
+		util.IptablesAzureChain: {
+			normalizeNftRule("ct state related,established accept"),
+			normalizeNftRule("ip daddr @azure-npm-1 accept"),
+		},
+		util.IptablesAzureIngressPortChain: {},
+	}
+	if drift := diffNftChains(desired, chains); drift != 0 {
+		t.Errorf("TestParseNftTable failed @ diffNftChains of desired state, drift %d", drift)
+	}
+
+	// A changed rule is missing and unexpected, and AZURE-NPM-EGRESS-PORT chain is missing.
+	desired[util.IptablesAzureChain][1] = normalizeNftRule("ip daddr @azure-npm-2 accept")
+	desired[util.IptablesAzureEgressPortChain] = []string{}
+	if drift := diffNftChains(desired, chains); drift != 3 {
+		t.Errorf("TestParseNftTable failed @ diffNftChains of changed rule, drift %d", drift)
+	}
+
+	// Swapped rules are drift.
+	desired = map[string][]string{
+		util.IptablesAzureChain: {chains[util.IptablesAzureChain][1], chains[util.IptablesAzureChain][0]},
+	}
+	if drift := diffNftChains(desired, chains); drift != 1 {
+		t.Errorf("TestParseNftTable failed @ diffNftChains of swapped rules, drift %d", drift)
+	}
+}
+
+func TestNormalizeNftRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected string
+	}{
+		{
+			rule:     "ip saddr != @azure-npm-1 meta l4proto tcp tcp dport 8000-8080 jump AZURE-NPM-INGRESS-FROM",
+			expected: "ip saddr != @azure-npm-1 tcp dport 8000-8080 jump AZURE-NPM-INGRESS-FROM",
+		},
+		{
+			rule:     "ip daddr 10.0.0.1/32 meta l4proto udp drop",
+			expected: "ip daddr 10.0.0.1 meta l4proto udp drop",
+		},
+		{
+			rule:     "ip6 saddr fd00::1/128 ip6 daddr fd00::/64 drop",
+			expected: "ip6 saddr fd00::1 ip6 daddr fd00::/64 drop",
+		},
+		{
+			rule:     "ct  state related,established accept",
+			expected: "ct state established,related accept",
+		},
+	}
+
+	for _, test := range tests {
+		if rule := normalizeNftRule(test.rule); rule != test.expected {
+			t.Errorf("TestNormalizeNftRule failed @ normalizeNftRule %s", rule)
+		}
+	}
+}
@@ -14,28 +14,117 @@ import (
 )
 
 type namespace struct {
-	name   string
-	setMap map[string]string
-	podMap map[types.UID]*corev1.Pod
-	npMap  map[string]*networkingv1.NetworkPolicy
-	ipsMgr *ipsm.IpsetManager
-	iptMgr *iptm.IptablesManager
+	name     string
+	setMap   map[string]string
+	podMap   map[types.UID]*corev1.Pod
+	npMap    map[string]*networkingv1.NetworkPolicy // network policies keyed by namespace/name.
+	ipsMgr   *ipsm.IpsetManager
+	iptMgr   *iptm.IptablesManager
+	ipsMgrV6 *ipsm.IpsetManager
+	iptMgrV6 *iptm.IptablesManager
 }
 
 // newNS constructs a new namespace object.
 func newNs(name string) (*namespace, error) {
 	ns := &namespace{
-		name:   name,
-		setMap: make(map[string]string),
-		podMap: make(map[types.UID]*corev1.Pod),
-		npMap:  make(map[string]*networkingv1.NetworkPolicy),
-		ipsMgr: ipsm.NewIpsetManager(),
-		iptMgr: iptm.NewIptablesManager(),
+		name:     name,
+		setMap:   make(map[string]string),
+		podMap:   make(map[types.UID]*corev1.Pod),
+		npMap:    make(map[string]*networkingv1.NetworkPolicy),
+		ipsMgr:   ipsm.NewIpsetManager(),
+		iptMgr:   iptm.NewIptablesManager(),
+		ipsMgrV6: ipsm.NewIpsetManagerForFamily(util.IPv6Family),
+		iptMgrV6: iptm.NewIptablesManagerForFamily(util.IPv6Family),
 	}
 
 	return ns, nil
 }
 
+// getFamilies returns the IP families whose policies are enforced.
+func (npMgr *NetworkPolicyManager) getFamilies() []string {
+	if npMgr.isIPv6Enabled {
+		return []string{util.IPv4Family, util.IPv6Family}
+	}
+
+	return []string{util.IPv4Family}
+}
+
+// getAllNs returns the all-namespace, whose managers program the sets and rules of every namespace.
+func (npMgr *NetworkPolicyManager) getAllNs() *namespace {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	return npMgr.nsMap[util.KubeAllNamespacesFlag]
+}
+
+// getNsNames returns the names of the namespaces known to NPM, except the all-namespace.
+func (npMgr *NetworkPolicyManager) getNsNames() []string {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
+	var nsNames []string
+	for nsName := range npMgr.nsMap {
+		if nsName != util.KubeAllNamespacesFlag {
+			nsNames = append(nsNames, nsName)
+		}
+	}
+
+	return nsNames
+}
+
+// getIpsetManager returns the ipset manager of an IP family.
+// The ipset managers are only used with ipsetLock held.
+func (npMgr *NetworkPolicyManager) getIpsetManager(family string) *ipsm.IpsetManager {
+	allNs := npMgr.getAllNs()
+	if family == util.IPv6Family {
+		return allNs.ipsMgrV6
+	}
+
+	return allNs.ipsMgr
+}
+
+// getIptablesManager returns the iptables manager of an IP family.
+// The iptables managers are only used with policyLock held.
+func (npMgr *NetworkPolicyManager) getIptablesManager(family string) *iptm.IptablesManager {
+	allNs := npMgr.getAllNs()
+	if family == util.IPv6Family {
+		return allNs.iptMgrV6
+	}
+
+	return allNs.iptMgr
+}
+
+// getIpsetManagers returns the ipset managers of all enforced IP families.
+func (npMgr *NetworkPolicyManager) getIpsetManagers() []*ipsm.IpsetManager {
+	var ipsMgrs []*ipsm.IpsetManager
+	for _, family := range npMgr.getFamilies() {
+		ipsMgrs = append(ipsMgrs, npMgr.getIpsetManager(family))
+	}
+
+	return ipsMgrs
+}
+
+// getIptablesManagers returns the iptables managers of all enforced IP families.
+func (npMgr *NetworkPolicyManager) getIptablesManagers() []*iptm.IptablesManager {
+	var iptMgrs []*iptm.IptablesManager
+	for _, family := range npMgr.getFamilies() {
+		iptMgrs = append(iptMgrs, npMgr.getIptablesManager(family))
+	}
+
+	return iptMgrs
+}
+
+// isFamilyEnforced checks whether policies of an IP family are enforced.
+func (npMgr *NetworkPolicyManager) isFamilyEnforced(family string) bool {
+	for _, enforced := range npMgr.getFamilies() {
+		if family == enforced {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isSystemNs(nsObj *corev1.Namespace) bool {
 	return nsObj.ObjectMeta.Name == util.KubeSystemFlag
 }
@@ -44,17 +133,19 @@ func getNsIpsetName(k, v string) string {
 	return "ns-" + k + ":" + v
 }
 
+func getNsKeyIpsetName(k string) string {
+	return "ns-" + k
+}
+
 // InitAllNsList syncs all-namespace ipset list.
+// The changes are programmed by the next ipsMgr.Apply, so ipsetLock has to be held.
 func (npMgr *NetworkPolicyManager) InitAllNsList() error {
-	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
-	for nsName := range npMgr.nsMap {
-		if nsName == util.KubeAllNamespacesFlag {
-			continue
-		}
-
-		if err := allNs.ipsMgr.AddToList(util.KubeAllNamespacesFlag, nsName); err != nil {
-			log.Printf("Error adding namespace set %s to list %s\n", nsName, util.KubeAllNamespacesFlag)
-			return err
+	for _, ipsMgr := range npMgr.getIpsetManagers() {
+		for _, nsName := range npMgr.getNsNames() {
+			if err := ipsMgr.AddToList(util.KubeAllNamespacesFlag, nsName); err != nil {
+				log.Printf("Error adding namespace set %s to list %s\n", nsName, util.KubeAllNamespacesFlag)
+				return err
+			}
 		}
 	}
 
@@ -62,70 +153,141 @@ func (npMgr *NetworkPolicyManager) InitAllNsList() error {
 }
 
 // UninitAllNsList cleans all-namespace ipset list.
+// The changes are programmed by the next ipsMgr.Apply, so ipsetLock has to be held.
 func (npMgr *NetworkPolicyManager) UninitAllNsList() error {
-	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
-	for nsName := range npMgr.nsMap {
-		if nsName == util.KubeAllNamespacesFlag {
-			continue
-		}
-
-		if err := allNs.ipsMgr.DeleteFromList(util.KubeAllNamespacesFlag, nsName); err != nil {
-			log.Printf("Error deleting namespace set %s from list %s\n", nsName, util.KubeAllNamespacesFlag)
-			return err
+	for _, ipsMgr := range npMgr.getIpsetManagers() {
+		for _, nsName := range npMgr.getNsNames() {
+			if err := ipsMgr.DeleteFromList(util.KubeAllNamespacesFlag, nsName); err != nil {
+				log.Printf("Error deleting namespace set %s from list %s\n", nsName, util.KubeAllNamespacesFlag)
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-// AddNamespace handles adding  namespace to ipset.
-func (npMgr *NetworkPolicyManager) AddNamespace(nsObj *corev1.Namespace) error {
-	npMgr.Lock()
-	defer npMgr.Unlock()
-
-	var err error
+// addNsToIpsets creates the ipset of a namespace and adds it to the ipset lists of its labels.
+func addNsToIpsets(ipsMgr *ipsm.IpsetManager, nsObj *corev1.Namespace) error {
+	nsName := nsObj.ObjectMeta.Name
 
-	defer func() {
-		if err = npMgr.UpdateAndSendReport(err, util.AddNamespaceEvent); err != nil {
-			log.Printf("Error sending NPM telemetry report")
-		}
-	}()
-
-	nsName, nsNs := nsObj.ObjectMeta.Name, nsObj.ObjectMeta.Namespace
-	log.Printf("NAMESPACE CREATING: %s/%s\n", nsName, nsNs)
-
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
 	// Create ipset for the namespace.
-	if err = ipsMgr.CreateSet(nsName); err != nil {
+	if err := ipsMgr.CreateSet(nsName); err != nil {
 		log.Printf("Error creating ipset for namespace %s.\n", nsName)
 		return err
 	}
 
-	if err = ipsMgr.AddToList(util.KubeAllNamespacesFlag, nsName); err != nil {
+	if err := ipsMgr.AddToList(util.KubeAllNamespacesFlag, nsName); err != nil {
 		log.Printf("Error adding %s to all-namespace ipset list.\n", nsName)
 		return err
 	}
 
 	// Add the namespace to its label's ipset list.
-	var labelKeys []string
 	nsLabels := nsObj.ObjectMeta.Labels
 	for nsLabelKey, nsLabelVal := range nsLabels {
 		labelKey := getNsIpsetName(nsLabelKey, nsLabelVal)
 		log.Printf("Adding namespace %s to ipset list %s\n", nsName, labelKey)
-		if err = ipsMgr.AddToList(labelKey, nsName); err != nil {
+		if err := ipsMgr.AddToList(labelKey, nsName); err != nil {
 			log.Printf("Error Adding namespace %s to ipset list %s\n", nsName, labelKey)
 			return err
 		}
-		labelKeys = append(labelKeys, labelKey)
+
+		// Add the namespace to its label key's ipset list, which backs Exists and DoesNotExist selectors.
+		keyList := getNsKeyIpsetName(nsLabelKey)
+		log.Printf("Adding namespace %s to ipset list %s\n", nsName, keyList)
+		if err := ipsMgr.AddToList(keyList, nsName); err != nil {
+			log.Printf("Error Adding namespace %s to ipset list %s\n", nsName, keyList)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteNsFromIpsets removes a namespace from the ipset lists of its labels and deletes its ipset.
+func deleteNsFromIpsets(ipsMgr *ipsm.IpsetManager, nsObj *corev1.Namespace) error {
+	nsName := nsObj.ObjectMeta.Name
+
+	// Delete the namespace from its label's ipset list.
+	nsLabels := nsObj.ObjectMeta.Labels
+	for nsLabelKey, nsLabelVal := range nsLabels {
+		labelKey := getNsIpsetName(nsLabelKey, nsLabelVal)
+		log.Printf("Deleting namespace %s from ipset list %s\n", nsName, labelKey)
+		if err := ipsMgr.DeleteFromList(labelKey, nsName); err != nil {
+			log.Printf("Error deleting namespace %s from ipset list %s\n", nsName, labelKey)
+			return err
+		}
+
+		keyList := getNsKeyIpsetName(nsLabelKey)
+		log.Printf("Deleting namespace %s from ipset list %s\n", nsName, keyList)
+		if err := ipsMgr.DeleteFromList(keyList, nsName); err != nil {
+			log.Printf("Error deleting namespace %s from ipset list %s\n", nsName, keyList)
+			return err
+		}
+	}
+
+	// Delete the namespace from all-namespace ipset list.
+	if err := ipsMgr.DeleteFromList(util.KubeAllNamespacesFlag, nsName); err != nil {
+		log.Printf("Error deleting namespace %s from ipset list %s\n", nsName, util.KubeAllNamespacesFlag)
+		return err
+	}
+
+	// Delete ipset for the namespace.
+	if err := ipsMgr.DeleteSet(nsName); err != nil {
+		log.Printf("Error deleting ipset for namespace %s.\n", nsName)
+		return err
+	}
+
+	return nil
+}
+
+// applyNsToIpsets programs the ipset changes of a namespace event in every IP family.
+func (npMgr *NetworkPolicyManager) applyNsToIpsets(nsObj *corev1.Namespace, change func(*ipsm.IpsetManager, *corev1.Namespace) error) error {
+	npMgr.ipsetLock.Lock()
+	defer npMgr.ipsetLock.Unlock()
+
+	for _, ipsMgr := range npMgr.getIpsetManagers() {
+		if err := change(ipsMgr, nsObj); err != nil {
+			return err
+		}
+
+		// Program all ipset changes of the event at once.
+		if err := ipsMgr.Apply(); err != nil {
+			log.Printf("Error applying ipset changes.\n")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddNamespace handles adding  namespace to ipset.
+func (npMgr *NetworkPolicyManager) AddNamespace(nsObj *corev1.Namespace) error {
+	var err error
+
+	defer func() {
+		if err = npMgr.UpdateAndSendReport(err, util.AddNamespaceEvent); err != nil {
+			log.Printf("Error sending NPM telemetry report")
+		}
+	}()
+
+	nsName, nsNs := nsObj.ObjectMeta.Name, nsObj.ObjectMeta.Namespace
+	log.Printf("NAMESPACE CREATING: %s/%s\n", nsName, nsNs)
+
+	// Namespaces are programmed in the ipsets of every IP family.
+	if err = npMgr.applyNsToIpsets(nsObj, addNsToIpsets); err != nil {
+		return err
 	}
 
 	ns, err := newNs(nsName)
 	if err != nil {
 		log.Printf("Error creating namespace %s\n", nsName)
 	}
-	npMgr.nsMap[nsName] = ns
 
+	npMgr.Lock()
+	npMgr.nsMap[nsName] = ns
 	npMgr.clusterState.NsCount++
+	npMgr.Unlock()
 
 	return nil
 }
@@ -135,11 +297,9 @@ func (npMgr *NetworkPolicyManager) UpdateNamespace(oldNsObj *corev1.Namespace, n
 	var err error
 
 	defer func() {
-		npMgr.Lock()
 		if err = npMgr.UpdateAndSendReport(err, util.AddNamespaceEvent); err != nil {
 			log.Printf("Error sending NPM telemetry report")
 		}
-		npMgr.Unlock()
 	}()
 
 	oldNsName, newNsName := oldNsObj.ObjectMeta.Name, newNsObj.ObjectMeta.Name
@@ -149,10 +309,12 @@ func (npMgr *NetworkPolicyManager) UpdateNamespace(oldNsObj *corev1.Namespace, n
 		return err
 	}
 
-	if newNsObj.ObjectMeta.DeletionTimestamp == nil && newNsObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
-		if err = npMgr.AddNamespace(newNsObj); err != nil {
-			return err
-		}
+	if isNsDeleting(newNsObj) {
+		return nil
+	}
+
+	if err = npMgr.AddNamespace(newNsObj); err != nil {
+		return err
 	}
 
 	return nil
@@ -160,9 +322,6 @@ func (npMgr *NetworkPolicyManager) UpdateNamespace(oldNsObj *corev1.Namespace, n
 
 // DeleteNamespace handles deleting namespace from ipset.
 func (npMgr *NetworkPolicyManager) DeleteNamespace(nsObj *corev1.Namespace) error {
-	npMgr.Lock()
-	defer npMgr.Unlock()
-
 	var err error
 
 	defer func() {
@@ -174,40 +333,85 @@ func (npMgr *NetworkPolicyManager) DeleteNamespace(nsObj *corev1.Namespace) erro
 	nsName, nsNs := nsObj.ObjectMeta.Name, nsObj.ObjectMeta.Namespace
 	log.Printf("NAMESPACE DELETING: %s/%s\n", nsName, nsNs)
 
+	npMgr.Lock()
 	_, exists := npMgr.nsMap[nsName]
+	npMgr.Unlock()
 	if !exists {
 		return nil
 	}
 
-	// Delete the namespace from its label's ipset list.
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
-	var labelKeys []string
-	nsLabels := nsObj.ObjectMeta.Labels
-	for nsLabelKey, nsLabelVal := range nsLabels {
-		labelKey := getNsIpsetName(nsLabelKey, nsLabelVal)
-		log.Printf("Deleting namespace %s from ipset list %s\n", nsName, labelKey)
-		if err = ipsMgr.DeleteFromList(labelKey, nsName); err != nil {
-			log.Printf("Error deleting namespace %s from ipset list %s\n", nsName, labelKey)
+	err = npMgr.deleteNamespace(nsObj, true)
+
+	return err
+}
+
+// deleteNamespace deletes the ipsets of a namespace.
+// Namespaces whose add failed were never counted, so only added ones are removed from the cluster state.
+func (npMgr *NetworkPolicyManager) deleteNamespace(nsObj *corev1.Namespace, isAdded bool) error {
+	if err := npMgr.applyNsToIpsets(nsObj, deleteNsFromIpsets); err != nil {
+		return err
+	}
+
+	if isAdded {
+		npMgr.Lock()
+		delete(npMgr.nsMap, nsObj.ObjectMeta.Name)
+		npMgr.clusterState.NsCount--
+		npMgr.Unlock()
+	}
+
+	return nil
+}
+
+// isNsDeleting checks whether a namespace is being deleted, in which case it's no longer programmed.
+func isNsDeleting(nsObj *corev1.Namespace) bool {
+	return nsObj.ObjectMeta.DeletionTimestamp != nil || nsObj.ObjectMeta.DeletionGracePeriodSeconds != nil
+}
+
+// syncNamespace programs the ipsets of a namespace from its latest state in the informer cache.
+// Changed namespaces are deleted then added again. Namespaces whose add failed are cleaned up
+// and retried as adds, so that the cluster state only counts added namespaces.
+func (npMgr *NetworkPolicyManager) syncNamespace(key string) error {
+	obj, exists, err := npMgr.nsInformer.Informer().GetIndexer().GetByKey(key)
+	if err != nil {
+		log.Printf("Error getting namespace %s from informer cache.\n", key)
+		return err
+	}
+
+	if oldNsObj, isSynced := npMgr.syncedNamespaces[key]; isSynced {
+		if exists && oldNsObj.ObjectMeta.ResourceVersion == obj.(*corev1.Namespace).ObjectMeta.ResourceVersion {
+			return nil
+		}
+
+		if err = npMgr.DeleteNamespace(oldNsObj); err != nil {
 			return err
 		}
-		labelKeys = append(labelKeys, labelKey)
+
+		delete(npMgr.syncedNamespaces, key)
 	}
 
-	// Delete the namespace from all-namespace ipset list.
-	if err = ipsMgr.DeleteFromList(util.KubeAllNamespacesFlag, nsName); err != nil {
-		log.Printf("Error deleting namespace %s from ipset list %s\n", nsName, util.KubeAllNamespacesFlag)
-		return err
+	if failedNsObj, isFailed := npMgr.failedNamespaces[key]; isFailed {
+		if err = npMgr.deleteNamespace(failedNsObj, false); err != nil {
+			return err
+		}
+
+		delete(npMgr.failedNamespaces, key)
 	}
 
-	// Delete ipset for the namespace.
-	if err = ipsMgr.DeleteSet(nsName); err != nil {
-		log.Printf("Error deleting ipset for namespace %s.\n", nsName)
-		return err
+	if !exists {
+		return nil
 	}
 
-	delete(npMgr.nsMap, nsName)
+	nsObj := obj.(*corev1.Namespace)
+	if isNsDeleting(nsObj) {
+		return nil
+	}
+
+	if err = npMgr.AddNamespace(nsObj); err != nil {
+		npMgr.failedNamespaces[key] = nsObj
+		return err
+	}
 
-	npMgr.clusterState.NsCount--
+	npMgr.syncedNamespaces[key] = nsObj
 
 	return nil
 }
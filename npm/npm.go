@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/iptm"
 	"github.com/Azure/azure-container-networking/npm/util"
 	"github.com/Azure/azure-container-networking/telemetry"
 	corev1 "k8s.io/api/core/v1"
@@ -26,8 +28,17 @@ var (
 )
 
 // NetworkPolicyManager contains informers for pod, namespace and networkpolicy.
+// Locks are acquired in the order policyLock, ipsetLock, then the embedded mutex.
 type NetworkPolicyManager struct {
+	// The embedded mutex guards nsMap, the npMap of the all-namespace, clusterState and reportManager.
+	// It's only held while they're accessed, so that workers don't wait for each other's events.
 	sync.Mutex
+	// ipsetLock serializes the batches of the ipset managers, so that events apply their own changes.
+	ipsetLock sync.Mutex
+	// policyLock serializes network policy events with the reconciler.
+	// It guards the iptables managers and isAzureNpmChainCreated.
+	policyLock sync.Mutex
+
 	clientset *kubernetes.Clientset
 
 	informerFactory informers.SharedInformerFactory
@@ -35,9 +46,29 @@ type NetworkPolicyManager struct {
 	nsInformer      coreinformers.NamespaceInformer
 	npInformer      networkinginformers.NetworkPolicyInformer
 
+	// Informer events are queued by object key and handled by one worker per queue.
+	podQueue *workQueue
+	nsQueue  *workQueue
+	npQueue  *workQueue
+
+	// Last objects programmed by the workers, keyed by object key.
+	// Each map is only accessed by the worker of its queue.
+	syncedPods       map[string]*corev1.Pod
+	syncedNamespaces map[string]*corev1.Namespace
+	syncedNwPolicies map[string]*networkingv1.NetworkPolicy
+
+	// Objects whose add failed, keyed by object key. Their ipsets may be partially programmed,
+	// so they're cleaned up before being retried as adds. Each map is only accessed by the worker of its queue.
+	failedPods       map[string]*corev1.Pod
+	failedNamespaces map[string]*corev1.Namespace
+
 	nodeName               string
+	backend                string
 	nsMap                  map[string]*namespace
 	isAzureNpmChainCreated bool
+	isIPv6Enabled          bool
+	isDropLoggingEnabled   bool
+	isAuditMode            bool
 
 	clusterState  telemetry.ClusterState
 	reportManager *telemetry.NPMReportManager
@@ -45,20 +76,38 @@ type NetworkPolicyManager struct {
 
 // GetClusterState returns current cluster state.
 func (npMgr *NetworkPolicyManager) GetClusterState() telemetry.ClusterState {
+	npMgr.Lock()
+	defer npMgr.Unlock()
+
 	return npMgr.clusterState
 }
 
-// UpdateAndSendReport updates the npm report then send it.
+// copyReportManager returns a report manager sending a copy of the npm report.
 // This function should only be called when npMgr is locked.
+func (npMgr *NetworkPolicyManager) copyReportManager() *telemetry.NPMReportManager {
+	report := *npMgr.reportManager.Report
+
+	return &telemetry.NPMReportManager{
+		ReportManager: npMgr.reportManager.ReportManager,
+		Report:        &report,
+	}
+}
+
+// UpdateAndSendReport updates the npm report then send it.
+// The report is sent after npMgr is unlocked, so that events don't wait for telemetry.
 func (npMgr *NetworkPolicyManager) UpdateAndSendReport(err error, eventMsg string) error {
-	npMgr.reportManager.Report.ClusterState = npMgr.GetClusterState()
+	npMgr.Lock()
+	npMgr.reportManager.Report.ClusterState = npMgr.clusterState
 	npMgr.reportManager.Report.EventMessage = eventMsg
 
 	if err != nil {
 		npMgr.reportManager.Report.ErrorMessage = err.Error()
 	}
 
-	return npMgr.reportManager.SendReport()
+	reportManager := npMgr.copyReportManager()
+	npMgr.Unlock()
+
+	return reportManager.SendReport()
 }
 
 // Run starts shared informers and waits for the shared informer cache to sync.
@@ -79,14 +128,40 @@ func (npMgr *NetworkPolicyManager) Run(stopCh <-chan struct{}) error {
 		return fmt.Errorf("Namespace informer failed to sync")
 	}
 
+	go runWorker(npMgr.podQueue, "pod", npMgr.syncPod)
+	go runWorker(npMgr.nsQueue, "namespace", npMgr.syncNamespace)
+	go runWorker(npMgr.npQueue, "network policy", npMgr.syncNetworkPolicy)
+
+	go func() {
+		<-stopCh
+		npMgr.podQueue.shutDownQueue()
+		npMgr.nsQueue.shutDownQueue()
+		npMgr.npQueue.shutDownQueue()
+	}()
+
 	return nil
 }
 
+// enqueue adds the key of an informer object to a queue.
+func enqueue(q *workQueue, obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Printf("Error getting key of object %+v: %v\n", obj, err)
+		return
+	}
+
+	q.add(key)
+}
+
 // RunReportManager starts NPMReportManager and send telemetry periodically.
 func (npMgr *NetworkPolicyManager) RunReportManager() {
 	for {
-		npMgr.reportManager.Report.ClusterState = npMgr.GetClusterState()
-		if err := npMgr.reportManager.SendReport(); err != nil {
+		npMgr.Lock()
+		npMgr.reportManager.Report.ClusterState = npMgr.clusterState
+		reportManager := npMgr.copyReportManager()
+		npMgr.Unlock()
+
+		if err := reportManager.SendReport(); err != nil {
 			log.Printf("Error sending NPM telemetry report")
 		}
 
@@ -94,22 +169,34 @@ func (npMgr *NetworkPolicyManager) RunReportManager() {
 	}
 }
 
-// NewNetworkPolicyManager creates a NetworkPolicyManager
-func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory, npmVersion string) *NetworkPolicyManager {
+// NewNetworkPolicyManager creates a NetworkPolicyManager programming rules and sets through the given backend.
+func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory informers.SharedInformerFactory, npmVersion string, backend string) *NetworkPolicyManager {
 
 	podInformer := informerFactory.Core().V1().Pods()
 	nsInformer := informerFactory.Core().V1().Namespaces()
 	npInformer := informerFactory.Networking().V1().NetworkPolicies()
 
 	npMgr := &NetworkPolicyManager{
-		clientset:       clientset,
-		informerFactory: informerFactory,
-		podInformer:     podInformer,
-		nsInformer:      nsInformer,
-		npInformer:      npInformer,
-		nodeName:        os.Getenv("HOSTNAME"),
-		nsMap:           make(map[string]*namespace),
+		clientset:              clientset,
+		informerFactory:        informerFactory,
+		podInformer:            podInformer,
+		nsInformer:             nsInformer,
+		npInformer:             npInformer,
+		podQueue:               newWorkQueue(),
+		nsQueue:                newWorkQueue(),
+		npQueue:                newWorkQueue(),
+		syncedPods:             make(map[string]*corev1.Pod),
+		syncedNamespaces:       make(map[string]*corev1.Namespace),
+		syncedNwPolicies:       make(map[string]*networkingv1.NetworkPolicy),
+		failedPods:             make(map[string]*corev1.Pod),
+		failedNamespaces:       make(map[string]*corev1.Namespace),
+		nodeName:               os.Getenv("HOSTNAME"),
+		backend:                backend,
+		nsMap:                  make(map[string]*namespace),
 		isAzureNpmChainCreated: false,
+		isIPv6Enabled:          util.IsIPv6Enabled(),
+		isDropLoggingEnabled:   os.Getenv(util.DropLoggingEnvVariable) == "true",
+		isAuditMode:            os.Getenv(util.AuditModeEnvVariable) == "true",
 		clusterState: telemetry.ClusterState{
 			PodCount:      0,
 			NsCount:       0,
@@ -139,19 +226,26 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 		log.Printf("Error creating all-namespace")
 		panic(err.Error)
 	}
+	// Rules and sets are programmed by the managers of the all-namespace, through the selected backend.
+	allNs.ipsMgr = ipsm.NewIpsetManagerWithBackend(util.IPv4Family, backend)
+	allNs.iptMgr = iptm.NewIptablesManagerWithBackend(util.IPv4Family, backend)
+	allNs.ipsMgrV6 = ipsm.NewIpsetManagerWithBackend(util.IPv6Family, backend)
+	allNs.iptMgrV6 = iptm.NewIptablesManagerWithBackend(util.IPv6Family, backend)
 	npMgr.nsMap[util.KubeAllNamespacesFlag] = allNs
 
+	// Events only queue the key of their object, so that rapid updates of an object are coalesced
+	// and failures are retried with the latest state of the object.
 	podInformer.Informer().AddEventHandler(
 		// Pod event handlers
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				npMgr.AddPod(obj.(*corev1.Pod))
+				enqueue(npMgr.podQueue, obj)
 			},
 			UpdateFunc: func(old, new interface{}) {
-				npMgr.UpdatePod(old.(*corev1.Pod), new.(*corev1.Pod))
+				enqueue(npMgr.podQueue, new)
 			},
 			DeleteFunc: func(obj interface{}) {
-				npMgr.DeletePod(obj.(*corev1.Pod))
+				enqueue(npMgr.podQueue, obj)
 			},
 		},
 	)
@@ -160,13 +254,13 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 		// Namespace event handlers
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				npMgr.AddNamespace(obj.(*corev1.Namespace))
+				enqueue(npMgr.nsQueue, obj)
 			},
 			UpdateFunc: func(old, new interface{}) {
-				npMgr.UpdateNamespace(old.(*corev1.Namespace), new.(*corev1.Namespace))
+				enqueue(npMgr.nsQueue, new)
 			},
 			DeleteFunc: func(obj interface{}) {
-				npMgr.DeleteNamespace(obj.(*corev1.Namespace))
+				enqueue(npMgr.nsQueue, obj)
 			},
 		},
 	)
@@ -175,13 +269,13 @@ func NewNetworkPolicyManager(clientset *kubernetes.Clientset, informerFactory in
 		// Network policy event handlers
 		cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
-				npMgr.AddNetworkPolicy(obj.(*networkingv1.NetworkPolicy))
+				enqueue(npMgr.npQueue, obj)
 			},
 			UpdateFunc: func(old, new interface{}) {
-				npMgr.UpdateNetworkPolicy(old.(*networkingv1.NetworkPolicy), new.(*networkingv1.NetworkPolicy))
+				enqueue(npMgr.npQueue, new)
 			},
 			DeleteFunc: func(obj interface{}) {
-				npMgr.DeleteNetworkPolicy(obj.(*networkingv1.NetworkPolicy))
+				enqueue(npMgr.npQueue, obj)
 			},
 		},
 	)
@@ -4,15 +4,86 @@ package npm
 
 import (
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/iptm"
 	"github.com/Azure/azure-container-networking/npm/util"
 	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/client-go/tools/cache"
 )
 
-// AddNetworkPolicy handles adding network policy to iptables.
-func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkPolicy) error {
+// parsePolicyWithDropLogging parses a network policy and logs its drops when drop logging or audit mode is enabled.
+func (npMgr *NetworkPolicyManager) parsePolicyWithDropLogging(npObj *networkingv1.NetworkPolicy) ([]string, []string, []*iptm.IptEntry) {
+	podSets, nsLists, iptEntries := parsePolicy(npObj)
+	if npMgr.isDropLoggingEnabled || npMgr.isAuditMode {
+		logPrefix := getDropLogPrefix(npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name)
+		iptEntries = addDropLogEntries(iptEntries, logPrefix, npMgr.isAuditMode)
+	}
+
+	return podSets, nsLists, iptEntries
+}
+
+// getNetworkPolicies returns the network policies added to NPM.
+func (npMgr *NetworkPolicyManager) getNetworkPolicies() []*networkingv1.NetworkPolicy {
 	npMgr.Lock()
 	defer npMgr.Unlock()
 
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	npObjs := make([]*networkingv1.NetworkPolicy, 0, len(allNs.npMap))
+	for _, npObj := range allNs.npMap {
+		npObjs = append(npObjs, npObj)
+	}
+
+	return npObjs
+}
+
+// createPolicyIpsets programs the ipsets referenced by a network policy in every IP family.
+func (npMgr *NetworkPolicyManager) createPolicyIpsets(npObj *networkingv1.NetworkPolicy, podSets, nsLists []string) error {
+	npMgr.ipsetLock.Lock()
+	defer npMgr.ipsetLock.Unlock()
+
+	npNs := npObj.ObjectMeta.Namespace
+
+	// Sets are created in every IP family so that rules of both stacks can reference them.
+	for _, ipsMgr := range npMgr.getIpsetManagers() {
+		// The default rules of azure-npm chains reference the kube-system ipset.
+		if !npMgr.isAzureNpmChainCreated {
+			if err := ipsMgr.CreateSet(util.KubeSystemFlag); err != nil {
+				log.Printf("Error initialize kube-system ipset.\n")
+				return err
+			}
+		}
+
+		for _, set := range podSets {
+			if err := ipsMgr.CreateSet(set); err != nil {
+				log.Printf("Error creating ipset %s-%s\n", npNs, set)
+				return err
+			}
+		}
+
+		for _, list := range nsLists {
+			if err := ipsMgr.CreateList(list); err != nil {
+				log.Printf("Error creating ipset list %s-%s\n", npNs, list)
+				return err
+			}
+		}
+	}
+
+	if err := npMgr.InitAllNsList(); err != nil {
+		log.Printf("Error initializing all-namespace ipset list.\n")
+		return err
+	}
+
+	for _, ipsMgr := range npMgr.getIpsetManagers() {
+		if err := ipsMgr.Apply(); err != nil {
+			log.Printf("Error applying ipset changes.\n")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddNetworkPolicy handles adding network policy to iptables.
+func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkPolicy) error {
 	var err error
 
 	defer func() {
@@ -21,64 +92,63 @@ func (npMgr *NetworkPolicyManager) AddNetworkPolicy(npObj *networkingv1.NetworkP
 		}
 	}()
 
+	// The report is sent once policyLock is released.
+	npMgr.policyLock.Lock()
+	defer npMgr.policyLock.Unlock()
+
 	npNs, npName := npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name
 	log.Printf("NETWORK POLICY CREATING: %s/%s\n", npNs, npName)
 
-	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
-
-	if !npMgr.isAzureNpmChainCreated {
-		if err = allNs.ipsMgr.CreateSet(util.KubeSystemFlag); err != nil {
-			log.Printf("Error initialize kube-system ipset.\n")
-			return err
-		}
-
-		if err = allNs.iptMgr.InitNpmChains(); err != nil {
-			log.Printf("Error initialize azure-npm chains.\n")
-			return err
-		}
-
-		npMgr.isAzureNpmChainCreated = true
+	// Policies of different namespaces can have the same name, so they're kept by namespace/name.
+	npKey, err := cache.MetaNamespaceKeyFunc(npObj)
+	if err != nil {
+		log.Printf("Error getting key of network policy %s/%s\n", npNs, npName)
+		return err
 	}
 
-	podSets, nsLists, iptEntries := parsePolicy(npObj)
+	podSets, nsLists, iptEntries := npMgr.parsePolicyWithDropLogging(npObj)
 
-	ipsMgr := allNs.ipsMgr
-	for _, set := range podSets {
-		if err = ipsMgr.CreateSet(set); err != nil {
-			log.Printf("Error creating ipset %s-%s\n", npNs, set)
-			return err
-		}
+	// Ipsets have to be programmed before the iptables rules referencing them.
+	if err = npMgr.createPolicyIpsets(npObj, podSets, nsLists); err != nil {
+		return err
 	}
 
-	for _, list := range nsLists {
-		if err = ipsMgr.CreateList(list); err != nil {
-			log.Printf("Error creating ipset list %s-%s\n", npNs, list)
-			return err
+	if !npMgr.isAzureNpmChainCreated {
+		for _, iptMgr := range npMgr.getIptablesManagers() {
+			if err = iptMgr.InitNpmChains(); err != nil {
+				log.Printf("Error initialize azure-npm chains.\n")
+				return err
+			}
 		}
+
+		npMgr.isAzureNpmChainCreated = true
 	}
 
-	if err = npMgr.InitAllNsList(); err != nil {
-		log.Printf("Error initializing all-namespace ipset list.\n")
+	// Rules with ipBlocks are only added to the iptables manager of their IP family.
+	iptMgrs := npMgr.getIptablesManagers()
+	if err = addPolicyRules(iptMgrs, iptEntries); err != nil {
 		return err
 	}
 
-	iptMgr := allNs.iptMgr
-	for _, iptEntry := range iptEntries {
-		if err = iptMgr.Add(iptEntry); err != nil {
-			log.Printf("Error applying iptables rule\n. Rule: %+v", iptEntry)
+	for _, iptMgr := range iptMgrs {
+		if err = iptMgr.Apply(); err != nil {
+			log.Printf("Error applying iptables rules.\n")
+			// The failed add is retried from scratch, the next Apply unprograms its rules.
+			deletePolicyRules(iptMgrs, iptEntries)
 			return err
 		}
 	}
 
-	allNs.npMap[npName] = npObj
-
-	npMgr.clusterState.NwPolicyCount++
-
 	ns, err := newNs(npNs)
 	if err != nil {
 		log.Printf("Error creating namespace %s\n", npNs)
 	}
+
+	npMgr.Lock()
+	npMgr.nsMap[util.KubeAllNamespacesFlag].npMap[npKey] = npObj
+	npMgr.clusterState.NwPolicyCount++
 	npMgr.nsMap[npNs] = ns
+	npMgr.Unlock()
 
 	return nil
 }
@@ -88,11 +158,9 @@ func (npMgr *NetworkPolicyManager) UpdateNetworkPolicy(oldNpObj *networkingv1.Ne
 	var err error
 
 	defer func() {
-		npMgr.Lock()
 		if err = npMgr.UpdateAndSendReport(err, util.UpdateNetworkPolicyEvent); err != nil {
 			log.Printf("Error sending NPM telemetry report")
 		}
-		npMgr.Unlock()
 	}()
 
 	oldNpNs, oldNpName := oldNpObj.ObjectMeta.Namespace, oldNpObj.ObjectMeta.Name
@@ -102,10 +170,12 @@ func (npMgr *NetworkPolicyManager) UpdateNetworkPolicy(oldNpObj *networkingv1.Ne
 		return err
 	}
 
-	if newNpObj.ObjectMeta.DeletionTimestamp == nil && newNpObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
-		if err = npMgr.AddNetworkPolicy(newNpObj); err != nil {
-			return err
-		}
+	if isNetworkPolicyDeleting(newNpObj) {
+		return nil
+	}
+
+	if err = npMgr.AddNetworkPolicy(newNpObj); err != nil {
+		return err
 	}
 
 	return nil
@@ -113,9 +183,6 @@ func (npMgr *NetworkPolicyManager) UpdateNetworkPolicy(oldNpObj *networkingv1.Ne
 
 // DeleteNetworkPolicy handles deleting network policy from iptables.
 func (npMgr *NetworkPolicyManager) DeleteNetworkPolicy(npObj *networkingv1.NetworkPolicy) error {
-	npMgr.Lock()
-	defer npMgr.Unlock()
-
 	var err error
 
 	defer func() {
@@ -124,32 +191,118 @@ func (npMgr *NetworkPolicyManager) DeleteNetworkPolicy(npObj *networkingv1.Netwo
 		}
 	}()
 
+	// The report is sent once policyLock is released.
+	npMgr.policyLock.Lock()
+	defer npMgr.policyLock.Unlock()
+
 	npNs, npName := npObj.ObjectMeta.Namespace, npObj.ObjectMeta.Name
 	log.Printf("NETWORK POLICY DELETING: %s/%s\n", npNs, npName)
 
-	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	npKey, err := cache.MetaNamespaceKeyFunc(npObj)
+	if err != nil {
+		log.Printf("Error getting key of network policy %s/%s\n", npNs, npName)
+		return err
+	}
+
+	_, _, iptEntries := npMgr.parsePolicyWithDropLogging(npObj)
 
-	_, _, iptEntries := parsePolicy(npObj)
+	iptMgrs := npMgr.getIptablesManagers()
+	deletePolicyRules(iptMgrs, iptEntries)
 
-	iptMgr := allNs.iptMgr
-	for _, iptEntry := range iptEntries {
-		if err = iptMgr.Delete(iptEntry); err != nil {
-			log.Printf("Error applying iptables rule.\n Rule: %+v", iptEntry)
+	for _, iptMgr := range iptMgrs {
+		if err = iptMgr.Apply(); err != nil {
+			log.Printf("Error applying iptables rules.\n")
 			return err
 		}
 	}
 
-	delete(allNs.npMap, npName)
-
+	npMgr.Lock()
+	allNs := npMgr.nsMap[util.KubeAllNamespacesFlag]
+	delete(allNs.npMap, npKey)
 	npMgr.clusterState.NwPolicyCount--
+	npCount := len(allNs.npMap)
+	npMgr.Unlock()
+
+	if npCount == 0 {
+		for _, iptMgr := range iptMgrs {
+			if err = iptMgr.UninitNpmChains(); err != nil {
+				log.Printf("Error uninitialize azure-npm chains.\n")
+				return err
+			}
+		}
+		npMgr.isAzureNpmChainCreated = false
+	}
 
-	if len(allNs.npMap) == 0 {
-		if err = iptMgr.UninitNpmChains(); err != nil {
-			log.Printf("Error uninitialize azure-npm chains.\n")
+	return nil
+}
+
+// addPolicyRules adds the rules of a network policy to the iptables managers.
+// Rules are reference counted, so the rules added before a failure are deleted again.
+func addPolicyRules(iptMgrs []*iptm.IptablesManager, iptEntries []*iptm.IptEntry) error {
+	for i, iptMgr := range iptMgrs {
+		for j, iptEntry := range iptEntries {
+			if err := iptMgr.Add(iptEntry); err != nil {
+				log.Printf("Error applying iptables rule\n. Rule: %+v", iptEntry)
+				deletePolicyRules(iptMgrs[:i], iptEntries)
+				deletePolicyRules(iptMgrs[i:i+1], iptEntries[:j])
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deletePolicyRules deletes the rules of a network policy from the iptables managers.
+func deletePolicyRules(iptMgrs []*iptm.IptablesManager, iptEntries []*iptm.IptEntry) {
+	for _, iptMgr := range iptMgrs {
+		for _, iptEntry := range iptEntries {
+			iptMgr.Delete(iptEntry)
+		}
+	}
+}
+
+// isNetworkPolicyDeleting checks whether a network policy is being deleted, in which case it's no longer programmed.
+func isNetworkPolicyDeleting(npObj *networkingv1.NetworkPolicy) bool {
+	return npObj.ObjectMeta.DeletionTimestamp != nil || npObj.ObjectMeta.DeletionGracePeriodSeconds != nil
+}
+
+// syncNetworkPolicy programs the iptables rules of a network policy from its latest state in the informer cache.
+// Changed policies are deleted then added again. A failed add rolls back its rules and isn't synced,
+// so it's retried as an add and the cluster state only counts added policies.
+func (npMgr *NetworkPolicyManager) syncNetworkPolicy(key string) error {
+	obj, exists, err := npMgr.npInformer.Informer().GetIndexer().GetByKey(key)
+	if err != nil {
+		log.Printf("Error getting network policy %s from informer cache.\n", key)
+		return err
+	}
+
+	if oldNpObj, isSynced := npMgr.syncedNwPolicies[key]; isSynced {
+		if exists && oldNpObj.ObjectMeta.ResourceVersion == obj.(*networkingv1.NetworkPolicy).ObjectMeta.ResourceVersion {
+			return nil
+		}
+
+		if err = npMgr.DeleteNetworkPolicy(oldNpObj); err != nil {
 			return err
 		}
-		npMgr.isAzureNpmChainCreated = false
+
+		delete(npMgr.syncedNwPolicies, key)
+	}
+
+	if !exists {
+		return nil
 	}
 
+	npObj := obj.(*networkingv1.NetworkPolicy)
+	if isNetworkPolicyDeleting(npObj) {
+		return nil
+	}
+
+	if err = npMgr.AddNetworkPolicy(npObj); err != nil {
+		return err
+	}
+
+	npMgr.syncedNwPolicies[key] = npObj
+
 	return nil
 }
@@ -3,11 +3,14 @@
 package npm
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/npm/ipsm"
 	"github.com/Azure/azure-container-networking/npm/iptm"
 	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/Azure/azure-container-networking/telemetry"
 
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
@@ -268,3 +271,127 @@ func TestDeleteNetworkPolicy(t *testing.T) {
 		t.Errorf("TestAddNetworkPolicy failed @ DeleteNetworkPolicy")
 	}
 }
+
+func TestAddDeleteNetworkPolicyWithDropLogging(t *testing.T) {
+	// Telemetry reports are sent to a local server.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	testCases := []struct {
+		name                 string
+		isDropLoggingEnabled bool
+		isAuditMode          bool
+	}{
+		{"drop logging", true, false},
+		{"audit mode", false, true},
+		{"drop logging in audit mode", true, true},
+	}
+
+	tcp := corev1.ProtocolTCP
+	allow := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "allow-ingress",
+			Namespace: "test-nwpolicy",
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				networkingv1.NetworkPolicyIngressRule{
+					From: []networkingv1.NetworkPolicyPeer{{
+						PodSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": "test"},
+						},
+					}},
+					Ports: []networkingv1.NetworkPolicyPort{{
+						Protocol: &tcp,
+						Port: &intstr.IntOrString{
+							StrVal: "8000",
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		npMgr := &NetworkPolicyManager{
+			nsMap:                make(map[string]*namespace),
+			isDropLoggingEnabled: tc.isDropLoggingEnabled,
+			isAuditMode:          tc.isAuditMode,
+			reportManager: &telemetry.NPMReportManager{
+				ReportManager: &telemetry.ReportManager{HostNetAgentURL: server.URL},
+				Report:        &telemetry.NPMReport{},
+			},
+		}
+
+		// The rules of the policy log drops, and only drop outside of audit mode.
+		_, _, iptEntries := npMgr.parsePolicyWithDropLogging(allow)
+		logEntries, dropEntries := 0, 0
+		for _, entry := range iptEntries {
+			for _, spec := range entry.Specs {
+				if spec == util.IptablesLog {
+					logEntries++
+				}
+			}
+			if isDropEntry(entry) {
+				dropEntries++
+			}
+		}
+
+		if logEntries == 0 {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ %s: no LOG rules", tc.name)
+		}
+
+		if tc.isAuditMode && dropEntries != 0 {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ %s: %d DROP rules in audit mode", tc.name, dropEntries)
+		}
+
+		if !tc.isAuditMode && dropEntries != logEntries {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ %s: %d DROP rules for %d LOG rules", tc.name, dropEntries, logEntries)
+		}
+
+		allNs, err := newNs(util.KubeAllNamespacesFlag)
+		if err != nil {
+			panic(err.Error)
+		}
+		npMgr.nsMap[util.KubeAllNamespacesFlag] = allNs
+
+		iptMgr := iptm.NewIptablesManager()
+		if err := iptMgr.Save(util.IptablesTestConfigFile); err != nil {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ iptMgr.Save")
+		}
+
+		ipsMgr := ipsm.NewIpsetManager()
+		if err := ipsMgr.Save(util.IpsetTestConfigFile); err != nil {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ ipsMgr.Save")
+		}
+
+		nsObj := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test-nwpolicy",
+				Labels: map[string]string{
+					"app": "test-namespace",
+				},
+			},
+		}
+
+		if err := npMgr.AddNamespace(nsObj); err != nil {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging @ npMgr.AddNamespace")
+		}
+
+		if err := npMgr.AddNetworkPolicy(allow); err != nil {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ %s: AddNetworkPolicy", tc.name)
+		}
+
+		if err := npMgr.DeleteNetworkPolicy(allow); err != nil {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ %s: DeleteNetworkPolicy", tc.name)
+		}
+
+		if err := iptMgr.Restore(util.IptablesTestConfigFile); err != nil {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ iptMgr.Restore")
+		}
+
+		if err := ipsMgr.Restore(util.IpsetTestConfigFile); err != nil {
+			t.Errorf("TestAddDeleteNetworkPolicyWithDropLogging failed @ ipsMgr.Restore")
+		}
+	}
+}
@@ -4,604 +4,724 @@ package npm
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/npm/iptm"
 	"github.com/Azure/azure-container-networking/npm/util"
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // azureNpmPrefix defines prefix for ipset.
 const azureNpmPrefix string = "azure-npm-"
 
 type portsInfo struct {
-	protocol string
-	port     string
+	protocol     string
+	port         string // empty to match all ports of the protocol, low:high for a range.
+	namedPortSet string // ipset of the pods exposing a named port.
 }
 
-func parseIngress(ns string, targetSets []string, rules []networkingv1.NetworkPolicyIngressRule) ([]string, []string, []*iptm.IptEntry) {
-	var (
-		portRuleExists    = false
-		fromRuleExists    = false
-		isAppliedToNs     = false
-		protPortPairSlice []*portsInfo
-		PodNsRuleSets     []string // pod sets listed in Ingress rules.
-		nsRuleLists       []string // namespace sets listed in Ingress rules
-		entries           []*iptm.IptEntry
-		ipblock           *networkingv1.IPBlock
-	)
+// parsePort translates a network policy port into the protocol and port to match.
+// The protocol defaults to TCP and a port without a number or name matches all ports of the protocol.
+// A numbered port with an end port matches the range of ports between them.
+func parsePort(portRule networkingv1.NetworkPolicyPort) *portsInfo {
+	portInfo := &portsInfo{
+		protocol: string(corev1.ProtocolTCP),
+	}
 
-	if len(targetSets) == 0 {
-		targetSets = append(targetSets, ns)
-		isAppliedToNs = true
+	if portRule.Protocol != nil {
+		portInfo.protocol = string(*portRule.Protocol)
 	}
 
-	for _, rule := range rules {
-		for _, portRule := range rule.Ports {
-			protPortPairSlice = append(protPortPairSlice,
-				&portsInfo{
-					protocol: string(*portRule.Protocol),
-					port:     fmt.Sprint(portRule.Port.IntVal),
-				})
-
-			portRuleExists = true
-		}
+	if portRule.Port == nil {
+		return portInfo
+	}
 
-		for _, fromRule := range rule.From {
-			if fromRule.PodSelector != nil {
-				if len(fromRule.PodSelector.MatchLabels) == 0 {
-					PodNsRuleSets = append(PodNsRuleSets, ns)
-				}
+	if portRule.Port.Type == intstr.String {
+		portInfo.namedPortSet = getNamedPortIpsetName(portRule.Port.StrVal)
+		return portInfo
+	}
 
-				for podLabelKey, podLabelVal := range fromRule.PodSelector.MatchLabels {
-					PodNsRuleSets = append(PodNsRuleSets, util.KubeAllNamespacesFlag+"-"+podLabelKey+":"+podLabelVal)
-				}
-			}
+	portInfo.port = fmt.Sprint(portRule.Port.IntVal)
 
-			if fromRule.NamespaceSelector != nil {
-				if len(fromRule.NamespaceSelector.MatchLabels) == 0 {
-					nsRuleLists = append(nsRuleLists, util.KubeAllNamespacesFlag)
-				}
+	if portRule.EndPort == nil || *portRule.EndPort == portRule.Port.IntVal {
+		return portInfo
+	}
 
-				for nsLabelKey, nsLabelVal := range fromRule.NamespaceSelector.MatchLabels {
-					nsRuleLists = append(nsRuleLists, "ns-"+nsLabelKey+":"+nsLabelVal)
-				}
-			}
+	if *portRule.EndPort < portRule.Port.IntVal {
+		log.Printf("Ignoring end port %d lower than port %d.", *portRule.EndPort, portRule.Port.IntVal)
+		return portInfo
+	}
 
-			if fromRule.IPBlock != nil {
-				ipblock = fromRule.IPBlock
-			}
+	portInfo.port = fmt.Sprintf("%d:%d", portRule.Port.IntVal, *portRule.EndPort)
 
-			fromRuleExists = true
+	return portInfo
+}
+
+// getPortSpecs returns iptables specs that match the destination port of a network policy port.
+// Named ports are matched against the ip,port pairs of the pods exposing them.
+func getPortSpecs(portInfo *portsInfo) []string {
+	if portInfo.namedPortSet != "" {
+		return []string{
+			util.IptablesProtFlag,
+			portInfo.protocol,
+			util.IptablesMatchFlag,
+			util.IptablesSetFlag,
+			util.IptablesMatchSetFlag,
+			util.GetHashedName(portInfo.namedPortSet),
+			util.IptablesDstDstFlag,
 		}
 	}
 
-	if isAppliedToNs {
-		hashedTargetSetName := util.GetHashedName(ns)
+	if portInfo.port == "" {
+		return []string{util.IptablesProtFlag, portInfo.protocol}
+	}
 
-		nsDrop := &iptm.IptEntry{
-			Name:       ns,
-			HashedName: hashedTargetSetName,
-			Chain:      util.IptablesAzureTargetSetsChain,
-			Specs: []string{
-				util.IptablesMatchFlag,
-				util.IptablesSetFlag,
-				util.IptablesMatchSetFlag,
-				hashedTargetSetName,
-				util.IptablesDstFlag,
-				util.IptablesJumpFlag,
-				util.IptablesDrop,
-			},
+	return []string{
+		util.IptablesProtFlag,
+		portInfo.protocol,
+		util.IptablesDstPortFlag,
+		portInfo.port,
+	}
+}
+
+// getMultiPortSpecs returns iptables specs that match any of the ports of a protocol.
+// A multiport match holds up to 15 ports, where a range takes two of them,
+// so longer lists are split over several specs.
+func getMultiPortSpecs(protocol string, ports []string) [][]string {
+	var (
+		result [][]string
+		chunk  []string
+		slots  int
+	)
+
+	appendSpec := func() {
+		result = append(result, []string{
+			util.IptablesProtFlag,
+			protocol,
+			util.IptablesMatchFlag,
+			util.IptablesMultiportFlag,
+			util.IptablesDstPortsFlag,
+			strings.Join(chunk, ","),
+		})
+	}
+
+	for _, port := range ports {
+		size := 1
+		if strings.Contains(port, ":") {
+			size = 2
 		}
-		entries = append(entries, nsDrop)
+
+		if slots+size > util.IptablesMultiportMaxPorts {
+			appendSpec()
+			chunk, slots = nil, 0
+		}
+
+		chunk = append(chunk, port)
+		slots += size
 	}
 
-	// Use hashed string for ipset name to avoid string length limit of ipset.
-	for _, targetSet := range targetSets {
-		log.Printf("Parsing iptables for label %s", targetSet)
+	if len(chunk) > 0 {
+		appendSpec()
+	}
 
-		hashedTargetSetName := util.GetHashedName(targetSet)
+	return result
+}
 
-		if len(rules) == 0 {
-			drop := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureIngressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesDrop,
-				},
-			}
-			entries = append(entries, drop)
+// getRulePortSpecs returns the alternatives of iptables specs that match the ports of a rule.
+// Numbered ports and port ranges of the same protocol are matched by a single multiport match.
+// A rule without ports has a single nil alternative that matches all ports.
+func getRulePortSpecs(portInfos []*portsInfo) [][]string {
+	if len(portInfos) == 0 {
+		return [][]string{nil}
+	}
+
+	var (
+		result    [][]string
+		protocols []string
+		ports     = make(map[string][]string)
+	)
+
+	for _, portInfo := range portInfos {
+		if portInfo.namedPortSet != "" || portInfo.port == "" {
+			result = append(result, getPortSpecs(portInfo))
 			continue
 		}
 
-		if !portRuleExists && !fromRuleExists {
-			allow := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureIngressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
-			}
-			entries = append(entries, allow)
+		if _, exists := ports[portInfo.protocol]; !exists {
+			protocols = append(protocols, portInfo.protocol)
+		}
+		ports[portInfo.protocol] = append(ports[portInfo.protocol], portInfo.port)
+	}
+
+	for _, protocol := range protocols {
+		if len(ports[protocol]) == 1 {
+			result = append(result, []string{
+				util.IptablesProtFlag,
+				protocol,
+				util.IptablesDstPortFlag,
+				ports[protocol][0],
+			})
 			continue
 		}
 
-		if !portRuleExists {
-			entry := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureIngressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAzureIngressFromChain,
-				},
-			}
-			entries = append(entries, entry)
+		result = append(result, getMultiPortSpecs(protocol, ports[protocol])...)
+	}
+
+	return result
+}
+
+// setMatch represents a match against an ipset, which is negated for NotIn and DoesNotExist selectors.
+type setMatch struct {
+	set     string
+	negated bool
+}
+
+// appendMatches returns a new slice holding the matches of both slices.
+func appendMatches(base []setMatch, matches ...setMatch) []setMatch {
+	result := make([]setMatch, 0, len(base)+len(matches))
+	result = append(result, base...)
+	return append(result, matches...)
+}
+
+// hasPositiveMatch checks whether any of the matches is not negated.
+func hasPositiveMatch(matches []setMatch) bool {
+	for _, match := range matches {
+		if !match.negated {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getSetMatchName returns a readable name for a set of matches.
+func getSetMatchName(matches []setMatch) string {
+	var names []string
+	for _, match := range matches {
+		if match.negated {
+			names = append(names, util.IptablesNotFlag+match.set)
 		} else {
-			for _, protPortPair := range protPortPairSlice {
-				entry := &iptm.IptEntry{
-					Name:       targetSet,
-					HashedName: hashedTargetSetName,
-					Chain:      util.IptablesAzureIngressPortChain,
-					Specs: []string{
-						util.IptablesProtFlag,
-						protPortPair.protocol,
-						util.IptablesDstPortFlag,
-						protPortPair.port,
-						util.IptablesMatchFlag,
-						util.IptablesSetFlag,
-						util.IptablesMatchSetFlag,
-						hashedTargetSetName,
-						util.IptablesDstFlag,
-						util.IptablesJumpFlag,
-						util.IptablesAzureIngressFromChain,
-					},
-				}
-				entries = append(entries, entry)
-			}
+			names = append(names, match.set)
 		}
+	}
 
-		if !fromRuleExists {
-			entry := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureIngressFromChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
-			}
-			entries = append(entries, entry)
-			continue
+	return strings.Join(names, ",")
+}
+
+// getSetMatchSpecs returns iptables specs that match when all the matches hold in the given direction.
+func getSetMatchSpecs(matches []setMatch, direction string) []string {
+	var specs []string
+	for _, match := range matches {
+		specs = append(specs, util.IptablesMatchFlag, util.IptablesSetFlag)
+		if match.negated {
+			specs = append(specs, util.IptablesNotFlag)
 		}
+		// Use hashed string for ipset name to avoid string length limit of ipset.
+		specs = append(specs, util.IptablesMatchSetFlag, util.GetHashedName(match.set), direction)
+	}
 
-		if ipblock != nil {
-			// Handle ipblock field of NetworkPolicyPeer
-			if len(ipblock.Except) > 0 {
-				for _, except := range ipblock.Except {
-					entry := &iptm.IptEntry{
-						Chain: util.IptablesAzureIngressFromChain,
-						Specs: []string{
-							util.IptablesMatchFlag,
-							util.IptablesSetFlag,
-							util.IptablesMatchSetFlag,
-							hashedTargetSetName,
-							util.IptablesDstFlag,
-							util.IptablesSFlag,
-							except,
-							util.IptablesJumpFlag,
-							util.IptablesDrop,
-						},
-					}
-					entries = append(entries, entry)
-				}
-			}
+	return specs
+}
+
+// parseSelector translates a label selector into alternatives of ipset matches.
+// An object is selected when all matches of any of the alternatives hold.
+// getLabelSet and getKeySet return the ipsets of objects with a given label and label key.
+func parseSelector(
+	selector *metav1.LabelSelector,
+	getLabelSet func(string, string) string,
+	getKeySet func(string) string) ([]string, [][]setMatch) {
+	var (
+		sets         []string
+		matches      []setMatch
+		alternatives = [][]setMatch{nil}
+	)
+
+	// Sort label keys so that the same selector always translates to the same iptables rules.
+	var labelKeys []string
+	for labelKey := range selector.MatchLabels {
+		labelKeys = append(labelKeys, labelKey)
+	}
+	sort.Strings(labelKeys)
 
-			if len(ipblock.CIDR) > 0 {
-				cidrEntry := &iptm.IptEntry{
-					Chain: util.IptablesAzureIngressFromChain,
-					Specs: []string{
-						util.IptablesMatchFlag,
-						util.IptablesSetFlag,
-						util.IptablesMatchSetFlag,
-						hashedTargetSetName,
-						util.IptablesDstFlag,
-						util.IptablesSFlag,
-						ipblock.CIDR,
-						util.IptablesJumpFlag,
-						util.IptablesAccept,
-					},
+	for _, labelKey := range labelKeys {
+		set := getLabelSet(labelKey, selector.MatchLabels[labelKey])
+		sets = append(sets, set)
+		matches = append(matches, setMatch{set: set})
+	}
+
+	for _, req := range selector.MatchExpressions {
+		switch req.Operator {
+		case metav1.LabelSelectorOpIn:
+			// Any of the values selects the object, so every alternative is expanded once per value.
+			var expanded [][]setMatch
+			for _, alternative := range alternatives {
+				for _, value := range req.Values {
+					set := getLabelSet(req.Key, value)
+					sets = append(sets, set)
+					expanded = append(expanded, appendMatches(alternative, setMatch{set: set}))
 				}
-				entries = append(entries, cidrEntry)
 			}
-		}
-
-		// Handle PodSelector field of NetworkPolicyPeer.
-		for _, podRuleSet := range PodNsRuleSets {
-			hashedRuleSetName := util.GetHashedName(podRuleSet)
-			entry := &iptm.IptEntry{
-				Name:       podRuleSet,
-				HashedName: hashedRuleSetName,
-				Chain:      util.IptablesAzureIngressFromChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedRuleSetName,
-					util.IptablesSrcFlag,
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
+			alternatives = expanded
+		case metav1.LabelSelectorOpNotIn:
+			for _, value := range req.Values {
+				set := getLabelSet(req.Key, value)
+				sets = append(sets, set)
+				matches = append(matches, setMatch{set: set, negated: true})
 			}
-			entries = append(entries, entry)
+		case metav1.LabelSelectorOpExists:
+			set := getKeySet(req.Key)
+			sets = append(sets, set)
+			matches = append(matches, setMatch{set: set})
+		case metav1.LabelSelectorOpDoesNotExist:
+			set := getKeySet(req.Key)
+			sets = append(sets, set)
+			matches = append(matches, setMatch{set: set, negated: true})
+		default:
+			log.Printf("Ignoring label selector requirement with unknown operator %+v", req)
 		}
+	}
 
-		// Handle NamespaceSelector field of NetworkPolicyPeer
-		for _, nsRuleSet := range nsRuleLists {
-			hashedRuleSetName := util.GetHashedName(nsRuleSet)
-			entry := &iptm.IptEntry{
-				Name:       nsRuleSet,
-				HashedName: hashedRuleSetName,
-				Chain:      util.IptablesAzureIngressFromChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedRuleSetName,
-					util.IptablesSrcFlag,
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
-			}
-			entries = append(entries, entry)
+	for i, alternative := range alternatives {
+		alternatives[i] = appendMatches(matches, alternative...)
+	}
+
+	return util.UniqueStrSlice(sets), alternatives
+}
+
+// isEmptySelector checks whether a label selector selects all objects.
+func isEmptySelector(selector *metav1.LabelSelector) bool {
+	return len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0
+}
+
+// parsePodSelector translates a pod selector into alternatives of ipset matches of pods in namespace ns.
+func parsePodSelector(ns string, selector *metav1.LabelSelector) ([]string, [][]setMatch) {
+	sets, alternatives := parseSelector(selector, getPodIpsetName, getPodKeyIpsetName)
+
+	// Pod label ipsets span all namespaces, so anchor every alternative to the namespace's ipset.
+	for i, alternative := range alternatives {
+		alternatives[i] = appendMatches([]setMatch{{set: ns}}, alternative...)
+	}
+
+	return append(sets, ns), alternatives
+}
+
+// parseNsSelector translates a namespace selector into alternatives of matches against namespace ipset lists.
+func parseNsSelector(selector *metav1.LabelSelector) ([]string, [][]setMatch) {
+	lists, alternatives := parseSelector(selector, getNsIpsetName, getNsKeyIpsetName)
+
+	// Negated matches alone would also match addresses outside of the cluster.
+	for i, alternative := range alternatives {
+		if !hasPositiveMatch(alternative) {
+			alternatives[i] = appendMatches([]setMatch{{set: util.KubeAllNamespacesFlag}}, alternative...)
+			lists = append(lists, util.KubeAllNamespacesFlag)
 		}
 	}
 
-	return PodNsRuleSets, nsRuleLists, entries
+	return util.UniqueStrSlice(lists), alternatives
 }
 
-func parseEgress(ns string, targetSets []string, rules []networkingv1.NetworkPolicyEgressRule) ([]string, []string, []*iptm.IptEntry) {
+// parsePeer translates a network policy peer into alternatives of ipset matches.
+// It returns the pod ipsets and namespace ipset lists referenced by the matches.
+func parsePeer(ns string, peer networkingv1.NetworkPolicyPeer) ([]string, []string, [][]setMatch) {
 	var (
-		portRuleExists    = false
-		toRuleExists      = false
-		isAppliedToNs     = false
-		protPortPairSlice []*portsInfo
-		PodNsRuleSets     []string // pod sets listed in Egress rules.
-		nsRuleLists       []string // namespace sets listed in Egress rules
-		entries           []*iptm.IptEntry
-		ipblock           *networkingv1.IPBlock
+		podSets      []string
+		nsLists      []string
+		alternatives [][]setMatch
 	)
 
-	if len(targetSets) == 0 {
-		targetSets = append(targetSets, ns)
-		isAppliedToNs = true
+	if peer.NamespaceSelector != nil {
+		nsLists, alternatives = parseNsSelector(peer.NamespaceSelector)
 	}
 
-	for _, rule := range rules {
-		for _, portRule := range rule.Ports {
-			protPortPairSlice = append(protPortPairSlice,
-				&portsInfo{
-					protocol: string(*portRule.Protocol),
-					port:     fmt.Sprint(portRule.Port.IntVal),
-				})
-
-			portRuleExists = true
+	if peer.PodSelector == nil {
+		return podSets, nsLists, alternatives
+	}
+
+	if peer.NamespaceSelector == nil {
+		// Without a namespace selector, pods are selected from the policy's namespace.
+		podSets, alternatives = parsePodSelector(ns, peer.PodSelector)
+		return podSets, nsLists, alternatives
+	}
+
+	// Pods have to match the pod selector and be in a namespace matching the namespace selector.
+	podSets, podAlternatives := parseSelector(peer.PodSelector, getPodIpsetName, getPodKeyIpsetName)
+
+	var combined [][]setMatch
+	for _, nsAlternative := range alternatives {
+		for _, podAlternative := range podAlternatives {
+			combined = append(combined, appendMatches(nsAlternative, podAlternative...))
 		}
+	}
 
-		for _, toRule := range rule.To {
-			if toRule.PodSelector != nil {
-				if len(toRule.PodSelector.MatchLabels) == 0 {
-					PodNsRuleSets = append(PodNsRuleSets, ns)
-				}
+	return podSets, nsLists, combined
+}
 
-				for podLabelKey, podLabelVal := range toRule.PodSelector.MatchLabels {
-					PodNsRuleSets = append(PodNsRuleSets, util.KubeAllNamespacesFlag+"-"+podLabelKey+":"+podLabelVal)
-				}
-			}
+// splitCIDR returns the two halves of a CIDR.
+func splitCIDR(ipNet *net.IPNet) (*net.IPNet, *net.IPNet) {
+	ones, bits := ipNet.Mask.Size()
+	mask := net.CIDRMask(ones+1, bits)
 
-			if toRule.NamespaceSelector != nil {
-				if len(toRule.NamespaceSelector.MatchLabels) == 0 {
-					nsRuleLists = append(nsRuleLists, util.KubeAllNamespacesFlag)
-				}
+	lower := &net.IPNet{IP: ipNet.IP.Mask(mask), Mask: mask}
 
-				for nsLabelKey, nsLabelVal := range toRule.NamespaceSelector.MatchLabels {
-					nsRuleLists = append(nsRuleLists, "ns-"+nsLabelKey+":"+nsLabelVal)
-				}
-			}
+	upperIP := make(net.IP, len(lower.IP))
+	copy(upperIP, lower.IP)
+	upperIP[ones/8] |= 0x80 >> uint(ones%8)
+	upper := &net.IPNet{IP: upperIP, Mask: mask}
 
-			if toRule.IPBlock != nil {
-				ipblock = toRule.IPBlock
-			}
+	return lower, upper
+}
 
-			toRuleExists = true
-		}
+// subtractCIDR returns the CIDRs covering the addresses of a CIDR that aren't in except.
+func subtractCIDR(ipNet *net.IPNet, except *net.IPNet) []*net.IPNet {
+	ones, _ := ipNet.Mask.Size()
+	exceptOnes, _ := except.Mask.Size()
+
+	switch {
+	case !ipNet.Contains(except.IP) && !except.Contains(ipNet.IP):
+		return []*net.IPNet{ipNet}
+	case exceptOnes <= ones:
+		return nil
 	}
 
-	if isAppliedToNs {
-		hashedTargetSetName := util.GetHashedName(ns)
+	lower, upper := splitCIDR(ipNet)
 
-		nsDrop := &iptm.IptEntry{
-			Name:       ns,
-			HashedName: hashedTargetSetName,
-			Chain:      util.IptablesAzureTargetSetsChain,
-			Specs: []string{
-				util.IptablesMatchFlag,
-				util.IptablesSetFlag,
-				util.IptablesMatchSetFlag,
-				hashedTargetSetName,
-				util.IptablesSrcFlag,
-				util.IptablesJumpFlag,
-				util.IptablesDrop,
-			},
+	return append(subtractCIDR(lower, except), subtractCIDR(upper, except)...)
+}
+
+// subtractCIDRs returns the CIDRs covering the addresses of an ipBlock's CIDR that aren't in its exceptions.
+// iptables can't match an address against a CIDR and several negated exceptions in a single rule,
+// and dropping exceptions in a chain shared with other peers would also drop traffic those peers allow.
+func subtractCIDRs(cidr string, excepts []string) []string {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		log.Printf("Error parsing ipBlock CIDR %s: %v\n", cidr, err)
+		return nil
+	}
+
+	remaining := []*net.IPNet{ipNet}
+	for _, except := range excepts {
+		_, exceptNet, err := net.ParseCIDR(except)
+		if err != nil || len(exceptNet.IP) != len(ipNet.IP) {
+			log.Printf("Ignoring ipBlock exception %s of CIDR %s\n", except, cidr)
+			continue
 		}
-		entries = append(entries, nsDrop)
+
+		var result []*net.IPNet
+		for _, r := range remaining {
+			result = append(result, subtractCIDR(r, exceptNet)...)
+		}
+		remaining = result
 	}
 
-	// Use hashed string for ipset name to avoid string length limit of ipset.
-	for _, targetSet := range targetSets {
-		hashedTargetSetName := util.GetHashedName(targetSet)
+	var cidrs []string
+	for _, r := range remaining {
+		cidrs = append(cidrs, r.String())
+	}
 
-		if len(rules) == 0 {
-			drop := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureEgressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesSrcFlag,
-					util.IptablesJumpFlag,
-					util.IptablesDrop,
-				},
+	return cidrs
+}
+
+// policyRule is an ingress or egress rule of a network policy.
+type policyRule struct {
+	ports []networkingv1.NetworkPolicyPort
+	peers []networkingv1.NetworkPolicyPeer
+}
+
+// peerInfo holds the iptables specs that match a network policy peer.
+type peerInfo struct {
+	name  string // name of the ipset matches, empty for ipBlocks.
+	specs []string
+}
+
+// ruleInfo holds the alternatives of iptables specs that match the ports and the peers of a rule.
+// A rule without ports matches all ports and a rule without peers matches all peers.
+type ruleInfo struct {
+	portSpecs [][]string
+	peers     []*peerInfo
+	allPeers  bool
+}
+
+// parseRule translates a rule into specs matching its ports and peers in the given direction.
+// It returns the pod ipsets and namespace ipset lists referenced by the specs.
+func parseRule(ns string, rule policyRule, peerDirection string, addressFlag string) ([]string, []string, *ruleInfo) {
+	var (
+		podSets   []string
+		nsLists   []string
+		portInfos []*portsInfo
+		info      = &ruleInfo{allPeers: len(rule.peers) == 0}
+	)
+
+	for _, portRule := range rule.ports {
+		portInfo := parsePort(portRule)
+		if portInfo.namedPortSet != "" {
+			podSets = append(podSets, portInfo.namedPortSet)
+		}
+		portInfos = append(portInfos, portInfo)
+	}
+	info.portSpecs = getRulePortSpecs(portInfos)
+
+	for _, peer := range rule.peers {
+		peerSets, peerLists, alternatives := parsePeer(ns, peer)
+		podSets = append(podSets, peerSets...)
+		nsLists = append(nsLists, peerLists...)
+
+		for _, alternative := range alternatives {
+			info.peers = append(info.peers, &peerInfo{
+				name:  getSetMatchName(alternative),
+				specs: getSetMatchSpecs(alternative, peerDirection),
+			})
+		}
+
+		if peer.IPBlock != nil {
+			for _, cidr := range subtractCIDRs(peer.IPBlock.CIDR, peer.IPBlock.Except) {
+				info.peers = append(info.peers, &peerInfo{specs: []string{addressFlag, cidr}})
 			}
-			entries = append(entries, drop)
-			continue
 		}
+	}
+
+	return podSets, nsLists, info
+}
 
-		if !portRuleExists && !toRuleExists {
+// getAllowEntries returns the entries that allow the traffic of rules to or from a target.
+// Packets to allowed ports of the target jump from the port chain to the peer chain,
+// where each rule accepts the packets of its peers to its own ports.
+func getAllowEntries(target []setMatch, targetDirection string, rules []*ruleInfo, portChain string, peerChain string) []*iptm.IptEntry {
+	var entries []*iptm.IptEntry
+
+	targetName := getSetMatchName(target)
+	hashedTargetSetName := util.GetHashedName(targetName)
+	targetSpecs := getSetMatchSpecs(target, targetDirection)
+
+	// A rule without ports and peers allows all traffic of the target.
+	for _, rule := range rules {
+		if rule.allPeers && len(rule.portSpecs) == 1 && rule.portSpecs[0] == nil {
 			allow := &iptm.IptEntry{
-				Name:       targetSet,
+				Name:       targetName,
 				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureEgressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesSrcFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
+				Chain:      portChain,
+				Specs:      append(targetSpecs, util.IptablesJumpFlag, util.IptablesAccept),
 			}
-			entries = append(entries, allow)
-			continue
+			return append(entries, allow)
 		}
+	}
 
-		if !portRuleExists {
-			entry := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureEgressPortChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAzureEgressToChain,
-				},
+	var (
+		portSpecs  [][]string
+		isPortSeen = make(map[string]bool)
+	)
+	for _, rule := range rules {
+		for _, specs := range rule.portSpecs {
+			if specs == nil {
+				// All ports are allowed to jump to the peer chain.
+				portSpecs = [][]string{nil}
+				break
 			}
-			entries = append(entries, entry)
-		} else {
-			for _, protPortPair := range protPortPairSlice {
-				entry := &iptm.IptEntry{
-					Name:       targetSet,
-					HashedName: hashedTargetSetName,
-					Chain:      util.IptablesAzureEgressPortChain,
-					Specs: []string{
-						util.IptablesProtFlag,
-						protPortPair.protocol,
-						util.IptablesDstPortFlag,
-						protPortPair.port,
-						util.IptablesMatchFlag,
-						util.IptablesSetFlag,
-						util.IptablesMatchSetFlag,
-						hashedTargetSetName,
-						util.IptablesSrcFlag,
-						util.IptablesJumpFlag,
-						util.IptablesAzureEgressToChain,
-					},
-				}
-				entries = append(entries, entry)
+
+			if key := strings.Join(specs, " "); !isPortSeen[key] {
+				isPortSeen[key] = true
+				portSpecs = append(portSpecs, specs)
 			}
 		}
 
-		if !toRuleExists {
-			entry := &iptm.IptEntry{
-				Name:       targetSet,
-				HashedName: hashedTargetSetName,
-				Chain:      util.IptablesAzureEgressToChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
-			}
-			entries = append(entries, entry)
-			continue
+		if len(portSpecs) == 1 && portSpecs[0] == nil {
+			break
 		}
+	}
 
-		if ipblock != nil {
-			// Handle ipblock field of NetworkPolicyPeer
-			if len(ipblock.Except) > 0 {
-				for _, except := range ipblock.Except {
-					entry := &iptm.IptEntry{
-						Chain: util.IptablesAzureEgressToChain,
-						Specs: []string{
-							util.IptablesMatchFlag,
-							util.IptablesSetFlag,
-							util.IptablesMatchSetFlag,
-							hashedTargetSetName,
-							util.IptablesSrcFlag,
-							util.IptablesDFlag,
-							except,
-							util.IptablesJumpFlag,
-							util.IptablesDrop,
-						},
-					}
-					entries = append(entries, entry)
-				}
+	for _, specs := range portSpecs {
+		var entrySpecs []string
+		entrySpecs = append(entrySpecs, specs...)
+		entrySpecs = append(entrySpecs, targetSpecs...)
+		entrySpecs = append(entrySpecs, util.IptablesJumpFlag, peerChain)
+
+		entry := &iptm.IptEntry{
+			Name:       targetName,
+			HashedName: hashedTargetSetName,
+			Chain:      portChain,
+			Specs:      entrySpecs,
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, rule := range rules {
+		peers := rule.peers
+		if rule.allPeers {
+			peers = []*peerInfo{{name: targetName}}
+		}
+
+		for _, peer := range peers {
+			name := peer.name
+			if name == "" {
+				name = targetName
 			}
 
-			if len(ipblock.CIDR) > 0 {
-				cidrEntry := &iptm.IptEntry{
-					Chain: util.IptablesAzureEgressToChain,
-					Specs: []string{
-						util.IptablesMatchFlag,
-						util.IptablesSetFlag,
-						util.IptablesMatchSetFlag,
-						hashedTargetSetName,
-						util.IptablesSrcFlag,
-						util.IptablesDFlag,
-						ipblock.CIDR,
-						util.IptablesJumpFlag,
-						util.IptablesAccept,
-					},
+			for _, specs := range rule.portSpecs {
+				var entrySpecs []string
+				entrySpecs = append(entrySpecs, peer.specs...)
+				entrySpecs = append(entrySpecs, specs...)
+				entrySpecs = append(entrySpecs, targetSpecs...)
+				entrySpecs = append(entrySpecs, util.IptablesJumpFlag, util.IptablesAccept)
+
+				entry := &iptm.IptEntry{
+					Name:       name,
+					HashedName: util.GetHashedName(name),
+					Chain:      peerChain,
+					Specs:      entrySpecs,
 				}
-				entries = append(entries, cidrEntry)
+				entries = append(entries, entry)
 			}
 		}
+	}
 
-		// Handle PodSelector field of NetworkPolicyPeer.
-		for _, podRuleSet := range PodNsRuleSets {
-			hashedRuleSetName := util.GetHashedName(podRuleSet)
-			entry := &iptm.IptEntry{
-				Name:       podRuleSet,
-				HashedName: hashedRuleSetName,
-				Chain:      util.IptablesAzureEgressToChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesSrcFlag,
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedRuleSetName,
-					util.IptablesDstFlag,
-					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
-			}
-			entries = append(entries, entry)
-		}
+	return entries
+}
+
+// getNsDropEntry returns the entry that drops the traffic of the pods of a namespace targeted by a policy
+// with an empty pod selector, unless it's allowed.
+func getNsDropEntry(ns string, direction string) *iptm.IptEntry {
+	hashedTargetSetName := util.GetHashedName(ns)
+
+	return &iptm.IptEntry{
+		Name:       ns,
+		HashedName: hashedTargetSetName,
+		Chain:      util.IptablesAzureTargetSetsChain,
+		Specs: []string{
+			util.IptablesMatchFlag,
+			util.IptablesSetFlag,
+			util.IptablesMatchSetFlag,
+			hashedTargetSetName,
+			direction,
+			util.IptablesJumpFlag,
+			util.IptablesDrop,
+		},
+	}
+}
+
+// parseRules translates the ingress or egress rules of a policy into iptables entries.
+// It returns the pod ipsets and namespace ipset lists referenced by the entries.
+func parseRules(
+	ns string,
+	targets [][]setMatch,
+	rules []policyRule,
+	targetDirection string,
+	peerDirection string,
+	addressFlag string,
+	portChain string,
+	peerChain string) ([]string, []string, []*iptm.IptEntry) {
+	var (
+		podSets   []string
+		nsLists   []string
+		ruleInfos []*ruleInfo
+		entries   []*iptm.IptEntry
+	)
+
+	if len(targets) == 0 {
+		targets = append(targets, []setMatch{{set: ns}})
+		entries = append(entries, getNsDropEntry(ns, targetDirection))
+	}
+
+	for _, rule := range rules {
+		ruleSets, ruleLists, info := parseRule(ns, rule, peerDirection, addressFlag)
+		podSets = append(podSets, ruleSets...)
+		nsLists = append(nsLists, ruleLists...)
+		ruleInfos = append(ruleInfos, info)
+	}
 
-		// Handle NamespaceSelector field of NetworkPolicyPeer
-		for _, nsRuleSet := range nsRuleLists {
-			hashedRuleSetName := util.GetHashedName(nsRuleSet)
-			entry := &iptm.IptEntry{
-				Name:       nsRuleSet,
-				HashedName: hashedRuleSetName,
-				Chain:      util.IptablesAzureEgressToChain,
-				Specs: []string{
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedTargetSetName,
-					util.IptablesSrcFlag,
-					util.IptablesMatchFlag,
-					util.IptablesSetFlag,
-					util.IptablesMatchSetFlag,
-					hashedRuleSetName,
-					util.IptablesDstFlag,
+	for _, target := range targets {
+		targetName := getSetMatchName(target)
+		log.Printf("Parsing iptables for label %s", targetName)
+
+		if len(rules) == 0 {
+			drop := &iptm.IptEntry{
+				Name:       targetName,
+				HashedName: util.GetHashedName(targetName),
+				Chain:      portChain,
+				Specs: append(
+					getSetMatchSpecs(target, targetDirection),
 					util.IptablesJumpFlag,
-					util.IptablesAccept,
-				},
+					util.IptablesDrop,
+				),
 			}
-			entries = append(entries, entry)
+			entries = append(entries, drop)
+			continue
 		}
+
+		entries = append(entries, getAllowEntries(target, targetDirection, ruleInfos, portChain, peerChain)...)
+	}
+
+	return podSets, nsLists, entries
+}
+
+func parseIngress(ns string, targets [][]setMatch, rules []networkingv1.NetworkPolicyIngressRule) ([]string, []string, []*iptm.IptEntry) {
+	var policyRules []policyRule
+	for _, rule := range rules {
+		policyRules = append(policyRules, policyRule{ports: rule.Ports, peers: rule.From})
 	}
 
-	return PodNsRuleSets, nsRuleLists, entries
+	return parseRules(
+		ns,
+		targets,
+		policyRules,
+		util.IptablesDstFlag,
+		util.IptablesSrcFlag,
+		util.IptablesSFlag,
+		util.IptablesAzureIngressPortChain,
+		util.IptablesAzureIngressFromChain,
+	)
+}
+
+func parseEgress(ns string, targets [][]setMatch, rules []networkingv1.NetworkPolicyEgressRule) ([]string, []string, []*iptm.IptEntry) {
+	var policyRules []policyRule
+	for _, rule := range rules {
+		policyRules = append(policyRules, policyRule{ports: rule.Ports, peers: rule.To})
+	}
+
+	return parseRules(
+		ns,
+		targets,
+		policyRules,
+		util.IptablesSrcFlag,
+		util.IptablesDstFlag,
+		util.IptablesDFlag,
+		util.IptablesAzureEgressPortChain,
+		util.IptablesAzureEgressToChain,
+	)
 }
 
 // Drop all non-whitelisted packets.
-func getDefaultDropEntries(targetSets []string) []*iptm.IptEntry {
+func getDefaultDropEntries(targets [][]setMatch) []*iptm.IptEntry {
 	var entries []*iptm.IptEntry
 
-	for _, targetSet := range targetSets {
-		hashedTargetSetName := util.GetHashedName(targetSet)
+	for _, target := range targets {
+		targetName := getSetMatchName(target)
+		hashedTargetSetName := util.GetHashedName(targetName)
 		entry := &iptm.IptEntry{
-			Name:       targetSet,
+			Name:       targetName,
 			HashedName: hashedTargetSetName,
 			Chain:      util.IptablesAzureTargetSetsChain,
-			Specs: []string{
-				util.IptablesMatchFlag,
-				util.IptablesSetFlag,
-				util.IptablesMatchSetFlag,
-				hashedTargetSetName,
-				util.IptablesSrcFlag,
+			Specs: append(
+				getSetMatchSpecs(target, util.IptablesSrcFlag),
 				util.IptablesJumpFlag,
 				util.IptablesDrop,
-			},
+			),
 		}
 		entries = append(entries, entry)
 
 		entry = &iptm.IptEntry{
-			Name:       targetSet,
+			Name:       targetName,
 			HashedName: hashedTargetSetName,
 			Chain:      util.IptablesAzureTargetSetsChain,
-			Specs: []string{
-				util.IptablesMatchFlag,
-				util.IptablesSetFlag,
-				util.IptablesMatchSetFlag,
-				hashedTargetSetName,
-				util.IptablesDstFlag,
+			Specs: append(
+				getSetMatchSpecs(target, util.IptablesDstFlag),
 				util.IptablesJumpFlag,
 				util.IptablesDrop,
-			},
+			),
 		}
 		entries = append(entries, entry)
 	}
@@ -609,34 +729,86 @@ func getDefaultDropEntries(targetSets []string) []*iptm.IptEntry {
 	return entries
 }
 
+// getDropLogPrefix returns the prefix of drop logs of a network policy.
+// The policy is identified by a hash because log prefixes are limited to 29 characters.
+func getDropLogPrefix(npNs string, npName string) string {
+	return util.IptablesDropLogPrefix + util.Hash(npNs+"/"+npName) + ":"
+}
+
+// isDropEntry checks whether an entry drops the packets it matches.
+func isDropEntry(entry *iptm.IptEntry) bool {
+	n := len(entry.Specs)
+	return n >= 2 && entry.Specs[n-2] == util.IptablesJumpFlag && entry.Specs[n-1] == util.IptablesDrop
+}
+
+// addDropLogEntries inserts a rate limited LOG entry ahead of every drop entry.
+// In audit mode drop entries are removed so that the packets are logged but not dropped.
+func addDropLogEntries(entries []*iptm.IptEntry, logPrefix string, isAuditMode bool) []*iptm.IptEntry {
+	var result []*iptm.IptEntry
+	for _, entry := range entries {
+		if !isDropEntry(entry) {
+			result = append(result, entry)
+			continue
+		}
+
+		var specs []string
+		specs = append(specs, entry.Specs[:len(entry.Specs)-2]...)
+		specs = append(
+			specs,
+			util.IptablesMatchFlag,
+			util.IptablesLimitFlag,
+			util.IptablesLimitRateFlag,
+			util.IptablesDropLogRate,
+			util.IptablesJumpFlag,
+			util.IptablesLog,
+			util.IptablesLogPrefixFlag,
+			logPrefix,
+		)
+
+		logEntry := &iptm.IptEntry{
+			Name:       entry.Name,
+			HashedName: entry.HashedName,
+			Chain:      entry.Chain,
+			Specs:      specs,
+		}
+		result = append(result, logEntry)
+
+		if !isAuditMode {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
 // ParsePolicy parses network policy.
 func parsePolicy(npObj *networkingv1.NetworkPolicy) ([]string, []string, []*iptm.IptEntry) {
 	var (
 		resultPodSets []string
 		resultNsLists []string
 		affectedSets  []string
+		targets       [][]setMatch
 		entries       []*iptm.IptEntry
 	)
 
 	// Get affected pods.
-	npNs, selector := npObj.ObjectMeta.Namespace, npObj.Spec.PodSelector.MatchLabels
-	for podLabelKey, podLabelVal := range selector {
-		affectedSet := util.KubeAllNamespacesFlag + "-" + podLabelKey + ":" + podLabelVal
-		affectedSets = append(affectedSets, affectedSet)
+	npNs, selector := npObj.ObjectMeta.Namespace, &npObj.Spec.PodSelector
+	if !isEmptySelector(selector) {
+		affectedSets, targets = parsePodSelector(npNs, selector)
 	}
 
 	if len(npObj.Spec.PolicyTypes) == 0 {
-		ingressPodSets, ingressNsSets, ingressEntries := parseIngress(npNs, affectedSets, npObj.Spec.Ingress)
+		ingressPodSets, ingressNsSets, ingressEntries := parseIngress(npNs, targets, npObj.Spec.Ingress)
 		resultPodSets = append(resultPodSets, ingressPodSets...)
 		resultNsLists = append(resultNsLists, ingressNsSets...)
 		entries = append(entries, ingressEntries...)
 
-		egressPodSets, egressNsSets, egressEntries := parseEgress(npNs, affectedSets, npObj.Spec.Egress)
+		egressPodSets, egressNsSets, egressEntries := parseEgress(npNs, targets, npObj.Spec.Egress)
 		resultPodSets = append(resultPodSets, egressPodSets...)
 		resultNsLists = append(resultNsLists, egressNsSets...)
 		entries = append(entries, egressEntries...)
 
-		entries = append(entries, getDefaultDropEntries(affectedSets)...)
+		entries = append(entries, getDefaultDropEntries(targets)...)
 
 		resultPodSets = append(resultPodSets, affectedSets...)
 
@@ -645,20 +817,20 @@ func parsePolicy(npObj *networkingv1.NetworkPolicy) ([]string, []string, []*iptm
 
 	for _, ptype := range npObj.Spec.PolicyTypes {
 		if ptype == networkingv1.PolicyTypeIngress {
-			ingressPodSets, ingressNsSets, ingressEntries := parseIngress(npNs, affectedSets, npObj.Spec.Ingress)
+			ingressPodSets, ingressNsSets, ingressEntries := parseIngress(npNs, targets, npObj.Spec.Ingress)
 			resultPodSets = append(resultPodSets, ingressPodSets...)
 			resultNsLists = append(resultNsLists, ingressNsSets...)
 			entries = append(entries, ingressEntries...)
 		}
 
 		if ptype == networkingv1.PolicyTypeEgress {
-			egressPodSets, egressNsSets, egressEntries := parseEgress(npNs, affectedSets, npObj.Spec.Egress)
+			egressPodSets, egressNsSets, egressEntries := parseEgress(npNs, targets, npObj.Spec.Egress)
 			resultPodSets = append(resultPodSets, egressPodSets...)
 			resultNsLists = append(resultNsLists, egressNsSets...)
 			entries = append(entries, egressEntries...)
 		}
 
-		entries = append(entries, getDefaultDropEntries(affectedSets)...)
+		entries = append(entries, getDefaultDropEntries(targets)...)
 	}
 
 	resultPodSets = append(resultPodSets, affectedSets...)
@@ -0,0 +1,669 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/debug"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestParseSelector(t *testing.T) {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "frontend"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "api"}},
+			{Key: "env", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"dev"}},
+			{Key: "team", Operator: metav1.LabelSelectorOpExists},
+			{Key: "canary", Operator: metav1.LabelSelectorOpDoesNotExist},
+		},
+	}
+
+	sets, alternatives := parsePodSelector("test-ns", selector)
+
+	expectedSets := []string{
+		getPodIpsetName("app", "frontend"),
+		getPodIpsetName("tier", "web"),
+		getPodIpsetName("tier", "api"),
+		getPodIpsetName("env", "dev"),
+		getPodKeyIpsetName("team"),
+		getPodKeyIpsetName("canary"),
+		"test-ns",
+	}
+	if !reflect.DeepEqual(sets, expectedSets) {
+		t.Errorf("TestParseSelector failed @ sets %+v", sets)
+	}
+
+	common := []setMatch{
+		{set: "test-ns"},
+		{set: getPodIpsetName("app", "frontend")},
+		{set: getPodIpsetName("env", "dev"), negated: true},
+		{set: getPodKeyIpsetName("team")},
+		{set: getPodKeyIpsetName("canary"), negated: true},
+	}
+	expectedAlternatives := [][]setMatch{
+		appendMatches(common, setMatch{set: getPodIpsetName("tier", "web")}),
+		appendMatches(common, setMatch{set: getPodIpsetName("tier", "api")}),
+	}
+	if !reflect.DeepEqual(alternatives, expectedAlternatives) {
+		t.Errorf("TestParseSelector failed @ alternatives %+v", alternatives)
+	}
+}
+
+func TestParseNsSelector(t *testing.T) {
+	selector := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "env", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"prod"}},
+		},
+	}
+
+	lists, alternatives := parseNsSelector(selector)
+
+	expectedLists := []string{getNsIpsetName("env", "prod"), util.KubeAllNamespacesFlag}
+	if !reflect.DeepEqual(lists, expectedLists) {
+		t.Errorf("TestParseNsSelector failed @ lists %+v", lists)
+	}
+
+	// Negated matches must be restricted to namespaces of the cluster.
+	expectedAlternatives := [][]setMatch{{
+		{set: util.KubeAllNamespacesFlag},
+		{set: getNsIpsetName("env", "prod"), negated: true},
+	}}
+	if !reflect.DeepEqual(alternatives, expectedAlternatives) {
+		t.Errorf("TestParseNsSelector failed @ alternatives %+v", alternatives)
+	}
+}
+
+func TestGetSetMatchSpecs(t *testing.T) {
+	matches := []setMatch{
+		{set: "test-ns"},
+		{set: getPodKeyIpsetName("canary"), negated: true},
+	}
+
+	specs := getSetMatchSpecs(matches, util.IptablesSrcFlag)
+
+	expectedSpecs := []string{
+		util.IptablesMatchFlag,
+		util.IptablesSetFlag,
+		util.IptablesMatchSetFlag,
+		util.GetHashedName("test-ns"),
+		util.IptablesSrcFlag,
+		util.IptablesMatchFlag,
+		util.IptablesSetFlag,
+		util.IptablesNotFlag,
+		util.IptablesMatchSetFlag,
+		util.GetHashedName(getPodKeyIpsetName("canary")),
+		util.IptablesSrcFlag,
+	}
+	if !reflect.DeepEqual(specs, expectedSpecs) {
+		t.Errorf("TestGetSetMatchSpecs failed @ specs %+v", specs)
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tcp, sctp := corev1.ProtocolTCP, corev1.Protocol("SCTP")
+	port, namedPort := intstr.FromInt(8000), intstr.FromString("http")
+	endPort, samePort, lowerPort := int32(8080), int32(8000), int32(7000)
+
+	tests := []struct {
+		name     string
+		portRule networkingv1.NetworkPolicyPort
+		expected portsInfo
+	}{
+		{
+			name:     "all ports",
+			portRule: networkingv1.NetworkPolicyPort{},
+			expected: portsInfo{protocol: "TCP"},
+		},
+		{
+			name:     "numbered port",
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &sctp, Port: &port},
+			expected: portsInfo{protocol: "SCTP", port: "8000"},
+		},
+		{
+			name:     "named port",
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &namedPort},
+			expected: portsInfo{protocol: "TCP", namedPortSet: getNamedPortIpsetName("http")},
+		},
+		{
+			name:     "port range",
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port, EndPort: &endPort},
+			expected: portsInfo{protocol: "TCP", port: "8000:8080"},
+		},
+		{
+			name:     "end port equal to port",
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port, EndPort: &samePort},
+			expected: portsInfo{protocol: "TCP", port: "8000"},
+		},
+		{
+			name:     "end port lower than port",
+			portRule: networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port, EndPort: &lowerPort},
+			expected: portsInfo{protocol: "TCP", port: "8000"},
+		},
+	}
+
+	for _, test := range tests {
+		if portInfo := parsePort(test.portRule); !reflect.DeepEqual(*portInfo, test.expected) {
+			t.Errorf("TestParsePort failed @ %s %+v", test.name, portInfo)
+		}
+	}
+}
+
+func TestGetMultiPortSpecs(t *testing.T) {
+	var ports, ranges []string
+	for port := 1000; port < 1016; port++ {
+		ports = append(ports, fmt.Sprint(port))
+	}
+	for port := 2000; port < 2008; port++ {
+		ranges = append(ranges, fmt.Sprintf("%d:%d", port*10, port*10+9))
+	}
+
+	tests := []struct {
+		name     string
+		ports    []string
+		expected []string
+	}{
+		{
+			name:     "ports",
+			ports:    ports[:15],
+			expected: []string{strings.Join(ports[:15], ",")},
+		},
+		{
+			name:     "ports split",
+			ports:    ports,
+			expected: []string{strings.Join(ports[:15], ","), "1015"},
+		},
+		{
+			// A range takes two of the 15 ports.
+			name:     "ranges split",
+			ports:    ranges,
+			expected: []string{strings.Join(ranges[:7], ","), ranges[7]},
+		},
+		{
+			name:     "range after 14 ports",
+			ports:    append(append([]string{}, ports[:14]...), ranges[0]),
+			expected: []string{strings.Join(ports[:14], ","), ranges[0]},
+		},
+		{
+			name:     "port after 7 ranges",
+			ports:    append(append([]string{}, ranges[:7]...), ports[0]),
+			expected: []string{strings.Join(ranges[:7], ",") + "," + ports[0]},
+		},
+	}
+
+	for _, test := range tests {
+		var expectedSpecs [][]string
+		for _, expectedPorts := range test.expected {
+			expectedSpecs = append(expectedSpecs, []string{
+				util.IptablesProtFlag,
+				"TCP",
+				util.IptablesMatchFlag,
+				util.IptablesMultiportFlag,
+				util.IptablesDstPortsFlag,
+				expectedPorts,
+			})
+		}
+
+		if specs := getMultiPortSpecs("TCP", test.ports); !reflect.DeepEqual(specs, expectedSpecs) {
+			t.Errorf("TestGetMultiPortSpecs failed @ %s %+v", test.name, specs)
+		}
+	}
+}
+
+func TestGetPortSpecs(t *testing.T) {
+	tcp := corev1.ProtocolTCP
+
+	port := intstr.FromInt(8000)
+	specs := getPortSpecs(parsePort(networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &port}))
+	expectedSpecs := []string{util.IptablesProtFlag, "TCP", util.IptablesDstPortFlag, "8000"}
+	if !reflect.DeepEqual(specs, expectedSpecs) {
+		t.Errorf("TestGetPortSpecs failed @ numbered port %+v", specs)
+	}
+
+	namedPort := intstr.FromString("http")
+	specs = getPortSpecs(parsePort(networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &namedPort}))
+	expectedSpecs = []string{
+		util.IptablesProtFlag,
+		"TCP",
+		util.IptablesMatchFlag,
+		util.IptablesSetFlag,
+		util.IptablesMatchSetFlag,
+		util.GetHashedName(getNamedPortIpsetName("http")),
+		util.IptablesDstDstFlag,
+	}
+	if !reflect.DeepEqual(specs, expectedSpecs) {
+		t.Errorf("TestGetPortSpecs failed @ named port %+v", specs)
+	}
+}
+
+func TestGetRulePortSpecs(t *testing.T) {
+	specs := getRulePortSpecs([]*portsInfo{
+		{protocol: "TCP", port: "80"},
+		{protocol: "SCTP", port: "9000"},
+		{protocol: "TCP", port: "8080"},
+		{protocol: "UDP"},
+	})
+
+	expectedSpecs := [][]string{
+		{util.IptablesProtFlag, "UDP"},
+		{
+			util.IptablesProtFlag,
+			"TCP",
+			util.IptablesMatchFlag,
+			util.IptablesMultiportFlag,
+			util.IptablesDstPortsFlag,
+			"80,8080",
+		},
+		{util.IptablesProtFlag, "SCTP", util.IptablesDstPortFlag, "9000"},
+	}
+	if !reflect.DeepEqual(specs, expectedSpecs) {
+		t.Errorf("TestGetRulePortSpecs failed @ specs %+v", specs)
+	}
+
+	// A multiport match holds up to 15 ports.
+	var portInfos []*portsInfo
+	for port := 1000; port < 1016; port++ {
+		portInfos = append(portInfos, &portsInfo{protocol: "TCP", port: fmt.Sprint(port)})
+	}
+
+	specs = getRulePortSpecs(portInfos)
+	if len(specs) != 2 || specs[1][len(specs[1])-1] != "1015" {
+		t.Errorf("TestGetRulePortSpecs failed @ split multiport %+v", specs)
+	}
+
+	if specs = getRulePortSpecs(nil); len(specs) != 1 || specs[0] != nil {
+		t.Errorf("TestGetRulePortSpecs failed @ rule without ports %+v", specs)
+	}
+}
+
+func TestSubtractCIDRs(t *testing.T) {
+	tests := []struct {
+		cidr     string
+		excepts  []string
+		expected []string
+	}{
+		{"10.0.0.0/16", nil, []string{"10.0.0.0/16"}},
+		{"10.0.0.0/16", []string{"10.0.0.0/17"}, []string{"10.0.128.0/17"}},
+		{"10.0.0.0/16", []string{"10.0.0.0/18", "10.0.192.0/18"}, []string{"10.0.64.0/18", "10.0.128.0/18"}},
+		{"10.0.0.0/30", []string{"10.0.0.1/32"}, []string{"10.0.0.0/32", "10.0.0.2/31"}},
+		{"10.0.0.0/24", []string{"10.0.0.0/16"}, nil},
+		{"10.0.0.0/24", []string{"10.1.0.0/16", "fd00::/64"}, []string{"10.0.0.0/24"}},
+		{"fd00::/64", []string{"fd00::/65"}, []string{"fd00::8000:0:0:0/65"}},
+	}
+
+	for _, test := range tests {
+		if cidrs := subtractCIDRs(test.cidr, test.excepts); !reflect.DeepEqual(cidrs, test.expected) {
+			t.Errorf("TestSubtractCIDRs failed @ %s except %v: %v", test.cidr, test.excepts, cidrs)
+		}
+	}
+}
+
+// conformancePod is a pod of the cluster the network policies of the conformance tests are evaluated in.
+type conformancePod struct {
+	ip     string
+	ns     string
+	labels map[string]string
+}
+
+// getConformanceSets returns the ipset save output of the cluster of the conformance tests.
+func getConformanceSets() string {
+	pods := []conformancePod{
+		{ip: "10.0.0.1", ns: "test-ns", labels: map[string]string{"app": "web"}},
+		{ip: "10.0.0.2", ns: "test-ns", labels: map[string]string{"app": "db"}},
+		{ip: "10.0.0.3", ns: "test-ns", labels: map[string]string{"app": "client"}},
+		{ip: "10.0.1.1", ns: "prod-ns", labels: map[string]string{"app": "client"}},
+	}
+	nsLabels := map[string]map[string]string{
+		"test-ns": {"env": "test"},
+		"prod-ns": {"env": "prod"},
+	}
+
+	members := make(map[string][]string)
+	for _, pod := range pods {
+		members[pod.ns] = append(members[pod.ns], pod.ip)
+		for k, v := range pod.labels {
+			members[getPodIpsetName(k, v)] = append(members[getPodIpsetName(k, v)], pod.ip)
+			members[getPodKeyIpsetName(k)] = append(members[getPodKeyIpsetName(k)], pod.ip)
+		}
+	}
+
+	var save string
+	for set, ips := range members {
+		save += fmt.Sprintf("create %s hash:net family inet hashsize 1024 maxelem 65536\n", util.GetHashedName(set))
+		for _, ip := range ips {
+			save += fmt.Sprintf("add %s %s\n", util.GetHashedName(set), ip)
+		}
+	}
+
+	lists := make(map[string][]string)
+	for ns, labels := range nsLabels {
+		lists[util.KubeAllNamespacesFlag] = append(lists[util.KubeAllNamespacesFlag], ns)
+		for k, v := range labels {
+			lists[getNsIpsetName(k, v)] = append(lists[getNsIpsetName(k, v)], ns)
+			lists[getNsKeyIpsetName(k)] = append(lists[getNsKeyIpsetName(k)], ns)
+		}
+	}
+
+	for list, sets := range lists {
+		save += fmt.Sprintf("create %s list:set size 8\n", util.GetHashedName(list))
+		for _, set := range sets {
+			save += fmt.Sprintf("add %s %s\n", util.GetHashedName(list), util.GetHashedName(set))
+		}
+	}
+
+	http := util.GetHashedName(getNamedPortIpsetName("http"))
+	save += fmt.Sprintf("create %s hash:ip,port family inet hashsize 1024 maxelem 65536\n", http)
+	save += fmt.Sprintf("add %s 10.0.0.1,tcp:8080\n", http)
+
+	return save
+}
+
+// TestPolicyConformance evaluates packets against the rules of network policies following the upstream semantics:
+// rules are ORed, the ports and peers of a rule are ANDed, and ipBlock exceptions only restrict their ipBlock.
+func TestPolicyConformance(t *testing.T) {
+	tcp, udp, sctp := corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.Protocol("SCTP")
+	http, dns, port80, port443, port5432, port9000 := intstr.FromString("http"), intstr.FromInt(53),
+		intstr.FromInt(80), intstr.FromInt(443), intstr.FromInt(5432), intstr.FromInt(9000)
+	endPort9100 := int32(9100)
+
+	web := metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	client := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "client"}}
+	db := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+	prod := &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+
+	// More ports than a multiport match holds.
+	var manyPorts []networkingv1.NetworkPolicyPort
+	for port := 1000; port < 1016; port++ {
+		value := intstr.FromInt(port)
+		manyPorts = append(manyPorts, networkingv1.NetworkPolicyPort{Protocol: &tcp, Port: &value})
+	}
+
+	type packet struct {
+		src, dst, protocol string
+		port               int
+		allowed            bool
+	}
+
+	tests := []struct {
+		name    string
+		spec    networkingv1.NetworkPolicySpec
+		packets []packet
+	}{
+		{
+			name: "deny all ingress",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			},
+			packets: []packet{
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, false},
+				{"10.0.0.3", "10.0.0.2", "TCP", 80, true},
+			},
+		},
+		{
+			name: "pod selector and port",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port80}},
+					From:  []networkingv1.NetworkPolicyPeer{{PodSelector: client}},
+				}},
+			},
+			packets: []packet{
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 81, false},
+				{"10.0.0.3", "10.0.0.1", "UDP", 80, false},
+				{"10.0.0.2", "10.0.0.1", "TCP", 80, false},
+				// Pod selectors without a namespace selector select pods of the policy's namespace.
+				{"10.0.1.1", "10.0.0.1", "TCP", 80, false},
+			},
+		},
+		{
+			name: "ports and peers are scoped to their rule",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{
+					{
+						Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port80}},
+						From:  []networkingv1.NetworkPolicyPeer{{PodSelector: client}},
+					},
+					{
+						Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &port5432}},
+						From:  []networkingv1.NetworkPolicyPeer{{PodSelector: db}},
+					},
+				},
+			},
+			packets: []packet{
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, true},
+				{"10.0.0.2", "10.0.0.1", "TCP", 5432, true},
+				{"10.0.0.2", "10.0.0.1", "TCP", 80, false},
+				{"10.0.0.3", "10.0.0.1", "TCP", 5432, false},
+			},
+		},
+		{
+			name: "ipBlock with exceptions and a pod selector",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.0.0/24"}}},
+						{PodSelector: client},
+					},
+				}},
+			},
+			packets: []packet{
+				// Exceptions don't restrict other peers.
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, true},
+				{"10.0.0.2", "10.0.0.1", "TCP", 80, false},
+				{"10.0.5.5", "10.0.0.1", "TCP", 80, true},
+				{"10.1.0.1", "10.0.0.1", "TCP", 80, false},
+			},
+		},
+		{
+			name: "namespace selector",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: prod}},
+				}},
+			},
+			packets: []packet{
+				{"10.0.1.1", "10.0.0.1", "TCP", 80, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, false},
+			},
+		},
+		{
+			name: "namespace and pod selector",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: prod, PodSelector: db}},
+				}},
+			},
+			packets: []packet{
+				{"10.0.1.1", "10.0.0.1", "TCP", 80, false},
+				{"10.0.0.2", "10.0.0.1", "TCP", 80, false},
+			},
+		},
+		{
+			name: "protocols and multiple ports",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Port: &port80},
+						{Protocol: &tcp, Port: &port443},
+						{Protocol: &sctp, Port: &port9000},
+						{Protocol: &udp},
+					},
+				}},
+			},
+			packets: []packet{
+				// The protocol defaults to TCP.
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 443, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 9000, false},
+				{"10.0.0.3", "10.0.0.1", "SCTP", 9000, true},
+				{"10.0.0.3", "10.0.0.1", "SCTP", 80, false},
+				// A port without a number matches all ports of the protocol.
+				{"10.0.0.3", "10.0.0.1", "UDP", 12345, true},
+			},
+		},
+		{
+			name: "ports split over multiport matches",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: manyPorts,
+					From:  []networkingv1.NetworkPolicyPeer{{PodSelector: client}},
+				}},
+			},
+			packets: []packet{
+				{"10.0.0.3", "10.0.0.1", "TCP", 1000, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 1014, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 1015, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 1016, false},
+				{"10.0.0.2", "10.0.0.1", "TCP", 1015, false},
+			},
+		},
+		{
+			name: "port ranges",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &tcp, Port: &port9000, EndPort: &endPort9100},
+						{Protocol: &tcp, Port: &port80},
+						{Protocol: &udp, Port: &port9000, EndPort: &endPort9100},
+					},
+				}},
+			},
+			packets: []packet{
+				{"10.0.0.3", "10.0.0.1", "TCP", 9000, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 9100, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 9101, false},
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, true},
+				{"10.0.0.3", "10.0.0.1", "UDP", 9050, true},
+				{"10.0.0.3", "10.0.0.1", "UDP", 8999, false},
+			},
+		},
+		{
+			name: "named port",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &tcp, Port: &http}},
+				}},
+			},
+			packets: []packet{
+				{"10.0.0.3", "10.0.0.1", "TCP", 8080, true},
+				{"10.0.0.3", "10.0.0.1", "TCP", 80, false},
+			},
+		},
+		{
+			name: "egress to ipBlock and port",
+			spec: networkingv1.NetworkPolicySpec{
+				PodSelector: web,
+				Egress: []networkingv1.NetworkPolicyEgressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Protocol: &udp, Port: &dns}},
+					To: []networkingv1.NetworkPolicyPeer{
+						{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.1.0/24", Except: []string{"10.0.1.128/25"}}},
+					},
+				}},
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			},
+			packets: []packet{
+				{"10.0.0.1", "10.0.1.1", "UDP", 53, true},
+				{"10.0.0.1", "10.0.1.1", "TCP", 53, false},
+				{"10.0.0.1", "10.0.1.129", "UDP", 53, false},
+				{"10.0.0.3", "10.0.1.129", "UDP", 53, true},
+			},
+		},
+	}
+
+	sets := getConformanceSets()
+
+	for _, test := range tests {
+		npObj := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-ns", Name: "test-policy"},
+			Spec:       test.spec,
+		}
+
+		iptMgr := iptm.NewIptablesManager()
+		if err := iptMgr.AddDefaultRules(); err != nil {
+			t.Errorf("TestPolicyConformance failed @ iptMgr.AddDefaultRules")
+		}
+
+		_, _, entries := parsePolicy(npObj)
+		for _, entry := range entries {
+			if err := iptMgr.Add(entry); err != nil {
+				t.Errorf("TestPolicyConformance failed @ %s iptMgr.Add %+v", test.name, entry)
+			}
+		}
+
+		rendered, err := iptMgr.Render()
+		if err != nil {
+			t.Errorf("TestPolicyConformance failed @ %s iptMgr.Render, err: %v", test.name, err)
+		}
+
+		state := debug.NewState(rendered, []byte(sets))
+		for _, p := range test.packets {
+			verdict := state.Evaluate(&debug.Packet{SrcIP: p.src, DstIP: p.dst, Protocol: p.protocol, DstPort: p.port})
+			if verdict.Allowed != p.allowed {
+				t.Errorf("TestPolicyConformance failed @ %s: %+v, allowed %t", test.name, p, verdict.Allowed)
+			}
+		}
+	}
+}
+
+func TestAddDropLogEntries(t *testing.T) {
+	allow := &iptm.IptEntry{
+		Chain: util.IptablesAzureIngressPortChain,
+		Specs: []string{util.IptablesJumpFlag, util.IptablesAccept},
+	}
+	drop := &iptm.IptEntry{
+		Chain: util.IptablesAzureTargetSetsChain,
+		Specs: []string{util.IptablesJumpFlag, util.IptablesDrop},
+	}
+
+	logPrefix := getDropLogPrefix("test-ns", "test-policy")
+	if len(logPrefix) > 29 {
+		t.Errorf("TestAddDropLogEntries failed @ getDropLogPrefix %s", logPrefix)
+	}
+
+	expectedLogSpecs := []string{
+		util.IptablesMatchFlag,
+		util.IptablesLimitFlag,
+		util.IptablesLimitRateFlag,
+		util.IptablesDropLogRate,
+		util.IptablesJumpFlag,
+		util.IptablesLog,
+		util.IptablesLogPrefixFlag,
+		logPrefix,
+	}
+
+	entries := addDropLogEntries([]*iptm.IptEntry{allow, drop}, logPrefix, false)
+	if len(entries) != 3 || entries[0] != allow || entries[2] != drop {
+		t.Errorf("TestAddDropLogEntries failed @ entries %+v", entries)
+	}
+
+	if entries[1].Chain != drop.Chain || !reflect.DeepEqual(entries[1].Specs, expectedLogSpecs) {
+		t.Errorf("TestAddDropLogEntries failed @ log entry %+v", entries[1])
+	}
+
+	// Drops are logged but not enforced in audit mode.
+	entries = addDropLogEntries([]*iptm.IptEntry{allow, drop}, logPrefix, true)
+	if len(entries) != 2 || entries[0] != allow || !reflect.DeepEqual(entries[1].Specs, expectedLogSpecs) {
+		t.Errorf("TestAddDropLogEntries failed @ audit mode entries %+v", entries)
+	}
+}
@@ -3,10 +3,13 @@
 package main
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
 	"github.com/Azure/azure-container-networking/npm"
+	"github.com/Azure/azure-container-networking/npm/util"
 
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
@@ -14,9 +17,37 @@ import (
 	"k8s.io/client-go/rest"
 )
 
+// Command line options of NPM.
+const (
+	optBackend      = "backend"
+	optBackendAlias = "b"
+)
+
 // Version is populated by make during build.
 var version string
 
+// Command line arguments for NPM.
+// The version option isn't offered as its alias is taken by the glog flags of the kubernetes client.
+var args = common.ArgumentList{
+	{
+		Name:         optBackend,
+		Shorthand:    optBackendAlias,
+		Description:  "Set the backend programming network policies",
+		Type:         "string",
+		DefaultValue: util.IptablesBackend,
+		ValueMap: map[string]interface{}{
+			util.IptablesBackend: util.IptablesBackend,
+			util.NftablesBackend: util.NftablesBackend,
+		},
+	},
+}
+
+// Prints description and version information.
+func printVersion() {
+	fmt.Printf("Azure network policy manager\n")
+	fmt.Printf("Version %v\n", version)
+}
+
 func initLogging() error {
 	log.SetName("azure-npm")
 	log.SetLevel(log.LevelInfo)
@@ -37,10 +68,15 @@ func main() {
 		}
 	}()
 
+	common.ParseArgs(&args, printVersion)
+	backend := common.GetArg(optBackend).(string)
+
 	if err = initLogging(); err != nil {
 		panic(err.Error())
 	}
 
+	log.Printf("[Azure-NPM] Programming network policies through %s.\n", backend)
+
 	// Creates the in-cluster config
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -56,7 +92,7 @@ func main() {
 
 	factory := informers.NewSharedInformerFactory(clientset, time.Hour*24)
 
-	npMgr := npm.NewNetworkPolicyManager(clientset, factory, version)
+	npMgr := npm.NewNetworkPolicyManager(clientset, factory, version, backend)
 	err = npMgr.Run(wait.NeverStop)
 	if err != nil {
 		log.Printf("[Azure-NPM] npm failed with error %v.", err)
@@ -65,5 +101,9 @@ func main() {
 
 	go npMgr.RunReportManager()
 
+	go npMgr.RunReconciler()
+
+	go npMgr.RunDropLogCollector()
+
 	select {}
 }
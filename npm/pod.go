@@ -3,9 +3,11 @@
 package npm
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/ipsm"
 	"github.com/Azure/azure-container-networking/npm/util"
 
 	corev1 "k8s.io/api/core/v1"
@@ -22,82 +24,165 @@ func isSystemPod(podObj *corev1.Pod) bool {
 	return podObj.ObjectMeta.Namespace == util.KubeSystemFlag
 }
 
-// AddPod handles adding pod ip to its label's ipset.
-func (npMgr *NetworkPolicyManager) AddPod(podObj *corev1.Pod) error {
-	npMgr.Lock()
-	defer npMgr.Unlock()
+func getPodIpsetName(k, v string) string {
+	return util.KubeAllNamespacesFlag + "-" + k + ":" + v
+}
 
-	if !isValidPod(podObj) {
-		return nil
-	}
+func getPodKeyIpsetName(k string) string {
+	return util.KubeAllNamespacesFlag + "-" + k
+}
 
-	var err error
+func getNamedPortIpsetName(portName string) string {
+	return util.NamedPortIpsetPrefix + portName
+}
 
-	defer func() {
-		if err = npMgr.UpdateAndSendReport(err, util.AddPodEvent); err != nil {
-			log.Printf("Error sending NPM telemetry report")
+// getNamedPortIpsetEntries returns the named port ipsets of a pod with their ip,protocol:port entries.
+func getNamedPortIpsetEntries(podObj *corev1.Pod) map[string][]string {
+	entries := make(map[string][]string)
+	for _, container := range podObj.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == "" {
+				continue
+			}
+
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+
+			setName := getNamedPortIpsetName(port.Name)
+			entry := fmt.Sprintf("%s,%s:%d", podObj.Status.PodIP, strings.ToLower(string(protocol)), port.ContainerPort)
+			entries[setName] = append(entries[setName], entry)
 		}
-	}()
+	}
+
+	return entries
+}
 
+// addPodToIpsets adds a pod to the ipsets of its namespace, labels and named ports.
+func addPodToIpsets(ipsMgr *ipsm.IpsetManager, podObj *corev1.Pod) error {
 	podNs := podObj.ObjectMeta.Namespace
-	podName := podObj.ObjectMeta.Name
-	podNodeName := podObj.Spec.NodeName
 	podLabels := podObj.ObjectMeta.Labels
 	podIP := podObj.Status.PodIP
-	log.Printf("POD CREATING: %s/%s/%s%+v%s\n", podNs, podName, podNodeName, podLabels, podIP)
 
-	// Add the pod to ipset
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
 	// Add the pod to its namespace's ipset.
 	log.Printf("Adding pod %s to ipset %s\n", podIP, podNs)
-	if err = ipsMgr.AddToSet(podNs, podIP); err != nil {
+	if err := ipsMgr.AddToSet(podNs, podIP); err != nil {
 		log.Printf("Error adding pod to namespace ipset.\n")
 		return err
 	}
 
 	// Add the pod to its label's ipset.
-	var labelKeys []string
 	for podLabelKey, podLabelVal := range podLabels {
 		//Ignore pod-template-hash label.
 		if strings.Contains(podLabelKey, util.KubePodTemplateHashFlag) {
 			continue
 		}
 
-		labelKey := util.KubeAllNamespacesFlag + "-" + podLabelKey + ":" + podLabelVal
+		labelKey := getPodIpsetName(podLabelKey, podLabelVal)
 		log.Printf("Adding pod %s to ipset %s\n", podIP, labelKey)
-		if err = ipsMgr.AddToSet(labelKey, podIP); err != nil {
+		if err := ipsMgr.AddToSet(labelKey, podIP); err != nil {
 			log.Printf("Error adding pod to label ipset.\n")
 			return err
 		}
-		labelKeys = append(labelKeys, labelKey)
+
+		// Add the pod to its label key's ipset, which backs Exists and DoesNotExist selectors.
+		keySet := getPodKeyIpsetName(podLabelKey)
+		log.Printf("Adding pod %s to ipset %s\n", podIP, keySet)
+		if err := ipsMgr.AddToSet(keySet, podIP); err != nil {
+			log.Printf("Error adding pod to label key ipset.\n")
+			return err
+		}
 	}
 
-	npMgr.clusterState.PodCount++
+	// Add the pod to the ipsets of its named container ports.
+	for setName, entries := range getNamedPortIpsetEntries(podObj) {
+		for _, entry := range entries {
+			log.Printf("Adding %s to ipset %s\n", entry, setName)
+			if err := ipsMgr.AddToSet(setName, entry); err != nil {
+				log.Printf("Error adding pod to named port ipset.\n")
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyPodToIpsets programs the ipset changes of a pod event in a single batch.
+func (npMgr *NetworkPolicyManager) applyPodToIpsets(podObj *corev1.Pod, family string, change func(*ipsm.IpsetManager, *corev1.Pod) error) error {
+	npMgr.ipsetLock.Lock()
+	defer npMgr.ipsetLock.Unlock()
+
+	ipsMgr := npMgr.getIpsetManager(family)
+	if err := change(ipsMgr, podObj); err != nil {
+		return err
+	}
+
+	// Program all ipset changes of the event at once.
+	if err := ipsMgr.Apply(); err != nil {
+		log.Printf("Error applying ipset changes.\n")
+		return err
+	}
+
+	return nil
+}
+
+// AddPod handles adding pod ip to its label's ipset.
+func (npMgr *NetworkPolicyManager) AddPod(podObj *corev1.Pod) error {
+	if !isValidPod(podObj) {
+		return nil
+	}
+
+	var err error
+
+	defer func() {
+		if err = npMgr.UpdateAndSendReport(err, util.AddPodEvent); err != nil {
+			log.Printf("Error sending NPM telemetry report")
+		}
+	}()
+
+	podNs := podObj.ObjectMeta.Namespace
+	podName := podObj.ObjectMeta.Name
+	podNodeName := podObj.Spec.NodeName
+	podLabels := podObj.ObjectMeta.Labels
+	podIP := podObj.Status.PodIP
+	log.Printf("POD CREATING: %s/%s/%s%+v%s\n", podNs, podName, podNodeName, podLabels, podIP)
+
+	// Add the pod to the ipsets of its IP family.
+	family := util.GetIPFamily(podIP)
+	if !npMgr.isFamilyEnforced(family) {
+		log.Printf("Ignoring pod %s/%s as policies of its IP %s aren't enforced.\n", podNs, podName, podIP)
+		return nil
+	}
+
+	if err = npMgr.applyPodToIpsets(podObj, family, addPodToIpsets); err != nil {
+		return err
+	}
 
 	ns, err := newNs(podNs)
 	if err != nil {
 		log.Printf("Error creating namespace %s\n", podNs)
 		return err
 	}
+
+	npMgr.Lock()
+	npMgr.clusterState.PodCount++
 	npMgr.nsMap[podNs] = ns
+	npMgr.Unlock()
 
 	return nil
 }
 
 // UpdatePod handles updating pod ip in its label's ipset.
+// Pods that are no longer valid, e.g. once they completed, are only deleted.
 func (npMgr *NetworkPolicyManager) UpdatePod(oldPodObj, newPodObj *corev1.Pod) error {
-	if !isValidPod(newPodObj) {
-		return nil
-	}
-
 	var err error
 
 	defer func() {
-		npMgr.Lock()
 		if err = npMgr.UpdateAndSendReport(err, util.UpdateNamespaceEvent); err != nil {
 			log.Printf("Error sending NPM telemetry report")
 		}
-		npMgr.Unlock()
 	}()
 
 	oldPodObjNs := oldPodObj.ObjectMeta.Namespace
@@ -118,10 +203,12 @@ func (npMgr *NetworkPolicyManager) UpdatePod(oldPodObj, newPodObj *corev1.Pod) e
 		return err
 	}
 
-	if newPodObj.ObjectMeta.DeletionTimestamp == nil && newPodObj.ObjectMeta.DeletionGracePeriodSeconds == nil {
-		if err = npMgr.AddPod(newPodObj); err != nil {
-			return err
-		}
+	if isPodDeleting(newPodObj) {
+		return nil
+	}
+
+	if err = npMgr.AddPod(newPodObj); err != nil {
+		return err
 	}
 
 	return nil
@@ -129,9 +216,6 @@ func (npMgr *NetworkPolicyManager) UpdatePod(oldPodObj, newPodObj *corev1.Pod) e
 
 // DeletePod handles deleting pod from its label's ipset.
 func (npMgr *NetworkPolicyManager) DeletePod(podObj *corev1.Pod) error {
-	npMgr.Lock()
-	defer npMgr.Unlock()
-
 	if !isValidPod(podObj) {
 		return nil
 	}
@@ -147,14 +231,21 @@ func (npMgr *NetworkPolicyManager) DeletePod(podObj *corev1.Pod) error {
 	podNs := podObj.ObjectMeta.Namespace
 	podName := podObj.ObjectMeta.Name
 	podNodeName := podObj.Spec.NodeName
+	log.Printf("POD DELETING: %s/%s/%s\n", podNs, podName, podNodeName)
+
+	err = npMgr.deletePod(podObj, true)
+
+	return err
+}
+
+// deletePodFromIpsets deletes a pod from the ipsets of its namespace, labels and named ports.
+func deletePodFromIpsets(ipsMgr *ipsm.IpsetManager, podObj *corev1.Pod) error {
+	podNs := podObj.ObjectMeta.Namespace
 	podLabels := podObj.ObjectMeta.Labels
 	podIP := podObj.Status.PodIP
-	log.Printf("POD DELETING: %s/%s/%s\n", podNs, podName, podNodeName)
 
-	// Delete pod from ipset
-	ipsMgr := npMgr.nsMap[util.KubeAllNamespacesFlag].ipsMgr
 	// Delete the pod from its namespace's ipset.
-	if err = ipsMgr.DeleteFromSet(podNs, podIP); err != nil {
+	if err := ipsMgr.DeleteFromSet(podNs, podIP); err != nil {
 		log.Printf("Error deleting pod from namespace ipset.\n")
 		return err
 	}
@@ -165,14 +256,102 @@ func (npMgr *NetworkPolicyManager) DeletePod(podObj *corev1.Pod) error {
 			continue
 		}
 
-		labelKey := util.KubeAllNamespacesFlag + "-" + podLabelKey + ":" + podLabelVal
-		if err = ipsMgr.DeleteFromSet(labelKey, podIP); err != nil {
+		labelKey := getPodIpsetName(podLabelKey, podLabelVal)
+		if err := ipsMgr.DeleteFromSet(labelKey, podIP); err != nil {
 			log.Printf("Error deleting pod from label ipset.\n")
 			return err
 		}
+
+		if err := ipsMgr.DeleteFromSet(getPodKeyIpsetName(podLabelKey), podIP); err != nil {
+			log.Printf("Error deleting pod from label key ipset.\n")
+			return err
+		}
+	}
+
+	// Delete the pod from the ipsets of its named container ports.
+	for setName, entries := range getNamedPortIpsetEntries(podObj) {
+		for _, entry := range entries {
+			if err := ipsMgr.DeleteFromSet(setName, entry); err != nil {
+				log.Printf("Error deleting pod from named port ipset.\n")
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// deletePod deletes a pod from the ipsets of its IP family.
+// Pods whose add failed were never counted, so only added ones are removed from the cluster state.
+func (npMgr *NetworkPolicyManager) deletePod(podObj *corev1.Pod, isAdded bool) error {
+	family := util.GetIPFamily(podObj.Status.PodIP)
+	if !isValidPod(podObj) || !npMgr.isFamilyEnforced(family) {
+		return nil
+	}
+
+	if err := npMgr.applyPodToIpsets(podObj, family, deletePodFromIpsets); err != nil {
+		return err
+	}
+
+	if isAdded {
+		npMgr.Lock()
+		npMgr.clusterState.PodCount--
+		npMgr.Unlock()
+	}
+
+	return nil
+}
+
+// isPodDeleting checks whether a pod is being deleted, in which case it's no longer programmed.
+func isPodDeleting(podObj *corev1.Pod) bool {
+	return podObj.ObjectMeta.DeletionTimestamp != nil || podObj.ObjectMeta.DeletionGracePeriodSeconds != nil
+}
+
+// syncPod programs the ipsets of a pod from its latest state in the informer cache.
+// Changed pods are deleted then added again. Pods whose add failed are cleaned up
+// and retried as adds, so that the cluster state only counts added pods.
+func (npMgr *NetworkPolicyManager) syncPod(key string) error {
+	obj, exists, err := npMgr.podInformer.Informer().GetIndexer().GetByKey(key)
+	if err != nil {
+		log.Printf("Error getting pod %s from informer cache.\n", key)
+		return err
+	}
+
+	if oldPodObj, isSynced := npMgr.syncedPods[key]; isSynced {
+		if exists && oldPodObj.ObjectMeta.ResourceVersion == obj.(*corev1.Pod).ObjectMeta.ResourceVersion {
+			return nil
+		}
+
+		if err = npMgr.DeletePod(oldPodObj); err != nil {
+			return err
+		}
+
+		delete(npMgr.syncedPods, key)
+	}
+
+	if failedPodObj, isFailed := npMgr.failedPods[key]; isFailed {
+		if err = npMgr.deletePod(failedPodObj, false); err != nil {
+			return err
+		}
+
+		delete(npMgr.failedPods, key)
+	}
+
+	if !exists {
+		return nil
+	}
+
+	podObj := obj.(*corev1.Pod)
+	if isPodDeleting(podObj) {
+		return nil
+	}
+
+	if err = npMgr.AddPod(podObj); err != nil {
+		npMgr.failedPods[key] = podObj
+		return err
 	}
 
-	npMgr.clusterState.PodCount--
+	npMgr.syncedPods[key] = podObj
 
 	return nil
 }
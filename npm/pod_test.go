@@ -3,12 +3,19 @@
 package npm
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Azure/azure-container-networking/npm/ipsm"
 	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/Azure/azure-container-networking/telemetry"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 )
 
 func TestisValidPod(t *testing.T) {
@@ -34,6 +41,30 @@ func TestisSystemPod(t *testing.T) {
 	}
 }
 
+func TestGetNamedPortIpsetEntries(t *testing.T) {
+	podObj := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Ports: []corev1.ContainerPort{
+					{Name: "http", ContainerPort: 8080},
+					{Name: "dns", ContainerPort: 53, Protocol: corev1.ProtocolUDP},
+					{ContainerPort: 9090},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{
+			PodIP: "1.2.3.4",
+		},
+	}
+
+	entries := getNamedPortIpsetEntries(podObj)
+	if len(entries) != 2 ||
+		len(entries["namedport:http"]) != 1 || entries["namedport:http"][0] != "1.2.3.4,tcp:8080" ||
+		len(entries["namedport:dns"]) != 1 || entries["namedport:dns"][0] != "1.2.3.4,udp:53" {
+		t.Errorf("TestGetNamedPortIpsetEntries failed @ getNamedPortIpsetEntries %+v", entries)
+	}
+}
+
 func TestAddPod(t *testing.T) {
 	npMgr := &NetworkPolicyManager{
 		nsMap: make(map[string]*namespace),
@@ -172,3 +203,104 @@ func TestDeletePod(t *testing.T) {
 		t.Errorf("TestDeletePod failed @ DeletePod")
 	}
 }
+
+func TestSyncPodRetriesFailedAdd(t *testing.T) {
+	// Telemetry reports are sent to a local server.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// ipset is replaced by a script failing while the fail file exists.
+	dir := t.TempDir()
+	failFile := filepath.Join(dir, "fail")
+	fakeCommands(t, dir, "#!/bin/sh\ncat > /dev/null\n[ ! -e "+failFile+" ]\n", util.Ipset)
+
+	podInformer := informers.NewSharedInformerFactory(nil, 0).Core().V1().Pods()
+	npMgr := &NetworkPolicyManager{
+		podInformer: podInformer,
+		podQueue:    newWorkQueue(),
+		syncedPods:  make(map[string]*corev1.Pod),
+		failedPods:  make(map[string]*corev1.Pod),
+		nsMap:       make(map[string]*namespace),
+		reportManager: &telemetry.NPMReportManager{
+			ReportManager: &telemetry.ReportManager{HostNetAgentURL: server.URL},
+			Report:        &telemetry.NPMReport{},
+		},
+	}
+
+	allNs, err := newNs(util.KubeAllNamespacesFlag)
+	if err != nil {
+		panic(err.Error)
+	}
+	npMgr.nsMap[util.KubeAllNamespacesFlag] = allNs
+
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "test-pod",
+			Namespace:       "test-namespace",
+			ResourceVersion: "1",
+			Labels:          map[string]string{"app": "test-pod"},
+		},
+		Status: corev1.PodStatus{
+			Phase: "Running",
+			PodIP: "1.2.3.4",
+		},
+	}
+	key := "test-namespace/test-pod"
+	indexer := podInformer.Informer().GetIndexer()
+
+	testCases := []struct {
+		name     string
+		fail     bool
+		update   func()
+		isSynced bool
+		isFailed bool
+		podCount int
+	}{
+		{"failed add", true, func() { indexer.Add(podObj) }, false, true, 0},
+		{"failed retry of a changed pod", true, func() {
+			podObj = podObj.DeepCopy()
+			podObj.ObjectMeta.ResourceVersion = "2"
+			podObj.ObjectMeta.Labels["app"] = "test-pod-2"
+			indexer.Update(podObj)
+		}, false, true, 0},
+		{"retried add", false, func() {}, true, false, 1},
+		{"synced pod", false, func() {}, true, false, 1},
+		{"updated pod", false, func() {
+			podObj = podObj.DeepCopy()
+			podObj.ObjectMeta.ResourceVersion = "3"
+			indexer.Update(podObj)
+		}, true, false, 1},
+		{"failed update", true, func() {
+			podObj = podObj.DeepCopy()
+			podObj.ObjectMeta.ResourceVersion = "4"
+			indexer.Update(podObj)
+		}, true, false, 1},
+		{"deleted pod", false, func() { indexer.Delete(podObj) }, false, false, 0},
+		{"deleted failed pod", true, func() { indexer.Add(podObj) }, false, true, 0},
+		{"cleaned up failed pod", false, func() { indexer.Delete(podObj) }, false, false, 0},
+	}
+
+	for _, tc := range testCases {
+		if tc.fail {
+			if err := ioutil.WriteFile(failFile, nil, 0644); err != nil {
+				t.Fatalf("TestSyncPodRetriesFailedAdd failed @ ioutil.WriteFile")
+			}
+		} else {
+			os.Remove(failFile)
+		}
+
+		tc.update()
+		err := npMgr.syncPod(key)
+		if (err != nil) != tc.fail {
+			t.Errorf("TestSyncPodRetriesFailedAdd failed @ %s: syncPod returned %v", tc.name, err)
+		}
+
+		_, isSynced := npMgr.syncedPods[key]
+		_, isFailed := npMgr.failedPods[key]
+		podCount := npMgr.GetClusterState().PodCount
+		if isSynced != tc.isSynced || isFailed != tc.isFailed || podCount != tc.podCount {
+			t.Errorf("TestSyncPodRetriesFailedAdd failed @ %s: synced %t failed %t pod count %d",
+				tc.name, isSynced, isFailed, podCount)
+		}
+	}
+}
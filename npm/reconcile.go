@@ -0,0 +1,199 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+	"github.com/Azure/azure-container-networking/npm/ipsm"
+	"github.com/Azure/azure-container-networking/npm/iptm"
+	"github.com/Azure/azure-container-networking/npm/util"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// reconcileInterval is the interval between two reconciliations of iptables and ipset.
+	reconcileInterval = 5 * time.Minute
+)
+
+// RunReconciler periodically repairs iptables and ipset drift from the state desired by NPM.
+func (npMgr *NetworkPolicyManager) RunReconciler() {
+	for {
+		time.Sleep(reconcileInterval)
+
+		if err := npMgr.reconcile(); err != nil {
+			log.Printf("Error reconciling iptables and ipset: %v", err)
+		}
+	}
+}
+
+// buildDesiredIpsets rebuilds the ipsets of an IP family desired by NPM from the informer caches and the network policies.
+func (npMgr *NetworkPolicyManager) buildDesiredIpsets(family string) (*ipsm.IpsetManager, error) {
+	ipsMgr := ipsm.NewIpsetManagerWithBackend(family, npMgr.backend)
+
+	nsObjs, err := npMgr.nsInformer.Lister().List(labels.Everything())
+	if err != nil {
+		log.Printf("Error listing namespaces.\n")
+		return nil, err
+	}
+
+	for _, nsObj := range nsObjs {
+		if nsObj.ObjectMeta.DeletionTimestamp != nil {
+			continue
+		}
+
+		if err = addNsToIpsets(ipsMgr, nsObj); err != nil {
+			return nil, err
+		}
+	}
+
+	podObjs, err := npMgr.podInformer.Lister().List(labels.Everything())
+	if err != nil {
+		log.Printf("Error listing pods.\n")
+		return nil, err
+	}
+
+	for _, podObj := range podObjs {
+		if !isValidPod(podObj) || podObj.ObjectMeta.DeletionTimestamp != nil || util.GetIPFamily(podObj.Status.PodIP) != family {
+			continue
+		}
+
+		if err = addPodToIpsets(ipsMgr, podObj); err != nil {
+			return nil, err
+		}
+	}
+
+	if npMgr.isAzureNpmChainCreated {
+		if err = ipsMgr.CreateSet(util.KubeSystemFlag); err != nil {
+			log.Printf("Error initialize kube-system ipset.\n")
+			return nil, err
+		}
+	}
+
+	for _, npObj := range npMgr.getNetworkPolicies() {
+		podSets, nsLists, _ := parsePolicy(npObj)
+		for _, set := range podSets {
+			if err = ipsMgr.CreateSet(set); err != nil {
+				log.Printf("Error creating ipset %s\n", set)
+				return nil, err
+			}
+		}
+
+		for _, list := range nsLists {
+			if err = ipsMgr.CreateList(list); err != nil {
+				log.Printf("Error creating ipset list %s\n", list)
+				return nil, err
+			}
+		}
+	}
+
+	return ipsMgr, nil
+}
+
+// buildDesiredIptables rebuilds the rules of azure-npm chains of an IP family desired by NPM from the network policies.
+func (npMgr *NetworkPolicyManager) buildDesiredIptables(family string) (*iptm.IptablesManager, error) {
+	iptMgr := iptm.NewIptablesManagerWithBackend(family, npMgr.backend)
+	if err := iptMgr.AddDefaultRules(); err != nil {
+		log.Printf("Error adding default rules of azure-npm chains.\n")
+		return nil, err
+	}
+
+	for _, npObj := range npMgr.getNetworkPolicies() {
+		_, _, iptEntries := npMgr.parsePolicyWithDropLogging(npObj)
+		for _, iptEntry := range iptEntries {
+			if err := iptMgr.Add(iptEntry); err != nil {
+				log.Printf("Error adding iptables rule\n. Rule: %+v", iptEntry)
+				return nil, err
+			}
+		}
+	}
+
+	return iptMgr, nil
+}
+
+// reconcile rebuilds the desired state of NPM, repairs iptables and ipset drift from it
+// and reports the drift.
+// Events are blocked while reconciling, so that the desired state doesn't miss a partially programmed one.
+func (npMgr *NetworkPolicyManager) reconcile() error {
+	npMgr.policyLock.Lock()
+	defer npMgr.policyLock.Unlock()
+
+	npMgr.ipsetLock.Lock()
+	defer npMgr.ipsetLock.Unlock()
+
+	npMgr.Lock()
+	npMgr.reportManager.Report.DriftState.ReconcileCount++
+	npMgr.Unlock()
+
+	for _, family := range npMgr.getFamilies() {
+		if err := npMgr.reconcileFamily(family); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reconcileFamily repairs iptables and ipset drift of an IP family.
+func (npMgr *NetworkPolicyManager) reconcileFamily(family string) error {
+	allNs := npMgr.getAllNs()
+
+	ipsMgr, err := npMgr.buildDesiredIpsets(family)
+	if err != nil {
+		return err
+	}
+	ipsMgr.DryRun = npMgr.getIpsetManager(family).DryRun
+
+	// Ipsets have to be repaired before the iptables rules referencing them.
+	ipsetDrift, err := ipsMgr.Reconcile()
+	npMgr.Lock()
+	npMgr.reportManager.Report.DriftState.IpsetDriftCount += ipsetDrift
+	npMgr.Unlock()
+	if err != nil {
+		log.Printf("Error reconciling %s ipset.\n", family)
+		return err
+	}
+
+	if family == util.IPv6Family {
+		allNs.ipsMgrV6 = ipsMgr
+	} else {
+		allNs.ipsMgr = ipsMgr
+	}
+
+	if ipsetDrift > 0 {
+		log.Printf("Repaired %d %s ipset drifts.\n", ipsetDrift, family)
+	}
+
+	if !npMgr.isAzureNpmChainCreated {
+		return nil
+	}
+
+	iptMgr, err := npMgr.buildDesiredIptables(family)
+	if err != nil {
+		return err
+	}
+	iptMgr.DryRun = npMgr.getIptablesManager(family).DryRun
+
+	iptablesDrift, err := iptMgr.Reconcile()
+	npMgr.Lock()
+	npMgr.reportManager.Report.DriftState.IptablesDriftCount += iptablesDrift
+	npMgr.Unlock()
+	if err != nil {
+		log.Printf("Error reconciling %s iptables.\n", family)
+		return err
+	}
+
+	if family == util.IPv6Family {
+		allNs.iptMgrV6 = iptMgr
+	} else {
+		allNs.iptMgr = iptMgr
+	}
+
+	if iptablesDrift > 0 {
+		log.Printf("Repaired %d %s iptables drifts.\n", iptablesDrift, family)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package npm
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-container-networking/npm/util"
+	"github.com/Azure/azure-container-networking/telemetry"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+)
+
+// fakeCommands replaces commands by a script in dir, which is put in front of PATH for the test.
+func fakeCommands(t *testing.T, dir string, script string, cmds ...string) {
+	for _, cmd := range cmds {
+		if err := ioutil.WriteFile(filepath.Join(dir, cmd), []byte(script), 0755); err != nil {
+			t.Fatalf("fakeCommands failed @ ioutil.WriteFile of %s", cmd)
+		}
+	}
+
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// getSortedRules returns the lines of the rendered rules of an IP family in a stable order.
+func getSortedRules(t *testing.T, npMgr *NetworkPolicyManager, family string) []string {
+	rendered, err := npMgr.getIptablesManager(family).Render()
+	if err != nil {
+		t.Fatalf("getSortedRules failed @ Render, err: %v", err)
+	}
+
+	rules := strings.Split(string(rendered), "\n")
+	sort.Strings(rules)
+
+	return rules
+}
+
+func TestReconcileSameNamedNetworkPolicies(t *testing.T) {
+	// Telemetry reports are sent to a local server.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// Commands succeed without output, so the reconciler finds everything missing and reprograms it in dry run mode.
+	fakeCommands(t, t.TempDir(), "#!/bin/sh\ncat > /dev/null\n",
+		util.Ipset, util.Iptables, util.IptablesSave, util.IptablesRestore)
+
+	informerFactory := informers.NewSharedInformerFactory(nil, 0)
+	npMgr := &NetworkPolicyManager{
+		podInformer: informerFactory.Core().V1().Pods(),
+		nsInformer:  informerFactory.Core().V1().Namespaces(),
+		nsMap:       make(map[string]*namespace),
+		reportManager: &telemetry.NPMReportManager{
+			ReportManager: &telemetry.ReportManager{HostNetAgentURL: server.URL},
+			Report:        &telemetry.NPMReport{},
+		},
+	}
+
+	allNs, err := newNs(util.KubeAllNamespacesFlag)
+	if err != nil {
+		panic(err.Error)
+	}
+	allNs.ipsMgr.DryRun = true
+	allNs.iptMgr.DryRun = true
+	npMgr.nsMap[util.KubeAllNamespacesFlag] = allNs
+
+	var npObjs []*networkingv1.NetworkPolicy
+	for _, npNs := range []string{"test-namespace-a", "test-namespace-b"} {
+		npObj := &networkingv1.NetworkPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "allow-ingress",
+				Namespace: npNs,
+			},
+			Spec: networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						PodSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": "test"},
+						},
+					}},
+				}},
+			},
+		}
+
+		if err := npMgr.AddNetworkPolicy(npObj); err != nil {
+			t.Fatalf("TestReconcileSameNamedNetworkPolicies failed @ AddNetworkPolicy of %s", npNs)
+		}
+		npObjs = append(npObjs, npObj)
+	}
+
+	// The desired state rebuilt by the reconciler keeps the rules of both policies.
+	rules := getSortedRules(t, npMgr, util.IPv4Family)
+	if err := npMgr.reconcile(); err != nil {
+		t.Errorf("TestReconcileSameNamedNetworkPolicies failed @ reconcile")
+	}
+
+	if reconciledRules := getSortedRules(t, npMgr, util.IPv4Family); strings.Join(reconciledRules, "\n") != strings.Join(rules, "\n") {
+		t.Errorf("TestReconcileSameNamedNetworkPolicies failed @ reconcile, rules %v, expected %v", reconciledRules, rules)
+	}
+
+	// Deleting one of the policies keeps azure-npm chains of the other.
+	if err := npMgr.DeleteNetworkPolicy(npObjs[0]); err != nil {
+		t.Errorf("TestReconcileSameNamedNetworkPolicies failed @ DeleteNetworkPolicy")
+	}
+
+	if !npMgr.isAzureNpmChainCreated || npMgr.GetClusterState().NwPolicyCount != 1 || len(npMgr.getNetworkPolicies()) != 1 {
+		t.Errorf("TestReconcileSameNamedNetworkPolicies failed @ DeleteNetworkPolicy, policy count %d", npMgr.GetClusterState().NwPolicyCount)
+	}
+}
@@ -15,6 +15,12 @@ const (
 	Iptables                      string = "iptables"
 	IptablesSave                  string = "iptables-save"
 	IptablesRestore               string = "iptables-restore"
+	Ip6tables                     string = "ip6tables"
+	Ip6tablesSave                 string = "ip6tables-save"
+	Ip6tablesRestore              string = "ip6tables-restore"
+	IptablesNoFlushFlag           string = "--noflush"
+	IptablesTableFlag             string = "-t"
+	IptablesFilterTable           string = "filter"
 	IptablesConfigFile            string = "/var/log/iptables.conf"
 	IptablesTestConfigFile        string = "/var/log/iptables-test.conf"
 	IptablesChainCreationFlag     string = "-N"
@@ -34,9 +40,13 @@ const (
 	IptablesSFlag                 string = "-s"
 	IptablesDFlag                 string = "-d"
 	IptablesDstPortFlag           string = "--dport"
+	IptablesDstPortsFlag          string = "--dports"
+	IptablesSrcPortFlag           string = "--sport"
+	IptablesDstDstFlag            string = "dst,dst"
 	IptablesMatchFlag             string = "-m"
 	IptablesSetFlag               string = "set"
 	IptablesMatchSetFlag          string = "--match-set"
+	IptablesNotFlag               string = "!"
 	IptablesStateFlag             string = "state"
 	IPtablesMatchStateFlag        string = "--state"
 	IptablesRelatedState          string = "RELATED"
@@ -48,6 +58,21 @@ const (
 	IptablesAzureEgressToChain    string = "AZURE-NPM-EGRESS-TO"
 	IptablesAzureTargetSetsChain  string = "AZURE-NPM-TARGET-SETS"
 	IptablesForwardChain          string = "FORWARD"
+	IptablesLog                   string = "LOG"
+	IptablesLogPrefixFlag         string = "--log-prefix"
+	IptablesLimitFlag             string = "limit"
+	IptablesLimitRateFlag         string = "--limit"
+	IptablesDropLogRate           string = "10/sec"
+	IptablesDropLogPrefix         string = "azure-npm-drop-"
+	IptablesMultiportFlag         string = "multiport"
+	IptablesMultiportMaxPorts     int    = 15
+)
+
+//NPM drop logging related constants.
+const (
+	KernelLogFile          string = "/dev/kmsg"
+	DropLoggingEnvVariable string = "AZURE_NPM_DROP_LOGGING"
+	AuditModeEnvVariable   string = "AZURE_NPM_AUDIT_MODE"
 )
 
 //ipset related constants.
@@ -63,12 +88,42 @@ const (
 	IpsetFlushFlag      string = "-F"
 	IpsetDestroyFlag    string = "-X"
 
-	IpsetExistFlag string = "-exist"
-	IpsetFileFlag  string = "-file"
+	IpsetExistFlag  string = "-exist"
+	IpsetFileFlag   string = "-file"
+	IpsetFamilyFlag string = "family"
+
+	IpsetSetListFlag    string = "setlist"
+	IpsetNetHashFlag    string = "nethash"
+	IpsetIPPortHashFlag string = "hash:ip,port"
+	AzureNpmPrefix      string = "azure-npm-"
 
-	IpsetSetListFlag string = "setlist"
-	IpsetNetHashFlag string = "nethash"
-	AzureNpmPrefix   string = "azure-npm-"
+	NamedPortIpsetPrefix string = "namedport:"
+	IPv6IpsetSuffix      string = "-v6"
+)
+
+//IP family related constants.
+const (
+	IPv4Family string = "inet"
+	IPv6Family string = "inet6"
+)
+
+//NPM backend related constants.
+const (
+	IptablesBackend string = "iptables"
+	NftablesBackend string = "nftables"
+)
+
+//nftables related constants.
+const (
+	Nft             string = "nft"
+	NftFileFlag     string = "-f"
+	NftStdin        string = "-"
+	NftList         string = "list"
+	NftTableFlag    string = "table"
+	NftTable        string = "azure-npm"
+	NftIPFamily     string = "ip"
+	NftIP6Family    string = "ip6"
+	NftForwardChain string = "FORWARD"
 )
 
 //NPM telemetry constants.
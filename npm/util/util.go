@@ -5,6 +5,9 @@ package util
 import (
 	"fmt"
 	"hash/fnv"
+	"net"
+	"os"
+	"os/exec"
 	"strings"
 )
 
@@ -53,3 +56,53 @@ func AppendMap(base, new map[string]string) map[string]string {
 func GetHashedName(name string) string {
 	return AzureNpmPrefix + Hash(name)
 }
+
+// GetHashedNameForFamily returns hashed ipset name of an IP family.
+// Ipset names are shared by both families, so IPv6 sets carry a suffix.
+func GetHashedNameForFamily(name string, family string) string {
+	if family == IPv6Family {
+		return GetHashedName(name) + IPv6IpsetSuffix
+	}
+
+	return GetHashedName(name)
+}
+
+// GetIPFamily returns the IP family of an address or CIDR, or an empty string if it's neither.
+// Trailing ipset entry fields such as ",tcp:80" are ignored.
+func GetIPFamily(address string) string {
+	address = strings.Split(address, ",")[0]
+	address = strings.Split(address, "/")[0]
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+
+	if ip.To4() == nil {
+		return IPv6Family
+	}
+
+	return IPv4Family
+}
+
+// IsIPv6Enabled checks whether the host has IPv6 and ip6tables to enforce IPv6 policies.
+func IsIPv6Enabled() bool {
+	if _, err := os.Stat("/proc/net/if_inet6"); err != nil {
+		return false
+	}
+
+	if _, err := exec.LookPath(Ip6tables); err != nil {
+		return false
+	}
+
+	return true
+}
+
+// GetNftFamily returns the nftables address family of an IP family.
+func GetNftFamily(family string) string {
+	if family == IPv6Family {
+		return NftIP6Family
+	}
+
+	return NftIPFamily
+}
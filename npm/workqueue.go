@@ -0,0 +1,184 @@
+// Copyright 2018 Microsoft. All rights reserved.
+// MIT License
+package npm
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// Backoff of retries of failed keys.
+	baseRetryDelay = 100 * time.Millisecond
+	maxRetryDelay  = 5 * time.Minute
+
+	// Keys failing more often are dropped and left to the reconciler.
+	maxRetries = 15
+)
+
+// workQueue is a queue of object keys processed by a single worker.
+// A key added several times before being processed is processed once, and a key added
+// while being processed is processed again afterwards, so rapid updates of an object coalesce.
+// Failed keys are retried with exponential backoff.
+type workQueue struct {
+	sync.Mutex
+	cond       *sync.Cond
+	queue      []string
+	queued     map[string]bool
+	processing map[string]bool
+	dirty      map[string]bool
+	failures   map[string]int
+	shutDown   bool
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// newWorkQueue creates a new instance for workQueue object.
+func newWorkQueue() *workQueue {
+	q := &workQueue{
+		queued:     make(map[string]bool),
+		processing: make(map[string]bool),
+		dirty:      make(map[string]bool),
+		failures:   make(map[string]int),
+		baseDelay:  baseRetryDelay,
+		maxDelay:   maxRetryDelay,
+	}
+	q.cond = sync.NewCond(&q.Mutex)
+
+	return q
+}
+
+// add adds a key to the queue unless it's already waiting to be processed.
+func (q *workQueue) add(key string) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.shutDown || q.queued[key] {
+		return
+	}
+
+	if q.processing[key] {
+		// The key is added back once its processing is done.
+		q.dirty[key] = true
+		return
+	}
+
+	q.queued[key] = true
+	q.queue = append(q.queue, key)
+	q.cond.Signal()
+}
+
+// addRateLimited adds a key to the queue after a delay that grows with its number of failures.
+func (q *workQueue) addRateLimited(key string) {
+	q.Lock()
+	delay := q.baseDelay << uint(q.failures[key])
+	if delay > q.maxDelay || delay <= 0 {
+		delay = q.maxDelay
+	}
+	q.failures[key]++
+	q.Unlock()
+
+	time.AfterFunc(delay, func() {
+		q.add(key)
+	})
+}
+
+// numRequeues returns the number of failures of a key since it was last forgotten.
+func (q *workQueue) numRequeues(key string) int {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.failures[key]
+}
+
+// forget resets the failures of a key.
+func (q *workQueue) forget(key string) {
+	q.Lock()
+	defer q.Unlock()
+
+	delete(q.failures, key)
+}
+
+// get blocks until a key is available and marks it as being processed.
+// It returns false once the queue is shut down.
+func (q *workQueue) get() (string, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	for len(q.queue) == 0 && !q.shutDown {
+		q.cond.Wait()
+	}
+
+	if len(q.queue) == 0 {
+		return "", false
+	}
+
+	key := q.queue[0]
+	q.queue = q.queue[1:]
+	delete(q.queued, key)
+	q.processing[key] = true
+
+	return key, true
+}
+
+// done marks a key as processed, and adds it back if it was added during its processing.
+func (q *workQueue) done(key string) {
+	q.Lock()
+	delete(q.processing, key)
+	isDirty := q.dirty[key]
+	delete(q.dirty, key)
+	q.Unlock()
+
+	if isDirty {
+		q.add(key)
+	}
+}
+
+// len returns the number of keys waiting to be processed.
+func (q *workQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return len(q.queue)
+}
+
+// shutDownQueue stops the queue and wakes up its worker.
+func (q *workQueue) shutDownQueue() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.shutDown = true
+	q.cond.Broadcast()
+}
+
+// runWorker processes the keys of a queue with a sync function until the queue is shut down.
+func runWorker(q *workQueue, kind string, syncFunc func(key string) error) {
+	for processNextKey(q, kind, syncFunc) {
+	}
+}
+
+// processNextKey processes the next key of a queue and retries it with backoff if it fails.
+// It returns false once the queue is shut down.
+func processNextKey(q *workQueue, kind string, syncFunc func(key string) error) bool {
+	key, ok := q.get()
+	if !ok {
+		return false
+	}
+	defer q.done(key)
+
+	if err := syncFunc(key); err != nil {
+		if q.numRequeues(key) < maxRetries {
+			log.Printf("Error syncing %s %s, retrying: %v\n", kind, key, err)
+			q.addRateLimited(key)
+			return true
+		}
+
+		log.Printf("Error syncing %s %s, dropping it after %d retries: %v\n", kind, key, maxRetries, err)
+	}
+
+	q.forget(key)
+
+	return true
+}
@@ -0,0 +1,102 @@
+package npm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWorkQueueCoalesce(t *testing.T) {
+	q := newWorkQueue()
+
+	q.add("default/a")
+	q.add("default/b")
+	q.add("default/a")
+	if q.len() != 2 {
+		t.Errorf("TestWorkQueueCoalesce failed @ q.add, queued %d keys", q.len())
+	}
+
+	key, ok := q.get()
+	if !ok || key != "default/a" {
+		t.Errorf("TestWorkQueueCoalesce failed @ q.get %s", key)
+	}
+
+	// A key added while being processed is processed again once done.
+	q.add("default/a")
+	if q.len() != 1 {
+		t.Errorf("TestWorkQueueCoalesce failed @ q.add while processing")
+	}
+
+	q.done("default/a")
+	if q.len() != 2 {
+		t.Errorf("TestWorkQueueCoalesce failed @ q.done")
+	}
+
+	q.shutDownQueue()
+	q.add("default/c")
+	if q.len() != 2 {
+		t.Errorf("TestWorkQueueCoalesce failed @ q.add after shut down")
+	}
+}
+
+func TestWorkQueueRetry(t *testing.T) {
+	q := newWorkQueue()
+	q.baseDelay = time.Millisecond
+
+	attempts := 0
+	syncFunc := func(key string) error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("transient failure")
+		}
+
+		return nil
+	}
+
+	q.add("default/a")
+	for i := 0; i < 3; i++ {
+		if !processNextKey(q, "test", syncFunc) {
+			t.Errorf("TestWorkQueueRetry failed @ processNextKey")
+		}
+	}
+
+	if attempts != 3 {
+		t.Errorf("TestWorkQueueRetry failed, %d attempts", attempts)
+	}
+
+	if q.numRequeues("default/a") != 0 {
+		t.Errorf("TestWorkQueueRetry failed @ q.forget")
+	}
+
+	q.shutDownQueue()
+	if processNextKey(q, "test", syncFunc) {
+		t.Errorf("TestWorkQueueRetry failed @ processNextKey after shut down")
+	}
+}
+
+func TestWorkQueueMaxRetries(t *testing.T) {
+	q := newWorkQueue()
+	q.baseDelay = time.Microsecond
+	q.maxDelay = time.Microsecond
+
+	attempts := 0
+	syncFunc := func(key string) error {
+		attempts++
+		return fmt.Errorf("permanent failure")
+	}
+
+	q.add("default/a")
+	for i := 0; i <= maxRetries; i++ {
+		processNextKey(q, "test", syncFunc)
+	}
+
+	if attempts != maxRetries+1 || q.numRequeues("default/a") != 0 {
+		t.Errorf("TestWorkQueueMaxRetries failed, %d attempts", attempts)
+	}
+
+	// The key is dropped after the last retry.
+	time.Sleep(10 * time.Millisecond)
+	if q.len() != 0 {
+		t.Errorf("TestWorkQueueMaxRetries failed @ q.len")
+	}
+}
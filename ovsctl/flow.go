@@ -0,0 +1,339 @@
+package ovsctl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	ovsOfctl = "ovs-ofctl"
+
+	// DefaultFlowPriority is the priority ovs-ofctl gives to flows that do not set one.
+	DefaultFlowPriority = 32768
+)
+
+// Fields of dumped flows that are statistics and timeouts rather than part of the flow.
+var flowStatFields = map[string]bool{
+	"duration":      true,
+	"n_packets":     true,
+	"n_bytes":       true,
+	"idle_age":      true,
+	"hard_age":      true,
+	"idle_timeout":  true,
+	"hard_timeout":  true,
+	"importance":    true,
+	"send_flow_rem": true,
+	"reset_counts":  true,
+	"check_overlap": true,
+}
+
+// FlowMatch is a field of the match of a flow. Protocol shorthands such as "ip" have no value.
+type FlowMatch struct {
+	Field string
+	Value string
+}
+
+// Flow represents an OpenFlow flow in the syntax of ovs-ofctl.
+// Flows are identified by their table, priority and match, as in OpenFlow.
+type Flow struct {
+	Table    int
+	Priority int
+	Cookie   uint64
+	Match    []FlowMatch
+	Actions  []string
+}
+
+// String returns the flow as an argument of ovs-ofctl add-flow.
+func (flow *Flow) String() string {
+	fields := []string{fmt.Sprintf("table=%d", flow.Table), fmt.Sprintf("priority=%d", flow.Priority)}
+
+	if flow.Cookie != 0 {
+		fields = append(fields, fmt.Sprintf("cookie=0x%x", flow.Cookie))
+	}
+
+	fields = append(fields, flow.matchFields()...)
+	fields = append(fields, "actions="+strings.Join(flow.Actions, ","))
+
+	return strings.Join(fields, ",")
+}
+
+// MatchString returns the table and match of the flow as an argument of ovs-ofctl del-flows,
+// which matches flows of any priority.
+func (flow *Flow) MatchString() string {
+	return strings.Join(append([]string{fmt.Sprintf("table=%d", flow.Table)}, flow.matchFields()...), ",")
+}
+
+// matchFields returns the match of the flow in the syntax of ovs-ofctl.
+func (flow *Flow) matchFields() []string {
+	var fields []string
+	for _, match := range flow.Match {
+		if match.Value == "" {
+			fields = append(fields, match.Field)
+		} else {
+			fields = append(fields, match.Field+"="+match.Value)
+		}
+	}
+	return fields
+}
+
+// normalizeFlowValue returns a value the way it is compared, regardless of how ovs-ofctl formats it.
+func normalizeFlowValue(value string) string {
+	value = strings.ToLower(strings.Trim(value, "\""))
+
+	if number, err := strconv.ParseUint(value, 0, 64); err == nil {
+		return strconv.FormatUint(number, 10)
+	}
+
+	return value
+}
+
+// key identifies a flow by its table, priority and match.
+func (flow *Flow) key() string {
+	var fields []string
+	for _, match := range flow.Match {
+		fields = append(fields, match.Field+"="+normalizeFlowValue(match.Value))
+	}
+	sort.Strings(fields)
+
+	return fmt.Sprintf("table=%d,priority=%d,%s", flow.Table, flow.Priority, strings.Join(fields, ","))
+}
+
+// actionsKey returns the actions of the flow the way they are compared, regardless of the case
+// ovs-ofctl formats them in.
+func (flow *Flow) actionsKey() string {
+	return strings.ToLower(strings.Join(flow.Actions, ","))
+}
+
+// splitActions splits a list of actions at commas that are not enclosed in parentheses or brackets.
+func splitActions(actions string) []string {
+	var result []string
+	depth := 0
+	start := 0
+
+	for i, c := range actions {
+		switch c {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				result = append(result, actions[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	if start < len(actions) {
+		result = append(result, actions[start:])
+	}
+
+	return result
+}
+
+// ParseFlow parses a flow in the format of ovs-ofctl dump-flows.
+func ParseFlow(line string) (*Flow, error) {
+	line = strings.TrimSpace(line)
+
+	index := strings.Index(line, "actions=")
+	if index < 0 {
+		return nil, fmt.Errorf("Missing actions in flow %s", line)
+	}
+
+	flow := &Flow{
+		Priority: DefaultFlowPriority,
+		Actions:  splitActions(line[index+len("actions="):]),
+	}
+
+	// Fields are separated by commas, and statistics by spaces as well.
+	fields := strings.FieldsFunc(line[:index], func(c rune) bool { return c == ',' || c == ' ' })
+
+	for _, field := range fields {
+		var name, value string
+		if i := strings.Index(field, "="); i >= 0 {
+			name, value = field[:i], field[i+1:]
+		} else {
+			name = field
+		}
+
+		var err error
+
+		switch {
+		case flowStatFields[name]:
+		case name == "table":
+			flow.Table, err = strconv.Atoi(value)
+		case name == "priority":
+			flow.Priority, err = strconv.Atoi(value)
+		case name == "cookie":
+			flow.Cookie, err = strconv.ParseUint(value, 0, 64)
+		default:
+			flow.Match = append(flow.Match, FlowMatch{Field: name, Value: value})
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid field %s in flow %s", field, line)
+		}
+	}
+
+	return flow, nil
+}
+
+// runOfctl runs ovs-ofctl with the given arguments and input, and returns its output.
+func runOfctl(input string, args ...string) ([]byte, error) {
+	cmd := exec.Command(ovsOfctl, args...)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("[ovs] Error running %s %v: %v %s", ovsOfctl, args, err, stderr.String())
+		return nil, fmt.Errorf("%s %s failed: %v %s", ovsOfctl, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// DumpFlows returns the flows of a bridge.
+func DumpFlows(bridgeName string) ([]*Flow, error) {
+	out, err := runOfctl("", "dump-flows", bridgeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var flows []*Flow
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Skip the header of the reply.
+		if !strings.Contains(line, "actions=") {
+			continue
+		}
+
+		flow, err := ParseFlow(line)
+		if err != nil {
+			log.Printf("[ovs] Ignoring flow: %v.", err)
+			continue
+		}
+
+		flows = append(flows, flow)
+	}
+
+	return flows, nil
+}
+
+// getMissingFlows returns the flows without a flow with the same table, priority and match in existing.
+func getMissingFlows(existing []*Flow, flows []*Flow) []*Flow {
+	keys := make(map[string]bool)
+	for _, flow := range existing {
+		keys[flow.key()] = true
+	}
+
+	var missing []*Flow
+	for _, flow := range flows {
+		if !keys[flow.key()] {
+			missing = append(missing, flow)
+		}
+	}
+
+	return missing
+}
+
+// getDivergedFlows returns the flows that are missing in existing or whose actions differ there.
+func getDivergedFlows(existing []*Flow, flows []*Flow) []*Flow {
+	actions := make(map[string]string)
+	for _, flow := range existing {
+		actions[flow.key()] = flow.actionsKey()
+	}
+
+	var diverged []*Flow
+	for _, flow := range flows {
+		if current, ok := actions[flow.key()]; !ok || current != flow.actionsKey() {
+			diverged = append(diverged, flow)
+		}
+	}
+
+	return diverged
+}
+
+// GetMissingFlows returns the flows that are not on a bridge.
+func GetMissingFlows(bridgeName string, flows []*Flow) ([]*Flow, error) {
+	existing, err := DumpFlows(bridgeName)
+	if err != nil {
+		return nil, err
+	}
+
+	return getMissingFlows(existing, flows), nil
+}
+
+// FlowExists returns whether a flow with the same table, priority and match is on a bridge.
+func FlowExists(bridgeName string, flow *Flow) (bool, error) {
+	missing, err := GetMissingFlows(bridgeName, []*Flow{flow})
+	if err != nil {
+		return false, err
+	}
+
+	return len(missing) == 0, nil
+}
+
+// AddFlows reconciles flows on a bridge. Flows that are missing, or whose actions differ from those
+// of the flow with the same table, priority and match, are added, and all of them are verified to be installed.
+func AddFlows(bridgeName string, flows ...*Flow) error {
+	existing, err := DumpFlows(bridgeName)
+	if err != nil {
+		return err
+	}
+
+	diverged := getDivergedFlows(existing, flows)
+	if len(diverged) == 0 {
+		log.Debugf("[ovs] Flows are already on bridge %v.", bridgeName)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, flow := range diverged {
+		fmt.Fprintf(&buf, "%s\n", flow)
+	}
+
+	log.Printf("[ovs] Adding %d of %d flows to bridge %v.", len(diverged), len(flows), bridgeName)
+	log.Debugf("[ovs] Adding flows to bridge %v:\n%s", bridgeName, buf.String())
+
+	if _, err := runOfctl(buf.String(), "add-flows", bridgeName, "-"); err != nil {
+		return err
+	}
+
+	missing, err := GetMissingFlows(bridgeName, flows)
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("Flow %s is missing on bridge %s after it was added", missing[0], bridgeName)
+	}
+
+	return nil
+}
+
+// DeleteFlows deletes the flows of a bridge in the same table with matches that include the given ones,
+// regardless of their priority.
+func DeleteFlows(bridgeName string, flows ...*Flow) error {
+	for _, flow := range flows {
+		if _, err := runOfctl("", "del-flows", bridgeName, flow.MatchString()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
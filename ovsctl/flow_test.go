@@ -0,0 +1,168 @@
+package ovsctl
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSplitActions(t *testing.T) {
+	tests := []struct {
+		actions  string
+		expected []string
+	}{
+		{actions: "", expected: nil},
+		{actions: "NORMAL", expected: []string{"NORMAL"}},
+		{actions: "mod_vlan_vid:10,resubmit(,1)", expected: []string{"mod_vlan_vid:10", "resubmit(,1)"}},
+		{
+			actions:  "load:0x2->NXM_OF_ARP_OP[],move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],IN_PORT",
+			expected: []string{"load:0x2->NXM_OF_ARP_OP[]", "move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[]", "IN_PORT"},
+		},
+		{
+			actions:  "learn(table=1,NXM_OF_ETH_DST[]=NXM_OF_ETH_SRC[],output:NXM_OF_IN_PORT[]),drop",
+			expected: []string{"learn(table=1,NXM_OF_ETH_DST[]=NXM_OF_ETH_SRC[],output:NXM_OF_IN_PORT[])", "drop"},
+		},
+	}
+
+	for _, test := range tests {
+		actions := splitActions(test.actions)
+		if !reflect.DeepEqual(actions, test.expected) {
+			t.Errorf("Expected %q to split into %q but got %q", test.actions, test.expected, actions)
+		}
+	}
+}
+
+func TestParseFlow(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected *Flow
+	}{
+		{
+			line: " cookie=0x0, duration=12.345s, table=0, n_packets=3, n_bytes=180, idle_age=5, priority=20,ip,in_port=5,vlan_tci=0x0000 actions=mod_dl_src:00:0d:3a:00:00:01,strip_vlan,NORMAL",
+			expected: &Flow{
+				Priority: 20,
+				Match:    []FlowMatch{{"ip", ""}, {"in_port", "5"}, {"vlan_tci", "0x0000"}},
+				Actions:  []string{"mod_dl_src:00:0d:3a:00:00:01", "strip_vlan", "NORMAL"},
+			},
+		},
+		{
+			line: "cookie=0x2a, duration=1.5s, table=1, n_packets=0, n_bytes=0, arp,in_port=7,arp_op=1 actions=mod_vlan_vid:10,resubmit(,1)",
+			expected: &Flow{
+				Table:    1,
+				Priority: DefaultFlowPriority,
+				Cookie:   42,
+				Match:    []FlowMatch{{"arp", ""}, {"in_port", "7"}, {"arp_op", "1"}},
+				Actions:  []string{"mod_vlan_vid:10", "resubmit(,1)"},
+			},
+		},
+		{line: "table=0, priority=20,ip"},
+		{line: "table=x, priority=20,ip actions=drop"},
+		{line: "priority=high,ip actions=drop"},
+	}
+
+	for _, test := range tests {
+		flow, err := ParseFlow(test.line)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("Expected an error for %q but got %+v", test.line, flow)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.line, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(flow, test.expected) {
+			t.Errorf("Expected %q to parse into %+v but got %+v", test.line, test.expected, flow)
+		}
+	}
+}
+
+func TestFlowString(t *testing.T) {
+	flow := &Flow{
+		Table:    1,
+		Priority: 20,
+		Cookie:   42,
+		Match:    []FlowMatch{{"arp", ""}, {"arp_tpa", "10.0.0.4"}, {"arp_op", "1"}},
+		Actions:  []string{"load:0x2->NXM_OF_ARP_OP[]", "IN_PORT"},
+	}
+
+	expected := "table=1,priority=20,cookie=0x2a,arp,arp_tpa=10.0.0.4,arp_op=1,actions=load:0x2->NXM_OF_ARP_OP[],IN_PORT"
+	if flow.String() != expected {
+		t.Errorf("Expected flow %q but got %q", expected, flow.String())
+	}
+
+	expected = "table=1,arp,arp_tpa=10.0.0.4,arp_op=1"
+	if flow.MatchString() != expected {
+		t.Errorf("Expected match %q but got %q", expected, flow.MatchString())
+	}
+
+	// A flow added with String is identified as the same flow when it is dumped.
+	dumped, err := ParseFlow(flow.String())
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", flow.String(), err)
+	}
+
+	if dumped.key() != flow.key() {
+		t.Errorf("Expected key %q but got %q", flow.key(), dumped.key())
+	}
+}
+
+func TestFlowKey(t *testing.T) {
+	flow := &Flow{
+		Priority: 20,
+		Match:    []FlowMatch{{"ip", ""}, {"in_port", "5"}, {"vlan_tci", "0"}, {"tun_id", "100"}},
+		Actions:  []string{"drop"},
+	}
+
+	tests := []struct {
+		other *Flow
+		same  bool
+	}{
+		// ovs-ofctl reorders fields and formats numbers in hex.
+		{other: &Flow{Priority: 20, Match: []FlowMatch{{"vlan_tci", "0x0000"}, {"tun_id", "0x64"}, {"in_port", "5"}, {"ip", ""}}}, same: true},
+		{other: &Flow{Priority: 20, Match: []FlowMatch{{"ip", ""}, {"in_port", "\"5\""}, {"vlan_tci", "0"}, {"tun_id", "100"}}}, same: true},
+		{other: &Flow{Priority: 10, Match: flow.Match}},
+		{other: &Flow{Table: 1, Priority: 20, Match: flow.Match}},
+		{other: &Flow{Priority: 20, Match: []FlowMatch{{"ip", ""}, {"in_port", "6"}, {"vlan_tci", "0"}, {"tun_id", "100"}}}},
+		{other: &Flow{Priority: 20, Match: []FlowMatch{{"ip", ""}, {"in_port", "5"}, {"tun_id", "100"}}}},
+	}
+
+	for _, test := range tests {
+		if (flow.key() == test.other.key()) != test.same {
+			t.Errorf("Expected same=%v for keys %q and %q", test.same, flow.key(), test.other.key())
+		}
+	}
+}
+
+func TestGetMissingAndDivergedFlows(t *testing.T) {
+	snat := getIpSnatFlows("5", "00:0d:3a:00:00:01")
+	dnat := getMacDnatFlow("6", net.ParseIP("10.0.0.4"), "00:0d:3a:00:00:02", 10)
+
+	existing := []*Flow{}
+	for _, line := range []string{
+		"table=0, priority=20,ip,in_port=5,vlan_tci=0x0000 actions=mod_dl_src:00:0d:3a:00:00:01,strip_vlan,NORMAL",
+		"table=0, priority=10,ip,in_port=5 actions=NORMAL",
+	} {
+		flow, err := ParseFlow(line)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", line, err)
+		}
+		existing = append(existing, flow)
+	}
+
+	flows := []*Flow{snat[0], snat[1], dnat}
+
+	missing := getMissingFlows(existing, flows)
+	if !reflect.DeepEqual(missing, []*Flow{dnat}) {
+		t.Errorf("Expected missing flows %v but got %v", []*Flow{dnat}, missing)
+	}
+
+	// The drop flow exists with different actions and is reinstalled.
+	diverged := getDivergedFlows(existing, flows)
+	if !reflect.DeepEqual(diverged, []*Flow{snat[1], dnat}) {
+		t.Errorf("Expected diverged flows %v but got %v", []*Flow{snat[1], dnat}, diverged)
+	}
+}
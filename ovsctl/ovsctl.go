@@ -3,22 +3,34 @@ package ovsctl
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/Azure/azure-container-networking/common"
 	"github.com/Azure/azure-container-networking/log"
-	"github.com/Azure/azure-container-networking/platform"
 )
 
 const (
 	defaultMacForArpResponse = "12:34:56:78:9a:bc"
 )
 
+// withOvsdbClient runs a function with a client of the local ovsdb-server.
+func withOvsdbClient(fn func(client *OvsdbClient) error) error {
+	client, err := NewOvsdbClient("unix", OvsdbSocket)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return fn(client)
+}
+
 func CreateOVSBridge(bridgeName string) error {
 	log.Printf("[ovs] Creating OVS Bridge %v", bridgeName)
 
-	ovsCreateCmd := fmt.Sprintf("ovs-vsctl add-br %s", bridgeName)
-	_, err := platform.ExecuteCommand(ovsCreateCmd)
+	err := withOvsdbClient(func(client *OvsdbClient) error {
+		return client.AddBridge(bridgeName)
+	})
 	if err != nil {
 		log.Printf("[ovs] Error while creating OVS bridge %v", err)
 		return err
@@ -30,8 +42,9 @@ func CreateOVSBridge(bridgeName string) error {
 func DeleteOVSBridge(bridgeName string) error {
 	log.Printf("[ovs] Deleting OVS Bridge %v", bridgeName)
 
-	ovsCreateCmd := fmt.Sprintf("ovs-vsctl del-br %s", bridgeName)
-	_, err := platform.ExecuteCommand(ovsCreateCmd)
+	err := withOvsdbClient(func(client *OvsdbClient) error {
+		return client.DeleteBridge(bridgeName)
+	})
 	if err != nil {
 		log.Printf("[ovs] Error while deleting OVS bridge %v", err)
 		return err
@@ -41,14 +54,9 @@ func DeleteOVSBridge(bridgeName string) error {
 }
 
 func AddPortOnOVSBridge(hostIfName string, bridgeName string, vlanID int) error {
-	cmd := ""
-
-	if vlanID == 0 {
-		cmd = fmt.Sprintf("ovs-vsctl add-port %s %s", bridgeName, hostIfName)
-	} else {
-		cmd = fmt.Sprintf("ovs-vsctl add-port %s %s tag=%d", bridgeName, hostIfName, vlanID)
-	}
-	_, err := platform.ExecuteCommand(cmd)
+	err := withOvsdbClient(func(client *OvsdbClient) error {
+		return client.AddPort(bridgeName, hostIfName, vlanID, "", nil)
+	})
 	if err != nil {
 		log.Printf("[ovs] Error while setting OVS as master to primary interface %v", err)
 		return err
@@ -58,20 +66,29 @@ func AddPortOnOVSBridge(hostIfName string, bridgeName string, vlanID int) error
 }
 
 func GetOVSPortNumber(interfaceName string) (string, error) {
-	cmd := fmt.Sprintf("ovs-vsctl get Interface %s ofport", interfaceName)
-	ofport, err := platform.ExecuteCommand(cmd)
+	var ofport int
+
+	err := withOvsdbClient(func(client *OvsdbClient) error {
+		var err error
+		ofport, err = client.GetOfport(interfaceName)
+		return err
+	})
 	if err != nil {
 		log.Printf("[ovs] Get ofport failed with error %v", err)
 		return "", err
 	}
 
-	return strings.Trim(ofport, "\n"), nil
+	return strconv.Itoa(ofport), nil
 }
 
 func AddVMIpAcceptRule(bridgeName string, primaryIP string, mac string) error {
-	cmd := fmt.Sprintf("ovs-ofctl add-flow %s ip,nw_dst=%s,dl_dst=%s,priority=20,actions=normal", bridgeName, primaryIP, mac)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
+	flow := &Flow{
+		Priority: 20,
+		Match:    []FlowMatch{{"ip", ""}, {"nw_dst", primaryIP}, {"dl_dst", mac}},
+		Actions:  []string{"normal"},
+	}
+
+	if err := AddFlows(bridgeName, flow); err != nil {
 		log.Printf("[ovs] Adding SNAT rule failed with error %v", err)
 		return err
 	}
@@ -80,10 +97,14 @@ func AddVMIpAcceptRule(bridgeName string, primaryIP string, mac string) error {
 }
 
 func AddArpSnatRule(bridgeName string, mac string, macHex string, ofport string) error {
-	cmd := fmt.Sprintf(`ovs-ofctl add-flow %v table=1,priority=10,arp,arp_op=1,actions='mod_dl_src:%s,
-		load:0x%s->NXM_NX_ARP_SHA[],output:%s'`, bridgeName, mac, macHex, ofport)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
+	flow := &Flow{
+		Table:    1,
+		Priority: 10,
+		Match:    []FlowMatch{{"arp", ""}, {"arp_op", "1"}},
+		Actions:  []string{"mod_dl_src:" + mac, fmt.Sprintf("load:0x%s->NXM_NX_ARP_SHA[]", macHex), "output:" + ofport},
+	}
+
+	if err := AddFlows(bridgeName, flow); err != nil {
 		log.Printf("[ovs] Adding ARP SNAT rule failed with error %v", err)
 		return err
 	}
@@ -91,20 +112,26 @@ func AddArpSnatRule(bridgeName string, mac string, macHex string, ofport string)
 	return nil
 }
 
-func AddIpSnatRule(bridgeName string, port string, mac string) error {
-	cmd := fmt.Sprintf("ovs-ofctl add-flow %v priority=20,ip,in_port=%s,vlan_tci=0,actions=mod_dl_src:%s,strip_vlan,normal",
-		bridgeName, port, mac)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
-		log.Printf("[ovs] Adding IP SNAT rule failed with error %v", err)
-		return err
+// getIpSnatFlows returns the flows that translate the source MAC address of untagged IP packets
+// received on a port, and drop tagged ones.
+func getIpSnatFlows(port string, mac string) []*Flow {
+	return []*Flow{
+		{
+			Priority: 20,
+			Match:    []FlowMatch{{"ip", ""}, {"in_port", port}, {"vlan_tci", "0"}},
+			Actions:  []string{"mod_dl_src:" + mac, "strip_vlan", "normal"},
+		},
+		{
+			Priority: 10,
+			Match:    []FlowMatch{{"ip", ""}, {"in_port", port}},
+			Actions:  []string{"drop"},
+		},
 	}
+}
 
-	cmd = fmt.Sprintf("ovs-ofctl add-flow %v priority=10,ip,in_port=%s,actions=drop",
-		bridgeName, port)
-	_, err = platform.ExecuteCommand(cmd)
-	if err != nil {
-		log.Printf("[ovs] Dropping vlantag packet rule failed with error %v", err)
+func AddIpSnatRule(bridgeName string, port string, mac string) error {
+	if err := AddFlows(bridgeName, getIpSnatFlows(port, mac)...); err != nil {
+		log.Printf("[ovs] Adding IP SNAT rule failed with error %v", err)
 		return err
 	}
 
@@ -113,10 +140,13 @@ func AddIpSnatRule(bridgeName string, port string, mac string) error {
 
 func AddArpDnatRule(bridgeName string, port string, mac string) error {
 	// Add DNAT rule to forward ARP replies to container interfaces.
-	cmd := fmt.Sprintf(`ovs-ofctl add-flow %s arp,arp_op=2,in_port=%s,actions='mod_dl_dst:ff:ff:ff:ff:ff:ff,
-		load:0x%s->NXM_NX_ARP_THA[],normal'`, bridgeName, port, mac)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
+	flow := &Flow{
+		Priority: DefaultFlowPriority,
+		Match:    []FlowMatch{{"arp", ""}, {"arp_op", "2"}, {"in_port", port}},
+		Actions:  []string{"mod_dl_dst:ff:ff:ff:ff:ff:ff", fmt.Sprintf("load:0x%s->NXM_NX_ARP_THA[]", mac), "normal"},
+	}
+
+	if err := AddFlows(bridgeName, flow); err != nil {
 		log.Printf("[ovs] Adding DNAT rule failed with error %v", err)
 		return err
 	}
@@ -130,13 +160,22 @@ func AddFakeArpReply(bridgeName string, ip net.IP) error {
 	ipAddrInt := common.IpToInt(ip)
 
 	log.Printf("[ovs] Adding ARP reply rule for IP address %v ", ip.String())
-	cmd := fmt.Sprintf(`ovs-ofctl add-flow %s arp,arp_op=1,priority=20,actions='load:0x2->NXM_OF_ARP_OP[],
-			move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],mod_dl_src:%s,
-			move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],move:NXM_OF_ARP_TPA[]->NXM_OF_ARP_SPA[],
-			load:0x%s->NXM_NX_ARP_SHA[],load:0x%x->NXM_OF_ARP_TPA[],IN_PORT'`,
-		bridgeName, defaultMacForArpResponse, macAddrHex, ipAddrInt)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
+	flow := &Flow{
+		Priority: 20,
+		Match:    []FlowMatch{{"arp", ""}, {"arp_op", "1"}},
+		Actions: []string{
+			"load:0x2->NXM_OF_ARP_OP[]",
+			"move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[]",
+			"mod_dl_src:" + defaultMacForArpResponse,
+			"move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[]",
+			"move:NXM_OF_ARP_TPA[]->NXM_OF_ARP_SPA[]",
+			fmt.Sprintf("load:0x%s->NXM_NX_ARP_SHA[]", macAddrHex),
+			fmt.Sprintf("load:0x%x->NXM_OF_ARP_TPA[]", ipAddrInt),
+			"IN_PORT",
+		},
+	}
+
+	if err := AddFlows(bridgeName, flow); err != nil {
 		log.Printf("[ovs] Adding ARP reply rule failed with error %v", err)
 		return err
 	}
@@ -144,28 +183,39 @@ func AddFakeArpReply(bridgeName string, ip net.IP) error {
 	return nil
 }
 
-func AddArpReplyRule(bridgeName string, port string, ip net.IP, mac string, vlanid int, mode string) error {
+// getArpReplyFlows returns the flows that tag ARP requests received on a port and reply to those for an IP address.
+func getArpReplyFlows(port string, ip net.IP, mac string, vlanid int) []*Flow {
 	ipAddrInt := common.IpToInt(ip)
 	macAddrHex := strings.Replace(mac, ":", "", -1)
 
-	log.Printf("[ovs] Adding ARP reply rule to add vlan %v and forward packet to table 1 for port %v", vlanid, port)
-	cmd := fmt.Sprintf(`ovs-ofctl add-flow %s arp,arp_op=1,in_port=%s,actions='mod_vlan_vid:%v,resubmit(,1)'`,
-		bridgeName, port, vlanid)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
-		log.Printf("[ovs] Adding ARP reply rule failed with error %v", err)
-		return err
+	return []*Flow{
+		{
+			Priority: DefaultFlowPriority,
+			Match:    []FlowMatch{{"arp", ""}, {"arp_op", "1"}, {"in_port", port}},
+			Actions:  []string{fmt.Sprintf("mod_vlan_vid:%v", vlanid), "resubmit(,1)"},
+		},
+		{
+			Table:    1,
+			Priority: 20,
+			Match:    []FlowMatch{{"arp", ""}, {"arp_tpa", ip.String()}, {"dl_vlan", strconv.Itoa(vlanid)}, {"arp_op", "1"}},
+			Actions: []string{
+				"load:0x2->NXM_OF_ARP_OP[]",
+				"move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[]",
+				"mod_dl_src:" + mac,
+				"move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[]",
+				"move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[]",
+				fmt.Sprintf("load:0x%s->NXM_NX_ARP_SHA[]", macAddrHex),
+				fmt.Sprintf("load:0x%x->NXM_OF_ARP_SPA[]", ipAddrInt),
+				"strip_vlan",
+				"IN_PORT",
+			},
+		},
 	}
+}
 
-	// If arp fields matches, set arp reply rule for the request
-	log.Printf("[ovs] Adding ARP reply rule for IP address %v and vlanid %v.", ip, vlanid)
-	cmd = fmt.Sprintf(`ovs-ofctl add-flow %s table=1,arp,arp_tpa=%s,dl_vlan=%v,arp_op=1,priority=20,actions='load:0x2->NXM_OF_ARP_OP[],
-			move:NXM_OF_ETH_SRC[]->NXM_OF_ETH_DST[],mod_dl_src:%s,
-			move:NXM_NX_ARP_SHA[]->NXM_NX_ARP_THA[],move:NXM_OF_ARP_SPA[]->NXM_OF_ARP_TPA[],
-			load:0x%s->NXM_NX_ARP_SHA[],load:0x%x->NXM_OF_ARP_SPA[],strip_vlan,IN_PORT'`,
-		bridgeName, ip.String(), vlanid, mac, macAddrHex, ipAddrInt)
-	_, err = platform.ExecuteCommand(cmd)
-	if err != nil {
+func AddArpReplyRule(bridgeName string, port string, ip net.IP, mac string, vlanid int, mode string) error {
+	log.Printf("[ovs] Adding ARP reply rules for IP address %v and vlanid %v on port %v.", ip, vlanid, port)
+	if err := AddFlows(bridgeName, getArpReplyFlows(port, ip, mac, vlanid)...); err != nil {
 		log.Printf("[ovs] Adding ARP reply rule failed with error %v", err)
 		return err
 	}
@@ -173,11 +223,18 @@ func AddArpReplyRule(bridgeName string, port string, ip net.IP, mac string, vlan
 	return nil
 }
 
+// getMacDnatFlow returns the flow that translates the destination MAC address of IP packets
+// for an IP address and vlan received on a port.
+func getMacDnatFlow(port string, ip net.IP, mac string, vlanid int) *Flow {
+	return &Flow{
+		Priority: DefaultFlowPriority,
+		Match:    []FlowMatch{{"ip", ""}, {"nw_dst", ip.String()}, {"dl_vlan", strconv.Itoa(vlanid)}, {"in_port", port}},
+		Actions:  []string{"mod_dl_dst:" + mac, "normal"},
+	}
+}
+
 func AddMacDnatRule(bridgeName string, port string, ip net.IP, mac string, vlanid int) error {
-	cmd := fmt.Sprintf("ovs-ofctl add-flow %s ip,nw_dst=%s,dl_vlan=%v,in_port=%s,actions=mod_dl_dst:%s,normal",
-		bridgeName, ip.String(), vlanid, port, mac)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
+	if err := AddFlows(bridgeName, getMacDnatFlow(port, ip, mac, vlanid)); err != nil {
 		log.Printf("[ovs] Adding MAC DNAT rule failed with error %v", err)
 		return err
 	}
@@ -185,44 +242,39 @@ func AddMacDnatRule(bridgeName string, port string, ip net.IP, mac string, vlani
 	return nil
 }
 
-func DeleteArpReplyRule(bridgeName string, port string, ip net.IP, vlanid int) {
-	cmd := fmt.Sprintf("ovs-ofctl del-flows %s arp,arp_op=1,in_port=%s",
-		bridgeName, port)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
-		log.Printf("[net] Deleting ARP reply rule failed with error %v", err)
+func DeleteArpReplyRule(bridgeName string, port string, ip net.IP, vlanid int) error {
+	if err := DeleteFlows(bridgeName, getArpReplyFlows(port, ip, "", vlanid)...); err != nil {
+		log.Printf("[ovs] Deleting ARP reply rule failed with error %v", err)
+		return err
 	}
 
-	cmd = fmt.Sprintf("ovs-ofctl del-flows %s table=1,arp,arp_tpa=%s,dl_vlan=%v,arp_op=1",
-		bridgeName, ip.String(), vlanid)
-	_, err = platform.ExecuteCommand(cmd)
-	if err != nil {
-		log.Printf("[net] Deleting ARP reply rule failed with error %v", err)
-	}
+	return nil
 }
 
-func DeleteIPSnatRule(bridgeName string, port string) {
-	cmd := fmt.Sprintf("ovs-ofctl del-flows %v ip,in_port=%s",
-		bridgeName, port)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
-		log.Printf("Error while deleting ovs rule %v error %v", cmd, err)
+func DeleteIPSnatRule(bridgeName string, port string) error {
+	// Deleting the less specific flow deletes both.
+	if err := DeleteFlows(bridgeName, getIpSnatFlows(port, "")[1]); err != nil {
+		log.Printf("[ovs] Deleting IP SNAT rule failed with error %v", err)
+		return err
 	}
+
+	return nil
 }
 
-func DeleteMacDnatRule(bridgeName string, port string, ip net.IP, vlanid int) {
-	cmd := fmt.Sprintf("ovs-ofctl del-flows %s ip,nw_dst=%s,dl_vlan=%v,in_port=%s",
-		bridgeName, ip.String(), vlanid, port)
-	_, err := platform.ExecuteCommand(cmd)
-	if err != nil {
-		log.Printf("[net] Deleting MAC DNAT rule failed with error %v", err)
+func DeleteMacDnatRule(bridgeName string, port string, ip net.IP, vlanid int) error {
+	if err := DeleteFlows(bridgeName, getMacDnatFlow(port, ip, "", vlanid)); err != nil {
+		log.Printf("[ovs] Deleting MAC DNAT rule failed with error %v", err)
+		return err
 	}
+
+	return nil
 }
 
 func DeletePortFromOVS(bridgeName string, interfaceName string) error {
 	// Disconnect external interface from its bridge.
-	cmd := fmt.Sprintf("ovs-vsctl del-port %s %s", bridgeName, interfaceName)
-	_, err := platform.ExecuteCommand(cmd)
+	err := withOvsdbClient(func(client *OvsdbClient) error {
+		return client.DeletePort(bridgeName, interfaceName)
+	})
 	if err != nil {
 		log.Printf("[ovs] Failed to disconnect interface %v from bridge, err:%v.", interfaceName, err)
 		return err
@@ -230,3 +282,71 @@ func DeletePortFromOVS(bridgeName string, interfaceName string) error {
 
 	return nil
 }
+
+func AddVxlanTunnelPort(bridgeName string, portName string) error {
+	// The VNI and remote endpoint are set per flow so that a single tunnel port serves all tenants.
+	options := map[string]string{"key": "flow", "remote_ip": "flow"}
+
+	err := withOvsdbClient(func(client *OvsdbClient) error {
+		return client.AddPort(bridgeName, portName, 0, "vxlan", options)
+	})
+	if err != nil {
+		log.Printf("[ovs] Adding vxlan tunnel port %v failed with error %v", portName, err)
+		return err
+	}
+
+	return nil
+}
+
+func AddVxlanEncapRule(bridgeName string, port string, tunnelPort string, vni int, remoteIP net.IP) error {
+	flows := []*Flow{
+		{
+			Priority: 20,
+			Match:    []FlowMatch{{"ip", ""}, {"in_port", port}},
+			Actions: []string{
+				fmt.Sprintf("set_field:%d->tun_id", vni),
+				fmt.Sprintf("set_field:%s->tun_dst", remoteIP.String()),
+				"output:" + tunnelPort,
+			},
+		},
+		{
+			Priority: 10,
+			Match:    []FlowMatch{{"ip", ""}, {"in_port", port}},
+			Actions:  []string{"drop"},
+		},
+	}
+
+	if err := AddFlows(bridgeName, flows...); err != nil {
+		log.Printf("[ovs] Adding vxlan encap rule failed with error %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// getVxlanDecapFlow returns the flow that delivers packets for an IP address received on a tunnel with a VNI.
+func getVxlanDecapFlow(tunnelPort string, ip net.IP, mac string, port string, vni int) *Flow {
+	return &Flow{
+		Priority: 20,
+		Match:    []FlowMatch{{"ip", ""}, {"in_port", tunnelPort}, {"tun_id", strconv.Itoa(vni)}, {"nw_dst", ip.String()}},
+		Actions:  []string{"mod_dl_dst:" + mac, "output:" + port},
+	}
+}
+
+func AddVxlanDecapRule(bridgeName string, tunnelPort string, ip net.IP, mac string, port string, vni int) error {
+	if err := AddFlows(bridgeName, getVxlanDecapFlow(tunnelPort, ip, mac, port, vni)); err != nil {
+		log.Printf("[ovs] Adding vxlan decap rule failed with error %v", err)
+		return err
+	}
+
+	return nil
+}
+
+func DeleteVxlanDecapRule(bridgeName string, tunnelPort string, ip net.IP, vni int) error {
+	if err := DeleteFlows(bridgeName, getVxlanDecapFlow(tunnelPort, ip, "", "", vni)); err != nil {
+		log.Printf("[ovs] Deleting vxlan decap rule failed with error %v", err)
+		return err
+	}
+
+	return nil
+}
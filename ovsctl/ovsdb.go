@@ -0,0 +1,463 @@
+package ovsctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-container-networking/log"
+)
+
+const (
+	// OvsdbSocket is the unix socket of the local ovsdb-server.
+	OvsdbSocket = "/var/run/openvswitch/db.sock"
+
+	ovsdbDatabase = "Open_vSwitch"
+
+	// ovs-vswitchd assigns OpenFlow port numbers after interfaces are committed to the database.
+	ofportPollInterval = 100 * time.Millisecond
+	ofportPollCount    = 50
+
+	// ovs-vswitchd reports the configuration it applied in cur_cfg.
+	cfgPollInterval = 100 * time.Millisecond
+	cfgPollCount    = 50
+)
+
+// OvsdbClient is a JSON-RPC client of the Open_vSwitch database, as specified in RFC 7047.
+// A client is not safe for concurrent use.
+type OvsdbClient struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	id   int
+}
+
+// ovsdbRequest is a JSON-RPC request or notification.
+type ovsdbRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	Id     interface{}   `json:"id"`
+}
+
+// ovsdbMessage is a JSON-RPC response, or a request sent by the server.
+type ovsdbMessage struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	Id     interface{}     `json:"id"`
+}
+
+// ovsdbResponse is a JSON-RPC response.
+type ovsdbResponse struct {
+	Result interface{} `json:"result"`
+	Error  interface{} `json:"error"`
+	Id     interface{} `json:"id"`
+}
+
+// ovsdbResult is the result of an operation of a transaction.
+type ovsdbResult struct {
+	Rows    []map[string]interface{} `json:"rows"`
+	Count   int                      `json:"count"`
+	Uuid    []interface{}            `json:"uuid"`
+	Error   string                   `json:"error"`
+	Details string                   `json:"details"`
+}
+
+// NewOvsdbClient connects to an ovsdb-server, such as "unix" OvsdbSocket or "tcp" "127.0.0.1:6640".
+func NewOvsdbClient(network string, address string) (*OvsdbClient, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		log.Printf("[ovs] Failed to connect to ovsdb-server at %v, err:%v.", address, err)
+		return nil, err
+	}
+
+	return &OvsdbClient{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(conn),
+	}, nil
+}
+
+// Close closes the connection of a client.
+func (client *OvsdbClient) Close() error {
+	return client.conn.Close()
+}
+
+// call sends a request and waits for its response, answering echo requests of the server meanwhile.
+func (client *OvsdbClient) call(method string, params []interface{}, result interface{}) error {
+	client.id++
+	id := client.id
+
+	if err := client.enc.Encode(&ovsdbRequest{Method: method, Params: params, Id: id}); err != nil {
+		return err
+	}
+
+	for {
+		var msg ovsdbMessage
+		if err := client.dec.Decode(&msg); err != nil {
+			return err
+		}
+
+		if msg.Method == "echo" {
+			var echo []interface{}
+			json.Unmarshal(msg.Params, &echo)
+			if err := client.enc.Encode(&ovsdbResponse{Result: echo, Id: msg.Id}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Skip notifications and responses to other requests.
+		if msg.Method != "" {
+			continue
+		}
+		if number, ok := msg.Id.(float64); !ok || int(number) != id {
+			continue
+		}
+
+		if msg.Error != nil {
+			return fmt.Errorf("%s failed: %v", method, msg.Error)
+		}
+
+		return json.Unmarshal(msg.Result, result)
+	}
+}
+
+// Echo checks that the server is responsive.
+func (client *OvsdbClient) Echo() error {
+	var result []interface{}
+	return client.call("echo", []interface{}{"ping"}, &result)
+}
+
+// transact runs operations in a single transaction and returns their results.
+func (client *OvsdbClient) transact(ops ...map[string]interface{}) ([]ovsdbResult, error) {
+	params := []interface{}{ovsdbDatabase}
+	for _, op := range ops {
+		params = append(params, op)
+	}
+
+	var results []ovsdbResult
+	if err := client.call("transact", params, &results); err != nil {
+		return nil, err
+	}
+
+	// A failed transaction reports the error of the failed operation, or an extra result
+	// when the transaction as a whole failed.
+	for _, result := range results {
+		if result.Error != "" {
+			return nil, fmt.Errorf("OVSDB transaction failed: %s: %s", result.Error, result.Details)
+		}
+	}
+
+	if len(results) < len(ops) {
+		return nil, fmt.Errorf("OVSDB transaction returned %d results for %d operations", len(results), len(ops))
+	}
+
+	return results, nil
+}
+
+// transactAndWait runs operations in a single transaction that also increments next_cfg, and waits
+// for ovs-vswitchd to apply the change, as ovs-vsctl does. It returns the results of the operations.
+func (client *OvsdbClient) transactAndWait(ops ...map[string]interface{}) ([]ovsdbResult, error) {
+	count := len(ops)
+	ops = append(ops,
+		mutateOp(ovsdbDatabase, []interface{}{}, "next_cfg", "+=", 1),
+		selectOp(ovsdbDatabase, []interface{}{}, "next_cfg"),
+	)
+
+	results, err := client.transact(ops...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := results[count+1].Rows
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("OVSDB table %s is empty", ovsdbDatabase)
+	}
+
+	nextCfg, ok := rows[0]["next_cfg"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("Invalid next_cfg %v", rows[0]["next_cfg"])
+	}
+
+	if err := client.waitForConfig(int(nextCfg)); err != nil {
+		return nil, err
+	}
+
+	return results[:count], nil
+}
+
+// waitForConfig waits for ovs-vswitchd to apply the configuration with the given sequence number.
+func (client *OvsdbClient) waitForConfig(nextCfg int) error {
+	for i := 0; i < cfgPollCount; i++ {
+		results, err := client.transact(selectOp(ovsdbDatabase, []interface{}{}, "cur_cfg"))
+		if err != nil {
+			return err
+		}
+
+		if len(results[0].Rows) > 0 {
+			if curCfg, ok := results[0].Rows[0]["cur_cfg"].(float64); ok && int(curCfg) >= nextCfg {
+				return nil
+			}
+		}
+
+		time.Sleep(cfgPollInterval)
+	}
+
+	return fmt.Errorf("Timed out waiting for ovs-vswitchd to apply configuration %d", nextCfg)
+}
+
+// Returns an OVSDB set of the given atoms.
+func ovsdbSet(atoms ...interface{}) []interface{} {
+	if atoms == nil {
+		atoms = []interface{}{}
+	}
+	return []interface{}{"set", atoms}
+}
+
+// Returns an OVSDB map of the given string pairs.
+func ovsdbMap(pairs map[string]string) []interface{} {
+	var keys []string
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := []interface{}{}
+	for _, key := range keys {
+		entries = append(entries, []interface{}{key, pairs[key]})
+	}
+
+	return []interface{}{"map", entries}
+}
+
+// Returns a reference to a row inserted in the same transaction.
+func namedUuid(name string) []interface{} {
+	return []interface{}{"named-uuid", name}
+}
+
+// Returns a condition that matches rows with a column equal to a value.
+func equals(column string, value interface{}) []interface{} {
+	return []interface{}{column, "==", value}
+}
+
+// Returns an operation that inserts a row.
+func insertOp(table string, row map[string]interface{}, uuidName string) map[string]interface{} {
+	return map[string]interface{}{"op": "insert", "table": table, "row": row, "uuid-name": uuidName}
+}
+
+// Returns an operation that selects columns of rows matching the given conditions.
+func selectOp(table string, where []interface{}, columns ...string) map[string]interface{} {
+	return map[string]interface{}{"op": "select", "table": table, "where": where, "columns": columns}
+}
+
+// Returns an operation that inserts values into or deletes values from a set column of matching rows.
+func mutateOp(table string, where []interface{}, column string, mutator string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"op":        "mutate",
+		"table":     table,
+		"where":     where,
+		"mutations": []interface{}{[]interface{}{column, mutator, value}},
+	}
+}
+
+// getUuids returns the UUIDs of a column that holds a UUID or a set of UUIDs.
+func getUuids(value interface{}) []interface{} {
+	atom, ok := value.([]interface{})
+	if !ok || len(atom) != 2 {
+		return nil
+	}
+
+	switch atom[0] {
+	case "uuid":
+		return []interface{}{atom}
+	case "set":
+		members, _ := atom[1].([]interface{})
+		return members
+	}
+
+	return nil
+}
+
+// getRowUuid returns the UUID of the only row named by a select operation, or nil if there is none.
+func (client *OvsdbClient) getRowUuid(table string, name string) (interface{}, error) {
+	results, err := client.transact(selectOp(table, []interface{}{equals("name", name)}, "_uuid"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results[0].Rows) == 0 {
+		return nil, nil
+	}
+
+	return results[0].Rows[0]["_uuid"], nil
+}
+
+// AddBridge adds a bridge with an internal port of the same name, and waits for ovs-vswitchd to create it.
+// Existing bridges are left unchanged.
+func (client *OvsdbClient) AddBridge(bridgeName string) error {
+	uuid, err := client.getRowUuid("Bridge", bridgeName)
+	if err != nil {
+		return err
+	}
+
+	if uuid != nil {
+		log.Printf("[ovs] Bridge %v already exists.", bridgeName)
+		return nil
+	}
+
+	_, err = client.transactAndWait(
+		insertOp("Interface", map[string]interface{}{"name": bridgeName, "type": "internal"}, "iface"),
+		insertOp("Port", map[string]interface{}{"name": bridgeName, "interfaces": namedUuid("iface")}, "port"),
+		insertOp("Bridge", map[string]interface{}{"name": bridgeName, "ports": namedUuid("port")}, "bridge"),
+		mutateOp(ovsdbDatabase, []interface{}{}, "bridges", "insert", ovsdbSet(namedUuid("bridge"))),
+	)
+
+	return err
+}
+
+// DeleteBridge deletes a bridge, along with its ports and interfaces.
+func (client *OvsdbClient) DeleteBridge(bridgeName string) error {
+	uuid, err := client.getRowUuid("Bridge", bridgeName)
+	if err != nil {
+		return err
+	}
+
+	if uuid == nil {
+		return fmt.Errorf("Bridge %s not found", bridgeName)
+	}
+
+	// Rows that are no longer referenced are garbage collected.
+	_, err = client.transactAndWait(mutateOp(ovsdbDatabase, []interface{}{}, "bridges", "delete", ovsdbSet(uuid)))
+
+	return err
+}
+
+// AddPort adds a port with an interface of the same name to a bridge, and waits for ovs-vswitchd to attach it.
+// Existing ports are left unchanged.
+// A non-zero tag makes the port an access port of that VLAN. The interface type defaults to "system".
+func (client *OvsdbClient) AddPort(bridgeName string, portName string, tag int, ifType string, options map[string]string) error {
+	uuid, err := client.getRowUuid("Port", portName)
+	if err != nil {
+		return err
+	}
+
+	if uuid != nil {
+		log.Printf("[ovs] Port %v already exists.", portName)
+		return nil
+	}
+
+	iface := map[string]interface{}{"name": portName}
+	if ifType != "" {
+		iface["type"] = ifType
+	}
+	if len(options) > 0 {
+		iface["options"] = ovsdbMap(options)
+	}
+
+	port := map[string]interface{}{"name": portName, "interfaces": namedUuid("iface")}
+	if tag != 0 {
+		port["tag"] = tag
+	}
+
+	results, err := client.transactAndWait(
+		insertOp("Interface", iface, "iface"),
+		insertOp("Port", port, "port"),
+		mutateOp("Bridge", []interface{}{equals("name", bridgeName)}, "ports", "insert", ovsdbSet(namedUuid("port"))),
+	)
+	if err != nil {
+		return err
+	}
+
+	// The new rows were garbage collected if the bridge does not exist.
+	if results[2].Count == 0 {
+		return fmt.Errorf("Bridge %s not found", bridgeName)
+	}
+
+	return nil
+}
+
+// DeletePort deletes a port and its interfaces from a bridge.
+func (client *OvsdbClient) DeletePort(bridgeName string, portName string) error {
+	uuid, err := client.getRowUuid("Port", portName)
+	if err != nil {
+		return err
+	}
+
+	if uuid == nil {
+		return fmt.Errorf("Port %s not found", portName)
+	}
+
+	results, err := client.transactAndWait(
+		mutateOp("Bridge", []interface{}{equals("name", bridgeName)}, "ports", "delete", ovsdbSet(uuid)),
+	)
+	if err != nil {
+		return err
+	}
+
+	if results[0].Count == 0 {
+		return fmt.Errorf("Bridge %s not found", bridgeName)
+	}
+
+	return nil
+}
+
+// ListPorts returns the names of the ports of a bridge.
+func (client *OvsdbClient) ListPorts(bridgeName string) ([]string, error) {
+	results, err := client.transact(
+		selectOp("Bridge", []interface{}{equals("name", bridgeName)}, "ports"),
+		selectOp("Port", []interface{}{}, "_uuid", "name"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results[0].Rows) == 0 {
+		return nil, fmt.Errorf("Bridge %s not found", bridgeName)
+	}
+
+	ports := make(map[string]bool)
+	for _, uuid := range getUuids(results[0].Rows[0]["ports"]) {
+		ports[fmt.Sprint(uuid)] = true
+	}
+
+	var names []string
+	for _, row := range results[1].Rows {
+		if ports[fmt.Sprint(row["_uuid"])] {
+			names = append(names, fmt.Sprint(row["name"]))
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// GetOfport returns the OpenFlow port number of an interface, waiting for ovs-vswitchd to assign one.
+func (client *OvsdbClient) GetOfport(interfaceName string) (int, error) {
+	for i := 0; i < ofportPollCount; i++ {
+		results, err := client.transact(selectOp("Interface", []interface{}{equals("name", interfaceName)}, "ofport"))
+		if err != nil {
+			return 0, err
+		}
+
+		if len(results[0].Rows) == 0 {
+			return 0, fmt.Errorf("Interface %s not found", interfaceName)
+		}
+
+		// The column is an empty set until a port number is assigned, and -1 if assignment failed.
+		if ofport, ok := results[0].Rows[0]["ofport"].(float64); ok {
+			if ofport < 0 {
+				return 0, fmt.Errorf("Interface %s has no OpenFlow port", interfaceName)
+			}
+			return int(ofport), nil
+		}
+
+		time.Sleep(ofportPollInterval)
+	}
+
+	return 0, fmt.Errorf("Timed out waiting for OpenFlow port of interface %s", interfaceName)
+}
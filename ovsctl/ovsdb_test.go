@@ -0,0 +1,170 @@
+package ovsctl
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestOvsdbEncoding(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{value: ovsdbSet(), expected: `["set",[]]`},
+		{value: ovsdbSet(namedUuid("port")), expected: `["set",[["named-uuid","port"]]]`},
+		{value: ovsdbMap(nil), expected: `["map",[]]`},
+		{value: ovsdbMap(map[string]string{"remote_ip": "flow", "key": "flow"}), expected: `["map",[["key","flow"],["remote_ip","flow"]]]`},
+		{value: equals("name", "br0"), expected: `["name","==","br0"]`},
+		{
+			value:    mutateOp("Bridge", []interface{}{equals("name", "br0")}, "ports", "insert", ovsdbSet(namedUuid("port"))),
+			expected: `{"mutations":[["ports","insert",["set",[["named-uuid","port"]]]]],"op":"mutate","table":"Bridge","where":[["name","==","br0"]]}`,
+		},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(test.value)
+		if err != nil {
+			t.Errorf("Failed to encode %v: %v", test.value, err)
+			continue
+		}
+
+		if string(data) != test.expected {
+			t.Errorf("Expected %s but got %s", test.expected, data)
+		}
+	}
+}
+
+func TestGetUuids(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int
+	}{
+		{value: `["uuid","8c1d5e3a-0000-0000-0000-000000000001"]`, expected: 1},
+		{value: `["set",[["uuid","8c1d5e3a-0000-0000-0000-000000000001"],["uuid","8c1d5e3a-0000-0000-0000-000000000002"]]]`, expected: 2},
+		{value: `["set",[]]`, expected: 0},
+		{value: `"br0"`, expected: 0},
+	}
+
+	for _, test := range tests {
+		var value interface{}
+		if err := json.Unmarshal([]byte(test.value), &value); err != nil {
+			t.Fatalf("Failed to decode %s: %v", test.value, err)
+		}
+
+		if uuids := getUuids(value); len(uuids) != test.expected {
+			t.Errorf("Expected %d UUIDs in %s but got %v", test.expected, test.value, uuids)
+		}
+	}
+}
+
+// fakeOvsdbServer answers the requests of a client with scripted transaction results,
+// and sends an echo request before each response.
+func fakeOvsdbServer(t *testing.T, conn net.Conn, results []string, requests chan<- []interface{}) {
+	defer close(requests)
+
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+
+	for _, result := range results {
+		var req ovsdbRequest
+		if err := dec.Decode(&req); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+			return
+		}
+		requests <- req.Params
+
+		if err := enc.Encode(&ovsdbRequest{Method: "echo", Params: []interface{}{"ping"}, Id: "echo"}); err != nil {
+			t.Errorf("Failed to send echo: %v", err)
+			return
+		}
+
+		var echo ovsdbMessage
+		if err := dec.Decode(&echo); err != nil || echo.Id != "echo" {
+			t.Errorf("Expected an echo reply but got %+v, err:%v", echo, err)
+			return
+		}
+
+		if err := enc.Encode(&ovsdbResponse{Result: json.RawMessage(result), Id: req.Id}); err != nil {
+			t.Errorf("Failed to send response: %v", err)
+			return
+		}
+	}
+}
+
+func TestAddBridgeWaitsForConfig(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	results := []string{
+		// The bridge does not exist.
+		`[{"rows":[]}]`,
+		// The bridge is inserted and next_cfg is incremented.
+		`[{"uuid":["uuid","1"]},{"uuid":["uuid","2"]},{"uuid":["uuid","3"]},{"count":1},{"count":1},{"rows":[{"next_cfg":7}]}]`,
+		// ovs-vswitchd applies the configuration on the second poll.
+		`[{"rows":[{"cur_cfg":6}]}]`,
+		`[{"rows":[{"cur_cfg":7}]}]`,
+	}
+
+	requests := make(chan []interface{}, len(results))
+	go fakeOvsdbServer(t, serverConn, results, requests)
+
+	client := &OvsdbClient{conn: clientConn, enc: json.NewEncoder(clientConn), dec: json.NewDecoder(clientConn)}
+	defer client.Close()
+
+	if err := client.AddBridge("br0"); err != nil {
+		t.Fatalf("AddBridge failed: %v", err)
+	}
+
+	var params [][]interface{}
+	for req := range requests {
+		params = append(params, req)
+	}
+
+	if len(params) != len(results) {
+		t.Fatalf("Expected %d requests but got %d", len(results), len(params))
+	}
+
+	// The insert transaction increments next_cfg and reads it back.
+	insert := params[1]
+	if len(insert) != 7 {
+		t.Fatalf("Expected 6 operations in the insert transaction but got %v", insert[1:])
+	}
+
+	mutation := insert[5].(map[string]interface{})["mutations"]
+	if !reflect.DeepEqual(mutation, []interface{}{[]interface{}{"next_cfg", "+=", float64(1)}}) {
+		t.Errorf("Expected next_cfg to be incremented but got %v", mutation)
+	}
+
+	for _, req := range params[2:] {
+		columns := req[1].(map[string]interface{})["columns"]
+		if !reflect.DeepEqual(columns, []interface{}{"cur_cfg"}) {
+			t.Errorf("Expected cur_cfg to be polled but got %v", req[1:])
+		}
+	}
+}
+
+func TestAddPortReportsMissingBridge(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+
+	results := []string{
+		`[{"rows":[]}]`,
+		`[{"uuid":["uuid","1"]},{"uuid":["uuid","2"]},{"count":0},{"count":1},{"rows":[{"next_cfg":3}]}]`,
+		`[{"rows":[{"cur_cfg":3}]}]`,
+	}
+
+	requests := make(chan []interface{}, len(results))
+	go fakeOvsdbServer(t, serverConn, results, requests)
+
+	client := &OvsdbClient{conn: clientConn, enc: json.NewEncoder(clientConn), dec: json.NewDecoder(clientConn)}
+	defer client.Close()
+
+	if err := client.AddPort("br0", "eth0", 10, "", nil); err == nil {
+		t.Errorf("Expected AddPort to fail for a missing bridge")
+	}
+
+	for range requests {
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"os/exec"
 	"time"
 
 	"github.com/Azure/azure-container-networking/log"
+	"golang.org/x/sys/unix"
 )
 
 const (
@@ -82,3 +84,20 @@ func SetOutboundSNAT(subnet string) error {
 	}
 	return nil
 }
+
+// IsNetworkNamespace returns whether a path is a bind mount of an existing network namespace.
+// Namespace links under /proc are rejected, since after a reboot the same process ID can refer to
+// an unrelated process. Bind mounts of namespaces that did not survive a reboot are plain files.
+func IsNetworkNamespace(nsPath string) bool {
+	info, err := os.Lstat(nsPath)
+	if err != nil || info.Mode()&os.ModeSymlink != 0 {
+		return false
+	}
+
+	var stat unix.Statfs_t
+	if err := unix.Statfs(nsPath, &stat); err != nil {
+		return false
+	}
+
+	return stat.Type == unix.NSFS_MAGIC
+}
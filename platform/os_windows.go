@@ -37,3 +37,9 @@ func ExecuteCommand(command string) (string, error) {
 func SetOutboundSNAT(subnet string) error {
 	return nil
 }
+
+// IsNetworkNamespace returns whether a path is a bind mount of an existing network namespace.
+// Windows has no network namespaces.
+func IsNetworkNamespace(nsPath string) bool {
+	return false
+}
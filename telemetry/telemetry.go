@@ -91,6 +91,13 @@ type ClusterState struct {
 	NwPolicyCount int
 }
 
+// DriftState contains the drift of iptables and ipset from the state desired by NPM.
+type DriftState struct {
+	ReconcileCount     int
+	IptablesDriftCount int
+	IpsetDriftCount    int
+}
+
 // NPMReport structure.
 type NPMReport struct {
 	StartFlag         bool
@@ -103,6 +110,7 @@ type NPMReport struct {
 	EventMessage      string
 	UpTime            string
 	ClusterState      ClusterState
+	DriftState        DriftState
 }
 
 // ReportManager structure.